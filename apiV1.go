@@ -0,0 +1,471 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//v1接口，请求和响应都是JSON，按HTTP方法区分操作，按状态码区分结果
+//成功时直接返回结果，失败时返回 {"error": "..."}
+type v1Handler func(res http.ResponseWriter, req *http.Request, params []string)
+
+type v1Route struct {
+	pattern string //{}包围的段匹配任意值，按顺序作为params传给处理函数
+	methods map[string]v1Handler
+}
+
+var v1Routes = []v1Route{
+	{"/v1/queues", map[string]v1Handler{"GET": v1ListQueues, "POST": v1CreateQueue}},
+	{"/v1/queues/{name}", map[string]v1Handler{"GET": v1GetQueue, "PUT": v1UpdateQueue, "DELETE": v1DeleteQueue}},
+	{"/v1/queues/{name}/redrive", map[string]v1Handler{"POST": v1Redrive}},
+	{"/v1/queues/{name}/purge", map[string]v1Handler{"POST": v1Purge}},
+	{"/v1/queues/{name}/messages", map[string]v1Handler{"GET": v1Peek, "POST": v1Push}},
+	{"/v1/queues/{name}/messages/batch", map[string]v1Handler{"POST": v1PushBatch}},
+	{"/v1/queues/{name}/messages/receive", map[string]v1Handler{"POST": v1Receive}},
+	{"/v1/queues/{name}/messages/{receipt}", map[string]v1Handler{"DELETE": v1DeleteMessage}},
+	{"/v1/queues/{name}/messages/{receipt}/visibility", map[string]v1Handler{"PUT": v1ChangeVisibility}},
+	{"/v1/queues/{name}/scheduled", map[string]v1Handler{"GET": v1ListScheduled}},
+	{"/v1/queues/{name}/scheduled/{id}", map[string]v1Handler{"DELETE": v1CancelScheduled}},
+}
+
+//按路由分发，返回匹配的路由模板用于请求耗时指标，没有匹配时返回other
+//固定的段优先于{}，messages/batch不会被当作回执
+func ServeV1(res http.ResponseWriter, req *http.Request) string {
+	segments := strings.Split(strings.Trim(req.URL.EscapedPath(), "/"), "/")
+
+	var matched *v1Route
+	var params []string
+	for i := range v1Routes {
+		if p, ok := v1Routes[i].match(segments); ok && (matched == nil || strings.Count(matched.pattern, "{") > strings.Count(v1Routes[i].pattern, "{")) {
+			matched, params = &v1Routes[i], p
+		}
+	}
+	if matched == nil {
+		v1Error(res, http.StatusNotFound, "no such API: "+req.URL.Path)
+		return "other"
+	}
+
+	handler, ok := matched.methods[req.Method]
+	if !ok {
+		var allowed []string
+		for method := range matched.methods {
+			allowed = append(allowed, method)
+		}
+		sort.Strings(allowed)
+		res.Header().Set("Allow", strings.Join(allowed, ", "))
+		v1Error(res, http.StatusMethodNotAllowed, "method "+req.Method+" is not allowed")
+		return matched.pattern
+	}
+	handler(res, req, params)
+	return matched.pattern
+}
+
+func (this *v1Route) match(segments []string) (params []string, ok bool) {
+	parts := strings.Split(strings.Trim(this.pattern, "/"), "/")
+	if len(parts) != len(segments) {
+		return nil, false
+	}
+	for i, part := range parts {
+		if strings.HasPrefix(part, "{") {
+			param, err := url.PathUnescape(segments[i])
+			if err != nil || param == "" {
+				return nil, false
+			}
+			params = append(params, param)
+		} else if part != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func v1Write(res http.ResponseWriter, status int, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	if v != nil {
+		result, err := json.Marshal(v)
+		must(err)
+		res.Write(result)
+	}
+}
+
+func v1Error(res http.ResponseWriter, status int, message string) {
+	v1Write(res, status, map[string]string{"error": message})
+}
+
+//按错误类型返回状态码，没有类型的错误来自存储
+func v1Fail(res http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch errorKind(err) {
+	case ErrInvalid:
+		status = http.StatusBadRequest
+	case ErrNotFound:
+		status = http.StatusNotFound
+	case ErrConflict:
+		status = http.StatusConflict
+	}
+	v1Error(res, status, err.Error())
+}
+
+//读取JSON请求体，请求体为空时保持v的零值
+func v1Read(res http.ResponseWriter, req *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil && err != io.EOF {
+		v1Error(res, http.StatusBadRequest, "request body is not valid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+//队列存在时返回true，否则返回404
+func v1QueueExists(res http.ResponseWriter, queueName string) bool {
+	if _, ok := Queue.Get(queueName); !ok {
+		v1Error(res, http.StatusNotFound, "Queue "+queueName+" doesn't exist")
+		return false
+	}
+	return true
+}
+
+//创建和修改队列的请求，与表单接口的参数相同，FifoQueue为空时修改保持原来的类型
+type v1QueueRequest struct {
+	QueueName                 string `json:"queueName"`
+	VisibilityTimeout         int64  `json:"visibilityTimeout"`
+	MessageRetentionPeriod    int64  `json:"messageRetentionPeriod"`
+	DelaySeconds              int64  `json:"delaySeconds"`
+	DeadLetterQueue           string `json:"deadLetterQueue"`
+	MaxReceiveCount           int64  `json:"maxReceiveCount"`
+	FifoQueue                 *bool  `json:"fifoQueue"`
+	DeduplicationWindow       int64  `json:"deduplicationWindow"`
+	ContentBasedDeduplication bool   `json:"contentBasedDeduplication"`
+	PriorityAging             int64  `json:"priorityAging"`
+}
+
+func (this v1QueueRequest) option() OptionQueue {
+	optionQueue := OptionQueue{this.QueueName, toString(this.VisibilityTimeout), toString(this.MessageRetentionPeriod),
+		toString(this.DelaySeconds), this.DeadLetterQueue, toString(this.MaxReceiveCount), "", toString(this.DeduplicationWindow),
+		strconv.FormatBool(this.ContentBasedDeduplication), toString(this.PriorityAging)}
+	if this.FifoQueue != nil {
+		optionQueue.FifoQueue = strconv.FormatBool(*this.FifoQueue)
+	}
+	return optionQueue
+}
+
+//入列的单条消息
+type v1MessageRequest struct {
+	Body            string            `json:"body"`
+	DelaySeconds    int64             `json:"delaySeconds"`
+	MessageGroupId  string            `json:"messageGroupId"`
+	DeduplicationId string            `json:"deduplicationId"`
+	Priority        int               `json:"priority"`
+	Attributes      map[string]string `json:"attributes"`
+	DeliverAt       v1DeliverAt       `json:"deliverAt"`
+}
+
+//到期时间，json中为RFC3339字符串或毫秒时间戳数字
+type v1DeliverAt string
+
+func (this *v1DeliverAt) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*this = v1DeliverAt(value)
+		return nil
+	}
+
+	var millis json.Number
+	if err := json.Unmarshal(data, &millis); err != nil {
+		return err
+	}
+	*this = v1DeliverAt(millis)
+	return nil
+}
+
+func (this v1MessageRequest) entry() PushEntry {
+	entry := PushEntry{this.Body, toString(this.DelaySeconds), this.MessageGroupId, this.DeduplicationId, strconv.Itoa(this.Priority), "", string(this.DeliverAt), nil}
+	if len(this.Attributes) != 0 {
+		attributes, err := json.Marshal(this.Attributes)
+		must(err)
+		entry.Attributes = string(attributes)
+	}
+	return entry
+}
+
+//GET /v1/queues?prefix=&nextToken=&maxResults=
+func v1ListQueues(res http.ResponseWriter, req *http.Request, params []string) {
+	query := req.URL.Query()
+	maxResults, _ := strconv.Atoi(query.Get("maxResults"))
+	if maxResults < 0 || maxResults > maxListResults {
+		v1Error(res, http.StatusBadRequest, "maxResults must be between 1 and "+strconv.Itoa(maxListResults))
+		return
+	}
+
+	names, next, err := Queue.List(query.Get("prefix"), query.Get("nextToken"), maxResults)
+	if err != nil {
+		v1Fail(res, err)
+		return
+	}
+	if names == nil {
+		names = []string{}
+	}
+	v1Write(res, http.StatusOK, map[string]interface{}{"queueNames": names, "nextToken": next})
+}
+
+//POST /v1/queues
+func v1CreateQueue(res http.ResponseWriter, req *http.Request, params []string) {
+	var request v1QueueRequest
+	if !v1Read(res, req, &request) {
+		return
+	}
+	if request.QueueName == "" {
+		v1Error(res, http.StatusBadRequest, "queueName must not be null")
+		return
+	}
+
+	if err := YumiQ.Create(request.option()); err != nil {
+		v1Fail(res, err)
+		return
+	}
+	v1Write(res, http.StatusCreated, map[string]string{"queueName": request.QueueName})
+}
+
+//GET /v1/queues/{name}
+func v1GetQueue(res http.ResponseWriter, req *http.Request, params []string) {
+	attributes, err := YumiQ.GetQueueAttributes(params[0])
+	if err != nil {
+		v1Fail(res, err)
+		return
+	}
+	v1Write(res, http.StatusOK, attributes)
+}
+
+//PUT /v1/queues/{name}，请求体中的queueName被忽略
+func v1UpdateQueue(res http.ResponseWriter, req *http.Request, params []string) {
+	var request v1QueueRequest
+	if !v1Read(res, req, &request) {
+		return
+	}
+	request.QueueName = params[0]
+
+	if err := YumiQ.Update(request.option()); err != nil {
+		v1Fail(res, err)
+		return
+	}
+	v1Write(res, http.StatusOK, map[string]string{"queueName": request.QueueName})
+}
+
+//DELETE /v1/queues/{name}
+func v1DeleteQueue(res http.ResponseWriter, req *http.Request, params []string) {
+	if exists, _ := Queue.queueExists(params[0]); !exists {
+		v1Error(res, http.StatusNotFound, "Queue "+params[0]+" doesn't exist")
+		return
+	}
+
+	if err := YumiQ.DelQueue(params[0]); err != nil {
+		v1Fail(res, err)
+		return
+	}
+	v1Write(res, http.StatusNoContent, nil)
+}
+
+//POST /v1/queues/{name}/redrive
+func v1Redrive(res http.ResponseWriter, req *http.Request, params []string) {
+	var request struct {
+		SourceQueue string `json:"sourceQueue"`
+		MaxMessages int64  `json:"maxMessages"`
+	}
+	if !v1Read(res, req, &request) {
+		return
+	}
+
+	count, err := YumiQ.Redrive(params[0], request.SourceQueue, request.MaxMessages)
+	if err != nil {
+		v1Fail(res, err)
+		return
+	}
+	v1Write(res, http.StatusOK, map[string]int64{"count": count})
+}
+
+//POST /v1/queues/{name}/purge，清空队列中的消息
+func v1Purge(res http.ResponseWriter, req *http.Request, params []string) {
+	if err := YumiQ.Purge(params[0]); err != nil {
+		v1Fail(res, err)
+		return
+	}
+	v1Write(res, http.StatusNoContent, nil)
+}
+
+//GET /v1/queues/{name}/messages?maxMessages=，查看准备队列中的消息，不改变消息状态
+func v1Peek(res http.ResponseWriter, req *http.Request, params []string) {
+	maxMessages, _ := strconv.Atoi(req.URL.Query().Get("maxMessages"))
+	if maxMessages < 0 {
+		v1Error(res, http.StatusBadRequest, "maxMessages must not be less than zero")
+		return
+	}
+
+	messages, err := YumiQ.Peek(params[0], maxMessages)
+	if err != nil {
+		v1Fail(res, err)
+		return
+	}
+	if messages == nil {
+		messages = []Message{}
+	}
+	v1Write(res, http.StatusOK, map[string]interface{}{"messages": messages})
+}
+
+//POST /v1/queues/{name}/messages，去重时返回之前入列的消息ID
+func v1Push(res http.ResponseWriter, req *http.Request, params []string) {
+	var request v1MessageRequest
+	if !v1Read(res, req, &request) {
+		return
+	}
+	if request.Body == "" {
+		v1Error(res, http.StatusBadRequest, "body must not be null")
+		return
+	}
+	if !v1QueueExists(res, params[0]) {
+		return
+	}
+
+	id, err := YumiQ.Push(params[0], request.entry())
+	if err != nil {
+		v1Fail(res, err)
+		return
+	}
+	v1Write(res, http.StatusCreated, map[string]string{"messageId": id})
+}
+
+type v1BatchEntryResult struct {
+	Index     int    `json:"index"`
+	MessageId string `json:"messageId,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+//POST /v1/queues/{name}/messages/batch，单条消息的错误放在对应的结果中，index从0开始
+func v1PushBatch(res http.ResponseWriter, req *http.Request, params []string) {
+	var request struct {
+		Messages []v1MessageRequest `json:"messages"`
+	}
+	if !v1Read(res, req, &request) {
+		return
+	}
+	if len(request.Messages) == 0 {
+		v1Error(res, http.StatusBadRequest, "messages must not be empty")
+		return
+	}
+	if !v1QueueExists(res, params[0]) {
+		return
+	}
+
+	entries := make([]PushEntry, len(request.Messages))
+	for i, message := range request.Messages {
+		entries[i] = message.entry()
+	}
+	ids, errs, err := YumiQ.PushBatch(params[0], entries)
+	if err != nil {
+		v1Fail(res, err)
+		return
+	}
+
+	results := make([]v1BatchEntryResult, len(entries))
+	for i := range entries {
+		if errs[i] != nil {
+			results[i] = v1BatchEntryResult{i, "", errs[i].Error()}
+		} else {
+			results[i] = v1BatchEntryResult{i, ids[i], ""}
+		}
+	}
+	v1Write(res, http.StatusOK, map[string]interface{}{"entries": results})
+}
+
+//POST /v1/queues/{name}/messages/receive，没有消息时返回空列表
+func v1Receive(res http.ResponseWriter, req *http.Request, params []string) {
+	var request struct {
+		WaitSeconds int `json:"waitSeconds"`
+		MaxMessages int `json:"maxMessages"`
+	}
+	if !v1Read(res, req, &request) {
+		return
+	}
+	if request.WaitSeconds < 0 || request.MaxMessages < 0 {
+		v1Error(res, http.StatusBadRequest, "waitSeconds and maxMessages must not be less than zero")
+		return
+	}
+
+	messages, err := YumiQ.Pop(params[0], request.WaitSeconds, request.MaxMessages)
+	if errorKind(err) == ErrEmpty {
+		messages, err = []Message{}, nil
+	}
+	if err != nil {
+		v1Fail(res, err)
+		return
+	}
+	v1Write(res, http.StatusOK, map[string]interface{}{"messages": messages})
+}
+
+//DELETE /v1/queues/{name}/messages/{receipt}
+func v1DeleteMessage(res http.ResponseWriter, req *http.Request, params []string) {
+	if !v1QueueExists(res, params[0]) {
+		return
+	}
+
+	if err := YumiQ.Del(params[0], params[1]); err != nil {
+		v1Fail(res, err)
+		return
+	}
+	v1Write(res, http.StatusNoContent, nil)
+}
+
+//GET /v1/queues/{name}/scheduled?maxMessages=，按到期时间先后查看尚未到期的延迟消息
+func v1ListScheduled(res http.ResponseWriter, req *http.Request, params []string) {
+	maxMessages, _ := strconv.Atoi(req.URL.Query().Get("maxMessages"))
+	if maxMessages < 0 {
+		v1Error(res, http.StatusBadRequest, "maxMessages must not be less than zero")
+		return
+	}
+
+	messages, err := YumiQ.Scheduled(params[0], maxMessages)
+	if err != nil {
+		v1Fail(res, err)
+		return
+	}
+	if messages == nil {
+		messages = []ScheduledMessage{}
+	}
+	v1Write(res, http.StatusOK, map[string]interface{}{"messages": messages})
+}
+
+//DELETE /v1/queues/{name}/scheduled/{id}，消息已到期或已被接收过时返回404
+func v1CancelScheduled(res http.ResponseWriter, req *http.Request, params []string) {
+	if err := YumiQ.CancelScheduled(params[0], params[1]); err != nil {
+		v1Fail(res, err)
+		return
+	}
+	v1Write(res, http.StatusNoContent, nil)
+}
+
+//PUT /v1/queues/{name}/messages/{receipt}/visibility，visibilityTimeout为0时按队列的隐藏时间
+func v1ChangeVisibility(res http.ResponseWriter, req *http.Request, params []string) {
+	var request struct {
+		VisibilityTimeout int64 `json:"visibilityTimeout"`
+	}
+	if !v1Read(res, req, &request) {
+		return
+	}
+	if request.VisibilityTimeout < 0 {
+		v1Error(res, http.StatusBadRequest, "visibilityTimeout must not be less than zero")
+		return
+	}
+	if !v1QueueExists(res, params[0]) {
+		return
+	}
+
+	if err := YumiQ.SetVisibilityTime(params[0], params[1], request.VisibilityTimeout); err != nil {
+		v1Fail(res, err)
+		return
+	}
+	v1Write(res, http.StatusNoContent, nil)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//使用内存存储，不需要redis
+func v1TestSetup(t *testing.T) {
+	Store = NewMemoryStorage()
+	MaxBatch = 10
+	YumiQ = NewYumi()
+	Queue = NewQueues()
+	if err := Queue.init(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func v1TestDo(method string, path string, body string) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	(&WaitForYou{}).ServeHTTP(rec, req)
+	return rec.Code
+}
+
+//返回状态码和解析后的json
+func v1TestCall(t *testing.T, method string, path string, body string) (int, map[string]interface{}, http.Header) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	(&WaitForYou{}).ServeHTTP(rec, req)
+
+	var result map[string]interface{}
+	if rec.Body.Len() != 0 {
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%s %s: %s", method, path, rec.Body.String())
+		}
+	}
+	return rec.Code, result, rec.Header()
+}
+
+//创建返回201时队列已可用
+func TestV1CreatedQueueAvailable(t *testing.T) {
+	v1TestSetup(t)
+
+	if code := v1TestDo("POST", "/v1/queues", `{"queueName":"q","visibilityTimeout":30}`); code != http.StatusCreated {
+		t.Fatalf("create queue: %d", code)
+	}
+	if code := v1TestDo("GET", "/v1/queues/q", ""); code != http.StatusOK {
+		t.Fatalf("get created queue: %d", code)
+	}
+	if code := v1TestDo("POST", "/v1/queues/q/messages", `{"body":"hello"}`); code != http.StatusCreated {
+		t.Fatalf("push to created queue: %d", code)
+	}
+}
+
+func TestV1DeletedQueueNotFound(t *testing.T) {
+	v1TestSetup(t)
+
+	if code := v1TestDo("POST", "/v1/queues", `{"queueName":"q","visibilityTimeout":30}`); code != http.StatusCreated {
+		t.Fatalf("create queue: %d", code)
+	}
+
+	if code := v1TestDo("DELETE", "/v1/queues/q", ""); code != http.StatusNoContent {
+		t.Fatalf("delete queue: %d", code)
+	}
+	if code := v1TestDo("GET", "/v1/queues/q", ""); code != http.StatusNotFound {
+		t.Fatalf("get deleted queue: %d", code)
+	}
+	if code := v1TestDo("POST", "/v1/queues/q/messages", `{"body":"hello"}`); code != http.StatusNotFound {
+		t.Fatalf("push to deleted queue: %d", code)
+	}
+	if stats, _ := Store.Stats("q"); stats.Ready != 0 {
+		t.Fatalf("message stored for deleted queue: %+v", stats)
+	}
+}
+
+//接收的消息按回执修改隐藏时间和删除，回执无效时返回400，已删除时返回404
+func TestV1Messages(t *testing.T) {
+	v1TestSetup(t)
+	v1TestDo("POST", "/v1/queues", `{"queueName":"q","visibilityTimeout":30}`)
+
+	code, result, _ := v1TestCall(t, "POST", "/v1/queues/q/messages", `{"body":"x","attributes":{"a":"b"}}`)
+	if code != http.StatusCreated || result["messageId"] == "" {
+		t.Fatalf("push: %d %v", code, result)
+	}
+	code, result, _ = v1TestCall(t, "POST", "/v1/queues/q/messages/batch", `{"messages":[{"body":"y"},{"body":""}]}`)
+	if entries, _ := result["entries"].([]interface{}); code != http.StatusOK || len(entries) != 2 {
+		t.Fatalf("push batch: %d %v", code, result)
+	}
+
+	code, result, _ = v1TestCall(t, "POST", "/v1/queues/q/messages/receive", `{"maxMessages":5}`)
+	messages, _ := result["messages"].([]interface{})
+	if code != http.StatusOK || len(messages) != 2 {
+		t.Fatalf("receive: %d %v", code, result)
+	}
+	first := messages[0].(map[string]interface{})
+	if attributes, _ := first["attributes"].(map[string]interface{}); attributes["a"] != "b" {
+		t.Fatalf("received message: %v", first)
+	}
+	receipt := first["receiptHandle"].(string)
+	if code := v1TestDo("PUT", "/v1/queues/q/messages/"+receipt+"/visibility", `{"visibilityTimeout":60}`); code != http.StatusNoContent {
+		t.Fatalf("change visibility: %d", code)
+	}
+	if code := v1TestDo("DELETE", "/v1/queues/q/messages/"+receipt, ""); code != http.StatusNoContent {
+		t.Fatalf("delete: %d", code)
+	}
+	if code := v1TestDo("DELETE", "/v1/queues/q/messages/"+receipt, ""); code != http.StatusNotFound {
+		t.Fatalf("delete twice: %d", code)
+	}
+	if code := v1TestDo("DELETE", "/v1/queues/q/messages/bad", ""); code != http.StatusBadRequest {
+		t.Fatalf("delete with bad receipt: %d", code)
+	}
+
+	code, result, _ = v1TestCall(t, "POST", "/v1/queues/q/messages/receive", "")
+	if messages, _ := result["messages"].([]interface{}); code != http.StatusOK || len(messages) != 0 {
+		t.Fatalf("receive from empty queue: %d %v", code, result)
+	}
+}
+
+//请求错误按错误类型返回状态码，不支持的方法返回405和Allow
+func TestV1Errors(t *testing.T) {
+	v1TestSetup(t)
+	v1TestDo("POST", "/v1/queues", `{"queueName":"q","visibilityTimeout":30}`)
+
+	for _, c := range []struct {
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"POST", "/v1/queues", `{"queueName":"q","visibilityTimeout":30}`, http.StatusConflict},
+		{"POST", "/v1/queues", `{"queueName":"z"}`, http.StatusBadRequest},
+		{"POST", "/v1/queues", `{bad`, http.StatusBadRequest},
+		{"GET", "/v1/queues/missing", "", http.StatusNotFound},
+		{"POST", "/v1/queues/missing/messages", `{"body":"x"}`, http.StatusNotFound},
+		{"POST", "/v1/queues/q/messages", `{"body":"x","priority":12}`, http.StatusBadRequest},
+		{"GET", "/v1/nothing", "", http.StatusNotFound},
+	} {
+		code, result, _ := v1TestCall(t, c.method, c.path, c.body)
+		if code != c.code || result["error"] == nil {
+			t.Fatalf("%s %s %s: %d %v", c.method, c.path, c.body, code, result)
+		}
+	}
+
+	code, _, header := v1TestCall(t, "PATCH", "/v1/queues/q", "")
+	if code != http.StatusMethodNotAllowed || header.Get("Allow") != "DELETE, GET, PUT" {
+		t.Fatalf("unsupported method: %d %q", code, header.Get("Allow"))
+	}
+
+	if code := v1TestDo("PUT", "/v1/queues/q", `{"visibilityTimeout":10}`); code != http.StatusOK {
+		t.Fatalf("update queue: %d", code)
+	}
+	code, result, _ := v1TestCall(t, "GET", "/v1/queues/q", "")
+	if code != http.StatusOK || result["visibilityTimeout"] != "10" {
+		t.Fatalf("get updated queue: %d %v", code, result)
+	}
+	code, result, _ = v1TestCall(t, "GET", "/v1/queues?prefix=q", "")
+	if names, _ := result["queueNames"].([]interface{}); code != http.StatusOK || len(names) != 1 {
+		t.Fatalf("list queues: %d %v", code, result)
+	}
+}
+
+//deliverAt可以是毫秒时间戳数字，列出和取消延迟消息
+func TestV1Scheduled(t *testing.T) {
+	v1TestSetup(t)
+	v1TestDo("POST", "/v1/queues", `{"queueName":"q","visibilityTimeout":30}`)
+
+	millis := time.Now().Add(100*time.Second).UnixNano() / 1e6
+	code, result, _ := v1TestCall(t, "POST", "/v1/queues/q/messages", `{"body":"x","deliverAt":`+strconv.FormatInt(millis, 10)+`}`)
+	if code != http.StatusCreated {
+		t.Fatalf("push: %d %v", code, result)
+	}
+	id := result["messageId"]
+	if code := v1TestDo("POST", "/v1/queues/q/messages", `{"body":"x","deliverAt":"nope"}`); code != http.StatusBadRequest {
+		t.Fatalf("push with bad deliverAt: %d", code)
+	}
+	v1TestDo("POST", "/v1/queues/q/messages", `{"body":"y","deliverAt":"`+time.Now().Add(200*time.Second).Format(time.RFC3339)+`"}`)
+
+	code, result, _ = v1TestCall(t, "GET", "/v1/queues/q/scheduled?maxMessages=1", "")
+	messages, _ := result["messages"].([]interface{})
+	if code != http.StatusOK || len(messages) != 1 || messages[0].(map[string]interface{})["messageId"] != id {
+		t.Fatalf("list scheduled: %d %v", code, result)
+	}
+	if code := v1TestDo("GET", "/v1/queues/q/scheduled?maxMessages=-1", ""); code != http.StatusBadRequest {
+		t.Fatalf("list with negative maxMessages: %d", code)
+	}
+	if code := v1TestDo("DELETE", "/v1/queues/q/scheduled/"+id.(string), ""); code != http.StatusNoContent {
+		t.Fatalf("cancel: %d", code)
+	}
+	if code := v1TestDo("DELETE", "/v1/queues/q/scheduled/"+id.(string), ""); code != http.StatusNotFound {
+		t.Fatalf("cancel twice: %d", code)
+	}
+	if code := v1TestDo("GET", "/v1/queues/missing/scheduled", ""); code != http.StatusNotFound {
+		t.Fatalf("list missing queue: %d", code)
+	}
+}
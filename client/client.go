@@ -0,0 +1,410 @@
+//yumiQ的Go客户端，封装/v1/下的JSON接口
+//
+//	c := client.New("http://localhost:9394")
+//	id, err := c.Push(ctx, "orders", client.PushMessage{Body: "hello"})
+//
+//所有方法都接受context，网络错误和5xx响应按指数退避重试，4xx响应直接返回*Error
+//出列只在连接失败时重试，请求发出后的错误直接返回
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultMaxRetries = 3
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 5 * time.Second
+)
+
+//客户端可以在多个goroutine中共用，底层连接复用
+//入列重试可能产生重复消息，需要时设置DeduplicationId，服务端在去重窗口内只入列一次
+//出列请求发出后失败时服务端可能已取出消息，重试会让这些消息在隐藏时间内无法再被接收，所以不重试，由调用方再次出列
+type Client struct {
+	Endpoint   string       //服务地址，如 http://localhost:9394
+	HTTPClient *http.Client //为空时使用New创建的连接池
+	MaxRetries int          //失败后最多重试次数，为0时不重试
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	Header     http.Header //每个请求都带上的请求头，如经过认证代理时的Authorization
+}
+
+//创建客户端，使用默认的重试次数和退避时间
+func New(endpoint string) *Client {
+	return &Client{strings.TrimRight(endpoint, "/"), newHTTPClient(), DefaultMaxRetries, DefaultMinBackoff, DefaultMaxBackoff, nil}
+}
+
+//长轮询的请求要保持连接，不设置整体超时，由context控制
+func newHTTPClient() *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+	}}
+}
+
+//服务端返回的错误
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (this *Error) Error() string {
+	return fmt.Sprintf("yumiq: %d %s", this.StatusCode, this.Message)
+}
+
+//队列或回执不存在
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+//队列已存在，或消息已不在隐藏中
+func IsConflict(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusConflict
+}
+
+//创建和修改队列的参数，FifoQueue为空时修改保持原来的类型
+type QueueOptions struct {
+	QueueName                 string `json:"queueName"`
+	VisibilityTimeout         int64  `json:"visibilityTimeout"`
+	MessageRetentionPeriod    int64  `json:"messageRetentionPeriod"`
+	DelaySeconds              int64  `json:"delaySeconds"`
+	DeadLetterQueue           string `json:"deadLetterQueue"`
+	MaxReceiveCount           int64  `json:"maxReceiveCount"`
+	FifoQueue                 *bool  `json:"fifoQueue,omitempty"`
+	DeduplicationWindow       int64  `json:"deduplicationWindow"`
+	ContentBasedDeduplication bool   `json:"contentBasedDeduplication"`
+	PriorityAging             int64  `json:"priorityAging"`
+}
+
+//队列属性，配置和服务端保存的一样都是字符串，消息数为近似值
+type QueueAttributes struct {
+	QueueName                 string `json:"queueName"`
+	VisibilityTimeout         string `json:"visibilityTimeout"`
+	MessageRetentionPeriod    string `json:"messageRetentionPeriod"`
+	DelaySeconds              string `json:"delaySeconds"`
+	DeadLetterQueue           string `json:"deadLetterQueue"`
+	MaxReceiveCount           string `json:"maxReceiveCount"`
+	FifoQueue                 string `json:"fifoQueue"`
+	DeduplicationWindow       string `json:"deduplicationWindow"`
+	ContentBasedDeduplication string `json:"contentBasedDeduplication"`
+	PriorityAging             string `json:"priorityAging"`
+	CreatedTimestamp          string `json:"createdTimestamp"`
+	LastModifiedTimestamp     string `json:"lastModifiedTimestamp"`
+	ReadyMessages             int64  `json:"readyMessages"`
+	DelayedMessages           int64  `json:"delayedMessages"`
+	InFlightMessages          int64  `json:"inFlightMessages"`
+	OldestMessageAge          int64  `json:"oldestMessageAge"`
+}
+
+//入列的消息
+type PushMessage struct {
+	Body            string            `json:"body"`
+	DelaySeconds    int64             `json:"delaySeconds,omitempty"`
+	MessageGroupId  string            `json:"messageGroupId,omitempty"`  //只用于FIFO队列
+	DeduplicationId string            `json:"deduplicationId,omitempty"` //去重窗口内相同去重ID的消息只入列一次
+	Priority        int               `json:"priority,omitempty"`        //0-9，数值大的先出列
+	Attributes      map[string]string `json:"attributes,omitempty"`
+	DeliverAt       *time.Time        `json:"deliverAt,omitempty"` //到期时间，不能与DelaySeconds同时指定，不能晚于队列的消息保留时间
+}
+
+//批量入列中单条消息的结果，Index为在请求中的位置，Error不为空时该条入列失败
+type BatchEntry struct {
+	Index     int    `json:"index"`
+	MessageId string `json:"messageId"`
+	Error     string `json:"error"`
+}
+
+//出列的消息，删除和修改隐藏时间使用ReceiptHandle
+type Message struct {
+	MessageId                        string            `json:"messageId"`
+	ReceiptHandle                    string            `json:"receiptHandle"`
+	Body                             string            `json:"body"`
+	Attributes                       map[string]string `json:"attributes"`
+	SentTimestamp                    int64             `json:"sentTimestamp"`
+	ApproximateFirstReceiveTimestamp int64             `json:"approximateFirstReceiveTimestamp"`
+	ApproximateReceiveCount          int64             `json:"approximateReceiveCount"`
+}
+
+//尚未到期的延迟消息，时间戳为秒
+type ScheduledMessage struct {
+	MessageId        string            `json:"messageId"`
+	Body             string            `json:"body"`
+	Attributes       map[string]string `json:"attributes"`
+	SentTimestamp    int64             `json:"sentTimestamp"`
+	DeliverTimestamp int64             `json:"deliverTimestamp"`
+}
+
+//发送请求，body不为空时编码为JSON，result不为空时解码响应
+func (this *Client) do(ctx context.Context, method string, path string, body interface{}, result interface{}) error {
+	return this.send(ctx, method, path, body, result, true)
+}
+
+//ambiguous为false时只重试没有发出的请求，用于重试有副作用的请求
+func (this *Client) send(ctx context.Context, method string, path string, body interface{}, result interface{}, ambiguous bool) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = this.once(ctx, method, path, payload, result)
+		if !retry || attempt >= this.MaxRetries || (!ambiguous && !dialError(err)) {
+			return err
+		}
+		if err := this.sleep(ctx, attempt); err != nil {
+			return err
+		}
+	}
+}
+
+//发送一次请求，返回是否可以重试
+func (this *Client) once(ctx context.Context, method string, path string, payload []byte, result interface{}) (bool, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, this.Endpoint+path, body)
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	for name, values := range this.Header {
+		req.Header[name] = values
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := this.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+
+	if res.StatusCode >= 300 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &e) != nil || e.Error == "" {
+			e.Error = strings.TrimSpace(string(data))
+		}
+		return res.StatusCode >= 500, &Error{res.StatusCode, e.Error}
+	}
+	if result != nil && len(data) != 0 {
+		return false, json.Unmarshal(data, result)
+	}
+	return false, nil
+}
+
+//连接失败，请求没有发出
+func dialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+//第attempt次重试前等待，退避时间按指数增长并加入随机抖动
+func (this *Client) sleep(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(this.backoff(attempt))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (this *Client) backoff(attempt int) time.Duration {
+	minBackoff, maxBackoff := this.MinBackoff, this.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = DefaultMinBackoff
+	}
+	if maxBackoff < minBackoff {
+		maxBackoff = minBackoff
+	}
+
+	backoff := maxBackoff
+	if attempt < 30 && minBackoff<<uint(attempt) < maxBackoff {
+		backoff = minBackoff << uint(attempt)
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func queuePath(queueName string) string {
+	return "/v1/queues/" + url.PathEscape(queueName)
+}
+
+//检查服务是否可用
+func (this *Client) Ping(ctx context.Context) error {
+	return this.do(ctx, "GET", "/ping", nil, nil)
+}
+
+func (this *Client) CreateQueue(ctx context.Context, options QueueOptions) error {
+	return this.do(ctx, "POST", "/v1/queues", options, nil)
+}
+
+//修改队列配置，没有设置的配置按零值保存
+func (this *Client) UpdateQueue(ctx context.Context, options QueueOptions) error {
+	return this.do(ctx, "PUT", queuePath(options.QueueName), options, nil)
+}
+
+func (this *Client) GetQueueAttributes(ctx context.Context, queueName string) (*QueueAttributes, error) {
+	var attributes QueueAttributes
+	if err := this.do(ctx, "GET", queuePath(queueName), nil, &attributes); err != nil {
+		return nil, err
+	}
+	return &attributes, nil
+}
+
+//按队列名排序列出以prefix开头的队列，next不为空时还有下一页
+func (this *Client) ListQueues(ctx context.Context, prefix string, nextToken string, maxResults int) (names []string, next string, err error) {
+	query := url.Values{}
+	query.Set("prefix", prefix)
+	query.Set("nextToken", nextToken)
+	if maxResults > 0 {
+		query.Set("maxResults", strconv.Itoa(maxResults))
+	}
+
+	var result struct {
+		QueueNames []string `json:"queueNames"`
+		NextToken  string   `json:"nextToken"`
+	}
+	if err = this.do(ctx, "GET", "/v1/queues?"+query.Encode(), nil, &result); err != nil {
+		return
+	}
+	return result.QueueNames, result.NextToken, nil
+}
+
+//删除队列及其中的消息
+func (this *Client) DeleteQueue(ctx context.Context, queueName string) error {
+	return this.do(ctx, "DELETE", queuePath(queueName), nil, nil)
+}
+
+//死信队列中的消息移回来源队列，sourceQueue不为空时只移回来自该队列的消息，maxMessages为0时不限条数
+func (this *Client) Redrive(ctx context.Context, queueName string, sourceQueue string, maxMessages int64) (int64, error) {
+	request := map[string]interface{}{"sourceQueue": sourceQueue, "maxMessages": maxMessages}
+	var result struct {
+		Count int64 `json:"count"`
+	}
+	if err := this.do(ctx, "POST", queuePath(queueName)+"/redrive", request, &result); err != nil {
+		return 0, err
+	}
+	return result.Count, nil
+}
+
+//清空队列中的消息，保留队列
+func (this *Client) Purge(ctx context.Context, queueName string) error {
+	return this.do(ctx, "POST", queuePath(queueName)+"/purge", nil, nil)
+}
+
+//按出列顺序查看准备队列中的消息，不改变消息状态，返回的消息没有回执
+func (this *Client) Peek(ctx context.Context, queueName string, maxMessages int) ([]Message, error) {
+	path := queuePath(queueName) + "/messages"
+	if maxMessages > 0 {
+		path += "?maxMessages=" + strconv.Itoa(maxMessages)
+	}
+	var result struct {
+		Messages []Message `json:"messages"`
+	}
+	if err := this.do(ctx, "GET", path, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Messages, nil
+}
+
+//按到期时间先后查看尚未到期的延迟消息，不包括隐藏中的消息
+func (this *Client) ListScheduled(ctx context.Context, queueName string, maxMessages int) ([]ScheduledMessage, error) {
+	path := queuePath(queueName) + "/scheduled"
+	if maxMessages > 0 {
+		path += "?maxMessages=" + strconv.Itoa(maxMessages)
+	}
+	var result struct {
+		Messages []ScheduledMessage `json:"messages"`
+	}
+	if err := this.do(ctx, "GET", path, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Messages, nil
+}
+
+//按消息ID取消尚未到期的延迟消息，消息已到期或不存在时返回的错误满足IsNotFound
+func (this *Client) CancelScheduled(ctx context.Context, queueName string, messageId string) error {
+	return this.do(ctx, "DELETE", queuePath(queueName)+"/scheduled/"+url.PathEscape(messageId), nil, nil)
+}
+
+//入列，返回消息ID，去重时返回之前入列的消息ID
+func (this *Client) Push(ctx context.Context, queueName string, message PushMessage) (string, error) {
+	var result struct {
+		MessageId string `json:"messageId"`
+	}
+	if err := this.do(ctx, "POST", queuePath(queueName)+"/messages", message, &result); err != nil {
+		return "", err
+	}
+	return result.MessageId, nil
+}
+
+//批量入列，单条消息的错误放在对应的BatchEntry中
+func (this *Client) PushBatch(ctx context.Context, queueName string, messages []PushMessage) ([]BatchEntry, error) {
+	var result struct {
+		Entries []BatchEntry `json:"entries"`
+	}
+	if err := this.do(ctx, "POST", queuePath(queueName)+"/messages/batch", map[string]interface{}{"messages": messages}, &result); err != nil {
+		return nil, err
+	}
+	return result.Entries, nil
+}
+
+//出列最多maxMessages条消息，没有消息时最多等待waitSeconds秒，仍没有时返回空列表
+//只在连接失败时重试，见Client的说明
+func (this *Client) Pop(ctx context.Context, queueName string, waitSeconds int, maxMessages int) ([]Message, error) {
+	request := map[string]int{"waitSeconds": waitSeconds, "maxMessages": maxMessages}
+	var result struct {
+		Messages []Message `json:"messages"`
+	}
+	if err := this.send(ctx, "POST", queuePath(queueName)+"/messages/receive", request, &result, false); err != nil {
+		return nil, err
+	}
+	return result.Messages, nil
+}
+
+//根据回执删除消息
+func (this *Client) Delete(ctx context.Context, queueName string, receiptHandle string) error {
+	return this.do(ctx, "DELETE", queuePath(queueName)+"/messages/"+url.PathEscape(receiptHandle), nil, nil)
+}
+
+//修改消息的隐藏时间，为0时按队列的隐藏时间
+func (this *Client) ChangeVisibility(ctx context.Context, queueName string, receiptHandle string, visibilityTimeout int64) error {
+	request := map[string]int64{"visibilityTimeout": visibilityTimeout}
+	return this.do(ctx, "PUT", queuePath(queueName)+"/messages/"+url.PathEscape(receiptHandle)+"/visibility", request, nil)
+}
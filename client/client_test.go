@@ -0,0 +1,230 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//按顺序返回codes中的状态码，用完后返回最后一个，记录请求次数
+func testServer(t *testing.T, codes ...int) (*Client, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1))
+		if n > len(codes) {
+			n = len(codes)
+		}
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(codes[n-1])
+		if codes[n-1] >= 300 {
+			res.Write([]byte(`{"error":"failed"}`))
+		} else {
+			res.Write([]byte(`{"messageId":"m1","messages":[{"messageId":"m1","body":"hello"}]}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	c := New(srv.URL)
+	c.MinBackoff, c.MaxBackoff = time.Millisecond, 2*time.Millisecond
+	return c, &calls
+}
+
+func TestPushRetriesServerErrors(t *testing.T) {
+	c, calls := testServer(t, 503, 500, 201)
+
+	id, err := c.Push(context.Background(), "q", PushMessage{Body: "hello"})
+	if err != nil || id != "m1" {
+		t.Fatalf("push: %q %v", id, err)
+	}
+	if *calls != 3 {
+		t.Fatalf("push sent %d requests, want 3", *calls)
+	}
+}
+
+func TestRetriesAtMostMaxRetries(t *testing.T) {
+	c, calls := testServer(t, 503)
+
+	_, err := c.Push(context.Background(), "q", PushMessage{Body: "hello"})
+	if e, ok := err.(*Error); !ok || e.StatusCode != 503 || e.Message != "failed" {
+		t.Fatalf("push: %v", err)
+	}
+	if *calls != int32(DefaultMaxRetries+1) {
+		t.Fatalf("push sent %d requests, want %d", *calls, DefaultMaxRetries+1)
+	}
+}
+
+func TestClientErrorsNotRetried(t *testing.T) {
+	c, calls := testServer(t, 404)
+
+	if _, err := c.Push(context.Background(), "q", PushMessage{Body: "hello"}); !IsNotFound(err) {
+		t.Fatalf("push: %v", err)
+	}
+	if *calls != 1 {
+		t.Fatalf("push sent %d requests, want 1", *calls)
+	}
+}
+
+//请求已发出后的失败不重试出列，服务端可能已取出消息
+func TestPopNotRetriedAfterSent(t *testing.T) {
+	c, calls := testServer(t, 503, 200)
+
+	if _, err := c.Pop(context.Background(), "q", 0, 1); err == nil {
+		t.Fatal("pop: want the server error")
+	}
+	if *calls != 1 {
+		t.Fatalf("pop sent %d requests, want 1", *calls)
+	}
+
+	messages, err := c.Pop(context.Background(), "q", 0, 1)
+	if err != nil || len(messages) != 1 || messages[0].Body != "hello" {
+		t.Fatalf("pop: %v %v", messages, err)
+	}
+}
+
+//连接失败时请求没有发出，出列也重试
+func TestPopRetriesDialErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	c := New("http://" + addr)
+	c.MinBackoff, c.MaxBackoff = time.Millisecond, 2*time.Millisecond
+	var dials int32
+	c.HTTPClient = &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}}}
+
+	if _, err := c.Pop(context.Background(), "q", 0, 1); err == nil {
+		t.Fatal("pop: want the dial error")
+	}
+	if dials != int32(DefaultMaxRetries+1) {
+		t.Fatalf("pop dialed %d times, want %d", dials, DefaultMaxRetries+1)
+	}
+}
+
+func TestRetryStopsWhenContextDone(t *testing.T) {
+	c, calls := testServer(t, 503)
+	c.MinBackoff, c.MaxBackoff = time.Hour, time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.Push(ctx, "q", PushMessage{Body: "hello"}); err != context.DeadlineExceeded {
+		t.Fatalf("push: %v", err)
+	}
+	if *calls != 1 {
+		t.Fatalf("push sent %d requests, want 1", *calls)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := &Client{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		for i := 0; i < 20; i++ {
+			if backoff := c.backoff(attempt); backoff < want/2 || backoff > want {
+				t.Fatalf("attempt %d: backoff %s, want between %s and %s", attempt, backoff, want/2, want)
+			}
+		}
+	}
+	if backoff := c.backoff(100); backoff > time.Second {
+		t.Fatalf("attempt 100: backoff %s", backoff)
+	}
+}
+
+//队列名和回执按路径转义，请求带Client.Header和json的Content-Type
+func TestRequestPaths(t *testing.T) {
+	var requests []string
+	var bodies []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.Method+" "+req.URL.EscapedPath()+" "+req.Header.Get("Authorization")+" "+req.Header.Get("Content-Type"))
+		var body map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&body)
+		bodies = append(bodies, body)
+		res.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	c.Header = http.Header{"Authorization": []string{"Bearer t"}}
+	ctx := context.Background()
+	c.Push(ctx, "a/b", PushMessage{Body: "x", Priority: 3})
+	c.Pop(ctx, "a b", 5, 2)
+	c.Delete(ctx, "q", "m1:t/1")
+	c.ChangeVisibility(ctx, "q", "m1:t", 60)
+	c.CancelScheduled(ctx, "q", "m 1")
+
+	want := []string{
+		"POST /v1/queues/a%2Fb/messages Bearer t application/json",
+		"POST /v1/queues/a%20b/messages/receive Bearer t application/json",
+		"DELETE /v1/queues/q/messages/m1:t%2F1 Bearer t ",
+		"PUT /v1/queues/q/messages/m1:t/visibility Bearer t application/json",
+		"DELETE /v1/queues/q/scheduled/m%201 Bearer t ",
+	}
+	if len(requests) != len(want) {
+		t.Fatalf("requests: %q", requests)
+	}
+	for i := range want {
+		if requests[i] != want[i] {
+			t.Fatalf("request %d: %q, want %q", i, requests[i], want[i])
+		}
+	}
+	if bodies[0]["priority"] != float64(3) || bodies[0]["delaySeconds"] != nil || bodies[1]["waitSeconds"] != float64(5) || bodies[3]["visibilityTimeout"] != float64(60) {
+		t.Fatalf("bodies: %v", bodies)
+	}
+}
+
+//错误响应不是json时按响应内容作为错误信息
+func TestErrorWithoutJSON(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		http.Error(res, "queue exists", http.StatusConflict)
+	}))
+	defer srv.Close()
+
+	err := New(srv.URL).CreateQueue(context.Background(), QueueOptions{QueueName: "q"})
+	if e, ok := err.(*Error); !ok || e.Message != "queue exists" || !IsConflict(err) || IsNotFound(err) {
+		t.Fatalf("create queue: %v", err)
+	}
+}
+
+//DeliverAt编码为RFC3339，为空时不发送；maxMessages为0时不带查询参数
+func TestScheduled(t *testing.T) {
+	var requests []string
+	var bodies []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.Method+" "+req.URL.RequestURI())
+		var body map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&body)
+		bodies = append(bodies, body)
+		res.Write([]byte(`{"messageId":"m1","messages":[{"messageId":"m1","body":"x","deliverTimestamp":1700000000}]}`))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	ctx := context.Background()
+	at := time.Date(2026, 11, 1, 9, 0, 0, 0, time.FixedZone("", 8*3600))
+	c.Push(ctx, "q", PushMessage{Body: "x", DeliverAt: &at})
+	c.Push(ctx, "q", PushMessage{Body: "y"})
+	messages, err := c.ListScheduled(ctx, "q", 5)
+	if err != nil || len(messages) != 1 || messages[0].MessageId != "m1" || messages[0].DeliverTimestamp != 1700000000 {
+		t.Fatalf("list scheduled: %v %v", messages, err)
+	}
+	c.ListScheduled(ctx, "q", 0)
+
+	if bodies[0]["deliverAt"] != "2026-11-01T09:00:00+08:00" {
+		t.Fatalf("deliverAt: %v", bodies[0])
+	}
+	if _, ok := bodies[1]["deliverAt"]; ok {
+		t.Fatalf("empty deliverAt sent: %v", bodies[1])
+	}
+	if requests[2] != "GET /v1/queues/q/scheduled?maxMessages=5" || requests[3] != "GET /v1/queues/q/scheduled" {
+		t.Fatalf("requests: %q", requests)
+	}
+}
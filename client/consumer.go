@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultWorkers     = 1
+	DefaultWaitSeconds = 20
+)
+
+//处理一条消息，返回nil时删除消息，返回错误时消息隐藏到期后重新投递
+type Handler func(ctx context.Context, message *Message) error
+
+//高层消费者，Workers个goroutine各自长轮询出列并调用Handler
+//处理期间定期延长消息的隐藏时间，处理成功后删除消息
+type Consumer struct {
+	Client      *Client
+	QueueName   string
+	Handler     Handler
+	Workers     int             //并发处理的goroutine数，默认1
+	WaitSeconds int             //长轮询等待秒数，默认20
+	Visibility  int64           //每次延长的隐藏时间，为0时按队列的隐藏时间
+	OnError     func(err error) //出列、延长隐藏时间和删除失败时调用，为空时忽略
+}
+
+//运行到ctx取消，等所有goroutine退出后返回
+//ctx取消时正在处理的消息的ctx也会取消，未删除的消息隐藏到期后重新投递
+func (this *Consumer) Run(ctx context.Context) error {
+	visibility := this.Visibility
+	if visibility <= 0 {
+		attributes, err := this.Client.GetQueueAttributes(ctx, this.QueueName)
+		if err != nil {
+			return err
+		}
+		visibility, _ = strconv.ParseInt(attributes.VisibilityTimeout, 10, 64)
+	}
+	//在隐藏到期前留出一半的时间延长
+	interval := time.Duration(visibility) * time.Second / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	workers := this.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			this.work(ctx, visibility, interval)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (this *Consumer) work(ctx context.Context, visibility int64, interval time.Duration) {
+	waitSeconds := this.WaitSeconds
+	if waitSeconds <= 0 {
+		waitSeconds = DefaultWaitSeconds
+	}
+
+	var failures int
+	for ctx.Err() == nil {
+		messages, err := this.Client.Pop(ctx, this.QueueName, waitSeconds, 1)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			//客户端已按MaxRetries重试过，连续失败时继续退避
+			this.report(err)
+			this.Client.sleep(ctx, failures)
+			failures++
+			continue
+		}
+		failures = 0
+
+		for i := range messages {
+			this.handle(ctx, &messages[i], visibility, interval)
+		}
+	}
+}
+
+//处理期间每隔interval延长一次隐藏时间
+func (this *Consumer) handle(ctx context.Context, message *Message, visibility int64, interval time.Duration) {
+	done := make(chan struct{})
+	extended := make(chan struct{})
+	go func() {
+		defer close(extended)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := this.Client.ChangeVisibility(ctx, this.QueueName, message.ReceiptHandle, visibility); err != nil && ctx.Err() == nil {
+					this.report(err)
+				}
+			}
+		}
+	}()
+
+	err := this.Handler(ctx, message)
+	close(done)
+	<-extended
+	if err != nil {
+		return
+	}
+
+	//ctx取消后处理成功的消息仍要删除
+	deleteCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := this.Client.Delete(deleteCtx, this.QueueName, message.ReceiptHandle); err != nil {
+		this.report(err)
+	}
+}
+
+func (this *Consumer) report(err error) {
+	if this.OnError != nil {
+		this.OnError(err)
+	}
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"yumiQ/client"
+)
+
+//表格中消息内容最多显示的字符数
+const maxBodyWidth = 60
+
+//创建和修改队列的参数，修改时只覆盖命令行中指定的参数
+type queueOptionFlags struct {
+	fs                        *flag.FlagSet
+	visibilityTimeout         *int64
+	messageRetentionPeriod    *int64
+	delaySeconds              *int64
+	deadLetterQueue           *string
+	maxReceiveCount           *int64
+	fifoQueue                 *bool
+	deduplicationWindow       *int64
+	contentBasedDeduplication *bool
+	priorityAging             *int64
+}
+
+func queueFlags(fs *flag.FlagSet) *queueOptionFlags {
+	return &queueOptionFlags{
+		fs,
+		fs.Int64("visibility-timeout", 30, "seconds a popped message stays hidden"),
+		fs.Int64("retention", 0, "message retention period in seconds, 0 for the server default"),
+		fs.Int64("delay", 0, "default delivery delay in seconds"),
+		fs.String("dlq", "", "dead letter queue"),
+		fs.Int64("max-receive-count", 0, "receives before a message moves to the dead letter queue"),
+		fs.Bool("fifo", false, "create a FIFO queue"),
+		fs.Int64("dedup-window", 0, "deduplication window in seconds"),
+		fs.Bool("content-dedup", false, "deduplicate by message body"),
+		fs.Int64("priority-aging", 0, "seconds after which a waiting message gains one priority level"),
+	}
+}
+
+//把命令行中指定的参数写入options
+func (this *queueOptionFlags) apply(options *client.QueueOptions) {
+	this.fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "visibility-timeout":
+			options.VisibilityTimeout = *this.visibilityTimeout
+		case "retention":
+			options.MessageRetentionPeriod = *this.messageRetentionPeriod
+		case "delay":
+			options.DelaySeconds = *this.delaySeconds
+		case "dlq":
+			options.DeadLetterQueue = *this.deadLetterQueue
+		case "max-receive-count":
+			options.MaxReceiveCount = *this.maxReceiveCount
+		case "fifo":
+			options.FifoQueue = this.fifoQueue
+		case "dedup-window":
+			options.DeduplicationWindow = *this.deduplicationWindow
+		case "content-dedup":
+			options.ContentBasedDeduplication = *this.contentBasedDeduplication
+		case "priority-aging":
+			options.PriorityAging = *this.priorityAging
+		}
+	})
+}
+
+//队列属性转为创建和修改队列的参数
+func attributesToOptions(attributes *client.QueueAttributes) client.QueueOptions {
+	number := func(s string) int64 {
+		n, _ := strconv.ParseInt(s, 10, 64)
+		return n
+	}
+	fifo := attributes.FifoQueue == "true"
+	return client.QueueOptions{
+		QueueName:                 attributes.QueueName,
+		VisibilityTimeout:         number(attributes.VisibilityTimeout),
+		MessageRetentionPeriod:    number(attributes.MessageRetentionPeriod),
+		DelaySeconds:              number(attributes.DelaySeconds),
+		DeadLetterQueue:           attributes.DeadLetterQueue,
+		MaxReceiveCount:           number(attributes.MaxReceiveCount),
+		FifoQueue:                 &fifo,
+		DeduplicationWindow:       number(attributes.DeduplicationWindow),
+		ContentBasedDeduplication: attributes.ContentBasedDeduplication == "true",
+		PriorityAging:             number(attributes.PriorityAging),
+	}
+}
+
+//列出全部队列名，自动翻页
+func listQueueNames(ctx context.Context, c *client.Client, prefix string) ([]string, error) {
+	var names []string
+	next := ""
+	for {
+		page, token, err := c.ListQueues(ctx, prefix, next, 0)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, page...)
+		if token == "" {
+			return names, nil
+		}
+		next = token
+	}
+}
+
+//增删改操作的输出
+func done(out *printer, queueName string, action string) error {
+	return out.print(map[string]string{"queueName": queueName, "result": action}, nil, [][]string{{"queue " + queueName + " " + action}})
+}
+
+func queuesList(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	fs := flag.NewFlagSet("queues list", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "only queues whose name starts with prefix")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	names, err := listQueueNames(ctx, c, *prefix)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(names))
+	for _, name := range names {
+		rows = append(rows, []string{name})
+	}
+	return out.print(map[string][]string{"queueNames": names}, []string{"NAME"}, rows)
+}
+
+func queuesCreate(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	fs := flag.NewFlagSet("queues create", flag.ContinueOnError)
+	flags := queueFlags(fs)
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	options := client.QueueOptions{QueueName: rest[0], VisibilityTimeout: *flags.visibilityTimeout}
+	flags.apply(&options)
+	if err := c.CreateQueue(ctx, options); err != nil {
+		return err
+	}
+	return done(out, rest[0], "created")
+}
+
+func queuesUpdate(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	fs := flag.NewFlagSet("queues update", flag.ContinueOnError)
+	flags := queueFlags(fs)
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	//修改接口会覆盖全部参数，先取当前参数再覆盖指定的部分
+	attributes, err := c.GetQueueAttributes(ctx, rest[0])
+	if err != nil {
+		return err
+	}
+	options := attributesToOptions(attributes)
+	options.FifoQueue = nil
+	flags.apply(&options)
+	if err := c.UpdateQueue(ctx, options); err != nil {
+		return err
+	}
+	return done(out, rest[0], "updated")
+}
+
+func queuesDelete(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	rest, err := parse(flag.NewFlagSet("queues delete", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+	if err := c.DeleteQueue(ctx, rest[0]); err != nil {
+		return err
+	}
+	return done(out, rest[0], "deleted")
+}
+
+func queuesStats(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	rest, err := parse(flag.NewFlagSet("queues stats", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+
+	a, err := c.GetQueueAttributes(ctx, rest[0])
+	if err != nil {
+		return err
+	}
+	rows := [][]string{
+		{"queueName", a.QueueName},
+		{"readyMessages", strconv.FormatInt(a.ReadyMessages, 10)},
+		{"delayedMessages", strconv.FormatInt(a.DelayedMessages, 10)},
+		{"inFlightMessages", strconv.FormatInt(a.InFlightMessages, 10)},
+		{"oldestMessageAge", strconv.FormatInt(a.OldestMessageAge, 10)},
+		{"visibilityTimeout", a.VisibilityTimeout},
+		{"messageRetentionPeriod", a.MessageRetentionPeriod},
+		{"delaySeconds", a.DelaySeconds},
+		{"deadLetterQueue", a.DeadLetterQueue},
+		{"maxReceiveCount", a.MaxReceiveCount},
+		{"fifoQueue", a.FifoQueue},
+		{"deduplicationWindow", a.DeduplicationWindow},
+		{"contentBasedDeduplication", a.ContentBasedDeduplication},
+		{"priorityAging", a.PriorityAging},
+		{"createdTimestamp", a.CreatedTimestamp},
+		{"lastModifiedTimestamp", a.LastModifiedTimestamp},
+	}
+	return out.print(a, []string{"ATTRIBUTE", "VALUE"}, rows)
+}
+
+func queuesPurge(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	rest, err := parse(flag.NewFlagSet("queues purge", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+	if err := c.Purge(ctx, rest[0]); err != nil {
+		return err
+	}
+	return done(out, rest[0], "purged")
+}
+
+//可重复的 -attr key=value 参数
+type attributeFlag map[string]string
+
+func (this attributeFlag) String() string {
+	pairs := make([]string, 0, len(this))
+	for k, v := range this {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (this attributeFlag) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i <= 0 {
+		return fmt.Errorf("attribute %q is not key=value", value)
+	}
+	this[value[:i]] = value[i+1:]
+	return nil
+}
+
+func messagesPush(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	fs := flag.NewFlagSet("messages push", flag.ContinueOnError)
+	delay := fs.Int64("delay", 0, "delivery delay in seconds")
+	deliverAt := fs.String("deliver-at", "", "delivery time in RFC3339, e.g. 2026-11-01T09:00:00+08:00")
+	group := fs.String("group", "", "message group id, FIFO queues only")
+	dedupId := fs.String("dedup-id", "", "deduplication id")
+	priority := fs.Int("priority", 0, "priority 0-9")
+	attributes := attributeFlag{}
+	fs.Var(attributes, "attr", "message attribute key=value, repeatable")
+	rest, err := parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	body := rest[1]
+	if body == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		body = string(data)
+	}
+	if len(attributes) == 0 {
+		attributes = nil
+	}
+
+	message := client.PushMessage{Body: body, DelaySeconds: *delay, MessageGroupId: *group, DeduplicationId: *dedupId, Priority: *priority, Attributes: attributes}
+	if *deliverAt != "" {
+		at, err := time.Parse(time.RFC3339, *deliverAt)
+		if err != nil {
+			return fmt.Errorf("invalid -deliver-at %q: %s", *deliverAt, err)
+		}
+		message.DeliverAt = &at
+	}
+
+	messageId, err := c.Push(ctx, rest[0], message)
+	if err != nil {
+		return err
+	}
+	return out.print(map[string]string{"messageId": messageId}, nil, [][]string{{messageId}})
+}
+
+func messagesPop(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	fs := flag.NewFlagSet("messages pop", flag.ContinueOnError)
+	wait := fs.Int("wait", 0, "seconds to wait for a message")
+	max := fs.Int("max", 1, "maximum number of messages")
+	del := fs.Bool("delete", false, "delete the messages after printing them")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	messages, err := c.Pop(ctx, rest[0], *wait, *max)
+	if err != nil {
+		return err
+	}
+	if err := printMessages(out, messages, true); err != nil {
+		return err
+	}
+	if *del {
+		for _, message := range messages {
+			if err := c.Delete(ctx, rest[0], message.ReceiptHandle); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func messagesPeek(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	fs := flag.NewFlagSet("messages peek", flag.ContinueOnError)
+	max := fs.Int("max", 10, "maximum number of messages")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	messages, err := c.Peek(ctx, rest[0], *max)
+	if err != nil {
+		return err
+	}
+	return printMessages(out, messages, false)
+}
+
+func printMessages(out *printer, messages []client.Message, receipt bool) error {
+	if messages == nil {
+		messages = []client.Message{}
+	}
+	headers := []string{"MESSAGE ID", "RECEIVES", "SENT", "BODY"}
+	if receipt {
+		headers = append(headers, "RECEIPT")
+	}
+	rows := make([][]string, 0, len(messages))
+	for _, m := range messages {
+		sent := time.Unix(m.SentTimestamp, 0).Format(time.RFC3339)
+		row := []string{m.MessageId, strconv.FormatInt(m.ApproximateReceiveCount, 10), sent, shortBody(m.Body)}
+		if receipt {
+			row = append(row, m.ReceiptHandle)
+		}
+		rows = append(rows, row)
+	}
+	return out.print(map[string][]client.Message{"messages": messages}, headers, rows)
+}
+
+//表格中的消息体合并空白并截断到maxBodyWidth个字符
+func shortBody(body string) string {
+	body = strings.Join(strings.Fields(body), " ")
+	if len([]rune(body)) > maxBodyWidth {
+		body = string([]rune(body)[:maxBodyWidth-3]) + "..."
+	}
+	return body
+}
+
+func messagesDelete(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	rest, err := parse(flag.NewFlagSet("messages delete", flag.ContinueOnError), args, 2)
+	if err != nil {
+		return err
+	}
+	if err := c.Delete(ctx, rest[0], rest[1]); err != nil {
+		return err
+	}
+	return out.print(map[string]string{"receiptHandle": rest[1], "result": "deleted"}, nil, [][]string{{"message deleted"}})
+}
+
+func messagesRedrive(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	fs := flag.NewFlagSet("messages redrive", flag.ContinueOnError)
+	source := fs.String("source", "", "only move messages that came from this queue")
+	max := fs.Int64("max", 0, "maximum number of messages, 0 for all")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	count, err := c.Redrive(ctx, rest[0], *source, *max)
+	if err != nil {
+		return err
+	}
+	return out.print(map[string]int64{"count": count}, nil, [][]string{{strconv.FormatInt(count, 10) + " message(s) moved"}})
+}
+
+func messagesScheduled(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	fs := flag.NewFlagSet("messages scheduled", flag.ContinueOnError)
+	max := fs.Int("max", 10, "maximum number of messages")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	messages, err := c.ListScheduled(ctx, rest[0], *max)
+	if err != nil {
+		return err
+	}
+	if messages == nil {
+		messages = []client.ScheduledMessage{}
+	}
+	rows := make([][]string, 0, len(messages))
+	for _, m := range messages {
+		deliver := time.Unix(m.DeliverTimestamp, 0).Format(time.RFC3339)
+		sent := time.Unix(m.SentTimestamp, 0).Format(time.RFC3339)
+		rows = append(rows, []string{m.MessageId, deliver, sent, shortBody(m.Body)})
+	}
+	return out.print(map[string][]client.ScheduledMessage{"messages": messages}, []string{"MESSAGE ID", "DELIVER AT", "SENT", "BODY"}, rows)
+}
+
+func messagesCancel(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	rest, err := parse(flag.NewFlagSet("messages cancel", flag.ContinueOnError), args, 2)
+	if err != nil {
+		return err
+	}
+	if err := c.CancelScheduled(ctx, rest[0], rest[1]); err != nil {
+		return err
+	}
+	return out.print(map[string]string{"messageId": rest[1], "result": "cancelled"}, nil, [][]string{{"message cancelled"}})
+}
+
+//导出队列定义，不导出消息，输出总是JSON
+func export(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "only queues whose name starts with prefix")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	names, err := listQueueNames(ctx, c, *prefix)
+	if err != nil {
+		return err
+	}
+	queues := make([]client.QueueOptions, 0, len(names))
+	for _, name := range names {
+		attributes, err := c.GetQueueAttributes(ctx, name)
+		if client.IsNotFound(err) {
+			continue //列出后被删除
+		}
+		if err != nil {
+			return err
+		}
+		queues = append(queues, attributesToOptions(attributes))
+	}
+	return (&printer{out.w, true}).print(queues, nil, nil)
+}
+
+//导入export输出的队列定义，已存在的队列默认跳过
+func importQueues(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	update := fs.Bool("update", false, "update queues that already exist")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if rest[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(rest[0])
+	}
+	if err != nil {
+		return err
+	}
+	var queues []client.QueueOptions
+	if err := json.Unmarshal(data, &queues); err != nil {
+		return err
+	}
+
+	results := make([]map[string]string, 0, len(queues))
+	for _, options := range orderByDeadLetterQueue(queues) {
+		result := "created"
+		err := c.CreateQueue(ctx, options)
+		if client.IsConflict(err) {
+			result = "skipped"
+			err = nil
+			if *update {
+				//修改不能改变队列类型
+				options.FifoQueue = nil
+				result = "updated"
+				err = c.UpdateQueue(ctx, options)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", options.QueueName, err.Error())
+		}
+		results = append(results, map[string]string{"queueName": options.QueueName, "result": result})
+	}
+
+	rows := make([][]string, 0, len(results))
+	for _, r := range results {
+		rows = append(rows, []string{r["queueName"], r["result"]})
+	}
+	return out.print(results, []string{"QUEUE", "RESULT"}, rows)
+}
+
+//死信队列排在使用它的队列之前，服务端要求死信队列已存在
+func orderByDeadLetterQueue(queues []client.QueueOptions) []client.QueueOptions {
+	ordered := make([]client.QueueOptions, 0, len(queues))
+	added := make([]bool, len(queues))
+	created := map[string]bool{}
+	pending := map[string]bool{}
+	for _, q := range queues {
+		pending[q.QueueName] = true
+	}
+
+	for len(ordered) < len(queues) {
+		progress := false
+		for i, q := range queues {
+			if added[i] {
+				continue
+			}
+			//死信队列不在导入文件中时认为已存在
+			if q.DeadLetterQueue != "" && q.DeadLetterQueue != q.QueueName && pending[q.DeadLetterQueue] && !created[q.DeadLetterQueue] {
+				continue
+			}
+			ordered = append(ordered, q)
+			added[i] = true
+			created[q.QueueName] = true
+			progress = true
+		}
+		if !progress {
+			//循环引用，剩下的按原顺序，由服务端报错
+			for i, q := range queues {
+				if !added[i] {
+					ordered = append(ordered, q)
+					added[i] = true
+				}
+			}
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"yumiQ/client"
+)
+
+//记录请求的服务端，existing中的队列创建时返回409，查询时返回attributes
+type testServer struct {
+	requests []string
+	bodies   []map[string]interface{}
+}
+
+func newTestServer(t *testing.T, existing map[string]client.QueueAttributes) (*testServer, *client.Client) {
+	s := &testServer{}
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&body)
+		s.requests = append(s.requests, req.Method+" "+req.URL.Path)
+		s.bodies = append(s.bodies, body)
+
+		name := strings.TrimPrefix(req.URL.Path, "/v1/queues/")
+		switch {
+		case req.Method == "POST" && req.URL.Path == "/v1/queues":
+			if _, ok := existing[body["queueName"].(string)]; ok {
+				res.WriteHeader(http.StatusConflict)
+				res.Write([]byte(`{"error":"exists"}`))
+				return
+			}
+			res.WriteHeader(http.StatusCreated)
+		case req.Method == "GET" && req.URL.Path == "/v1/queues":
+			var names []string
+			for name := range existing {
+				names = append(names, name)
+			}
+			json.NewEncoder(res).Encode(map[string]interface{}{"queueNames": names})
+		case req.Method == "GET":
+			attributes, ok := existing[name]
+			if !ok {
+				res.WriteHeader(http.StatusNotFound)
+				res.Write([]byte(`{"error":"missing"}`))
+				return
+			}
+			json.NewEncoder(res).Encode(attributes)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return s, client.New(srv.URL)
+}
+
+//修改队列时保留未指定的参数，不发送队列类型
+func TestQueuesUpdateKeepsOptions(t *testing.T) {
+	s, c := newTestServer(t, map[string]client.QueueAttributes{
+		"q": {QueueName: "q", VisibilityTimeout: "30", MessageRetentionPeriod: "600", DeadLetterQueue: "dlq", MaxReceiveCount: "3", FifoQueue: "true"},
+	})
+
+	var out bytes.Buffer
+	if err := queuesUpdate(context.Background(), c, &printer{&out, false}, []string{"-visibility-timeout", "60", "q"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.requests) != 2 || s.requests[1] != "PUT /v1/queues/q" {
+		t.Fatalf("requests: %v", s.requests)
+	}
+	body := s.bodies[1]
+	if body["visibilityTimeout"] != float64(60) || body["messageRetentionPeriod"] != float64(600) || body["deadLetterQueue"] != "dlq" || body["maxReceiveCount"] != float64(3) {
+		t.Fatalf("update body: %v", body)
+	}
+	if _, ok := body["fifoQueue"]; ok {
+		t.Fatalf("update sent fifoQueue: %v", body)
+	}
+	if !strings.Contains(out.String(), "queue q updated") {
+		t.Fatalf("output: %q", out.String())
+	}
+}
+
+//导入时先创建死信队列，已存在的队列跳过，-update时修改
+func TestImportQueues(t *testing.T) {
+	fifo := true
+	data, _ := json.Marshal([]client.QueueOptions{
+		{QueueName: "a", VisibilityTimeout: 30, DeadLetterQueue: "dlq", MaxReceiveCount: 3},
+		{QueueName: "dlq", VisibilityTimeout: 30},
+		{QueueName: "old", VisibilityTimeout: 10, FifoQueue: &fifo},
+	})
+	file := filepath.Join(t.TempDir(), "queues.json")
+	os.WriteFile(file, data, 0644)
+	existing := map[string]client.QueueAttributes{"old": {QueueName: "old", VisibilityTimeout: "30"}}
+
+	s, c := newTestServer(t, existing)
+	var out bytes.Buffer
+	if err := importQueues(context.Background(), c, &printer{&out, true}, []string{file}); err != nil {
+		t.Fatal(err)
+	}
+	var results []map[string]string
+	json.Unmarshal(out.Bytes(), &results)
+	if len(results) != 3 || results[0]["queueName"] != "dlq" || results[1]["result"] != "skipped" || results[2]["queueName"] != "a" {
+		t.Fatalf("results: %v", results)
+	}
+	if len(s.requests) != 3 {
+		t.Fatalf("requests: %v", s.requests)
+	}
+
+	s, c = newTestServer(t, existing)
+	out.Reset()
+	if err := importQueues(context.Background(), c, &printer{&out, true}, []string{"-update", file}); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.requests) != 4 || s.requests[2] != "PUT /v1/queues/old" {
+		t.Fatalf("requests: %v", s.requests)
+	}
+	if body := s.bodies[2]; body["visibilityTimeout"] != float64(10) || body["fifoQueue"] != nil {
+		t.Fatalf("update body: %v", body)
+	}
+}
+
+//死信队列循环引用时按原顺序，不在文件中的死信队列认为已存在
+func TestOrderByDeadLetterQueue(t *testing.T) {
+	queues := []client.QueueOptions{
+		{QueueName: "a", DeadLetterQueue: "b"},
+		{QueueName: "b", DeadLetterQueue: "c"},
+		{QueueName: "c"},
+		{QueueName: "d", DeadLetterQueue: "outside"},
+		{QueueName: "x", DeadLetterQueue: "y"},
+		{QueueName: "y", DeadLetterQueue: "x"},
+	}
+	var names []string
+	for _, q := range orderByDeadLetterQueue(queues) {
+		names = append(names, q.QueueName)
+	}
+	if strings.Join(names, ",") != "c,d,b,a,x,y" {
+		t.Fatalf("order: %v", names)
+	}
+}
+
+//环境变量覆盖配置文件，指定的配置文件不存在时报错
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("YUMIQ_SERVER", "")
+	t.Setenv("YUMIQ_TOKEN", "")
+	t.Setenv("YUMIQCTL_CONFIG", "")
+
+	if conf, err := loadConfig(""); err != nil || conf.Server != defaultServer || conf.Token != "" {
+		t.Fatalf("default config: %+v %v", conf, err)
+	}
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatal("missing config file accepted")
+	}
+
+	path := filepath.Join(t.TempDir(), "conf.json")
+	os.WriteFile(path, []byte(`{"server":"http://file:1","token":"file"}`), 0600)
+	t.Setenv("YUMIQCTL_CONFIG", path)
+	if conf, err := loadConfig(""); err != nil || conf.Server != "http://file:1" || conf.Token != "file" {
+		t.Fatalf("config file: %+v %v", conf, err)
+	}
+	t.Setenv("YUMIQ_TOKEN", "env")
+	if conf, _ := loadConfig(""); conf.Server != "http://file:1" || conf.Token != "env" {
+		t.Fatalf("environment: %+v", conf)
+	}
+}
+
+func TestCommandName(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		name string
+		rest int
+	}{
+		{[]string{"export", "-prefix", "a"}, "export", 2},
+		{[]string{"queues", "list"}, "queues list", 0},
+		{[]string{"messages", "push", "q", "body"}, "messages push", 2},
+		{[]string{"queues"}, "queues", 0},
+		{nil, "", 0},
+	} {
+		if name, rest := commandName(c.args); name != c.name || len(rest) != c.rest {
+			t.Fatalf("%v: %q %v", c.args, name, rest)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+//yumiqctl 是yumiQ的命令行管理工具，通过HTTP接口管理队列和消息
+//
+//服务地址和凭证依次从 命令行参数、环境变量（YUMIQ_SERVER、YUMIQ_TOKEN）、配置文件 中读取
+//配置文件默认为 ~/.yumiqctl.json，可用 -config 或环境变量 YUMIQCTL_CONFIG 指定，格式为
+//
+//	{"server": "http://localhost:9394", "token": "..."}
+//
+//token不为空时作为 Authorization: Bearer 请求头发送，用于服务前有认证代理的部署
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"yumiQ/client"
+)
+
+const defaultServer = "http://localhost:9394"
+
+type config struct {
+	Server string `json:"server"`
+	Token  string `json:"token"`
+}
+
+//子命令，args为子命令名之后的参数
+type command struct {
+	usage string
+	run   func(ctx context.Context, c *client.Client, out *printer, args []string) error
+}
+
+var commands = map[string]command{
+	"queues list":        {"queues list [-prefix P]", queuesList},
+	"queues create":      {"queues create [queue options] QUEUE", queuesCreate},
+	"queues update":      {"queues update [queue options] QUEUE", queuesUpdate},
+	"queues delete":      {"queues delete QUEUE", queuesDelete},
+	"queues stats":       {"queues stats QUEUE", queuesStats},
+	"queues purge":       {"queues purge QUEUE", queuesPurge},
+	"messages push":      {"messages push [-delay S | -deliver-at RFC3339] [-group G] [-dedup-id ID] [-priority P] [-attr K=V ...] QUEUE BODY|-", messagesPush},
+	"messages pop":       {"messages pop [-wait S] [-max N] [-delete] QUEUE", messagesPop},
+	"messages peek":      {"messages peek [-max N] QUEUE", messagesPeek},
+	"messages delete":    {"messages delete QUEUE RECEIPT", messagesDelete},
+	"messages redrive":   {"messages redrive [-source QUEUE] [-max N] DEAD_LETTER_QUEUE", messagesRedrive},
+	"messages scheduled": {"messages scheduled [-max N] QUEUE", messagesScheduled},
+	"messages cancel":    {"messages cancel QUEUE MESSAGE_ID", messagesCancel},
+	"export":             {"export [-prefix P]", export},
+	"import":             {"import [-update] FILE|-", importQueues},
+}
+
+func main() {
+	flags := flag.NewFlagSet("yumiqctl", flag.ExitOnError)
+	server := flags.String("server", "", "server address, default from $YUMIQ_SERVER or config file")
+	token := flags.String("token", "", "bearer token, default from $YUMIQ_TOKEN or config file")
+	configPath := flags.String("config", "", "config file, default $YUMIQCTL_CONFIG or ~/.yumiqctl.json")
+	output := flags.String("o", "table", "output format: table or json")
+	flags.Usage = usage(flags)
+	flags.Parse(os.Args[1:])
+
+	if *output != "table" && *output != "json" {
+		fatalf("unknown output format %q", *output)
+	}
+
+	args := flags.Args()
+	name, rest := commandName(args)
+	cmd, ok := commands[name]
+	if !ok {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	conf, err := loadConfig(*configPath)
+	if err != nil {
+		fatalf("%s", err.Error())
+	}
+	if *server != "" {
+		conf.Server = *server
+	}
+	if *token != "" {
+		conf.Token = *token
+	}
+
+	c := client.New(conf.Server)
+	if conf.Token != "" {
+		c.Header = map[string][]string{"Authorization": {"Bearer " + conf.Token}}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	if err := cmd.run(ctx, c, &printer{os.Stdout, *output == "json"}, rest); err != nil {
+		fatalf("%s", err.Error())
+	}
+}
+
+//命令名为一个或两个单词，如 export、queues list
+func commandName(args []string) (string, []string) {
+	if len(args) == 0 {
+		return "", nil
+	}
+	if _, ok := commands[args[0]]; ok {
+		return args[0], args[1:]
+	}
+	if len(args) >= 2 {
+		return args[0] + " " + args[1], args[2:]
+	}
+	return args[0], nil
+}
+
+func usage(flags *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintln(os.Stderr, "usage: yumiqctl [-server URL] [-token TOKEN] [-config FILE] [-o table|json] COMMAND")
+		fmt.Fprintln(os.Stderr, "\ncommands:")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(os.Stderr, "  "+commands[name].usage)
+		}
+		fmt.Fprintln(os.Stderr, "\nqueue options:")
+		queueFlags(flag.NewFlagSet("", flag.ContinueOnError)).fs.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "\nglobal flags:")
+		flags.PrintDefaults()
+	}
+}
+
+//配置文件不存在时使用默认值，环境变量覆盖配置文件
+func loadConfig(path string) (conf config, err error) {
+	if path == "" {
+		path = os.Getenv("YUMIQCTL_CONFIG")
+	}
+	explicit := path != ""
+	if !explicit {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, ".yumiqctl.json")
+		}
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && (explicit || !os.IsNotExist(err)) {
+			return conf, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &conf); err != nil {
+				return conf, fmt.Errorf("config file %s: %s", path, err.Error())
+			}
+		}
+	}
+
+	if server := os.Getenv("YUMIQ_SERVER"); server != "" {
+		conf.Server = server
+	}
+	if token := os.Getenv("YUMIQ_TOKEN"); token != "" {
+		conf.Token = token
+	}
+	if conf.Server == "" {
+		conf.Server = defaultServer
+	}
+	return conf, nil
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "yumiqctl: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+//按表格或JSON输出
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+//JSON格式输出v，表格格式输出headers和rows
+func (this *printer) print(v interface{}, headers []string, rows [][]string) error {
+	if this.json {
+		enc := json.NewEncoder(this.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(this.w, 0, 4, 2, ' ', 0)
+	if len(headers) != 0 {
+		fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	}
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+//子命令的参数，flags在位置参数之前
+func parse(fs *flag.FlagSet, args []string, positional int) ([]string, error) {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != positional {
+		return nil, fmt.Errorf("expected %d argument(s), got %d", positional, fs.NArg())
+	}
+	return fs.Args(), nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultMaxUnacked = 10 //推送消费默认最多未确认的消息数
+
+//推送消费的消费者，grpc的Consume和WebSocket订阅共用
+//记录已推送未确认的回执及其隐藏截止时间，未确认的消息达到MaxUnacked时暂停推送
+type Consumer struct {
+	MaxUnacked int
+	mu         sync.Mutex
+	unacked    map[string]int64
+	acked      chan struct{} //有消息被确认时通知，缓冲为1
+}
+
+//隐藏中的未确认消息数，已到期的不再计入
+func (this *Consumer) Unacked(now int64) int {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	for receipt, deadline := range this.unacked {
+		if deadline <= now {
+			delete(this.unacked, receipt)
+			Consumers.forget(receipt)
+		}
+	}
+	return len(this.unacked)
+}
+
+func (this *Consumer) Track(receiptHandle string, deadline int64) {
+	this.mu.Lock()
+	this.unacked[receiptHandle] = deadline
+	this.mu.Unlock()
+
+	Consumers.remember(receiptHandle, this)
+}
+
+//从queues中依次出列并推送，直到done关闭或send、出列失败
+//每轮从上次之后的队列开始，避免消息多的队列占满未确认的额度
+func (this *Consumer) Run(done <-chan struct{}, queues []string, send func(queueName string, message *Message) error) error {
+	next := 0
+	for {
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+
+		sent := false
+		for i := 0; i < len(queues); i++ {
+			free := this.MaxUnacked - this.Unacked(theMoment())
+			if free <= 0 {
+				break
+			}
+			if free > MaxBatch {
+				free = MaxBatch
+			}
+
+			queueName := queues[(next+i)%len(queues)]
+			messages, err := YumiQ.Pop(queueName, 0, free)
+			if errorKind(err) == ErrEmpty {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			//隐藏截止时间按出列时的队列配置估算，出列后修改隐藏时间的由SetVisibilityTime更新
+			optionQueue, _ := Queue.Get(queueName)
+			deadline := theMoment() + toInt64(optionQueue.VisibilityTimeout)
+			for j := range messages {
+				this.Track(messages[j].ReceiptHandle, deadline)
+				if err := send(queueName, &messages[j]); err != nil {
+					return err
+				}
+			}
+			sent = true
+		}
+		next = (next + 1) % len(queues)
+
+		if !sent {
+			//没有消息或未确认的消息已满，等确认、隐藏到期或下一次轮询
+			select {
+			case <-done:
+				return nil
+			case <-this.acked:
+			case <-time.After(popPollInterval):
+			}
+		}
+	}
+}
+
+//所有推送消费的消费者，按回执找到推送该消息的消费者
+//消息通过任一接口删除或修改隐藏时间时都会更新，HTTP和grpc的确认都计入流控
+type ConsumerRegistry struct {
+	mu        sync.Mutex
+	consumers map[*Consumer]bool
+	receipts  map[string]*Consumer
+}
+
+var Consumers = &ConsumerRegistry{consumers: make(map[*Consumer]bool), receipts: make(map[string]*Consumer)}
+
+//maxUnacked为0时为defaultMaxUnacked
+func (this *ConsumerRegistry) Add(maxUnacked int) *Consumer {
+	if maxUnacked <= 0 {
+		maxUnacked = defaultMaxUnacked
+	}
+	c := &Consumer{MaxUnacked: maxUnacked, unacked: make(map[string]int64), acked: make(chan struct{}, 1)}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.consumers[c] = true
+	return c
+}
+
+func (this *ConsumerRegistry) Remove(c *Consumer) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	delete(this.consumers, c)
+	for receipt, owner := range this.receipts {
+		if owner == c {
+			delete(this.receipts, receipt)
+		}
+	}
+}
+
+func (this *ConsumerRegistry) remember(receiptHandle string, c *Consumer) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.consumers[c] {
+		this.receipts[receiptHandle] = c
+	}
+}
+
+func (this *ConsumerRegistry) forget(receiptHandle string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	delete(this.receipts, receiptHandle)
+}
+
+func (this *ConsumerRegistry) owner(receiptHandle string) *Consumer {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	return this.receipts[receiptHandle]
+}
+
+//消息已删除或已放回队列，不再计入未确认
+func (this *ConsumerRegistry) Acked(receiptHandle string) {
+	c := this.owner(receiptHandle)
+	if c == nil {
+		return
+	}
+	this.forget(receiptHandle)
+
+	c.mu.Lock()
+	delete(c.unacked, receiptHandle)
+	c.mu.Unlock()
+
+	select {
+	case c.acked <- struct{}{}:
+	default:
+	}
+}
+
+//消息的隐藏截止时间已修改
+func (this *ConsumerRegistry) Extended(receiptHandle string, deadline int64) {
+	c := this.owner(receiptHandle)
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	if _, ok := c.unacked[receiptHandle]; ok {
+		c.unacked[receiptHandle] = deadline
+	}
+	c.mu.Unlock()
+}
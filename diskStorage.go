@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	diskSnapshotFile = "snapshot.json" //快照，记录最后一条已包含的日志序号
+	diskLogFile      = "wal.log"       //预写日志，每行一条修改操作
+)
+
+//预写日志中的一条操作，按Op只使用对应的字段
+type walEntry struct {
+	Seq      int64                   `json:"seq"`
+	Op       string                  `json:"op"`
+	Queue    string                  `json:"queue"`
+	Id       string                  `json:"id,omitempty"`
+	Options  map[string]string       `json:"options,omitempty"`
+	Messages []NewMessage            `json:"messages,omitempty"`
+	Pop      *PopOption              `json:"pop,omitempty"`
+	Time     int64                   `json:"time,omitempty"`
+	Limit    int64                   `json:"limit,omitempty"`
+	Source   string                  `json:"source,omitempty"`
+	Exists   []string                `json:"exists,omitempty"` //redrive时仍存在的来源队列，重放时按此判断
+	Topic    string                  `json:"topic,omitempty"`
+	Value    string                  `json:"value,omitempty"`
+	Batch    map[string][]NewMessage `json:"batch,omitempty"`  //同时入列到多个队列的消息
+	Queues   []string                `json:"queues,omitempty"` //过滤掉消息的订阅
+}
+
+type diskSnapshot struct {
+	Seq  int64           `json:"seq"`
+	Data json.RawMessage `json:"data"`
+}
+
+//单机磁盘存储，数据保存在内存存储中，每次修改先写入预写日志并落盘
+//定期把内存数据写成快照并清空日志，启动时加载快照再重放日志恢复
+//只读方法直接使用内存存储，新增的修改方法必须在这里先记录日志再修改内存，日志写入失败时内存不变
+type DiskStorage struct {
+	*MemoryStorage
+	mu  sync.Mutex
+	dir string
+	wal *os.File
+	seq int64
+	err error //日志无法回滚到写入前时记录，之后的修改都返回该错误，直到下一次快照成功
+}
+
+//打开数据目录并恢复数据，snapshotInterval为0时不定期快照
+func NewDiskStorage(dir string, snapshotInterval time.Duration) (*DiskStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	this := &DiskStorage{MemoryStorage: NewMemoryStorage(), dir: dir}
+	if err := this.recover(); err != nil {
+		return nil, err
+	}
+	//恢复后立即快照，之后的日志从空文件开始
+	if err := this.Snapshot(); err != nil {
+		return nil, err
+	}
+
+	if snapshotInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(snapshotInterval)
+			for {
+				select {
+				case <-ticker.C:
+					if err := this.Snapshot(); err != nil {
+						log.Printf("disk snapshot error: %s", err.Error())
+					}
+				}
+			}
+		}()
+	}
+	return this, nil
+}
+
+//加载快照并重放之后的日志，日志末尾未写完整的一行直接丢弃
+func (this *DiskStorage) recover() error {
+	data, err := ioutil.ReadFile(filepath.Join(this.dir, diskSnapshotFile))
+	if err == nil {
+		var snapshot diskSnapshot
+		if err = json.Unmarshal(data, &snapshot); err != nil {
+			return fmt.Errorf("snapshot is broken: %s", err.Error())
+		}
+		if err = this.MemoryStorage.unmarshal(snapshot.Data); err != nil {
+			return fmt.Errorf("snapshot is broken: %s", err.Error())
+		}
+		this.seq = snapshot.Seq
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	f, err := os.Open(filepath.Join(this.dir, diskLogFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) != 0 {
+				log.Printf("disk storage: drop incomplete log entry")
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var entry walEntry
+		if err = json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("log entry after seq %d is broken: %s", this.seq, err.Error())
+		}
+		if entry.Seq <= this.seq { //快照中已包含
+			continue
+		}
+		if err = this.replay(entry); err != nil {
+			return err
+		}
+		this.seq = entry.Seq
+	}
+}
+
+//重放一条日志
+func (this *DiskStorage) replay(entry walEntry) (err error) {
+	m := this.MemoryStorage
+	switch entry.Op {
+	case "saveOptions":
+		err = m.SaveOptions(entry.Queue, entry.Options)
+	case "delOptions":
+		err = m.DelOptions(entry.Queue)
+	case "addQueueName":
+		err = m.AddQueueName(entry.Queue)
+	case "delQueueName":
+		err = m.DelQueueName(entry.Queue)
+	case "addTopicName":
+		err = m.AddTopicName(entry.Topic)
+	case "delTopicName":
+		err = m.DelTopicName(entry.Topic)
+	case "saveSubscription":
+		err = m.SaveSubscription(entry.Topic, entry.Queue, entry.Value)
+	case "delSubscription":
+		err = m.DelSubscription(entry.Topic, entry.Queue)
+	case "addFiltered":
+		err = m.AddFiltered(entry.Topic, entry.Queues)
+	case "push":
+		_, err = m.Push(entry.Queue, entry.Messages)
+	case "pushMulti":
+		_, err = m.PushMulti(entry.Batch)
+	case "pop":
+		_, err = m.Pop(entry.Queue, *entry.Pop)
+	case "delete":
+		err = m.Delete(entry.Queue, entry.Id)
+	case "cancelScheduled":
+		_, err = m.CancelScheduled(entry.Queue, entry.Id)
+	case "changeVisibility":
+		_, err = m.ChangeVisibility(entry.Queue, entry.Id, entry.Time)
+	case "promote":
+		_, err = m.Promote(entry.Queue, entry.Time, int(entry.Limit))
+	case "clean":
+		_, err = m.Clean(entry.Queue, entry.Time)
+	case "redrive":
+		_, err = m.Redrive(entry.Queue, entry.Source, entry.Limit, func(source string) bool {
+			for _, v := range entry.Exists {
+				if v == source {
+					return true
+				}
+			}
+			return false
+		})
+	case "delMessages":
+		err = m.DelMessages(entry.Queue)
+	default:
+		err = fmt.Errorf("unknown log entry: %s", entry.Op)
+	}
+	return
+}
+
+//追加一条日志并落盘，调用方需持有锁
+//写入或落盘失败时把日志截断回写入前的长度，序号不变，截断也失败时存储不再接受修改
+func (this *DiskStorage) append(entry walEntry) (err error) {
+	if this.err != nil {
+		return fmt.Errorf("disk storage is unavailable: %s", this.err.Error())
+	}
+
+	entry.Seq = this.seq + 1
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	info, err := this.wal.Stat()
+	if err != nil {
+		return
+	}
+	if _, err = this.wal.Write(append(line, '\n')); err == nil {
+		err = this.wal.Sync()
+	}
+	if err != nil {
+		if e := this.wal.Truncate(info.Size()); e != nil {
+			this.err = e
+		} else if e = this.wal.Sync(); e != nil {
+			this.err = e
+		}
+		return
+	}
+
+	this.seq = entry.Seq
+	return
+}
+
+//写入快照后清空日志，快照先写临时文件再改名，任何时刻退出都能恢复
+func (this *DiskStorage) Snapshot() (err error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	data, err := this.MemoryStorage.marshal()
+	if err != nil {
+		return
+	}
+	content, err := json.Marshal(diskSnapshot{this.seq, data})
+	if err != nil {
+		return
+	}
+
+	tmp := filepath.Join(this.dir, diskSnapshotFile+".tmp")
+	if err = writeFileSync(tmp, content); err != nil {
+		return
+	}
+	if err = os.Rename(tmp, filepath.Join(this.dir, diskSnapshotFile)); err != nil {
+		return
+	}
+	//改名和清空日志都要等目录落盘后才算完成
+	if err = syncDir(this.dir); err != nil {
+		return
+	}
+
+	if this.wal != nil {
+		this.wal.Close()
+	}
+	this.wal, err = os.OpenFile(filepath.Join(this.dir, diskLogFile), os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	if err == nil {
+		err = syncDir(this.dir)
+	}
+	//日志已关闭或无法确认清空时不再接受修改，快照成功后恢复
+	this.err = err
+	return
+}
+
+//目录落盘，保证其中文件的创建、改名已写入磁盘
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
+
+//写文件并落盘
+func writeFileSync(name string, content []byte) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = f.Write(content); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func (this *DiskStorage) SaveOptions(queueName string, opt map[string]string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if err := this.append(walEntry{Op: "saveOptions", Queue: queueName, Options: opt}); err != nil {
+		return err
+	}
+	return this.MemoryStorage.SaveOptions(queueName, opt)
+}
+
+func (this *DiskStorage) DelOptions(queueName string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if err := this.append(walEntry{Op: "delOptions", Queue: queueName}); err != nil {
+		return err
+	}
+	return this.MemoryStorage.DelOptions(queueName)
+}
+
+func (this *DiskStorage) AddQueueName(queueName string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if err := this.append(walEntry{Op: "addQueueName", Queue: queueName}); err != nil {
+		return err
+	}
+	return this.MemoryStorage.AddQueueName(queueName)
+}
+
+func (this *DiskStorage) DelQueueName(queueName string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if err := this.append(walEntry{Op: "delQueueName", Queue: queueName}); err != nil {
+		return err
+	}
+	return this.MemoryStorage.DelQueueName(queueName)
+}
+
+func (this *DiskStorage) AddTopicName(topicName string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if err := this.append(walEntry{Op: "addTopicName", Topic: topicName}); err != nil {
+		return err
+	}
+	return this.MemoryStorage.AddTopicName(topicName)
+}
+
+func (this *DiskStorage) DelTopicName(topicName string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if err := this.append(walEntry{Op: "delTopicName", Topic: topicName}); err != nil {
+		return err
+	}
+	return this.MemoryStorage.DelTopicName(topicName)
+}
+
+func (this *DiskStorage) SaveSubscription(topicName string, queueName string, subscription string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if err := this.append(walEntry{Op: "saveSubscription", Queue: queueName, Topic: topicName, Value: subscription}); err != nil {
+		return err
+	}
+	return this.MemoryStorage.SaveSubscription(topicName, queueName, subscription)
+}
+
+func (this *DiskStorage) DelSubscription(topicName string, queueName string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if err := this.append(walEntry{Op: "delSubscription", Queue: queueName, Topic: topicName}); err != nil {
+		return err
+	}
+	return this.MemoryStorage.DelSubscription(topicName, queueName)
+}
+
+func (this *DiskStorage) AddFiltered(topicName string, queueNames []string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if err := this.append(walEntry{Op: "addFiltered", Topic: topicName, Queues: queueNames}); err != nil {
+		return err
+	}
+	return this.MemoryStorage.AddFiltered(topicName, queueNames)
+}
+
+func (this *DiskStorage) Push(queueName string, messages []NewMessage) ([]string, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if err := this.append(walEntry{Op: "push", Queue: queueName, Messages: messages}); err != nil {
+		return nil, err
+	}
+	return this.MemoryStorage.Push(queueName, messages)
+}
+
+//多个队列的消息记录为一条日志，重放时同样全部入列
+func (this *DiskStorage) PushMulti(messages map[string][]NewMessage) (map[string][]string, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if err := this.append(walEntry{Op: "pushMulti", Batch: messages}); err != nil {
+		return nil, err
+	}
+	return this.MemoryStorage.PushMulti(messages)
+}
+
+//准备队列为空时出列不改变数据，不记录日志
+//否则即使没有返回消息（超过接收次数移入死信队列、消息体已不存在而丢弃）也记录
+func (this *DiskStorage) Pop(queueName string, opt PopOption) ([]Message, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if !this.MemoryStorage.inspect(queueName, (*memoryQueue).hasReady) {
+		return nil, nil
+	}
+	if err := this.append(walEntry{Op: "pop", Queue: queueName, Pop: &opt}); err != nil {
+		return nil, err
+	}
+	return this.MemoryStorage.Pop(queueName, opt)
+}
+
+func (this *DiskStorage) Delete(queueName string, id string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if err := this.append(walEntry{Op: "delete", Queue: queueName, Id: id}); err != nil {
+		return err
+	}
+	return this.MemoryStorage.Delete(queueName, id)
+}
+
+func (this *DiskStorage) CancelScheduled(queueName string, id string) (bool, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if !this.MemoryStorage.inspect(queueName, func(q *memoryQueue) bool { return q.isScheduled(id) }) {
+		return false, nil
+	}
+	if err := this.append(walEntry{Op: "cancelScheduled", Queue: queueName, Id: id}); err != nil {
+		return false, err
+	}
+	return this.MemoryStorage.CancelScheduled(queueName, id)
+}
+
+func (this *DiskStorage) ChangeVisibility(queueName string, id string, deadline int64) (bool, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if !this.MemoryStorage.inspect(queueName, func(q *memoryQueue) bool { _, ok := q.Delay[id]; return ok }) {
+		return false, nil
+	}
+	if err := this.append(walEntry{Op: "changeVisibility", Queue: queueName, Id: id, Time: deadline}); err != nil {
+		return false, err
+	}
+	return this.MemoryStorage.ChangeVisibility(queueName, id, deadline)
+}
+
+func (this *DiskStorage) Promote(queueName string, now int64, limit int) (int, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if !this.MemoryStorage.inspect(queueName, func(q *memoryQueue) bool { return q.hasDue(now) }) {
+		return 0, nil
+	}
+	if err := this.append(walEntry{Op: "promote", Queue: queueName, Time: now, Limit: int64(limit)}); err != nil {
+		return 0, err
+	}
+	return this.MemoryStorage.Promote(queueName, now, limit)
+}
+
+func (this *DiskStorage) Clean(queueName string, before int64) (int, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if !this.MemoryStorage.inspect(queueName, func(q *memoryQueue) bool { return q.hasDue(before) }) {
+		return 0, nil
+	}
+	if err := this.append(walEntry{Op: "clean", Queue: queueName, Time: before}); err != nil {
+		return 0, err
+	}
+	return this.MemoryStorage.Clean(queueName, before)
+}
+
+//先找出准备队列中可以移回的来源队列，日志中记录它们，重放时按同样的来源队列移回
+func (this *DiskStorage) Redrive(queueName string, sourceQueue string, maxMessages int64, exists func(string) bool) (int64, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	var existing []string
+	for _, source := range this.MemoryStorage.redriveSources(queueName, sourceQueue) {
+		if exists(source) {
+			existing = append(existing, source)
+		}
+	}
+	if len(existing) == 0 {
+		return 0, nil
+	}
+	if err := this.append(walEntry{Op: "redrive", Queue: queueName, Source: sourceQueue, Limit: maxMessages, Exists: existing}); err != nil {
+		return 0, err
+	}
+	return this.MemoryStorage.Redrive(queueName, sourceQueue, maxMessages, func(source string) bool {
+		for _, v := range existing {
+			if v == source {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (this *DiskStorage) DelMessages(queueName string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if err := this.append(walEntry{Op: "delMessages", Queue: queueName}); err != nil {
+		return err
+	}
+	return this.MemoryStorage.DelMessages(queueName)
+}
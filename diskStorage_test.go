@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func diskTestOpen(t *testing.T, dir string) *DiskStorage {
+	disk, err := NewDiskStorage(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return disk
+}
+
+//重新打开时加载快照并重放日志，恢复后的数据与退出前一致
+func TestDiskStorageReplay(t *testing.T) {
+	dir := t.TempDir()
+	disk := diskTestOpen(t, dir)
+	random := rand.New(rand.NewSource(1))
+	disk.AddQueueName("q")
+	disk.AddQueueName("dlq")
+	disk.AddTopicName("topic")
+
+	var receipts []string
+	for step := 0; step < 500; step++ {
+		now := int64(step)
+		switch random.Intn(8) {
+		case 0, 1:
+			message := NewMessage{Id: fmt.Sprintf("m%d", step), Body: "x", SentAt: now, Priority: random.Intn(3), Attributes: map[string]string{"k": "v"}}
+			if random.Intn(3) == 0 {
+				message.DeliverAt = now + int64(random.Intn(20))
+			}
+			if random.Intn(4) == 0 {
+				message.GroupId, message.Priority, message.DeliverAt = fmt.Sprintf("g%d", random.Intn(3)), 0, 0
+			}
+			disk.Push("q", []NewMessage{message})
+		case 2:
+			messages, _ := disk.Pop("q", PopOption{MaxMessages: 2, Deadline: now + 5, Token: fmt.Sprintf("t%d", step), DeadLetterQueue: "dlq", MaxReceiveCount: 2, Now: now})
+			for _, message := range messages {
+				receipts = append(receipts, message.ReceiptHandle)
+			}
+		case 3:
+			if len(receipts) != 0 {
+				if id, err := disk.CheckReceipt("q", receipts[random.Intn(len(receipts))]); err == nil {
+					disk.Delete("q", id)
+				}
+			}
+		case 4:
+			disk.Promote("q", now, 3)
+		case 5:
+			disk.Redrive("dlq", "", 1, func(string) bool { return true })
+		case 6:
+			disk.AddFiltered("topic", []string{"q"})
+		case 7:
+			if random.Intn(10) == 0 {
+				if err := disk.Snapshot(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		if step%25 == 0 {
+			want, _ := disk.marshal()
+			got, _ := diskTestOpen(t, dir).marshal()
+			if !bytes.Equal(want, got) {
+				t.Fatalf("step %d: recovered data differs\nwant %s\ngot  %s", step, want, got)
+			}
+		}
+	}
+}
+
+//日志末尾未写完整的一行在恢复时丢弃，之前的日志照常重放
+func TestDiskStorageTornTail(t *testing.T) {
+	dir := t.TempDir()
+	disk := diskTestOpen(t, dir)
+	disk.Push("q", []NewMessage{{Id: "a", Body: "A", SentAt: 1}, {Id: "b", Body: "B", SentAt: 1}})
+	if err := disk.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	disk.Delete("q", "a")
+
+	file, err := os.OpenFile(filepath.Join(dir, diskLogFile), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte(`{"seq":99,"op":"pu`))
+	file.Close()
+
+	recovered := diskTestOpen(t, dir)
+	if peeked, _ := recovered.Peek("q", 10); len(peeked) != 1 || peeked[0].MessageId != "b" {
+		t.Fatalf("peek: %v", peeked)
+	}
+	//丢弃的半行不影响之后追加的日志
+	recovered.Delete("q", "b")
+	if stats, _ := diskTestOpen(t, dir).Stats("q"); stats.Ready != 0 {
+		t.Fatalf("stats: %+v", stats)
+	}
+}
+
+//日志写入失败时内存不变、序号不变，无法回滚时拒绝之后的修改，快照成功后恢复
+func TestDiskStorageAppendFailure(t *testing.T) {
+	dir := t.TempDir()
+	disk := diskTestOpen(t, dir)
+	if err := disk.AddQueueName("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	seq, wal := disk.seq, disk.wal
+	readOnly, err := os.Open(filepath.Join(dir, diskLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk.wal = readOnly
+	if err := disk.AddQueueName("b"); err == nil {
+		t.Fatal("write to a read-only log must fail")
+	}
+	if disk.seq != seq {
+		t.Fatalf("seq advanced to %d after a failed write", disk.seq)
+	}
+	if exists, _ := disk.ExistsQueueName("b"); exists {
+		t.Fatal("memory changed after a failed write")
+	}
+	if err := disk.AddQueueName("c"); err == nil {
+		t.Fatal("storage must refuse changes after the log can't be rolled back")
+	}
+
+	wal.Close()
+	if err := disk.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := disk.AddQueueName("d"); err != nil {
+		t.Fatal(err)
+	}
+	if names, _ := diskTestOpen(t, dir).QueueNames(); len(names) != 2 {
+		t.Fatalf("recovered queue names: %v", names)
+	}
+}
+
+//过滤计数写入日志，重新打开后恢复，删除订阅和主题时一并删除
+func TestDiskStorageFilteredCounts(t *testing.T) {
+	dir := t.TempDir()
+	disk := diskTestOpen(t, dir)
+	disk.AddTopicName("t")
+	disk.AddFiltered("t", []string{"a", "b"})
+	disk.AddFiltered("t", []string{"a"})
+
+	disk = diskTestOpen(t, dir)
+	if counts, _ := disk.FilteredCounts("t"); counts["a"] != 2 || counts["b"] != 1 {
+		t.Fatalf("counts after replay: %v", counts)
+	}
+	if err := disk.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	disk.DelSubscription("t", "b")
+
+	disk = diskTestOpen(t, dir)
+	if counts, _ := disk.FilteredCounts("t"); counts["a"] != 2 || len(counts) != 1 {
+		t.Fatalf("counts after snapshot: %v", counts)
+	}
+	disk.DelTopicName("t")
+	if counts, _ := diskTestOpen(t, dir).FilteredCounts("t"); len(counts) != 0 {
+		t.Fatalf("counts of deleted topic: %v", counts)
+	}
+}
+
+//取消延迟消息写入日志，重新打开后不再恢复
+func TestDiskStorageCancelScheduled(t *testing.T) {
+	dir := t.TempDir()
+	disk := diskTestOpen(t, dir)
+	disk.Push("q", []NewMessage{{Id: "a", Body: "x", SentAt: 1, DeliverAt: 100}, {Id: "b", Body: "y", SentAt: 1, DeliverAt: 50}})
+	if ok, err := disk.CancelScheduled("q", "a"); err != nil || !ok {
+		t.Fatalf("cancel: %v %v", ok, err)
+	}
+
+	disk = diskTestOpen(t, dir)
+	if scheduled, _ := disk.Scheduled("q", 10); len(scheduled) != 1 || scheduled[0].MessageId != "b" {
+		t.Fatalf("scheduled after replay: %v", scheduled)
+	}
+	if ok, _ := disk.CancelScheduled("q", "a"); ok {
+		t.Fatal("cancelled message recovered")
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+)
+
+//错误类型，v1接口据此返回HTTP状态码
+type ErrorKind int
+
+const (
+	ErrInvalid  ErrorKind = iota + 1 //参数错误
+	ErrNotFound                      //队列或回执不存在
+	ErrConflict                      //队列已存在或消息状态不允许该操作
+	ErrEmpty                         //队列中没有可接收的消息
+)
+
+//带类型的错误，Error()仍为原来的文字，旧接口的返回不变
+//没有类型的错误来自存储，按服务器错误处理
+type YumiError struct {
+	Kind    ErrorKind
+	Message string
+}
+
+func (this *YumiError) Error() string {
+	return this.Message
+}
+
+func newError(kind ErrorKind, format string, args ...interface{}) error {
+	return &YumiError{kind, fmt.Sprintf(format, args...)}
+}
+
+//错误的类型，没有类型时返回0
+func errorKind(err error) ErrorKind {
+	if e, ok := err.(*YumiError); ok {
+		return e.Kind
+	}
+	return 0
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+//订阅的过滤策略，键为消息属性名，值为条件列表
+//各属性都满足时消息才入列到订阅的队列，同一属性的多个条件满足任意一个即可，如
+//	{"color": ["red", {"prefix": "bl"}], "size": [{"numeric": [">=", 10, "<", 20]}], "debug": [{"exists": false}], "env": [{"anything-but": ["test", "dev"]}]}
+type FilterPolicy map[string][]filterCondition
+
+//单个条件，op为 exact、prefix、numeric、exists 或 anything-but
+type filterCondition struct {
+	op     string
+	value  string
+	number *float64          //exact的值为数字时按数值比较
+	bounds []numericBound    //numeric的上下界
+	exists bool              //exists为false时要求没有该属性
+	not    []filterCondition //anything-but中的条件
+}
+
+type numericBound struct {
+	op    string //=、>、>=、<、<=
+	value float64
+}
+
+//解析过滤策略，策略为空时返回nil，不过滤
+func parseFilterPolicy(policy string) (FilterPolicy, error) {
+	if policy == "" {
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(policy))
+	decoder.UseNumber()
+	var raw map[string]interface{}
+	if err := decoder.Decode(&raw); err != nil || raw == nil {
+		return nil, newError(ErrInvalid, "filterPolicy must be a JSON object")
+	}
+
+	filter := make(FilterPolicy, len(raw))
+	for name, value := range raw {
+		values, ok := value.([]interface{})
+		if !ok || len(values) == 0 {
+			return nil, newError(ErrInvalid, "filterPolicy %s must be a non-empty array", name)
+		}
+		for _, v := range values {
+			condition, err := parseFilterCondition(name, v)
+			if err != nil {
+				return nil, err
+			}
+			filter[name] = append(filter[name], condition)
+		}
+	}
+	return filter, nil
+}
+
+func parseFilterCondition(name string, value interface{}) (filterCondition, error) {
+	switch v := value.(type) {
+	case string:
+		return filterCondition{op: "exact", value: v}, nil
+	case json.Number:
+		number, err := v.Float64()
+		if err != nil {
+			break
+		}
+		return filterCondition{op: "exact", value: v.String(), number: &number}, nil
+	case map[string]interface{}:
+		if len(v) != 1 {
+			break
+		}
+		for op, operand := range v {
+			switch op {
+			case "prefix":
+				if prefix, ok := operand.(string); ok && prefix != "" {
+					return filterCondition{op: op, value: prefix}, nil
+				}
+			case "exists":
+				if exists, ok := operand.(bool); ok {
+					return filterCondition{op: op, exists: exists}, nil
+				}
+			case "numeric":
+				return parseNumericCondition(name, operand)
+			case "anything-but":
+				return parseAnythingBut(name, operand)
+			}
+		}
+	}
+	return filterCondition{}, newError(ErrInvalid, "filterPolicy %s has an unsupported condition", name)
+}
+
+//numeric的值为 ["=", 5] 或者一个下界和/或一个上界，如 [">", 0, "<=", 5]
+func parseNumericCondition(name string, operand interface{}) (filterCondition, error) {
+	invalid := newError(ErrInvalid, "filterPolicy %s has an invalid numeric condition", name)
+
+	values, ok := operand.([]interface{})
+	if !ok || len(values) == 0 || len(values)%2 != 0 || len(values) > 4 {
+		return filterCondition{}, invalid
+	}
+
+	var bounds []numericBound
+	var lower, upper bool
+	for i := 0; i < len(values); i += 2 {
+		op, ok := values[i].(string)
+		number, isNumber := values[i+1].(json.Number)
+		if !ok || !isNumber {
+			return filterCondition{}, invalid
+		}
+		value, err := number.Float64()
+		if err != nil {
+			return filterCondition{}, invalid
+		}
+
+		switch op {
+		case "=":
+			if len(values) != 2 {
+				return filterCondition{}, invalid
+			}
+		case ">", ">=":
+			if lower {
+				return filterCondition{}, invalid
+			}
+			lower = true
+		case "<", "<=":
+			if upper {
+				return filterCondition{}, invalid
+			}
+			upper = true
+		default:
+			return filterCondition{}, invalid
+		}
+		bounds = append(bounds, numericBound{op, value})
+	}
+	return filterCondition{op: "numeric", bounds: bounds}, nil
+}
+
+//anything-but的值为字符串、数字、它们的数组，或者 {"prefix": "..."}
+func parseAnythingBut(name string, operand interface{}) (filterCondition, error) {
+	values, ok := operand.([]interface{})
+	if !ok {
+		values = []interface{}{operand}
+	}
+	if len(values) == 0 {
+		return filterCondition{}, newError(ErrInvalid, "filterPolicy %s has an empty anything-but condition", name)
+	}
+
+	condition := filterCondition{op: "anything-but"}
+	for _, v := range values {
+		not, err := parseFilterCondition(name, v)
+		if err != nil {
+			return filterCondition{}, err
+		}
+		if not.op != "exact" && not.op != "prefix" {
+			return filterCondition{}, newError(ErrInvalid, "filterPolicy %s has an unsupported anything-but condition", name)
+		}
+		condition.not = append(condition.not, not)
+	}
+	return condition, nil
+}
+
+//消息属性是否满足过滤策略
+func (this FilterPolicy) Match(attributes map[string]string) bool {
+	for name, conditions := range this {
+		value, exists := attributes[name]
+		matched := false
+		for _, condition := range conditions {
+			if condition.match(value, exists) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+//除exists外的条件都要求消息有该属性
+func (this filterCondition) match(value string, exists bool) bool {
+	if this.op == "exists" {
+		return this.exists == exists
+	}
+	if !exists {
+		return false
+	}
+
+	switch this.op {
+	case "exact":
+		if this.number != nil {
+			number, err := strconv.ParseFloat(value, 64)
+			return err == nil && number == *this.number
+		}
+		return value == this.value
+	case "prefix":
+		return strings.HasPrefix(value, this.value)
+	case "numeric":
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false
+		}
+		for _, bound := range this.bounds {
+			if !bound.match(number) {
+				return false
+			}
+		}
+		return true
+	case "anything-but":
+		for _, not := range this.not {
+			if not.match(value, true) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (this numericBound) match(number float64) bool {
+	switch this.op {
+	case "=":
+		return number == this.value
+	case ">":
+		return number > this.value
+	case ">=":
+		return number >= this.value
+	case "<":
+		return number < this.value
+	case "<=":
+		return number <= this.value
+	}
+	return false
+}
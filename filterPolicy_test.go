@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestFilterPolicyMatch(t *testing.T) {
+	cases := []struct {
+		policy     string
+		attributes map[string]string
+		want       bool
+	}{
+		{`{"color":["red","blue"]}`, map[string]string{"color": "red"}, true},
+		{`{"color":["red","blue"]}`, map[string]string{"color": "green"}, false},
+		{`{"color":["red","blue"]}`, nil, false},
+		{`{"color":[{"prefix":"bl"}]}`, map[string]string{"color": "black"}, true},
+		{`{"size":[{"numeric":[">=",10,"<",20]}]}`, map[string]string{"size": "10"}, true},
+		{`{"size":[{"numeric":[">=",10,"<",20]}]}`, map[string]string{"size": "20"}, false},
+		{`{"size":[{"numeric":[">=",10,"<",20]}]}`, map[string]string{"size": "abc"}, false},
+		{`{"size":[{"numeric":["=",5]}]}`, map[string]string{"size": "5.0"}, true},
+		{`{"size":[5]}`, map[string]string{"size": "5.00"}, true},
+		{`{"debug":[{"exists":false}]}`, nil, true},
+		{`{"debug":[{"exists":false}]}`, map[string]string{"debug": "1"}, false},
+		{`{"debug":[{"exists":true}]}`, map[string]string{"debug": ""}, true},
+		{`{"env":[{"anything-but":["test","dev"]}]}`, map[string]string{"env": "prod"}, true},
+		{`{"env":[{"anything-but":["test","dev"]}]}`, map[string]string{"env": "dev"}, false},
+		{`{"env":[{"anything-but":"dev"}]}`, nil, false},
+		{`{"env":[{"anything-but":{"prefix":"te"}}]}`, map[string]string{"env": "test"}, false},
+		{`{"a":["x"],"b":["y"]}`, map[string]string{"a": "x", "b": "z"}, false},
+		{`{"a":["x"],"b":["y",{"exists":false}]}`, map[string]string{"a": "x"}, true},
+	}
+	for _, c := range cases {
+		filter, err := parseFilterPolicy(c.policy)
+		if err != nil {
+			t.Fatalf("%s: %v", c.policy, err)
+		}
+		if filter.Match(c.attributes) != c.want {
+			t.Fatalf("%s with %v: want %v", c.policy, c.attributes, c.want)
+		}
+	}
+	if filter, err := parseFilterPolicy(""); filter != nil || err != nil {
+		t.Fatalf("empty policy: %v %v", filter, err)
+	}
+}
+
+func TestFilterPolicyInvalid(t *testing.T) {
+	for _, policy := range []string{`[]`, `null`, `{"a":[]}`, `{"a":"x"}`, `{"a":[{"numeric":["=",1,"<",2]}]}`,
+		`{"a":[{"numeric":[">",1,">",2]}]}`, `{"a":[{"numeric":["~",1]}]}`, `{"a":[{"prefix":""}]}`, `{"a":[{"exists":"yes"}]}`,
+		`{"a":[{"anything-but":[]}]}`, `{"a":[{"anything-but":{"exists":true}}]}`, `{"a":[{"x":1,"y":2}]}`, `{"a":[true]}`, `{`} {
+		if _, err := parseFilterPolicy(policy); errorKind(err) != ErrInvalid {
+			t.Fatalf("%s: %v", policy, err)
+		}
+	}
+}
+
+//不满足过滤策略的消息不入列，按订阅计入过滤掉的消息数，重新订阅替换策略并保留订阅时间
+func TestPublishFiltered(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		for _, queueName := range []string{"all", "red", "big"} {
+			storageTestQueue(t, OptionQueue{QueueName: queueName})
+		}
+		YumiQ.CreateTopic("t")
+		defer YumiQ.DelTopic("t")
+		YumiQ.Subscribe("t", "all", "")
+		YumiQ.Subscribe("t", "red", `{"color":["red"]}`)
+		if err := YumiQ.Subscribe("t", "big", `{"size":[{"numeric":[">",`); errorKind(err) != ErrInvalid {
+			t.Fatalf("subscribe with invalid policy: %v", err)
+		}
+		YumiQ.Subscribe("t", "big", `{"size":[{"numeric":[">",100]}]}`)
+
+		if ids, _ := YumiQ.Publish("t", PushEntry{Body: "1", Attributes: `{"color":"red","size":"5"}`}); len(ids) != 2 || ids["big"] != "" {
+			t.Fatalf("publish red: %v", ids)
+		}
+		if ids, _ := YumiQ.Publish("t", PushEntry{Body: "2", Attributes: `{"size":"500"}`}); len(ids) != 2 || ids["red"] != "" {
+			t.Fatalf("publish big: %v", ids)
+		}
+		YumiQ.Publish("t", PushEntry{Body: "3"})
+		if _, err := YumiQ.Publish("t", PushEntry{Body: "4", Attributes: "nope"}); errorKind(err) != ErrInvalid {
+			t.Fatalf("publish with invalid attributes: %v", err)
+		}
+		for queueName, want := range map[string]int64{"all": 3, "red": 1, "big": 1} {
+			if stats, _ := Store.Stats(queueName); stats.Ready != want {
+				t.Fatalf("%s stats: %+v", queueName, stats)
+			}
+		}
+
+		subscriptions, _ := YumiQ.Subscriptions("t")
+		if len(subscriptions) != 3 || subscriptions[0].FilteredMessages != 0 || subscriptions[1].FilteredMessages != 2 || subscriptions[2].FilteredMessages != 2 {
+			t.Fatalf("subscriptions: %+v", subscriptions)
+		}
+		if got := testutil.ToFloat64(filteredTotal.WithLabelValues("t", "red")); got != 2 {
+			t.Fatalf("filtered metric: %v", got)
+		}
+
+		Store.SaveSubscription("t", "red", `{"queueName":"red","createdTimestamp":1,"filterPolicy":{"color":["red"]}}`)
+		YumiQ.Subscribe("t", "red", "")
+		subscriptions, _ = YumiQ.Subscriptions("t")
+		if subscriptions[2].FilterPolicy != nil || subscriptions[2].CreatedTimestamp != 1 {
+			t.Fatalf("resubscribe: %+v", subscriptions[2])
+		}
+
+		//取消订阅后过滤计数清零
+		YumiQ.Unsubscribe("t", "big")
+		YumiQ.Subscribe("t", "big", "")
+		if subscriptions, _ = YumiQ.Subscriptions("t"); subscriptions[1].FilteredMessages != 0 {
+			t.Fatalf("filtered messages after resubscribe: %+v", subscriptions[1])
+		}
+		if testutil.CollectAndCount(filteredTotal) != 1 {
+			t.Fatalf("filtered metrics: %d", testutil.CollectAndCount(filteredTotal))
+		}
+	})
+}
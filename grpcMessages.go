@@ -0,0 +1,455 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+//proto/yumiq.proto中消息的编解码，按protobuf的wire格式手写，不依赖生成的代码
+//字段为零值时不编码，与proto3一致；未知字段解码时跳过
+type rpcMessage interface {
+	marshal() []byte
+	unmarshal(data []byte) error
+}
+
+//grpc编解码器，名字为proto，其他语言的客户端按yumiq.proto生成代码即可调用
+type rpcCodec struct{}
+
+func (this rpcCodec) Marshal(v interface{}) ([]byte, error) {
+	message, ok := v.(rpcMessage)
+	if !ok {
+		return nil, fmt.Errorf("grpc codec: unsupported type %T", v)
+	}
+	return message.marshal(), nil
+}
+
+func (this rpcCodec) Unmarshal(data []byte, v interface{}) error {
+	message, ok := v.(rpcMessage)
+	if !ok {
+		return fmt.Errorf("grpc codec: unsupported type %T", v)
+	}
+	return message.unmarshal(data)
+}
+
+func (this rpcCodec) Name() string {
+	return "proto"
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+func appendMessage(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+//map<string, string>按键排序，每项编码为 {1: 键, 2: 值}
+func appendMap(b []byte, num protowire.Number, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		b = appendMessage(b, num, appendString(appendString(nil, 1, k), 2, m[k]))
+	}
+	return b
+}
+
+//逐个读取字段，varint字段的值在v中，长度前缀字段的值在data中，其他类型跳过
+func consumeFields(b []byte, field func(num protowire.Number, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			if err := field(num, v, nil); err != nil {
+				return err
+			}
+		case protowire.BytesType:
+			data, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			if err := field(num, 0, data); err != nil {
+				return err
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+//读取map的一项
+func consumeMapEntry(data []byte, m map[string]string) error {
+	var key, value string
+	err := consumeFields(data, func(num protowire.Number, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			key = string(data)
+		case 2:
+			value = string(data)
+		}
+		return nil
+	})
+	m[key] = value
+	return err
+}
+
+type rpcQueueRequest struct {
+	QueueName                 string
+	VisibilityTimeout         int64
+	MessageRetentionPeriod    int64
+	DelaySeconds              int64
+	DeadLetterQueue           string
+	MaxReceiveCount           int64
+	FifoQueue                 *bool
+	DeduplicationWindow       int64
+	ContentBasedDeduplication bool
+	PriorityAging             int64
+}
+
+func (this *rpcQueueRequest) marshal() (b []byte) {
+	b = appendString(b, 1, this.QueueName)
+	b = appendInt(b, 2, this.VisibilityTimeout)
+	b = appendInt(b, 3, this.MessageRetentionPeriod)
+	b = appendInt(b, 4, this.DelaySeconds)
+	b = appendString(b, 5, this.DeadLetterQueue)
+	b = appendInt(b, 6, this.MaxReceiveCount)
+	if this.FifoQueue != nil { //optional字段设置了就编码
+		b = protowire.AppendTag(b, 7, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(*this.FifoQueue))
+	}
+	b = appendInt(b, 8, this.DeduplicationWindow)
+	b = appendBool(b, 9, this.ContentBasedDeduplication)
+	b = appendInt(b, 10, this.PriorityAging)
+	return
+}
+
+func (this *rpcQueueRequest) unmarshal(data []byte) error {
+	return consumeFields(data, func(num protowire.Number, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			this.QueueName = string(data)
+		case 2:
+			this.VisibilityTimeout = int64(v)
+		case 3:
+			this.MessageRetentionPeriod = int64(v)
+		case 4:
+			this.DelaySeconds = int64(v)
+		case 5:
+			this.DeadLetterQueue = string(data)
+		case 6:
+			this.MaxReceiveCount = int64(v)
+		case 7:
+			fifo := protowire.DecodeBool(v)
+			this.FifoQueue = &fifo
+		case 8:
+			this.DeduplicationWindow = int64(v)
+		case 9:
+			this.ContentBasedDeduplication = protowire.DecodeBool(v)
+		case 10:
+			this.PriorityAging = int64(v)
+		}
+		return nil
+	})
+}
+
+//与v1接口的请求相同，复用其转换
+func (this *rpcQueueRequest) option() OptionQueue {
+	return v1QueueRequest{this.QueueName, this.VisibilityTimeout, this.MessageRetentionPeriod, this.DelaySeconds, this.DeadLetterQueue,
+		this.MaxReceiveCount, this.FifoQueue, this.DeduplicationWindow, this.ContentBasedDeduplication, this.PriorityAging}.option()
+}
+
+type rpcQueueReply struct {
+	QueueName string
+}
+
+func (this *rpcQueueReply) marshal() []byte {
+	return appendString(nil, 1, this.QueueName)
+}
+
+func (this *rpcQueueReply) unmarshal(data []byte) error {
+	return consumeFields(data, func(num protowire.Number, v uint64, data []byte) error {
+		if num == 1 {
+			this.QueueName = string(data)
+		}
+		return nil
+	})
+}
+
+//只有队列名的请求，用于DeleteQueueRequest
+type rpcQueueNameRequest struct {
+	QueueName string
+}
+
+func (this *rpcQueueNameRequest) marshal() []byte {
+	return appendString(nil, 1, this.QueueName)
+}
+
+func (this *rpcQueueNameRequest) unmarshal(data []byte) error {
+	return consumeFields(data, func(num protowire.Number, v uint64, data []byte) error {
+		if num == 1 {
+			this.QueueName = string(data)
+		}
+		return nil
+	})
+}
+
+//没有字段的回复，用于DeleteQueueReply、DeleteReply和ChangeVisibilityReply
+type rpcEmpty struct{}
+
+func (this *rpcEmpty) marshal() []byte {
+	return nil
+}
+
+func (this *rpcEmpty) unmarshal(data []byte) error {
+	return consumeFields(data, func(num protowire.Number, v uint64, data []byte) error {
+		return nil
+	})
+}
+
+type rpcPushRequest struct {
+	QueueName       string
+	Body            string
+	DelaySeconds    int64
+	MessageGroupId  string
+	DeduplicationId string
+	Priority        int32
+	Attributes      map[string]string
+	DeliverAt       string
+}
+
+func (this *rpcPushRequest) marshal() (b []byte) {
+	b = appendString(b, 1, this.QueueName)
+	b = appendString(b, 2, this.Body)
+	b = appendInt(b, 3, this.DelaySeconds)
+	b = appendString(b, 4, this.MessageGroupId)
+	b = appendString(b, 5, this.DeduplicationId)
+	b = appendInt(b, 6, int64(this.Priority))
+	b = appendMap(b, 7, this.Attributes)
+	return appendString(b, 8, this.DeliverAt)
+}
+
+func (this *rpcPushRequest) unmarshal(data []byte) error {
+	return consumeFields(data, func(num protowire.Number, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			this.QueueName = string(data)
+		case 2:
+			this.Body = string(data)
+		case 3:
+			this.DelaySeconds = int64(v)
+		case 4:
+			this.MessageGroupId = string(data)
+		case 5:
+			this.DeduplicationId = string(data)
+		case 6:
+			this.Priority = int32(v)
+		case 7:
+			if this.Attributes == nil {
+				this.Attributes = make(map[string]string)
+			}
+			return consumeMapEntry(data, this.Attributes)
+		case 8:
+			this.DeliverAt = string(data)
+		}
+		return nil
+	})
+}
+
+func (this *rpcPushRequest) entry() PushEntry {
+	return v1MessageRequest{this.Body, this.DelaySeconds, this.MessageGroupId, this.DeduplicationId, int(this.Priority), this.Attributes, v1DeliverAt(this.DeliverAt)}.entry()
+}
+
+type rpcPushReply struct {
+	MessageId string
+}
+
+func (this *rpcPushReply) marshal() []byte {
+	return appendString(nil, 1, this.MessageId)
+}
+
+func (this *rpcPushReply) unmarshal(data []byte) error {
+	return consumeFields(data, func(num protowire.Number, v uint64, data []byte) error {
+		if num == 1 {
+			this.MessageId = string(data)
+		}
+		return nil
+	})
+}
+
+type rpcPopRequest struct {
+	QueueName   string
+	WaitSeconds int32
+	MaxMessages int32
+}
+
+func (this *rpcPopRequest) marshal() (b []byte) {
+	b = appendString(b, 1, this.QueueName)
+	b = appendInt(b, 2, int64(this.WaitSeconds))
+	return appendInt(b, 3, int64(this.MaxMessages))
+}
+
+func (this *rpcPopRequest) unmarshal(data []byte) error {
+	return consumeFields(data, func(num protowire.Number, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			this.QueueName = string(data)
+		case 2:
+			this.WaitSeconds = int32(v)
+		case 3:
+			this.MaxMessages = int32(v)
+		}
+		return nil
+	})
+}
+
+type rpcPopReply struct {
+	Messages []Message
+}
+
+func (this *rpcPopReply) marshal() (b []byte) {
+	for i := range this.Messages {
+		b = appendMessage(b, 1, this.Messages[i].marshal())
+	}
+	return
+}
+
+func (this *rpcPopReply) unmarshal(data []byte) error {
+	return consumeFields(data, func(num protowire.Number, v uint64, data []byte) error {
+		if num != 1 {
+			return nil
+		}
+		var message Message
+		if err := message.unmarshal(data); err != nil {
+			return err
+		}
+		this.Messages = append(this.Messages, message)
+		return nil
+	})
+}
+
+//出列的消息直接按Message编码
+func (this *Message) marshal() (b []byte) {
+	b = appendString(b, 1, this.MessageId)
+	b = appendString(b, 2, this.ReceiptHandle)
+	b = appendString(b, 3, this.Body)
+	b = appendMap(b, 4, this.Attributes)
+	b = appendInt(b, 5, this.SentTimestamp)
+	b = appendInt(b, 6, this.ApproximateFirstReceiveTimestamp)
+	return appendInt(b, 7, this.ApproximateReceiveCount)
+}
+
+func (this *Message) unmarshal(data []byte) error {
+	return consumeFields(data, func(num protowire.Number, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			this.MessageId = string(data)
+		case 2:
+			this.ReceiptHandle = string(data)
+		case 3:
+			this.Body = string(data)
+		case 4:
+			if this.Attributes == nil {
+				this.Attributes = make(map[string]string)
+			}
+			return consumeMapEntry(data, this.Attributes)
+		case 5:
+			this.SentTimestamp = int64(v)
+		case 6:
+			this.ApproximateFirstReceiveTimestamp = int64(v)
+		case 7:
+			this.ApproximateReceiveCount = int64(v)
+		}
+		return nil
+	})
+}
+
+//DeleteRequest和ChangeVisibilityRequest，删除时VisibilityTimeout不使用
+type rpcReceiptRequest struct {
+	QueueName         string
+	ReceiptHandle     string
+	VisibilityTimeout int64
+}
+
+func (this *rpcReceiptRequest) marshal() (b []byte) {
+	b = appendString(b, 1, this.QueueName)
+	b = appendString(b, 2, this.ReceiptHandle)
+	return appendInt(b, 3, this.VisibilityTimeout)
+}
+
+func (this *rpcReceiptRequest) unmarshal(data []byte) error {
+	return consumeFields(data, func(num protowire.Number, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			this.QueueName = string(data)
+		case 2:
+			this.ReceiptHandle = string(data)
+		case 3:
+			this.VisibilityTimeout = int64(v)
+		}
+		return nil
+	})
+}
+
+type rpcConsumeRequest struct {
+	QueueName  string
+	MaxUnacked int32
+}
+
+func (this *rpcConsumeRequest) marshal() (b []byte) {
+	b = appendString(b, 1, this.QueueName)
+	return appendInt(b, 2, int64(this.MaxUnacked))
+}
+
+func (this *rpcConsumeRequest) unmarshal(data []byte) error {
+	return consumeFields(data, func(num protowire.Number, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			this.QueueName = string(data)
+		case 2:
+			this.MaxUnacked = int32(v)
+		}
+		return nil
+	})
+}
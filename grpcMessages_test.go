@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+//proto中的消息与手写编解码的类型，多个消息可以共用一个类型
+var rpcTestTypes = map[string]func() rpcMessage{
+	"QueueRequest":            func() rpcMessage { return &rpcQueueRequest{} },
+	"QueueReply":              func() rpcMessage { return &rpcQueueReply{} },
+	"DeleteQueueRequest":      func() rpcMessage { return &rpcQueueNameRequest{} },
+	"DeleteQueueReply":        func() rpcMessage { return &rpcEmpty{} },
+	"PushRequest":             func() rpcMessage { return &rpcPushRequest{} },
+	"PushReply":               func() rpcMessage { return &rpcPushReply{} },
+	"PopRequest":              func() rpcMessage { return &rpcPopRequest{} },
+	"PopReply":                func() rpcMessage { return &rpcPopReply{} },
+	"Message":                 func() rpcMessage { return &Message{} },
+	"DeleteRequest":           func() rpcMessage { return &rpcReceiptRequest{} },
+	"DeleteReply":             func() rpcMessage { return &rpcEmpty{} },
+	"ChangeVisibilityRequest": func() rpcMessage { return &rpcReceiptRequest{} },
+	"ChangeVisibilityReply":   func() rpcMessage { return &rpcEmpty{} },
+	"ConsumeRequest":          func() rpcMessage { return &rpcConsumeRequest{} },
+}
+
+var (
+	rpcTestMessageLine = regexp.MustCompile(`^message (\w+) \{(\})?$`)
+	rpcTestFieldLine   = regexp.MustCompile(`^(optional |repeated )?(map<(\w+), (\w+)>|\w+) (\w+) = (\d+);$`)
+	rpcTestRpcLine     = regexp.MustCompile(`^rpc (\w+)\((\w+)\) returns \((stream )?(\w+)\);$`)
+	rpcTestScalarTypes = map[string]descriptorpb.FieldDescriptorProto_Type{
+		"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		"int64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		"int32":  descriptorpb.FieldDescriptorProto_TYPE_INT32,
+		"bool":   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	}
+)
+
+//proto中的rpc
+type rpcTestMethod struct {
+	request string
+	reply   string
+	stream  bool
+}
+
+//按yumiq.proto用到的语法解析出文件描述，不支持的写法直接失败，避免静默漏掉字段
+func rpcTestDescriptor(t *testing.T) (protoreflect.FileDescriptor, map[string]rpcTestMethod) {
+	data, err := ioutil.ReadFile("proto/yumiq.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := &descriptorpb.FileDescriptorProto{Name: proto.String("yumiq.proto"), Package: proto.String("yumiq"), Syntax: proto.String("proto3")}
+	methods := make(map[string]rpcTestMethod)
+	var message *descriptorpb.DescriptorProto
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "syntax ") || strings.HasPrefix(line, "package ") ||
+			strings.HasPrefix(line, "option ") || strings.HasPrefix(line, "service "):
+		case line == "}":
+			message = nil
+		case rpcTestMessageLine.MatchString(line):
+			match := rpcTestMessageLine.FindStringSubmatch(line)
+			message = &descriptorpb.DescriptorProto{Name: proto.String(match[1])}
+			file.MessageType = append(file.MessageType, message)
+			if match[2] != "" {
+				message = nil
+			}
+		case rpcTestRpcLine.MatchString(line):
+			match := rpcTestRpcLine.FindStringSubmatch(line)
+			methods[match[1]] = rpcTestMethod{match[2], match[4], match[3] != ""}
+		case message != nil && rpcTestFieldLine.MatchString(line):
+			match := rpcTestFieldLine.FindStringSubmatch(line)
+			number, _ := strconv.Atoi(match[6])
+			field := &descriptorpb.FieldDescriptorProto{Name: proto.String(match[5]), Number: proto.Int32(int32(number)),
+				JsonName: proto.String(match[5]), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()}
+			if match[1] == "repeated " {
+				field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			}
+
+			if match[3] != "" { //map按嵌套的Entry消息描述
+				entry := &descriptorpb.DescriptorProto{Name: proto.String(rpcTestCamel(match[5]) + "Entry"),
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)}}
+				for i, typ := range []string{match[3], match[4]} {
+					entry.Field = append(entry.Field, &descriptorpb.FieldDescriptorProto{Name: proto.String([]string{"key", "value"}[i]),
+						Number: proto.Int32(int32(i + 1)), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+						Type: rpcTestScalarTypes[typ].Enum()})
+				}
+				message.NestedType = append(message.NestedType, entry)
+				field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+				field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+				field.TypeName = proto.String(".yumiq." + message.GetName() + "." + entry.GetName())
+			} else if typ, ok := rpcTestScalarTypes[match[2]]; ok {
+				field.Type = typ.Enum()
+			} else {
+				field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+				field.TypeName = proto.String(".yumiq." + match[2])
+			}
+
+			if match[1] == "optional " { //proto3的optional是只有一个字段的oneof
+				field.Proto3Optional = proto.Bool(true)
+				field.OneofIndex = proto.Int32(int32(len(message.OneofDecl)))
+				message.OneofDecl = append(message.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String("_" + match[5])})
+			}
+			message.Field = append(message.Field, field)
+		default:
+			t.Fatalf("yumiq.proto: unsupported line %q", line)
+		}
+	}
+
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fd, methods
+}
+
+//queue_name对应Go的QueueName
+func rpcTestCamel(name string) string {
+	parts := strings.Split(name, "_")
+	for i, part := range parts {
+		parts[i] = strings.ToUpper(part[:1]) + part[1:]
+	}
+	return strings.Join(parts, "")
+}
+
+//按字段号给proto中的每个字段设置不同的非零值，嵌套消息同样填满
+func rpcTestFill(t *testing.T, v reflect.Value, desc protoreflect.MessageDescriptor) {
+	fields := desc.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		field := v.FieldByName(rpcTestCamel(string(fd.Name())))
+		if !field.IsValid() {
+			t.Fatalf("%s.%s has no Go field %s", desc.Name(), fd.Name(), rpcTestCamel(string(fd.Name())))
+		}
+
+		n := int64(fd.Number())
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(fmt.Sprintf("%s-%d", fd.Name(), n))
+		case reflect.Int32, reflect.Int64:
+			field.SetInt(-(n*1000 + 7)) //负数检查varint的符号扩展
+		case reflect.Bool:
+			field.SetBool(true)
+		case reflect.Ptr: //optional bool，设置为false检查有值时也编码
+			field.Set(reflect.New(field.Type().Elem()))
+		case reflect.Map:
+			field.Set(reflect.ValueOf(map[string]string{"a": fmt.Sprint(n), "b": ""}))
+		case reflect.Slice:
+			elem := reflect.New(field.Type().Elem()).Elem()
+			rpcTestFill(t, elem, fd.Message())
+			field.Set(reflect.Append(reflect.MakeSlice(field.Type(), 0, 2), elem, elem))
+		default:
+			t.Fatalf("%s.%s: unsupported Go type %s", desc.Name(), fd.Name(), field.Type())
+		}
+	}
+}
+
+//按proto的描述逐个字段比较解码结果与Go的值
+func rpcTestCompare(t *testing.T, v reflect.Value, m protoreflect.Message) {
+	desc := m.Descriptor()
+	if len(m.GetUnknown()) != 0 {
+		t.Errorf("%s: unknown fields in encoded message", desc.Name())
+	}
+
+	fields := desc.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		field := v.FieldByName(rpcTestCamel(string(fd.Name())))
+		got := m.Get(fd)
+
+		var ok bool
+		switch field.Kind() {
+		case reflect.String:
+			ok = fd.Kind() == protoreflect.StringKind && got.String() == field.String()
+		case reflect.Int32, reflect.Int64:
+			ok = (fd.Kind() == protoreflect.Int32Kind || fd.Kind() == protoreflect.Int64Kind) && got.Int() == field.Int()
+		case reflect.Bool:
+			ok = fd.Kind() == protoreflect.BoolKind && got.Bool() == field.Bool()
+		case reflect.Ptr:
+			ok = fd.HasPresence() && m.Has(fd) && got.Bool() == field.Elem().Bool()
+		case reflect.Map:
+			ok = fd.IsMap() && got.Map().Len() == field.Len()
+			got.Map().Range(func(key protoreflect.MapKey, value protoreflect.Value) bool {
+				ok = ok && field.MapIndex(reflect.ValueOf(key.String())).String() == value.String()
+				return ok
+			})
+		case reflect.Slice:
+			ok = fd.IsList() && got.List().Len() == field.Len()
+			for j := 0; ok && j < field.Len(); j++ {
+				rpcTestCompare(t, field.Index(j), got.List().Get(j).Message())
+			}
+		}
+		if !ok {
+			t.Errorf("%s.%s: Go value %v decoded as %v", desc.Name(), fd.Name(), field.Interface(), got)
+		}
+	}
+}
+
+//手写的编码能按proto解码且每个字段的号码、类型一致，按proto编码的数据也能解码回原值
+func TestRpcCodecMatchesProto(t *testing.T) {
+	fd, _ := rpcTestDescriptor(t)
+
+	messages := fd.Messages()
+	for i := 0; i < messages.Len(); i++ {
+		desc := messages.Get(i)
+		newMessage, ok := rpcTestTypes[string(desc.Name())]
+		if !ok {
+			t.Errorf("%s has no Go type", desc.Name())
+			continue
+		}
+
+		value := newMessage()
+		rpcTestFill(t, reflect.ValueOf(value).Elem(), desc)
+		data, err := rpcCodec{}.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded := dynamicpb.NewMessage(desc)
+		if err = proto.Unmarshal(data, decoded); err != nil {
+			t.Errorf("%s: %s", desc.Name(), err.Error())
+			continue
+		}
+		rpcTestCompare(t, reflect.ValueOf(value).Elem(), decoded)
+
+		data, err = proto.MarshalOptions{Deterministic: true}.Marshal(decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back := newMessage()
+		if err = (rpcCodec{}).Unmarshal(data, back); err != nil {
+			t.Errorf("%s: %s", desc.Name(), err.Error())
+		} else if !reflect.DeepEqual(back, value) {
+			t.Errorf("%s: decoded %+v, want %+v", desc.Name(), back, value)
+		}
+	}
+}
+
+var errRpcTestStop = errors.New("stop")
+
+//只接收请求，记录请求的类型
+type rpcTestStream struct {
+	grpc.ServerStream
+	request interface{}
+}
+
+func (this *rpcTestStream) RecvMsg(m interface{}) error {
+	this.request = m
+	return errRpcTestStop
+}
+
+//服务描述中的方法及其请求类型与proto一致
+func TestRpcServiceMatchesProto(t *testing.T) {
+	_, methods := rpcTestDescriptor(t)
+
+	served := make(map[string]bool)
+	for _, method := range yumiQServiceDesc.Methods {
+		served[method.MethodName] = true
+		want, ok := methods[method.MethodName]
+		if !ok || want.stream {
+			t.Errorf("%s is not a unary rpc in yumiq.proto", method.MethodName)
+			continue
+		}
+
+		var request interface{}
+		method.Handler(nil, context.Background(), func(m interface{}) error {
+			request = m
+			return errRpcTestStop
+		}, nil)
+		if reflect.TypeOf(request) != reflect.TypeOf(rpcTestTypes[want.request]()) {
+			t.Errorf("%s decodes %T, want the type of %s", method.MethodName, request, want.request)
+		}
+	}
+
+	for _, stream := range yumiQServiceDesc.Streams {
+		served[stream.StreamName] = true
+		want, ok := methods[stream.StreamName]
+		if !ok || !want.stream || !stream.ServerStreams || stream.ClientStreams {
+			t.Errorf("%s is not a server streaming rpc in yumiq.proto", stream.StreamName)
+			continue
+		}
+
+		recv := &rpcTestStream{}
+		stream.Handler(nil, recv)
+		if reflect.TypeOf(recv.request) != reflect.TypeOf(rpcTestTypes[want.request]()) {
+			t.Errorf("%s decodes %T, want the type of %s", stream.StreamName, recv.request, want.request)
+		}
+	}
+
+	for name := range methods {
+		if !served[name] {
+			t.Errorf("%s in yumiq.proto is not served", name)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//grpc服务，接口定义见proto/yumiq.proto
+type yumiQServer interface {
+	CreateQueue(ctx context.Context, req *rpcQueueRequest) (*rpcQueueReply, error)
+	UpdateQueue(ctx context.Context, req *rpcQueueRequest) (*rpcQueueReply, error)
+	DeleteQueue(ctx context.Context, req *rpcQueueNameRequest) (*rpcEmpty, error)
+	Push(ctx context.Context, req *rpcPushRequest) (*rpcPushReply, error)
+	Pop(ctx context.Context, req *rpcPopRequest) (*rpcPopReply, error)
+	Delete(ctx context.Context, req *rpcReceiptRequest) (*rpcEmpty, error)
+	ChangeVisibility(ctx context.Context, req *rpcReceiptRequest) (*rpcEmpty, error)
+	Consume(req *rpcConsumeRequest, stream grpc.ServerStream) error
+}
+
+//一元调用的处理函数，解码请求后经过拦截器调用服务的方法
+func rpcUnary(method string, newRequest func() rpcMessage, call func(srv yumiQServer, ctx context.Context, req rpcMessage) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newRequest()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(yumiQServer), ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/yumiq.YumiQ/" + method}
+			return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(yumiQServer), ctx, req.(rpcMessage))
+			})
+		},
+	}
+}
+
+var yumiQServiceDesc = grpc.ServiceDesc{
+	ServiceName: "yumiq.YumiQ",
+	HandlerType: (*yumiQServer)(nil),
+	Methods: []grpc.MethodDesc{
+		rpcUnary("CreateQueue", func() rpcMessage { return &rpcQueueRequest{} }, func(srv yumiQServer, ctx context.Context, req rpcMessage) (interface{}, error) {
+			return srv.CreateQueue(ctx, req.(*rpcQueueRequest))
+		}),
+		rpcUnary("UpdateQueue", func() rpcMessage { return &rpcQueueRequest{} }, func(srv yumiQServer, ctx context.Context, req rpcMessage) (interface{}, error) {
+			return srv.UpdateQueue(ctx, req.(*rpcQueueRequest))
+		}),
+		rpcUnary("DeleteQueue", func() rpcMessage { return &rpcQueueNameRequest{} }, func(srv yumiQServer, ctx context.Context, req rpcMessage) (interface{}, error) {
+			return srv.DeleteQueue(ctx, req.(*rpcQueueNameRequest))
+		}),
+		rpcUnary("Push", func() rpcMessage { return &rpcPushRequest{} }, func(srv yumiQServer, ctx context.Context, req rpcMessage) (interface{}, error) {
+			return srv.Push(ctx, req.(*rpcPushRequest))
+		}),
+		rpcUnary("Pop", func() rpcMessage { return &rpcPopRequest{} }, func(srv yumiQServer, ctx context.Context, req rpcMessage) (interface{}, error) {
+			return srv.Pop(ctx, req.(*rpcPopRequest))
+		}),
+		rpcUnary("Delete", func() rpcMessage { return &rpcReceiptRequest{} }, func(srv yumiQServer, ctx context.Context, req rpcMessage) (interface{}, error) {
+			return srv.Delete(ctx, req.(*rpcReceiptRequest))
+		}),
+		rpcUnary("ChangeVisibility", func() rpcMessage { return &rpcReceiptRequest{} }, func(srv yumiQServer, ctx context.Context, req rpcMessage) (interface{}, error) {
+			return srv.ChangeVisibility(ctx, req.(*rpcReceiptRequest))
+		}),
+	},
+	Streams: []grpc.StreamDesc{{
+		StreamName: "Consume",
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			req := &rpcConsumeRequest{}
+			if err := stream.RecvMsg(req); err != nil {
+				return err
+			}
+			return srv.(yumiQServer).Consume(req, stream)
+		},
+		ServerStreams: true,
+	}},
+	Metadata: "yumiq.proto",
+}
+
+//grpc服务，使用手写的编解码器
+func NewGrpcServer() *grpc.Server {
+	server := grpc.NewServer(grpc.ForceServerCodec(rpcCodec{}))
+	server.RegisterService(&yumiQServiceDesc, &GrpcServer{})
+	return server
+}
+
+func ServeGrpc(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return NewGrpcServer().Serve(listener)
+}
+
+//按错误类型返回grpc状态码，ctx结束时返回对应的状态码，没有类型的错误来自存储
+func rpcError(err error) error {
+	switch err {
+	case context.Canceled:
+		return status.Error(codes.Canceled, err.Error())
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+
+	switch errorKind(err) {
+	case ErrInvalid:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case ErrNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case ErrConflict:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func rpcQueueExists(queueName string) error {
+	if _, ok := Queue.Get(queueName); !ok {
+		return status.Errorf(codes.NotFound, "Queue %s doesn't exist", queueName)
+	}
+	return nil
+}
+
+type GrpcServer struct{}
+
+func (this *GrpcServer) CreateQueue(ctx context.Context, req *rpcQueueRequest) (*rpcQueueReply, error) {
+	if req.QueueName == "" {
+		return nil, status.Error(codes.InvalidArgument, "queue_name must not be null")
+	}
+	if err := YumiQ.Create(req.option()); errorKind(err) == ErrConflict {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	} else if err != nil {
+		return nil, rpcError(err)
+	}
+	return &rpcQueueReply{req.QueueName}, nil
+}
+
+func (this *GrpcServer) UpdateQueue(ctx context.Context, req *rpcQueueRequest) (*rpcQueueReply, error) {
+	if req.QueueName == "" {
+		return nil, status.Error(codes.InvalidArgument, "queue_name must not be null")
+	}
+	if err := YumiQ.Update(req.option()); err != nil {
+		return nil, rpcError(err)
+	}
+	return &rpcQueueReply{req.QueueName}, nil
+}
+
+func (this *GrpcServer) DeleteQueue(ctx context.Context, req *rpcQueueNameRequest) (*rpcEmpty, error) {
+	if exists, _ := Queue.queueExists(req.QueueName); !exists {
+		return nil, status.Errorf(codes.NotFound, "Queue %s doesn't exist", req.QueueName)
+	}
+	if err := YumiQ.DelQueue(req.QueueName); err != nil {
+		return nil, rpcError(err)
+	}
+	return &rpcEmpty{}, nil
+}
+
+func (this *GrpcServer) Push(ctx context.Context, req *rpcPushRequest) (*rpcPushReply, error) {
+	if req.Body == "" {
+		return nil, status.Error(codes.InvalidArgument, "body must not be null")
+	}
+	if err := rpcQueueExists(req.QueueName); err != nil {
+		return nil, err
+	}
+
+	id, err := YumiQ.Push(req.QueueName, req.entry())
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return &rpcPushReply{id}, nil
+}
+
+//没有消息时返回空列表，客户端取消或超时后不再等待，不会出列消息
+func (this *GrpcServer) Pop(ctx context.Context, req *rpcPopRequest) (*rpcPopReply, error) {
+	if req.WaitSeconds < 0 || req.MaxMessages < 0 {
+		return nil, status.Error(codes.InvalidArgument, "wait_seconds and max_messages must not be less than zero")
+	}
+
+	messages, err := YumiQ.PopContext(ctx, req.QueueName, int(req.WaitSeconds), int(req.MaxMessages), 0)
+	if errorKind(err) == ErrEmpty {
+		return &rpcPopReply{}, nil
+	} else if err != nil {
+		return nil, rpcError(err)
+	}
+	return &rpcPopReply{messages}, nil
+}
+
+func (this *GrpcServer) Delete(ctx context.Context, req *rpcReceiptRequest) (*rpcEmpty, error) {
+	if err := rpcQueueExists(req.QueueName); err != nil {
+		return nil, err
+	}
+	if err := YumiQ.Del(req.QueueName, req.ReceiptHandle); err != nil {
+		return nil, rpcError(err)
+	}
+	return &rpcEmpty{}, nil
+}
+
+func (this *GrpcServer) ChangeVisibility(ctx context.Context, req *rpcReceiptRequest) (*rpcEmpty, error) {
+	if req.VisibilityTimeout < 0 {
+		return nil, status.Error(codes.InvalidArgument, "visibility_timeout must not be less than zero")
+	}
+	if err := rpcQueueExists(req.QueueName); err != nil {
+		return nil, err
+	}
+	if err := YumiQ.SetVisibilityTime(req.QueueName, req.ReceiptHandle, req.VisibilityTimeout); err != nil {
+		return nil, rpcError(err)
+	}
+	return &rpcEmpty{}, nil
+}
+
+//消息就绪后推送给客户端，直到客户端断开
+//已推送但未删除、仍在隐藏中的消息达到MaxUnacked时暂停，客户端删除消息或消息隐藏到期后继续
+func (this *GrpcServer) Consume(req *rpcConsumeRequest, stream grpc.ServerStream) error {
+	if err := rpcQueueExists(req.QueueName); err != nil {
+		return err
+	}
+	if req.MaxUnacked < 0 {
+		return status.Error(codes.InvalidArgument, "max_unacked must not be less than zero")
+	}
+
+	c := Consumers.Add(int(req.MaxUnacked))
+	defer Consumers.Remove(c)
+
+	err := c.Run(stream.Context().Done(), []string{req.QueueName}, func(queueName string, message *Message) error {
+		return stream.SendMsg(message)
+	})
+	if errorKind(err) != 0 {
+		return rpcError(err)
+	}
+	return err
+}
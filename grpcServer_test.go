@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+//在随机端口启动grpc服务，返回已连接的客户端
+func grpcTestDial(t *testing.T) *grpc.ClientConn {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewGrpcServer()
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithDefaultCallOptions(grpc.ForceCodec(rpcCodec{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func grpcTestInvoke(conn *grpc.ClientConn, method string, req rpcMessage, reply rpcMessage) error {
+	return conn.Invoke(context.Background(), "/yumiq.YumiQ/"+method, req, reply)
+}
+
+//一元调用按错误类型返回grpc状态码
+func TestGrpcUnary(t *testing.T) {
+	storageTestSetup(t, "memory")
+	conn := grpcTestDial(t)
+
+	if err := grpcTestInvoke(conn, "CreateQueue", &rpcQueueRequest{QueueName: "g", VisibilityTimeout: 30}, &rpcQueueReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := grpcTestInvoke(conn, "CreateQueue", &rpcQueueRequest{QueueName: "g", VisibilityTimeout: 30}, &rpcQueueReply{}); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("create existing queue: %v", err)
+	}
+	if err := grpcTestInvoke(conn, "Push", &rpcPushRequest{QueueName: "missing", Body: "x"}, &rpcPushReply{}); status.Code(err) != codes.NotFound {
+		t.Fatalf("push to missing queue: %v", err)
+	}
+	if err := grpcTestInvoke(conn, "Push", &rpcPushRequest{QueueName: "g", Body: "x", Priority: 10}, &rpcPushReply{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("push with bad priority: %v", err)
+	}
+
+	push := &rpcPushReply{}
+	if err := grpcTestInvoke(conn, "Push", &rpcPushRequest{QueueName: "g", Body: "x", Attributes: map[string]string{"a": "b"}}, push); err != nil || push.MessageId == "" {
+		t.Fatalf("push: %v %v", push, err)
+	}
+	pop := &rpcPopReply{}
+	if err := grpcTestInvoke(conn, "Pop", &rpcPopRequest{QueueName: "g", MaxMessages: 5}, pop); err != nil || len(pop.Messages) != 1 {
+		t.Fatalf("pop: %v %v", pop, err)
+	}
+	if message := pop.Messages[0]; message.MessageId != push.MessageId || message.Attributes["a"] != "b" || message.ApproximateReceiveCount != 1 {
+		t.Fatalf("popped message: %+v", message)
+	}
+	if err := grpcTestInvoke(conn, "ChangeVisibility", &rpcReceiptRequest{QueueName: "g", ReceiptHandle: pop.Messages[0].ReceiptHandle, VisibilityTimeout: 60}, &rpcEmpty{}); err != nil {
+		t.Fatalf("change visibility: %v", err)
+	}
+	if err := grpcTestInvoke(conn, "Delete", &rpcReceiptRequest{QueueName: "g", ReceiptHandle: pop.Messages[0].ReceiptHandle}, &rpcEmpty{}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := grpcTestInvoke(conn, "Delete", &rpcReceiptRequest{QueueName: "g", ReceiptHandle: pop.Messages[0].ReceiptHandle}, &rpcEmpty{}); status.Code(err) != codes.NotFound {
+		t.Fatalf("delete twice: %v", err)
+	}
+
+	if err := grpcTestInvoke(conn, "DeleteQueue", &rpcQueueNameRequest{QueueName: "g"}, &rpcEmpty{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := grpcTestInvoke(conn, "Pop", &rpcPopRequest{QueueName: "g"}, &rpcPopReply{}); status.Code(err) != codes.NotFound {
+		t.Fatalf("pop from deleted queue: %v", err)
+	}
+}
+
+//推送的未确认消息达到MaxUnacked时暂停，删除消息或隐藏到期后继续推送
+func TestGrpcConsumeFlowControl(t *testing.T) {
+	storageTestSetup(t, "memory")
+	conn := grpcTestDial(t)
+	storageTestQueue(t, OptionQueue{QueueName: "g"})
+	for i := 0; i < 5; i++ {
+		YumiQ.Push("g", PushEntry{Body: "m" + toString(int64(i))})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/yumiq.YumiQ/Consume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.SendMsg(&rpcConsumeRequest{QueueName: "g", MaxUnacked: 2})
+	stream.CloseSend()
+	received := make(chan Message, 10)
+	go func() {
+		for {
+			var message Message
+			if err := stream.RecvMsg(&message); err != nil {
+				close(received)
+				return
+			}
+			received <- message
+		}
+	}()
+
+	receive := func() Message {
+		select {
+		case message := <-received:
+			return message
+		case <-time.After(2 * time.Second):
+			t.Fatal("no message pushed")
+		}
+		return Message{}
+	}
+	expectNone := func() {
+		select {
+		case message := <-received:
+			t.Fatalf("pushed over MaxUnacked: %+v", message)
+		case <-time.After(3 * popPollInterval):
+		}
+	}
+
+	first, second := receive(), receive()
+	expectNone()
+
+	//通过HTTP接口确认也计入流控
+	if err := YumiQ.Del("g", first.ReceiptHandle); err != nil {
+		t.Fatal(err)
+	}
+	receive()
+	expectNone()
+
+	//隐藏到期的消息不再计入未确认
+	if err := YumiQ.SetVisibilityTime("g", second.ReceiptHandle, -1); err != nil {
+		t.Fatal(err)
+	}
+	if again := receive(); again.MessageId == second.MessageId {
+		t.Fatalf("expired message pushed before the queue's other messages: %+v", again)
+	}
+	expectNone()
+
+}
+
+//队列不存在或MaxUnacked为负数时推送消费立即返回错误
+func TestGrpcConsumeErrors(t *testing.T) {
+	storageTestSetup(t, "memory")
+	conn := grpcTestDial(t)
+	storageTestQueue(t, OptionQueue{QueueName: "g"})
+
+	for _, c := range []struct {
+		req  *rpcConsumeRequest
+		code codes.Code
+	}{
+		{&rpcConsumeRequest{QueueName: "missing"}, codes.NotFound},
+		{&rpcConsumeRequest{QueueName: "g", MaxUnacked: -1}, codes.InvalidArgument},
+	} {
+		stream, err := conn.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/yumiq.YumiQ/Consume")
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.SendMsg(c.req)
+		stream.CloseSend()
+		if err := stream.RecvMsg(&Message{}); status.Code(err) != c.code {
+			t.Fatalf("consume %+v: %v", c.req, err)
+		}
+	}
+}
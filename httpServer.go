@@ -3,6 +3,10 @@ package main
 import (
 	"net/http"
 	"log"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 type WaitForYou struct{}
@@ -15,6 +19,26 @@ func (this *WaitForYou) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	}()
 
 	ac := req.URL.Path
+	route := ac //未知路径统一记为other，避免指标标签无限增长
+	upgrade := websocket.IsWebSocketUpgrade(req)
+	defer func(start time.Time) {
+		if !upgrade { //WebSocket长连接的耗时是连接时长，不计入请求耗时
+			observeRequest(route, start)
+		}
+	}(time.Now())
+
+	//v1接口，路由指标按路由模板记录
+	if strings.HasPrefix(ac, "/v1/") {
+		route = ServeV1(res, req)
+		return
+	}
+
+	//SQS兼容接口，AWS SDK请求根路径或队列URL，路由指标按操作名记录
+	if req.Header.Get("X-Amz-Target") != "" || ac == "/" || strings.HasPrefix(ac, "/"+sqsAccountId+"/") {
+		route = ServeSqs(res, req)
+		return
+	}
+
 	if ac == "/createQueue" {
 		CreateQueue(res, req)
 		return
@@ -24,6 +48,9 @@ func (this *WaitForYou) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	} else if ac == "/push" {
 		Push(res, req)
 		return
+	} else if ac == "/pushBatch" {
+		PushBatch(res, req)
+		return
 	} else if ac == "/pop" {
 		Pop(res, req)
 		return
@@ -36,11 +63,66 @@ func (this *WaitForYou) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	} else if ac == "/delQueue" {
 		DelQueue(res, req)
 		return
+	} else if ac == "/redrive" {
+		Redrive(res, req)
+		return
+	} else if ac == "/purgeQueue" {
+		PurgeQueue(res, req)
+		return
+	} else if ac == "/peek" {
+		Peek(res, req)
+		return
+	} else if ac == "/listScheduled" {
+		ListScheduled(res, req)
+		return
+	} else if ac == "/cancelScheduled" {
+		CancelScheduled(res, req)
+		return
+	} else if ac == "/getQueueAttributes" {
+		GetQueueAttributes(res, req)
+		return
+	} else if ac == "/listQueues" {
+		ListQueues(res, req)
+		return
+	} else if ac == "/ws" {
+		Consume(res, req)
+		return
+	} else if ac == "/subscribe" {
+		SubscribeTopic(res, req)
+		return
+	} else if ac == "/createTopic" {
+		CreateTopic(res, req)
+		return
+	} else if ac == "/delTopic" {
+		DelTopic(res, req)
+		return
+	} else if ac == "/listTopics" {
+		ListTopics(res, req)
+		return
+	} else if ac == "/unsubscribe" {
+		UnsubscribeTopic(res, req)
+		return
+	} else if ac == "/listSubscriptions" {
+		ListSubscriptions(res, req)
+		return
+	} else if ac == "/publish" {
+		Publish(res, req)
+		return
+	} else if ac == "/setWebhook" {
+		SetWebhook(res, req)
+		return
+	} else if ac == "/getWebhook" {
+		GetWebhook(res, req)
+		return
+	} else if ac == "/metrics" {
+		Metrics(res, req)
+		return
 	} else if ac == "/ping" {
 		res.Write([]byte("pong"))
 		return
 	}
 
+	route = "other"
 	http.NotFound(res, req)
 }
 
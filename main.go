@@ -5,52 +5,65 @@ import (
 	"time"
 	"log"
 	"flag"
-	"github.com/gomodule/redigo/redis"
-	"github.com/hjr265/redsync.go/redsync"
 )
 
 var (
-	Host  string
-	Port  string
-	Redis string
-	Auth  string
+	Host             string
+	Port             string
+	GrpcPort         string
+	RespPort         string
+	RespPassword     string
+	Redis            string
+	Auth             string
+	PromoteBatch     int
+	MaxBatch         int
+	StorageType      string
+	DataDir          string
+	SnapshotInterval int
 )
 
-func init() {
+//解析参数，初始化存储、队列管理器和调度器，在main中调用，测试时不会解析参数和连接存储
+func boot() {
 
 	log.SetFlags(log.LstdFlags)
 	flag.StringVar(&Host, "host", "localhost", "Bound IP. default:localhost")
 	flag.StringVar(&Port, "port", "9394", "port. default:9394")
+	flag.StringVar(&GrpcPort, "grpcPort", "", "gRPC port, empty to disable. default:disabled")
+	flag.StringVar(&RespPort, "respPort", "", "Redis protocol (RESP) port, empty to disable. default:disabled")
+	flag.StringVar(&RespPassword, "respPassword", "", "password clients must send with AUTH on the RESP port, empty to disable")
 	flag.StringVar(&Redis, "redis", "127.0.0.1:6379", "redis server. default:127.0.0.1:6379")
 	flag.StringVar(&Auth, "auth", "", "redis server auth password")
+	flag.IntVar(&PromoteBatch, "promoteBatch", 1000, "max delayed messages moved to ready queue per tick. default:1000")
+	flag.StringVar(&StorageType, "storage", "redis", "storage backend: redis, memory or disk. default:redis")
+	flag.StringVar(&DataDir, "dataDir", "./data", "data directory of disk storage. default:./data")
+	flag.IntVar(&SnapshotInterval, "snapshotInterval", 60, "seconds between disk storage snapshots. default:60")
+	flag.IntVar(&MaxBatch, "maxBatch", 10, "max messages per batch push or pop. default:10")
 
 	flag.Parse()
-	Pool = newPool(Redis) //创建redis连接池
-	log.Printf("Success:redis have connected")
 
 	var err error
-	redisPool := []*redis.Pool{Pool}
-	Qlock, err = redsync.NewMutexWithPool("redsync", redisPool)
-	if err != nil{
-		log.Fatalf("redsync error: %v", err)
+	if Store, err = NewStorage(StorageType); err != nil {
+		log.Fatalf("storage error: %s", err.Error())
 	}
+	log.Printf("Success:%s storage is ready", StorageType)
 
 	YumiQ = NewYumi()
-	ReadyQ = NewReadyQueue()
-	DelayQ = NewDelayQueue()
 	Queue = NewQueues()
+	Sched = NewScheduler()
 
 	if err := Queue.init(); err != nil {
 		log.Fatalf("queue init error: %s", err.Error())
 	}
 
-	DelayQ.Trigger()
+	Sched.Start()
+	Webhooks.Start() //只有调度器的leader推送
 	YumiQ.FunWork() //暂去掉
 }
 
 func main() {
 
 	//runtime.GOMAXPROCS(runtime.NumCPU())
+	boot()
 
 	s := &http.Server{
 		Addr:           Host + ":" + Port,
@@ -60,18 +73,52 @@ func main() {
 		MaxHeaderBytes: 1 << 20,
 	}
 
+	//grpc服务与http服务使用同一个绑定IP
+	if GrpcPort != "" {
+		go func() {
+			log.Printf("Success:gRPC has been started")
+			log.Fatal(ServeGrpc(Host + ":" + GrpcPort))
+		}()
+	}
+
+	if RespPort != "" {
+		go func() {
+			log.Printf("Success:RESP has been started")
+			log.Fatal(ServeResp(Host + ":" + RespPort))
+		}()
+	}
+
 	log.Printf("Success:HTTP has been started")
 	log.Fatal(s.ListenAndServe())
 
 }
 
 /*
-分3个存储方式
+redis存储（-storage redis，见redisStorage.go），memory存储用内存中的map和slice实现同样的结构
+disk存储在memory存储之上把每次修改写入-dataDir下的预写日志，并定期写快照
 1.set用于存储队列的名字
 2.hash用于存储队列的配置信息
-	QueueName              string
-	VisibilityTimeout      string
-	MessageRetentionPeriod string
-	DelaySeconds           string
-3.zset用于存储延迟队列
+	QueueName                 string
+	VisibilityTimeout         string
+	MessageRetentionPeriod    string
+	DelaySeconds              string
+	DeadLetterQueue           string
+	MaxReceiveCount           string
+	FifoQueue                 string
+	DeduplicationWindow       string
+	ContentBasedDeduplication string
+	PriorityAging             string
+	CreatedTimestamp          string
+	LastModifiedTimestamp     string
+	WebhookEndpoint           string（webhook配置，见webhook.go，修改队列时保留）
+	WebhookMaxConcurrency     string
+	WebhookTimeout            string
+	WebhookSecret             string
+3.zset用于存储延迟队列，成员为消息ID
+  另有一个zset作为所有队列的到期索引，成员为队列名，分数为该队列最早的到期时间
+4.hash用于存储消息体、消息属性（json）、最近一次接收的回执、首次接收时间、接收次数以及死信消息的来源队列，键为消息ID
+5.zset用于存储消息的入列时间，成员为消息ID
+6.FIFO队列另有hash存储消息所属的消息组，每个消息组一个list按入列顺序存放消息ID，只有组内第一条在准备或延迟队列中
+7.去重ID存放在hash中，其截止时间存放在zset中，入列时清理过期的去重ID
+8.优先级大于0的消息放在按优先级区分的准备队列（list）中，其优先级存放在hash中
 */
\ No newline at end of file
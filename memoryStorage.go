@@ -0,0 +1,1057 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+//单个队列在内存中的数据
+//字段导出用于磁盘存储的快照
+type memoryQueue struct {
+	Ready    []string          `json:"ready"`    //优先级为0的准备队列，队首先出
+	Delay    map[string]int64  `json:"delay"`    //延迟队列，隐藏中的消息也在其中，值为到期时间
+	Bodies   map[string]string `json:"bodies"`   //消息体
+	Receipts map[string]string `json:"receipts"` //最近一次接收的回执
+	Counts   map[string]int64  `json:"counts"`   //接收次数
+	Sources  map[string]string `json:"sources"`  //死信消息的来源队列
+	SentAt   map[string]int64  `json:"sentAt"`   //入列时间
+
+	MessageGroups map[string]string            `json:"messageGroups"` //消息所属的消息组
+	Groups        map[string][]string          `json:"groups"`        //消息组，按入列顺序存放组内的消息ID
+	Dedup         map[string]memoryDedupEntry  `json:"dedup"`         //去重ID
+	PriorityReady map[int][]string             `json:"priorityReady"` //优先级大于0的准备队列，按优先级存放
+	Priorities    map[string]int               `json:"priorities"`    //优先级大于0的消息的优先级
+	Attributes    map[string]map[string]string `json:"attributes"`    //消息属性
+	FirstReceive  map[string]int64             `json:"firstReceive"`  //首次接收时间
+
+	//以下由上面的数据推算，恢复快照时重建
+	signal   chan struct{}  //有消息进入准备队列时关闭，唤醒所有等待出列的调用方
+	dueHeap  memoryTimeHeap //延迟队列的到期索引
+	sentHeap memoryTimeHeap //入列时间索引，用于统计最早的入列时间
+	inFlight int64          //延迟队列中有回执的消息数，即隐藏中的消息数
+	waiting  int64          //消息组中排在第一条之后的消息数
+}
+
+//时间索引项，时间与对应map中不一致或消息已不在其中的已失效，读取时跳过
+type memoryTimeEntry struct {
+	at int64
+	id string
+}
+
+//按时间排列的最小堆，时间相同时按消息ID
+type memoryTimeHeap []memoryTimeEntry
+
+func (this memoryTimeHeap) Len() int { return len(this) }
+func (this memoryTimeHeap) Less(i, j int) bool {
+	if this[i].at != this[j].at {
+		return this[i].at < this[j].at
+	}
+	return this[i].id < this[j].id
+}
+func (this memoryTimeHeap) Swap(i, j int)       { this[i], this[j] = this[j], this[i] }
+func (this *memoryTimeHeap) Push(x interface{}) { *this = append(*this, x.(memoryTimeEntry)) }
+func (this *memoryTimeHeap) Pop() interface{} {
+	old := *this
+	entry := old[len(old)-1]
+	*this = old[:len(old)-1]
+	return entry
+}
+
+//去重ID对应的消息ID及截止时间
+type memoryDedupEntry struct {
+	Id    string `json:"id"`
+	Until int64  `json:"until"`
+}
+
+func newMemoryQueue() *memoryQueue {
+	q := &memoryQueue{}
+	q.fill()
+	return q
+}
+
+//补齐为空的map，快照恢复的队列也要调用
+func (this *memoryQueue) fill() {
+	if this.Delay == nil {
+		this.Delay = make(map[string]int64)
+	}
+	if this.Bodies == nil {
+		this.Bodies = make(map[string]string)
+	}
+	if this.Receipts == nil {
+		this.Receipts = make(map[string]string)
+	}
+	if this.Counts == nil {
+		this.Counts = make(map[string]int64)
+	}
+	if this.Sources == nil {
+		this.Sources = make(map[string]string)
+	}
+	if this.SentAt == nil {
+		this.SentAt = make(map[string]int64)
+	}
+	if this.MessageGroups == nil {
+		this.MessageGroups = make(map[string]string)
+	}
+	if this.Groups == nil {
+		this.Groups = make(map[string][]string)
+	}
+	if this.Dedup == nil {
+		this.Dedup = make(map[string]memoryDedupEntry)
+	}
+	if this.PriorityReady == nil {
+		this.PriorityReady = make(map[int][]string)
+	}
+	if this.Priorities == nil {
+		this.Priorities = make(map[string]int)
+	}
+	if this.Attributes == nil {
+		this.Attributes = make(map[string]map[string]string)
+	}
+	if this.FirstReceive == nil {
+		this.FirstReceive = make(map[string]int64)
+	}
+	if this.dueHeap == nil {
+		this.reindex()
+	}
+}
+
+//按快照中的数据重建索引和计数
+func (this *memoryQueue) reindex() {
+	this.rebuildDue()
+	this.rebuildSent()
+	this.inFlight, this.waiting = 0, 0
+	for id := range this.Delay {
+		if _, ok := this.Receipts[id]; ok {
+			this.inFlight++
+		}
+	}
+	for _, ids := range this.Groups {
+		this.waiting += int64(len(ids) - 1)
+	}
+}
+
+//按消息的优先级放入准备队列
+func (this *memoryQueue) pushReady(id string) {
+	if priority := this.Priorities[id]; priority != 0 {
+		this.PriorityReady[priority] = append(this.PriorityReady[priority], id)
+		this.notify()
+	} else {
+		this.appendReady(id)
+	}
+}
+
+//放入优先级为0的准备队列
+func (this *memoryQueue) appendReady(id string) {
+	this.Ready = append(this.Ready, id)
+	this.notify()
+}
+
+//唤醒等待出列的调用方
+func (this *memoryQueue) notify() {
+	if this.signal != nil {
+		close(this.signal)
+		this.signal = nil
+	}
+}
+
+//从所在优先级的准备队列中移除
+func (this *memoryQueue) removeReady(id string) bool {
+	priority := this.Priorities[id]
+	ready := this.Ready
+	if priority != 0 {
+		ready = this.PriorityReady[priority]
+	}
+
+	for i, v := range ready {
+		if v != id {
+			continue
+		}
+		ready = append(ready[:i], ready[i+1:]...)
+		if priority == 0 {
+			this.Ready = ready
+		} else if len(ready) == 0 {
+			delete(this.PriorityReady, priority)
+		} else {
+			this.PriorityReady[priority] = ready
+		}
+		return true
+	}
+	return false
+}
+
+//与redis的popReady一致：aging为0时从最高优先级取，否则按各优先级队首消息老化后的优先级比较，相同时取原优先级高的
+func (this *memoryQueue) popReady(now int64, aging int64) (string, bool) {
+	best, chosen := int64(-1), -1
+	for priority := maxPriority; priority >= 0; priority-- {
+		ready := this.Ready
+		if priority != 0 {
+			ready = this.PriorityReady[priority]
+		}
+		if len(ready) == 0 {
+			continue
+		}
+		if aging <= 0 {
+			chosen = priority
+			break
+		}
+
+		effective := int64(priority)
+		if sentAt, ok := this.SentAt[ready[0]]; ok && now > sentAt {
+			effective += (now - sentAt) / aging
+		}
+		if effective > best {
+			best, chosen = effective, priority
+		}
+	}
+
+	switch {
+	case chosen < 0:
+		return "", false
+	case chosen == 0:
+		id := this.Ready[0]
+		this.Ready = this.Ready[1:]
+		return id, true
+	default:
+		ready := this.PriorityReady[chosen]
+		if len(ready) == 1 {
+			delete(this.PriorityReady, chosen)
+		} else {
+			this.PriorityReady[chosen] = ready[1:]
+		}
+		return ready[0], true
+	}
+}
+
+//删除消息的全部数据
+func (this *memoryQueue) remove(id string) {
+	this.removeReady(id)
+	this.forget(id)
+}
+
+//删除准备队列以外的全部数据，用于已移出准备队列的消息
+func (this *memoryQueue) forget(id string) {
+	if _, ok := this.Delay[id]; ok {
+		if _, ok = this.Receipts[id]; ok {
+			this.inFlight--
+		}
+	}
+	delete(this.Delay, id)
+	delete(this.Bodies, id)
+	delete(this.Receipts, id)
+	delete(this.Counts, id)
+	delete(this.Sources, id)
+	delete(this.SentAt, id)
+	delete(this.Priorities, id)
+	delete(this.Attributes, id)
+	delete(this.FirstReceive, id)
+	this.releaseGroup(id)
+}
+
+//移出所在的消息组，是组内第一条时把下一条放入准备队列
+func (this *memoryQueue) releaseGroup(id string) {
+	group, ok := this.MessageGroups[id]
+	if !ok {
+		return
+	}
+	delete(this.MessageGroups, id)
+
+	ids := this.Groups[group]
+	for i, v := range ids {
+		if v != id {
+			continue
+		}
+		ids = append(ids[:i:i], ids[i+1:]...)
+		if len(ids) != 0 { //移出的是排在后面的消息，或下一条成为组内第一条
+			this.waiting--
+		}
+		if i == 0 && len(ids) != 0 {
+			this.appendReady(ids[0])
+		}
+		break
+	}
+	if len(ids) == 0 {
+		delete(this.Groups, group)
+	} else {
+		this.Groups[group] = ids
+	}
+}
+
+//放入延迟队列并记录到期索引，原来的索引项随之失效
+func (this *memoryQueue) setDelay(id string, deliverAt int64) {
+	if _, ok := this.Delay[id]; !ok {
+		if _, ok = this.Receipts[id]; ok {
+			this.inFlight++
+		}
+	}
+	this.Delay[id] = deliverAt
+	heap.Push(&this.dueHeap, memoryTimeEntry{deliverAt, id})
+	//失效的索引项过多时按延迟队列重建
+	if len(this.dueHeap) > 2*len(this.Delay)+64 {
+		this.rebuildDue()
+	}
+}
+
+func (this *memoryQueue) rebuildDue() {
+	this.dueHeap = make(memoryTimeHeap, 0, len(this.Delay))
+	for id, deliverAt := range this.Delay {
+		this.dueHeap = append(this.dueHeap, memoryTimeEntry{deliverAt, id})
+	}
+	heap.Init(&this.dueHeap)
+}
+
+//延迟队列中最早到期的消息，堆顶失效的索引项在这里移除
+func (this *memoryQueue) nextDue() (memoryTimeEntry, bool) {
+	for len(this.dueHeap) != 0 {
+		entry := this.dueHeap[0]
+		if deliverAt, ok := this.Delay[entry.id]; ok && deliverAt == entry.at {
+			return entry, true
+		}
+		heap.Pop(&this.dueHeap)
+	}
+	return memoryTimeEntry{}, false
+}
+
+//是否有到期时间不晚于before的延迟消息
+func (this *memoryQueue) hasDue(before int64) bool {
+	entry, ok := this.nextDue()
+	return ok && entry.at <= before
+}
+
+//按到期时间先后移出到期时间不晚于before的延迟消息，limit为0时不限条数
+func (this *memoryQueue) takeDue(before int64, limit int) []string {
+	var ids []string
+	for limit <= 0 || len(ids) < limit {
+		entry, ok := this.nextDue()
+		if !ok || entry.at > before {
+			break
+		}
+		heap.Pop(&this.dueHeap)
+		if _, ok = this.Receipts[entry.id]; ok {
+			this.inFlight--
+		}
+		delete(this.Delay, entry.id)
+		ids = append(ids, entry.id)
+	}
+	return ids
+}
+
+//记录入列时间及其索引
+func (this *memoryQueue) setSentAt(id string, sentAt int64) {
+	this.SentAt[id] = sentAt
+	heap.Push(&this.sentHeap, memoryTimeEntry{sentAt, id})
+	if len(this.sentHeap) > 2*len(this.SentAt)+64 {
+		this.rebuildSent()
+	}
+}
+
+func (this *memoryQueue) rebuildSent() {
+	this.sentHeap = make(memoryTimeHeap, 0, len(this.SentAt))
+	for id, sentAt := range this.SentAt {
+		this.sentHeap = append(this.sentHeap, memoryTimeEntry{sentAt, id})
+	}
+	heap.Init(&this.sentHeap)
+}
+
+//最早的入列时间，没有消息时为0
+func (this *memoryQueue) oldestSentAt() int64 {
+	for len(this.sentHeap) != 0 {
+		entry := this.sentHeap[0]
+		if sentAt, ok := this.SentAt[entry.id]; ok && sentAt == entry.at {
+			return entry.at
+		}
+		heap.Pop(&this.sentHeap)
+	}
+	return 0
+}
+
+//准备队列中是否有消息，有消息时出列一定会改变数据
+func (this *memoryQueue) hasReady() bool {
+	return len(this.Ready) != 0 || len(this.PriorityReady) != 0
+}
+
+//是否在延迟队列中且没有回执（未被接收过）
+func (this *memoryQueue) isScheduled(id string) bool {
+	if _, ok := this.Delay[id]; !ok {
+		return false
+	}
+	_, received := this.Receipts[id]
+	return !received
+}
+
+//按到期时间先后返回延迟队列中没有回执（未被接收过）的消息
+func (this *memoryQueue) scheduled() []string {
+	var ids []string
+	for id := range this.Delay {
+		if this.isScheduled(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if this.Delay[ids[i]] != this.Delay[ids[j]] {
+			return this.Delay[ids[i]] < this.Delay[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+//内存存储，进程退出后数据丢失，用于本地开发和单元测试
+type MemoryStorage struct {
+	mu       sync.Mutex
+	options  map[string]map[string]string
+	names    map[string]bool
+	queues   map[string]*memoryQueue
+	topics   map[string]map[string]string //主题及其订阅，按队列名存放订阅配置
+	filtered map[string]map[string]int64  //主题的各订阅过滤掉的消息数
+	due      map[string]int64             //到期索引，与redis的到期索引一致，值为该队列延迟队列中最早的到期时间
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{options: make(map[string]map[string]string), names: make(map[string]bool), queues: make(map[string]*memoryQueue),
+		topics: make(map[string]map[string]string), filtered: make(map[string]map[string]int64), due: make(map[string]int64)}
+}
+
+//按队列最早的到期时间更新到期索引，调用方需持有锁
+func (this *MemoryStorage) markDue(queueName string, q *memoryQueue) {
+	if entry, ok := q.nextDue(); ok {
+		this.due[queueName] = entry.at
+	} else {
+		delete(this.due, queueName)
+	}
+}
+
+//持有锁读取队列数据，队列不存在时返回false，磁盘存储用它判断修改前是否需要记录日志
+func (this *MemoryStorage) inspect(queueName string, fn func(q *memoryQueue) bool) bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	q, ok := this.queues[queueName]
+	return ok && fn(q)
+}
+
+//获取队列数据，不存在时创建，调用方需持有锁
+func (this *MemoryStorage) queue(queueName string) *memoryQueue {
+	q, ok := this.queues[queueName]
+	if !ok {
+		q = newMemoryQueue()
+		this.queues[queueName] = q
+	}
+	return q
+}
+
+func (this *MemoryStorage) SaveOptions(queueName string, opt map[string]string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	saved, ok := this.options[queueName]
+	if !ok {
+		saved = make(map[string]string)
+		this.options[queueName] = saved
+	}
+	for k, v := range opt {
+		saved[k] = v
+	}
+	return nil
+}
+
+func (this *MemoryStorage) GetOptions(queueName string) (map[string]string, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	opt := make(map[string]string)
+	for k, v := range this.options[queueName] {
+		opt[k] = v
+	}
+	return opt, nil
+}
+
+func (this *MemoryStorage) OptionsExist(queueName string) (bool, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	_, ok := this.options[queueName]
+	return ok, nil
+}
+
+func (this *MemoryStorage) DelOptions(queueName string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	delete(this.options, queueName)
+	return nil
+}
+
+func (this *MemoryStorage) AddQueueName(queueName string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.names[queueName] = true
+	return nil
+}
+
+func (this *MemoryStorage) DelQueueName(queueName string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	delete(this.names, queueName)
+	return nil
+}
+
+func (this *MemoryStorage) ExistsQueueName(queueName string) (bool, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	return this.names[queueName], nil
+}
+
+func (this *MemoryStorage) QueueNames() ([]string, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	names := make([]string, 0, len(this.names))
+	for name := range this.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (this *MemoryStorage) AddTopicName(topicName string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if _, ok := this.topics[topicName]; !ok {
+		this.topics[topicName] = make(map[string]string)
+	}
+	return nil
+}
+
+func (this *MemoryStorage) DelTopicName(topicName string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	delete(this.topics, topicName)
+	delete(this.filtered, topicName)
+	return nil
+}
+
+func (this *MemoryStorage) ExistsTopicName(topicName string) (bool, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	_, ok := this.topics[topicName]
+	return ok, nil
+}
+
+func (this *MemoryStorage) TopicNames() ([]string, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	names := make([]string, 0, len(this.topics))
+	for name := range this.topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+//与redis一致，主题不存在时也保存订阅
+func (this *MemoryStorage) SaveSubscription(topicName string, queueName string, subscription string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	subscriptions, ok := this.topics[topicName]
+	if !ok {
+		subscriptions = make(map[string]string)
+		this.topics[topicName] = subscriptions
+	}
+	subscriptions[queueName] = subscription
+	return nil
+}
+
+func (this *MemoryStorage) DelSubscription(topicName string, queueName string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	delete(this.topics[topicName], queueName)
+	delete(this.filtered[topicName], queueName)
+	return nil
+}
+
+func (this *MemoryStorage) Subscriptions(topicName string) (map[string]string, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	subscriptions := make(map[string]string)
+	for k, v := range this.topics[topicName] {
+		subscriptions[k] = v
+	}
+	return subscriptions, nil
+}
+
+func (this *MemoryStorage) AddFiltered(topicName string, queueNames []string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.filtered[topicName] == nil {
+		this.filtered[topicName] = make(map[string]int64)
+	}
+	for _, queueName := range queueNames {
+		this.filtered[topicName][queueName]++
+	}
+	return nil
+}
+
+func (this *MemoryStorage) FilteredCounts(topicName string) (map[string]int64, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	counts := make(map[string]int64)
+	for k, v := range this.filtered[topicName] {
+		counts[k] = v
+	}
+	return counts, nil
+}
+
+func (this *MemoryStorage) Push(queueName string, messages []NewMessage) ([]string, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	return this.push(queueName, messages), nil
+}
+
+//在同一次加锁中入列到全部队列
+func (this *MemoryStorage) PushMulti(messages map[string][]NewMessage) (map[string][]string, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	ids := make(map[string][]string, len(messages))
+	for queueName, queueMessages := range messages {
+		if len(queueMessages) != 0 {
+			ids[queueName] = this.push(queueName, queueMessages)
+		}
+	}
+	return ids, nil
+}
+
+//与redis的入列脚本一致：先按第一条消息的入列时间清理过期的去重ID，调用方需持有锁
+func (this *MemoryStorage) push(queueName string, messages []NewMessage) []string {
+	q := this.queue(queueName)
+	if len(messages) != 0 {
+		for dedupId, entry := range q.Dedup {
+			if entry.Until <= messages[0].SentAt {
+				delete(q.Dedup, dedupId)
+			}
+		}
+	}
+
+	ids := make([]string, len(messages))
+	for i, message := range messages {
+		if entry, ok := q.Dedup[message.DeduplicationId]; ok && message.DeduplicationId != "" {
+			ids[i] = entry.Id
+			continue
+		}
+		if message.DeduplicationId != "" {
+			q.Dedup[message.DeduplicationId] = memoryDedupEntry{message.Id, message.DedupUntil}
+		}
+
+		ids[i] = message.Id
+		q.Bodies[message.Id] = message.Body
+		q.setSentAt(message.Id, message.SentAt)
+		if message.Priority > 0 {
+			q.Priorities[message.Id] = message.Priority
+		}
+		if len(message.Attributes) != 0 {
+			q.Attributes[message.Id] = message.Attributes
+		}
+		if message.GroupId != "" {
+			q.MessageGroups[message.Id] = message.GroupId
+			q.Groups[message.GroupId] = append(q.Groups[message.GroupId], message.Id)
+			if len(q.Groups[message.GroupId]) > 1 { //排在组内前一条之后
+				q.waiting++
+				continue
+			}
+		}
+
+		if message.DeliverAt == 0 {
+			q.pushReady(message.Id)
+		} else {
+			q.setDelay(message.Id, message.DeliverAt)
+		}
+	}
+	this.markDue(queueName, q)
+	return ids
+}
+
+//与redis的出列脚本一致：接收次数超过上限的移入死信队列，消息体已不存在的直接丢弃
+func (this *MemoryStorage) Pop(queueName string, opt PopOption) (messages []Message, err error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	q := this.queue(queueName)
+	for len(messages) < opt.MaxMessages {
+		id, ok := q.popReady(opt.Now, opt.PriorityAging)
+		if !ok {
+			break
+		}
+
+		body, ok := q.Bodies[id]
+		if !ok {
+			q.forget(id)
+			continue
+		}
+
+		q.Counts[id]++
+		if opt.MaxReceiveCount > 0 && q.Counts[id] > opt.MaxReceiveCount {
+			sentAt, hasSentAt := q.SentAt[id]
+			attributes, hasAttributes := q.Attributes[id]
+			q.forget(id)
+			dlq := this.queue(opt.DeadLetterQueue)
+			dlq.Bodies[id] = body
+			if hasSentAt {
+				dlq.setSentAt(id, sentAt)
+			}
+			if hasAttributes {
+				dlq.Attributes[id] = attributes
+			}
+			dlq.Sources[id] = queueName
+			dlq.appendReady(id)
+			continue
+		}
+
+		receiptHandle := id + ":" + opt.Token
+		q.Receipts[id] = receiptHandle
+		q.setDelay(id, opt.Deadline)
+		if _, ok := q.FirstReceive[id]; !ok {
+			q.FirstReceive[id] = opt.Now
+		}
+		messages = append(messages, Message{id, receiptHandle, body, q.Attributes[id], q.SentAt[id], q.FirstReceive[id], q.Counts[id], nil})
+	}
+	this.markDue(queueName, q)
+	return
+}
+
+//准备队列为空时等待下一次有消息进入准备队列
+func (this *MemoryStorage) Wait(ctx context.Context, queueName string, timeout time.Duration) error {
+	this.mu.Lock()
+	q := this.queue(queueName)
+	if q.hasReady() {
+		this.mu.Unlock()
+		return nil
+	}
+	if q.signal == nil {
+		q.signal = make(chan struct{})
+	}
+	signal := q.signal
+	this.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-signal:
+	case <-timer.C:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (this *MemoryStorage) CheckReceipt(queueName string, receiptHandle string) (string, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	id := receiptMessageID(receiptHandle)
+	if id == "" {
+		return "", newError(ErrInvalid, "receipt handle is invalid")
+	}
+
+	if current, ok := this.queue(queueName).Receipts[id]; !ok || current != receiptHandle {
+		return "", newError(ErrNotFound, "receipt handle has expired")
+	}
+	return id, nil
+}
+
+func (this *MemoryStorage) Delete(queueName string, id string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	q := this.queue(queueName)
+	q.remove(id)
+	this.markDue(queueName, q)
+	return nil
+}
+
+func (this *MemoryStorage) ChangeVisibility(queueName string, id string, deadline int64) (bool, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	q := this.queue(queueName)
+	if _, ok := q.Delay[id]; !ok {
+		return false, nil
+	}
+	q.setDelay(id, deadline)
+	this.markDue(queueName, q)
+	return true, nil
+}
+
+func (this *MemoryStorage) Promote(queueName string, now int64, limit int) (int, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	q := this.queue(queueName)
+	ids := q.takeDue(now, limit)
+	for _, id := range ids {
+		q.pushReady(id)
+	}
+	this.markDue(queueName, q)
+	return len(ids), nil
+}
+
+func (this *MemoryStorage) Clean(queueName string, before int64) (int, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	q := this.queue(queueName)
+	ids := q.takeDue(before, 0)
+	for _, id := range ids {
+		q.remove(id)
+	}
+	this.markDue(queueName, q)
+	return len(ids), nil
+}
+
+//exists可能读取存储，在加锁前判断各来源队列是否存在，之后新出现的来源队列本次不移回
+func (this *MemoryStorage) Redrive(queueName string, sourceQueue string, maxMessages int64, exists func(string) bool) (count int64, err error) {
+	existing := make(map[string]bool)
+	for _, source := range this.redriveSources(queueName, sourceQueue) {
+		existing[source] = exists(source)
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	//一次遍历准备队列，留下的消息按原顺序保留
+	q := this.queue(queueName)
+	kept := make([]string, 0, len(q.Ready))
+	for _, id := range q.Ready {
+		source := q.Sources[id]
+		if (maxMessages > 0 && count >= maxMessages) || source == "" || (sourceQueue != "" && source != sourceQueue) || !existing[source] {
+			kept = append(kept, id)
+			continue
+		}
+
+		body := q.Bodies[id]
+		sentAt, hasSentAt := q.SentAt[id]
+		attributes, hasAttributes := q.Attributes[id]
+		q.forget(id)
+		src := this.queue(source)
+		src.Bodies[id] = body
+		if hasSentAt {
+			src.setSentAt(id, sentAt)
+		}
+		if hasAttributes {
+			src.Attributes[id] = attributes
+		}
+		src.appendReady(id)
+		count++
+	}
+	q.Ready = kept
+	return
+}
+
+//死信准备队列中的消息来自的队列，sourceQueue不为空时只有该队列
+func (this *MemoryStorage) redriveSources(queueName string, sourceQueue string) (sources []string) {
+	this.inspect(queueName, func(q *memoryQueue) bool {
+		checked := make(map[string]bool)
+		for _, id := range q.Ready {
+			source := q.Sources[id]
+			if source == "" || (sourceQueue != "" && source != sourceQueue) || checked[source] {
+				continue
+			}
+			checked[source] = true
+			sources = append(sources, source)
+		}
+		return false
+	})
+	return
+}
+
+func (this *MemoryStorage) DelMessages(queueName string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	delete(this.queues, queueName)
+	delete(this.due, queueName)
+	return nil
+}
+
+//与redis一致，延迟队列中有回执的消息是隐藏中的，消息组中排在后面的算作准备
+func (this *MemoryStorage) Stats(queueName string) (stats QueueStats, err error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	q, ok := this.queues[queueName]
+	if !ok {
+		return
+	}
+
+	stats.Ready = int64(len(q.Ready)) + q.waiting
+	for _, ids := range q.PriorityReady {
+		stats.Ready += int64(len(ids))
+	}
+	stats.InFlight = q.inFlight
+	stats.Delayed = int64(len(q.Delay)) - q.inFlight
+	stats.OldestSentAt = q.oldestSentAt()
+	return
+}
+
+func (this *MemoryStorage) Peek(queueName string, maxMessages int) (messages []Message, err error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	q, ok := this.queues[queueName]
+	if !ok {
+		return
+	}
+
+	for priority := maxPriority; priority >= 0; priority-- {
+		ready := q.Ready
+		if priority != 0 {
+			ready = q.PriorityReady[priority]
+		}
+		for _, id := range ready {
+			if len(messages) == maxMessages {
+				return
+			}
+			messages = append(messages, Message{id, "", q.Bodies[id], q.Attributes[id], q.SentAt[id], q.FirstReceive[id], q.Counts[id], nil})
+		}
+	}
+	return
+}
+
+func (this *MemoryStorage) Scheduled(queueName string, maxMessages int) (messages []ScheduledMessage, err error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	q, ok := this.queues[queueName]
+	if !ok {
+		return
+	}
+
+	for _, id := range q.scheduled() {
+		if len(messages) == maxMessages {
+			break
+		}
+		messages = append(messages, ScheduledMessage{id, q.Bodies[id], q.Attributes[id], q.SentAt[id], q.Delay[id]})
+	}
+	return
+}
+
+func (this *MemoryStorage) CancelScheduled(queueName string, id string) (bool, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	q, ok := this.queues[queueName]
+	if !ok || !q.isScheduled(id) {
+		return false, nil
+	}
+	q.remove(id)
+	this.markDue(queueName, q)
+	return true, nil
+}
+
+func (this *MemoryStorage) DueQueues(now int64) ([]string, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	var queues []string
+	for name, deliverAt := range this.due {
+		if deliverAt <= now {
+			queues = append(queues, name)
+		}
+	}
+	sort.Strings(queues)
+	return queues, nil
+}
+
+func (this *MemoryStorage) OldestDue() (oldest int64, err error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	for _, deliverAt := range this.due {
+		if oldest == 0 || deliverAt < oldest {
+			oldest = deliverAt
+		}
+	}
+	return
+}
+
+func (this *MemoryStorage) Reindex(queueName string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if q, ok := this.queues[queueName]; ok {
+		this.markDue(queueName, q)
+	}
+	return nil
+}
+
+func (this *MemoryStorage) Unschedule(queueName string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	delete(this.due, queueName)
+	return nil
+}
+
+//内存存储只在单个进程内，始终为leader
+func (this *MemoryStorage) AcquireLease(instanceId string, lease time.Duration) (bool, error) {
+	return true, nil
+}
+
+//内存数据快照，磁盘存储用它保存和恢复
+type memorySnapshot struct {
+	Options  map[string]map[string]string `json:"options"`
+	Names    map[string]bool              `json:"names"`
+	Queues   map[string]*memoryQueue      `json:"queues"`
+	Topics   map[string]map[string]string `json:"topics"`
+	Filtered map[string]map[string]int64  `json:"filtered"`
+}
+
+func (this *MemoryStorage) marshal() ([]byte, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	return json.Marshal(memorySnapshot{this.options, this.names, this.queues, this.topics, this.filtered})
+}
+
+func (this *MemoryStorage) unmarshal(data []byte) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	snapshot := memorySnapshot{make(map[string]map[string]string), make(map[string]bool), make(map[string]*memoryQueue), make(map[string]map[string]string),
+		make(map[string]map[string]int64)}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	//快照中为空的map补齐，到期索引按延迟队列重建
+	due := make(map[string]int64)
+	for name, q := range snapshot.Queues {
+		q.fill()
+		if entry, ok := q.nextDue(); ok {
+			due[name] = entry.at
+		}
+	}
+	//旧版本的快照中没有过滤计数
+	if snapshot.Filtered == nil {
+		snapshot.Filtered = make(map[string]map[string]int64)
+	}
+	this.options, this.names, this.queues, this.topics, this.filtered, this.due = snapshot.Options, snapshot.Names, snapshot.Queues, snapshot.Topics,
+		snapshot.Filtered, due
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+//同样的操作序列在内存存储和redis存储上得到同样的结果，内存存储与redis脚本的语义一致
+//redis的到期索引只会提前，不比较到期索引
+func TestMemoryStorageMatchesRedis(t *testing.T) {
+	redisStore, _ := redisTestStorage(t)
+	memoryStore := NewMemoryStorage()
+	stores := []Storage{redisStore, memoryStore}
+	random := rand.New(rand.NewSource(1))
+	for _, store := range stores {
+		for _, name := range []string{"a", "b", "dlq"} {
+			store.AddQueueName(name)
+		}
+	}
+
+	var receipts []string
+	for step := 0; step < 600; step++ {
+		now := int64(1000 + step)
+		queueName := []string{"a", "b"}[random.Intn(2)]
+		var op string
+		var results []interface{}
+		for _, store := range stores {
+			r := rand.New(rand.NewSource(int64(step)))
+			switch r.Intn(9) {
+			case 0, 1, 2:
+				op = "push"
+				message := NewMessage{Id: fmt.Sprintf("m%d", step), Body: "x", SentAt: now, Priority: r.Intn(3)}
+				if r.Intn(3) == 0 {
+					message.DeliverAt = now + int64(r.Intn(20))
+				}
+				if r.Intn(4) == 0 {
+					message.GroupId, message.Priority, message.DeliverAt = fmt.Sprintf("g%d", r.Intn(3)), 0, 0
+				}
+				if r.Intn(4) == 0 {
+					message.DeduplicationId, message.DedupUntil = fmt.Sprintf("d%d", r.Intn(5)), now+10
+				}
+				ids, err := store.Push(queueName, []NewMessage{message})
+				results = append(results, ids, err)
+			case 3, 4:
+				op = "pop"
+				messages, err := store.Pop(queueName, PopOption{MaxMessages: 1 + r.Intn(3), Deadline: now + int64(r.Intn(10)), Token: fmt.Sprintf("t%d", step),
+					DeadLetterQueue: "dlq", MaxReceiveCount: 2, Now: now, PriorityAging: int64(r.Intn(2) * 5)})
+				results = append(results, messages, err)
+			case 5:
+				op = "delete"
+				if len(receipts) != 0 {
+					id, err := store.CheckReceipt(queueName, receipts[r.Intn(len(receipts))])
+					results = append(results, id, err)
+					if err == nil {
+						results = append(results, store.Delete(queueName, id))
+					}
+				}
+			case 6:
+				op = "promote"
+				n, err := store.Promote(queueName, now, 1+r.Intn(5))
+				results = append(results, n, err)
+			case 7:
+				op = "changeVisibility"
+				if len(receipts) != 0 {
+					id, err := store.CheckReceipt(queueName, receipts[r.Intn(len(receipts))])
+					results = append(results, id, err)
+					if err == nil {
+						changed, err := store.ChangeVisibility(queueName, id, now+int64(r.Intn(10)))
+						results = append(results, changed, err)
+					}
+				}
+			case 8:
+				op = "redrive"
+				n, err := store.Redrive("dlq", "", int64(r.Intn(3)), func(string) bool { return true })
+				results = append(results, n, err)
+			}
+		}
+
+		half := len(results) / 2
+		if !reflect.DeepEqual(results[:half], results[half:]) {
+			t.Fatalf("step %d %s %s: redis %v, memory %v", step, op, queueName, results[:half], results[half:])
+		}
+		if op == "pop" {
+			for _, message := range results[0].([]Message) {
+				receipts = append(receipts, message.ReceiptHandle)
+			}
+		}
+		for _, name := range []string{"a", "b", "dlq"} {
+			redisStats, _ := redisStore.Stats(name)
+			memoryStats, _ := memoryStore.Stats(name)
+			if redisStats != memoryStats {
+				t.Fatalf("step %d %s %s stats: redis %+v, memory %+v", step, op, name, redisStats, memoryStats)
+			}
+			redisPeek, _ := redisStore.Peek(name, 100)
+			memoryPeek, _ := memoryStore.Peek(name, 100)
+			if !reflect.DeepEqual(redisPeek, memoryPeek) {
+				t.Fatalf("step %d %s %s peek: redis %v, memory %v", step, op, name, redisPeek, memoryPeek)
+			}
+		}
+	}
+}
+
+//反复修改隐藏时间留下的失效索引项不影响到期时间，索引过大时重建
+func TestMemoryDueHeapStaleEntries(t *testing.T) {
+	store := NewMemoryStorage()
+	store.Push("q", []NewMessage{{Id: "m1", Body: "a", SentAt: 1}, {Id: "m2", Body: "b", SentAt: 1, DeliverAt: 500}})
+	store.Pop("q", PopOption{MaxMessages: 1, Deadline: 10, Token: "t", Now: 1})
+	for i := int64(0); i < 1000; i++ {
+		if changed, err := store.ChangeVisibility("q", "m1", 1000-i); err != nil || !changed {
+			t.Fatalf("change visibility: %v %v", changed, err)
+		}
+	}
+	if n := len(store.queues["q"].dueHeap); n > 2*2+64 {
+		t.Fatalf("due heap not rebuilt: %d entries", n)
+	}
+	if oldest, _ := store.OldestDue(); oldest != 1 {
+		t.Fatalf("oldest due: %d", oldest)
+	}
+
+	store.ChangeVisibility("q", "m1", 800)
+	if due, _ := store.DueQueues(499); len(due) != 0 {
+		t.Fatalf("due queues before any deadline: %v", due)
+	}
+	if oldest, _ := store.OldestDue(); oldest != 500 {
+		t.Fatalf("oldest due: %d", oldest)
+	}
+	if n, _ := store.Promote("q", 799, 10); n != 1 {
+		t.Fatalf("promoted %d messages", n)
+	}
+	if peeked, _ := store.Peek("q", 10); len(peeked) != 1 || peeked[0].MessageId != "m2" {
+		t.Fatalf("peek: %v", peeked)
+	}
+	if oldest, _ := store.OldestDue(); oldest != 800 {
+		t.Fatalf("oldest due: %d", oldest)
+	}
+}
+
+//增量维护的计数与按数据重新统计的结果一致
+func TestMemoryStatsCounters(t *testing.T) {
+	store := NewMemoryStorage()
+	random := rand.New(rand.NewSource(1))
+	var receipts []string
+	for step := 0; step < 3000; step++ {
+		now := int64(step)
+		switch random.Intn(6) {
+		case 0, 1:
+			message := NewMessage{Id: fmt.Sprintf("m%d", step), Body: "x", SentAt: int64(random.Intn(1000))}
+			if random.Intn(3) == 0 {
+				message.GroupId = fmt.Sprintf("g%d", random.Intn(4))
+			} else if random.Intn(2) == 0 {
+				message.DeliverAt = now + int64(random.Intn(20))
+			}
+			store.Push("q", []NewMessage{message})
+		case 2:
+			messages, _ := store.Pop("q", PopOption{MaxMessages: 2, Now: now, Deadline: now + int64(random.Intn(10)), Token: "t", MaxReceiveCount: 3, DeadLetterQueue: "dlq"})
+			for _, message := range messages {
+				receipts = append(receipts, message.ReceiptHandle)
+			}
+		case 3:
+			if len(receipts) != 0 {
+				if id, err := store.CheckReceipt("q", receipts[random.Intn(len(receipts))]); err == nil {
+					store.Delete("q", id)
+				}
+			}
+		case 4:
+			store.Promote("q", now, 5)
+		case 5:
+			if random.Intn(10) == 0 {
+				store.Clean("q", now-30)
+			}
+		}
+
+		for _, name := range []string{"q", "dlq"} {
+			q, ok := store.queues[name]
+			if !ok {
+				continue
+			}
+			got, _ := store.Stats(name)
+			recount := *q
+			recount.reindex()
+			want := QueueStats{int64(len(recount.Ready)) + recount.waiting, int64(len(recount.Delay)) - recount.inFlight, recount.inFlight, 0}
+			for _, ids := range recount.PriorityReady {
+				want.Ready += int64(len(ids))
+			}
+			for _, sentAt := range recount.SentAt {
+				if want.OldestSentAt == 0 || sentAt < want.OldestSentAt {
+					want.OldestSentAt = sentAt
+				}
+			}
+			if got != want {
+				t.Fatalf("step %d %s: stats %+v, recounted %+v", step, name, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+//prometheus指标，计数在Yumi的各方法中累加，队列深度读取调度器定期刷新的快照，其余在抓取时从存储读取
+var (
+	pushedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yumiq_messages_pushed_total",
+		Help: "Messages pushed, by queue.",
+	}, []string{"queue"})
+	poppedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yumiq_messages_popped_total",
+		Help: "Messages received, by queue.",
+	}, []string{"queue"})
+	deletedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yumiq_messages_deleted_total",
+		Help: "Messages deleted by receipt handle, by queue.",
+	}, []string{"queue"})
+	visibilityChangesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yumiq_visibility_changes_total",
+		Help: "Visibility timeout changes, by queue.",
+	}, []string{"queue"})
+	retentionDeletedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yumiq_retention_deleted_messages_total",
+		Help: "Messages deleted by retention cleanup, by queue.",
+	}, []string{"queue"})
+	scheduledCancelledTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yumiq_scheduled_messages_cancelled_total",
+		Help: "Delayed messages cancelled before becoming visible, by queue.",
+	}, []string{"queue"})
+	publishedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yumiq_topic_messages_published_total",
+		Help: "Messages published, by topic. Each publish pushes one copy per subscribed queue.",
+	}, []string{"topic"})
+	filteredTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yumiq_subscription_messages_filtered_total",
+		Help: "Published messages not delivered to a subscribed queue because of its filter policy, by topic and queue.",
+	}, []string{"topic", "queue"})
+	webhookDeliveriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yumiq_webhook_deliveries_total",
+		Help: "Webhook push deliveries, by queue and result (success, failure).",
+	}, []string{"queue", "result"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "yumiq_http_request_duration_seconds",
+		Help:    "HTTP handler latency, by path. Long-polling pops include the wait.",
+		Buckets: []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30},
+	}, []string{"path"})
+
+	metricsHandler = promhttp.Handler()
+)
+
+var (
+	queueMessagesDesc = prometheus.NewDesc("yumiq_queue_messages",
+		"Messages in a queue, by state (ready, delayed, inflight).", []string{"queue", "state"}, nil)
+	redisConnectionsDesc = prometheus.NewDesc("yumiq_redis_pool_connections",
+		"Redis pool connections, by state. Active connections include idle ones.", []string{"state"}, nil)
+	promotionLagDesc = prometheus.NewDesc("yumiq_scheduler_promotion_lag_seconds",
+		"Seconds since the earliest due delayed or in-flight message became due and is still not promoted.", nil, nil)
+)
+
+const queueDepthInterval = 15 * time.Second //队列深度快照的刷新间隔
+
+//队列深度快照，抓取时不逐个队列访问存储
+var queueDepths = struct {
+	sync.RWMutex
+	stats map[string]QueueStats
+}{stats: make(map[string]QueueStats)}
+
+func init() {
+	prometheus.MustRegister(pushedTotal, poppedTotal, deletedTotal, visibilityChangesTotal, retentionDeletedTotal, scheduledCancelledTotal, publishedTotal, filteredTotal, webhookDeliveriesTotal, httpDuration)
+	prometheus.MustRegister(&storeCollector{})
+}
+
+//删除队列时一并删除该队列的计数
+func forgetQueueMetrics(queueName string) {
+	for _, vec := range []*prometheus.CounterVec{pushedTotal, poppedTotal, deletedTotal, visibilityChangesTotal, retentionDeletedTotal, scheduledCancelledTotal} {
+		vec.DeleteLabelValues(queueName)
+	}
+	webhookDeliveriesTotal.DeleteLabelValues(queueName, "success")
+	webhookDeliveriesTotal.DeleteLabelValues(queueName, "failure")
+}
+
+//记录HTTP请求耗时
+func observeRequest(path string, start time.Time) {
+	httpDuration.WithLabelValues(path).Observe(time.Since(start).Seconds())
+}
+
+func Metrics(res http.ResponseWriter, req *http.Request) {
+	metricsHandler.ServeHTTP(res, req)
+}
+
+//从存储读取所有队列的深度，替换快照
+func refreshQueueDepths() {
+	queues, err := Store.QueueNames()
+	if err != nil {
+		log.Printf("metrics queue names error: %s", err.Error())
+		return
+	}
+
+	depths := make(map[string]QueueStats, len(queues))
+	for _, qname := range queues {
+		stats, err := Store.Stats(qname)
+		if err != nil {
+			log.Printf("%s queue metrics error: %s", qname, err.Error())
+			continue
+		}
+		depths[qname] = stats
+	}
+
+	queueDepths.Lock()
+	queueDepths.stats = depths
+	queueDepths.Unlock()
+}
+
+//抓取时读取的指标
+type storeCollector struct{}
+
+func (this *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueMessagesDesc
+	ch <- redisConnectionsDesc
+	ch <- promotionLagDesc
+}
+
+func (this *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	if Store == nil {
+		return
+	}
+
+	queueDepths.RLock()
+	for qname, stats := range queueDepths.stats {
+		ch <- prometheus.MustNewConstMetric(queueMessagesDesc, prometheus.GaugeValue, float64(stats.Ready), qname, "ready")
+		ch <- prometheus.MustNewConstMetric(queueMessagesDesc, prometheus.GaugeValue, float64(stats.Delayed), qname, "delayed")
+		ch <- prometheus.MustNewConstMetric(queueMessagesDesc, prometheus.GaugeValue, float64(stats.InFlight), qname, "inflight")
+	}
+	queueDepths.RUnlock()
+
+	//只有redis存储使用连接池
+	if Pool != nil {
+		stats := Pool.Stats()
+		ch <- prometheus.MustNewConstMetric(redisConnectionsDesc, prometheus.GaugeValue, float64(stats.ActiveCount), "active")
+		ch <- prometheus.MustNewConstMetric(redisConnectionsDesc, prometheus.GaugeValue, float64(stats.IdleCount), "idle")
+	}
+
+	//调度正常时最早的到期时间总在一个调度间隔以内
+	if oldest, err := Store.OldestDue(); err != nil {
+		log.Printf("metrics due index error: %s", err.Error())
+	} else {
+		var lag int64
+		if now := theMoment(); oldest != 0 && oldest < now {
+			lag = now - oldest
+		}
+		ch <- prometheus.MustNewConstMetric(promotionLagDesc, prometheus.GaugeValue, float64(lag))
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func metricsTestScrape() string {
+	rec := httptest.NewRecorder()
+	(&WaitForYou{}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return rec.Body.String()
+}
+
+//各操作累加对应队列的计数，删除队列时删除它的计数
+func TestQueueCounters(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "metrics", MessageRetentionPeriod: "60"})
+		YumiQ.Push("metrics", PushEntry{Body: "a"})
+		YumiQ.Push("metrics", PushEntry{Body: "b"})
+		YumiQ.Push("metrics", PushEntry{Body: "c", DelaySeconds: "5"})
+		messages, _ := YumiQ.Pop("metrics", 0, 2)
+		YumiQ.SetVisibilityTime("metrics", messages[1].ReceiptHandle, 10)
+		YumiQ.Del("metrics", messages[0].ReceiptHandle)
+		Store.Push("metrics", []NewMessage{{Id: "old", Body: "d", SentAt: 1, DeliverAt: 1}})
+		YumiQ.CleanQueue("metrics")
+
+		for name, c := range map[string]struct {
+			counter *prometheus.CounterVec
+			want    float64
+		}{
+			"pushed":     {pushedTotal, 3},
+			"popped":     {poppedTotal, 2},
+			"visibility": {visibilityChangesTotal, 1},
+			"deleted":    {deletedTotal, 1},
+			"retention":  {retentionDeletedTotal, 1},
+		} {
+			if got := testutil.ToFloat64(c.counter.WithLabelValues("metrics")); got != c.want {
+				t.Fatalf("%s: %v, want %v", name, got, c.want)
+			}
+		}
+
+		refreshQueueDepths()
+		out := metricsTestScrape()
+		for _, want := range []string{`yumiq_messages_pushed_total{queue="metrics"} 3`, `yumiq_queue_messages{queue="metrics",state="delayed"} 1`,
+			`yumiq_queue_messages{queue="metrics",state="inflight"} 1`, `yumiq_scheduler_promotion_lag_seconds`} {
+			if !strings.Contains(out, want) {
+				t.Fatalf("metrics missing %s:\n%s", want, out)
+			}
+		}
+
+		if err := YumiQ.DelQueue("metrics"); err != nil {
+			t.Fatal(err)
+		}
+		if out := metricsTestScrape(); strings.Contains(out, `yumiq_messages_pushed_total{queue="metrics"}`) {
+			t.Fatalf("counters of deleted queue still exported:\n%s", out)
+		}
+	})
+}
+
+//请求耗时按路由记录，未知路径记为other，WebSocket连接不记录
+func TestRequestDurationRoutes(t *testing.T) {
+	storageTestSetup(t, "memory")
+	httpDuration.Reset()
+
+	for _, path := range []string{"/listQueues", "/missing", "/another/missing", "/v1/queues/q"} {
+		(&WaitForYou{}).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	upgrade := httptest.NewRequest("GET", "/ws?queueName=q", nil)
+	upgrade.Header.Set("Connection", "Upgrade")
+	upgrade.Header.Set("Upgrade", "websocket")
+	(&WaitForYou{}).ServeHTTP(httptest.NewRecorder(), upgrade)
+
+	if n := testutil.CollectAndCount(httpDuration); n != 3 {
+		t.Fatalf("%d routes observed, want 3", n)
+	}
+	out := metricsTestScrape()
+	for _, want := range []string{`yumiq_http_request_duration_seconds_count{path="/listQueues"} 1`,
+		`yumiq_http_request_duration_seconds_count{path="other"} 2`, `yumiq_http_request_duration_seconds_count{path="/v1/queues/{name}"} 1`} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("metrics missing %s:\n%s", want, out)
+		}
+	}
+}
@@ -1,23 +1,42 @@
 package main
 
 import (
+	"context"
 	"net/http"
-	"github.com/gomodule/redigo/redis"
 	"fmt"
+	"log"
 	"time"
 	"encoding/json"
-	"log"
+	"strconv"
+	"sort"
+	"strings"
+	"sync"
 )
 
 var (
-	YumiQ   *Yumi //全局调度器
-	ReadyQ  *ReadyQueue //全局准备队列管理器
-	DelayQ  *DelayQueue //全局延迟队列管理器
-	Queue   *Queues //全局队列管理器
+	YumiQ *Yumi      //全局调度器
+	Queue *Queues    //全局队列管理器
+	Sched *Scheduler //全局延迟队列调度器
 )
 
 const (
-	OptQueueNames = "SysInfo_queue_names"
+	OptQueueNames      = "SysInfo_queue_names"
+	OptDueIndex        = "SysInfo_due_index"        //到期索引，成员为队列名，分数为该队列延迟队列中最早的到期时间
+	OptSchedulerLeader = "SysInfo_scheduler_leader" //调度器leader租约
+	OptTopicNames      = "SysInfo_topic_names"      //主题名集合
+
+	popPollInterval      = 100 * time.Millisecond //推送消费没有消息时的重试间隔
+	queueRefreshInterval = 5 * time.Second        //从存储重新读取队列配置的间隔
+	maxListResults  = 1000                   //列出队列时每页最多条数
+
+	defaultDeduplicationWindow = 300 //去重ID默认的有效秒数
+	maxPriority                = 9   //消息的最高优先级
+	maxMessageAttributes       = 10  //每条消息最多的属性个数
+	maxPeekMessages            = 100 //查看消息时每次最多条数
+
+	minDeliverAtMillis = 1e12 //deliverAt为毫秒时间戳，小于它的多半误传了秒级时间戳
+
+	attributeTypesName = "yumiq:dataTypes" //保留的消息属性名，值为SQS消息属性的数据类型，SQS的属性名不能含冒号
 )
 
 //每个队列具体配置
@@ -26,154 +45,282 @@ type OptionQueue struct {
 	VisibilityTimeout      string
 	MessageRetentionPeriod string
 	DelaySeconds           string
+	DeadLetterQueue        string //死信队列
+	MaxReceiveCount        string //最大接收次数，超过后移入死信队列
+	FifoQueue              string //FIFO队列，同一消息组的消息按入列顺序逐条投递
+	DeduplicationWindow    string //去重ID的有效秒数
+	ContentBasedDedup      string //没有去重ID时按消息体去重
+	PriorityAging          string //每等待多少秒优先级加一，为0时严格按优先级出列
+}
+
+func (this OptionQueue) Fifo() bool {
+	fifo, _ := strconv.ParseBool(this.FifoQueue)
+	return fifo
+}
+
+func (this OptionQueue) contentBasedDedup() bool {
+	dedup, _ := strconv.ParseBool(this.ContentBasedDedup)
+	return dedup
+}
+
+//去重ID的有效秒数，未配置时为默认值
+func (this OptionQueue) dedupWindow() int64 {
+	if window := toInt64(this.DeduplicationWindow); window > 0 {
+		return window
+	}
+	return defaultDeduplicationWindow
+}
+
+//出列的消息，除消息属性外还带有系统属性：入列时间、首次接收时间和接收次数（包括本次）
+type Message struct {
+	MessageId                        string            `json:"messageId"`
+	ReceiptHandle                    string            `json:"receiptHandle"`
+	Body                             string            `json:"body"`
+	Attributes                       map[string]string `json:"attributes,omitempty"`
+	SentTimestamp                    int64             `json:"sentTimestamp"`
+	ApproximateFirstReceiveTimestamp int64             `json:"approximateFirstReceiveTimestamp"`
+	ApproximateReceiveCount          int64             `json:"approximateReceiveCount"`
+	dataTypes                        map[string]string //消息属性的数据类型，出列时从保留属性中取出，只有SQS接口使用
+}
+
+//延迟队列中未被接收过的消息，到期前可以查看和取消
+type ScheduledMessage struct {
+	MessageId        string            `json:"messageId"`
+	Body             string            `json:"body"`
+	Attributes       map[string]string `json:"attributes,omitempty"`
+	SentTimestamp    int64             `json:"sentTimestamp"`
+	DeliverTimestamp int64             `json:"deliverTimestamp"` //到期时间，到期后进入准备队列
+}
+
+//队列属性，包括配置和各状态的消息数
+type QueueAttributes struct {
+	QueueName              string `json:"queueName"`
+	VisibilityTimeout      string `json:"visibilityTimeout"`
+	MessageRetentionPeriod string `json:"messageRetentionPeriod"`
+	DelaySeconds           string `json:"delaySeconds"`
+	DeadLetterQueue        string `json:"deadLetterQueue"`
+	MaxReceiveCount        string `json:"maxReceiveCount"`
+	FifoQueue              string `json:"fifoQueue"`
+	DeduplicationWindow    string `json:"deduplicationWindow"`
+	ContentBasedDedup      string `json:"contentBasedDeduplication"`
+	PriorityAging          string `json:"priorityAging"`
+	CreatedTimestamp       string `json:"createdTimestamp"`
+	LastModifiedTimestamp  string `json:"lastModifiedTimestamp"`
+	ReadyMessages          int64  `json:"readyMessages"`
+	DelayedMessages        int64  `json:"delayedMessages"`
+	InFlightMessages       int64  `json:"inFlightMessages"`
+	OldestMessageAge       int64  `json:"oldestMessageAge"` //最早入列的消息已存在的秒数，队列为空时为0
+}
+
+//入列的单条消息
+type PushEntry struct {
+	Body            string
+	DelaySeconds    string
+	MessageGroupId  string            //消息组，FIFO队列必须指定
+	DeduplicationId string            //去重ID，有效期内相同去重ID的消息只入列一次，队列按消息体去重时可为空
+	Priority        string            //优先级0-9，数值大的先出列，默认0
+	Attributes      string            //消息属性，值为字符串的json对象
+	DeliverAt       string            //到期时间，RFC3339或毫秒时间戳，不能与DelaySeconds同时指定
+	AttributeTypes  map[string]string //消息属性的数据类型，只有SQS接口使用，String类型的不记录
 }
 
 //队列管理器配置
+//Option和QueueNameCache由HTTP处理、调度器等多个Go程读写，只能在持有mu时访问
+//内存中的配置是存储的缓存，定期刷新，其他实例创建、修改或删除的队列在一个刷新间隔内生效
 type Queues struct {
+	mu             sync.RWMutex
 	Option         map[string]OptionQueue
-	UpdateQueue    chan map[string]string
 	QueueNameCache map[string]string
 }
 
 //创建新的队列管理器
 func NewQueues() *Queues {
-	return &Queues{make(map[string]OptionQueue), make(chan map[string]string), make(map[string]string)}
+	return &Queues{Option: make(map[string]OptionQueue), QueueNameCache: make(map[string]string)}
 }
 
 func (this *Queues) init() (err error) {
 
-	//动态更新队列配置
+	//定期从存储重新读取队列配置
 	go func() {
+		ticker := time.NewTicker(queueRefreshInterval)
 		for {
 			select {
-			case uo := <-this.UpdateQueue: //监控updateQueue
-				this.AddQueueInOpt(uo["queueName"]) //记录新添加的queue，添加到set，记录队列的名字
-				this.SaveOptCache(uo["queueName"], uo) //记录到全局队列管理器的配置map中
-				go DelayQ.Monitor(uo["queueName"])  //为每个队列创建监视器，每秒查一次，延迟队列时间到后，发布到准备队列
+			case <-ticker.C:
+				if err := this.refresh(); err != nil {
+					log.Printf("queue options refresh error: %s", err.Error())
+				}
 			}
 		}
 	}()
 
-	items, _ := this.GetAllQueuesInfo()
+	return this.refresh()
+}
 
-	if len(items) == 0 {
-		return
+//从存储读取全部队列名和配置，替换内存中的缓存
+func (this *Queues) refresh() error {
+	items, err := this.GetAllQueuesInfo()
+	if err != nil {
+		return err
 	}
 
-	var optMap map[string]string
+	options := make(map[string]OptionQueue, len(items))
+	names := make(map[string]string, len(items))
 	for _, qname := range items {
-		if optMap, err = this.GetOptions(qname); err != nil { //获取所有队列的配置信息
-			return
+		optMap, err := this.GetOptions(qname) //获取所有队列的配置信息
+		if err != nil {
+			return err
+		}
+		if len(optMap) != 0 {
+			optMap["queueName"] = qname
+			options[qname] = newOptionQueue(optMap)
 		}
-		//更新到内存
-		this.SaveOptCache(qname, optMap)
+		names[qname] = ""
 	}
-	return
+
+	this.mu.Lock()
+	this.Option, this.QueueNameCache = options, names
+	this.mu.Unlock()
+	return nil
+}
+
+func newOptionQueue(opt map[string]string) OptionQueue {
+	return OptionQueue{opt["queueName"], opt["visibilityTimeout"], opt["messageRetentionPeriod"], opt["delaySeconds"], opt["deadLetterQueue"], opt["maxReceiveCount"], opt["fifoQueue"], opt["deduplicationWindow"], opt["contentBasedDeduplication"], opt["priorityAging"]}
 }
 
 func (this *Queues) SaveOptCache(qname string, opt map[string]string) {
-	this.Option[qname] = OptionQueue{opt["queueName"], opt["visibilityTimeout"], opt["messageRetentionPeriod"], opt["delaySeconds"]}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.Option[qname] = newOptionQueue(opt)
 }
 
+//从存储读取单个队列的配置更新到内存，存储中已没有该队列时从内存中删除
+func (this *Queues) load(queueName string) (qn OptionQueue, ok bool, err error) {
+	opt, err := this.GetOptions(queueName)
+	if err != nil {
+		return
+	}
+
+	if len(opt) == 0 {
+		this.mu.Lock()
+		delete(this.Option, queueName)
+		this.mu.Unlock()
+		return
+	}
+	opt["queueName"] = queueName
+	this.SaveOptCache(queueName, opt)
+	return newOptionQueue(opt), true, nil
+}
+
+//内存中没有时从存储读取，其他实例刚创建的队列不必等到下一次刷新
 func (this *Queues) Get(queueName string) (qn OptionQueue, ok bool) {
+	this.mu.RLock()
 	qn, ok = this.Option[queueName]
+	this.mu.RUnlock()
+
+	if !ok {
+		qn, ok, _ = this.load(queueName)
+	}
 	return
 }
 
 //系统配置
 func (this *Queues) AddQueueInOpt(qname string) (err error) {
-	rdg := Pool.Get()
-	defer rdg.Close()
 	//记录所有的队列名
-	_, err = rdg.Do("SADD", OptQueueNames, qname)
+	err = Store.AddQueueName(qname)
 
+	this.mu.Lock()
 	this.QueueNameCache[qname] = ""
+	this.mu.Unlock()
 
 	return
 }
 
 func (this *Queues) DelQueueInOpt(k string) (err error) {
-	rdg := Pool.Get()
-	defer rdg.Close()
-
-	_, err = rdg.Do("SREM", OptQueueNames, k)
+	err = Store.DelQueueName(k)
 
-	if _, ok := this.QueueNameCache[k]; ok {
-		delete(this.QueueNameCache, k)
-	}
+	this.mu.Lock()
+	delete(this.QueueNameCache, k)
+	this.mu.Unlock()
 	return
 }
 
 func (this *Queues) ExistsQueueInOpt(qname string) (ok bool, err error) {
-	rdg := Pool.Get()
-	defer rdg.Close()
+	this.mu.RLock()
+	_, ok = this.QueueNameCache[qname]
+	this.mu.RUnlock()
 
-	if _, ok = this.QueueNameCache[qname]; !ok {
-		ok, err = redis.Bool(rdg.Do("SISMEMBER", OptQueueNames, qname))
+	if !ok {
+		ok, err = Store.ExistsQueueName(qname)
 	}
-
 	return
 }
 
-//获取系统配置，获取队列名set中的队列名，存到全局队列管理器中的队列缓存里（key是队列名，value为空）
+//获取队列名set中的队列名，只读存储，不修改队列缓存
 func (this *Queues) GetAllQueuesInfo() ([]string, error) {
-	rdg := Pool.Get()
-	defer rdg.Close()
-
-	queues, err := redis.Strings(rdg.Do("SMEMBERS", OptQueueNames))
-
-	for _, q := range queues {
-		this.QueueNameCache[q] = "" //cache
-	}
-	return queues, err
+	return Store.QueueNames()
 }
 
-//直接获取全局队列管理器中的队列缓存
+//获取全局队列管理器中的队列缓存的副本（key是队列名，value为空）
 func (this *Queues) GetAllQueuesInfoByCache() (map[string]string, error) {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
 
 	if len(this.QueueNameCache) == 0 {
 		return nil,fmt.Errorf("queues info cache not exists")
 	}
-	return this.QueueNameCache,nil
+	queues := make(map[string]string, len(this.QueueNameCache))
+	for k, v := range this.QueueNameCache {
+		queues[k] = v
+	}
+	return queues,nil
 }
 
-//删除配置hash中的队列
+//删除队列配置，同时删除内存中的配置，之后Get不再返回该队列
 func (this *Queues) DelQueue(queueName string) (err error) {
-	rdg := Pool.Get()
-	defer rdg.Close()
-
-	if _, err = rdg.Do("DEL", this.Table(queueName)); err == nil {
+	if err = Store.DelOptions(queueName); err == nil {
 		err = this.DelQueueInOpt(queueName)
 	}
+	this.mu.Lock()
+	delete(this.Option, queueName)
+	this.mu.Unlock()
 
 	return
 }
 
-//queues读取配置时规定的配置键名
-func (this *Queues) Table(queueName string) string {
-	return "configureQueue_" + queueName
-}
-
-//获取某队列的配置hash中
+//获取某队列的配置
 func (this *Queues) GetOptions(queueName string) (opt map[string]string, err error) {
-	rdg := Pool.Get()
-	defer rdg.Close()
+	return Store.GetOptions(queueName)
+}
 
-	queueName = this.Table(queueName)
+//按队列名排序列出以prefix开头的队列，从nextToken之后开始，最多maxResults条
+//还有剩余时返回本页最后一个队列名作为下一页的nextToken
+func (this *Queues) List(prefix string, nextToken string, maxResults int) (names []string, next string, err error) {
+	if maxResults <= 0 || maxResults > maxListResults {
+		maxResults = maxListResults
+	}
 
-	if opt, err = redis.StringMap(rdg.Do("HGETALL", queueName)); err != nil {
+	queues, err := this.GetAllQueuesInfo()
+	if err != nil {
 		return
 	}
+	sort.Strings(queues)
 
+	for _, q := range queues {
+		if !strings.HasPrefix(q, prefix) || (nextToken != "" && q <= nextToken) {
+			continue
+		}
+		if len(names) == maxResults {
+			return names, names[len(names)-1], nil
+		}
+		names = append(names, q)
+	}
 	return
 }
 
 //查看配置中有无此队列
 func (this *Queues) queueExists(queueName string) (bool, error) {
-	rdg := Pool.Get()
-	defer rdg.Close()
-
-	if result, _ := redis.Bool(rdg.Do("EXISTS", this.Table(queueName))); result {
+	if result, _ := Store.OptionsExist(queueName); result {
 		return true, fmt.Errorf("queue exists:%s", queueName)
 	}
 
@@ -181,45 +328,80 @@ func (this *Queues) queueExists(queueName string) (bool, error) {
 }
 
 //创建配置
-func (this *Queues) build(opt OptionQueue) (err error) {
-	rdg := Pool.Get()
-	defer rdg.Close()
-
-	queueName := this.Table(opt.QueueName)
-
+//创建时同时记录创建时间，每次保存都更新修改时间
+func (this *Queues) build(opt OptionQueue, create bool) (err error) {
 	visibilityTimeout, messageRetentionPeriod, delaySeconds := toInt64(opt.VisibilityTimeout), toInt64(opt.MessageRetentionPeriod), toInt64(opt.DelaySeconds)
 
 	if visibilityTimeout == 0 {
-		return fmt.Errorf("VisibilityTimeout must be greater than zero!")
+		return newError(ErrInvalid, "VisibilityTimeout must be greater than zero!")
 	}
 
-	//隐藏时间
-	if _, err = rdg.Do("HSET", queueName, "visibilityTimeout", visibilityTimeout); err != nil {
-		return
+	maxReceiveCount := toInt64(opt.MaxReceiveCount)
+	if opt.DeadLetterQueue != "" {
+		if opt.DeadLetterQueue == opt.QueueName {
+			return newError(ErrInvalid, "DeadLetterQueue must not be the queue itself!")
+		}
+		if result, _ := this.queueExists(opt.DeadLetterQueue); !result {
+			return newError(ErrInvalid, "DeadLetterQueue %s doesn't exist", opt.DeadLetterQueue)
+		}
+		if maxReceiveCount <= 0 {
+			return newError(ErrInvalid, "MaxReceiveCount must be greater than zero!")
+		}
 	}
-	//信息最大保存时间
-	if _, err = rdg.Do("HSET", queueName, "messageRetentionPeriod", messageRetentionPeriod); err != nil {
-		return
+
+	//FIFO队列的消息按组逐条投递，不支持延迟
+	fifo := opt.Fifo()
+	if fifo && delaySeconds != 0 {
+		return newError(ErrInvalid, "FIFO queue doesn't support DelaySeconds!")
 	}
-	//延迟队列
-	if _, err = rdg.Do("HSET", queueName, "delaySeconds", delaySeconds); err != nil {
-		return
+	if toInt64(opt.DeduplicationWindow) < 0 {
+		return newError(ErrInvalid, "DeduplicationWindow must not be less than zero!")
 	}
-	return
+	priorityAging := toInt64(opt.PriorityAging)
+	if priorityAging < 0 {
+		return newError(ErrInvalid, "PriorityAging must not be less than zero!")
+	}
+
+	now := toString(theMoment())
+	saved := map[string]string{
+		"visibilityTimeout":         toString(visibilityTimeout),                 //隐藏时间
+		"messageRetentionPeriod":    toString(messageRetentionPeriod),            //信息最大保存时间
+		"delaySeconds":              toString(delaySeconds),                      //延迟队列
+		"deadLetterQueue":           opt.DeadLetterQueue,                         //死信队列
+		"maxReceiveCount":           toString(maxReceiveCount),                   //最大接收次数
+		"fifoQueue":                 strconv.FormatBool(fifo),                    //FIFO队列
+		"deduplicationWindow":       toString(opt.dedupWindow()),                 //去重ID的有效秒数
+		"contentBasedDeduplication": strconv.FormatBool(opt.contentBasedDedup()), //按消息体去重
+		"priorityAging":             toString(priorityAging),                     //优先级老化秒数
+		"lastModifiedTimestamp":     now,                                         //修改时间
+	}
+	if create {
+		saved["createdTimestamp"] = now //创建时间
+	}
+	return Store.SaveOptions(opt.QueueName, saved)
 }
 
 func (this *Queues) Create(opt OptionQueue) (err error) {
 	if result, _ := this.queueExists(opt.QueueName); result {
-		return fmt.Errorf("Queue %s exist", opt.QueueName)
+		return newError(ErrConflict, "Queue %s exist", opt.QueueName)
 	}
-	return this.build(opt)
+	return this.build(opt, true)
 }
 
 func (this *Queues) Update(opt OptionQueue) (err error) {
 	if result, _ := this.queueExists(opt.QueueName); !result {
-		return fmt.Errorf("Queue %s doesn't exist", opt.QueueName)
+		return newError(ErrNotFound, "Queue %s doesn't exist", opt.QueueName)
+	}
+
+	//已有的消息按原来的类型存放，不能修改
+	current, err := this.GetOptions(opt.QueueName)
+	if err != nil {
+		return
+	}
+	if opt.Fifo() != (current["fifoQueue"] == "true") {
+		return newError(ErrInvalid, "FifoQueue can't be changed")
 	}
-	return this.build(opt)
+	return this.build(opt, false)
 }
 
 type Yumi struct {
@@ -246,313 +428,499 @@ func (this *Yumi) FunWork() {
 }
 
 //创建队列，调用queues的create方法，先更新redis中的hash
-//然后更新queues的configmap,同时更新redis的表名set，并启动一个delayqueue的监视Go程
+//然后记录队列名，并把存储中的配置读入内存，返回时队列已可以使用
 func (this *Yumi) Create(optionQueue OptionQueue) (err error) {
 
-	if err = Queue.Create(optionQueue); err == nil {
-		opt := make(map[string]string)
-		opt["queueName"] = optionQueue.QueueName
-		opt["visibilityTimeout"] = optionQueue.VisibilityTimeout
-		opt["messageRetentionPeriod"] = optionQueue.MessageRetentionPeriod
-		opt["delaySeconds"] = optionQueue.DelaySeconds
-
-		Queue.UpdateQueue <- opt //创建
+	if err = Queue.Create(optionQueue); err != nil {
+		return
+	}
+	if err = Queue.AddQueueInOpt(optionQueue.QueueName); err != nil {
+		return
 	}
+	_, _, err = Queue.load(optionQueue.QueueName)
 	return
 }
 
+//FifoQueue为空时保持原来的类型
 func (this *Yumi) Update(optionQueue OptionQueue) (err error) {
+	if current, ok := Queue.Get(optionQueue.QueueName); ok && optionQueue.FifoQueue == "" {
+		optionQueue.FifoQueue = current.FifoQueue
+	}
 
+	//返回时内存中已是修改后的配置
 	if err = Queue.Update(optionQueue); err == nil {
-		opt := make(map[string]string)
-		opt["queueName"] = optionQueue.QueueName
-		opt["visibilityTimeout"] = optionQueue.VisibilityTimeout
-		opt["messageRetentionPeriod"] = optionQueue.MessageRetentionPeriod
-		opt["delaySeconds"] = optionQueue.DelaySeconds
-
-		Queue.UpdateQueue <- opt //更新
+		_, _, err = Queue.load(optionQueue.QueueName)
 	}
 	return
 }
 
-//插入队列
-func (this *Yumi) Push(queueName string, body string, delaySeconds string) (err error) {
+//插入队列，消息体按ID单独保存，准备队列和延迟队列中只存放消息ID
+//去重ID有效期内重复入列时不再入列，返回之前入列的消息ID，生产者超时重试不会产生重复消息
+func (this *Yumi) Push(queueName string, entry PushEntry) (id string, err error) {
 	optionQueue, ok := Queue.Get(queueName) //获取队列管理器queues中的队列配置
 
 	if !ok {
-		return fmt.Errorf("Queue %s exception", queueName)
+		return "", newError(ErrNotFound, "Queue %s exception", queueName)
 	}
 
-	var delaySecondsInt, queueDelaySeconds int64
-
-	if delaySeconds != "" {
-		delaySecondsInt = toInt64(delaySeconds)
+	message, err := this.newMessage(optionQueue, newID(), entry)
+	if err != nil {
+		return "", err
 	}
 
-	if optionQueue.DelaySeconds != "" {
-		queueDelaySeconds = toInt64(optionQueue.DelaySeconds)
+	ids, err := Store.Push(queueName, []NewMessage{message})
+	if err != nil {
+		return "", err
 	}
-
-	if delaySecondsInt != 0 {  //入列有延时，按入列延时
-		err = this.delayPush(queueName, body, delaySecondsInt)
-	} else if queueDelaySeconds != 0 {  //入列没有延时，按队列延时
-		err = this.delayPush(queueName, body, queueDelaySeconds)
-	} else {  //都没有延时，插入准备队列
-		err = this.readyPush(queueName, body)
+	if ids[0] == message.Id {
+		pushedTotal.WithLabelValues(queueName).Inc()
 	}
-	return
+	return ids[0], nil
 }
 
-//插入延迟队列
-func (this *Yumi) delayPush(queueName string, body string, delaySeconds int64) (err error) {
-	if err = DelayQ.Add(queueName, body, delaySeconds); err != nil {
-		return
+//批量插入队列，最多MaxBatch条，单条消息的错误放在errs中对应位置，整批失败时返回err
+func (this *Yumi) PushBatch(queueName string, entries []PushEntry) (ids []string, errs []error, err error) {
+	optionQueue, ok := Queue.Get(queueName)
+
+	if !ok {
+		return nil, nil, newError(ErrNotFound, "Queue %s exception", queueName)
+	}
+	if len(entries) > MaxBatch {
+		return nil, nil, newError(ErrInvalid, "at most %d messages per batch", MaxBatch)
 	}
-	return
-}
 
-//插入准备队列
-func (this *Yumi) readyPush(queueName string, body string) (err error) {
-	if err = ReadyQ.Push(queueName, body); err != nil {
-		return
+	ids, errs = make([]string, len(entries)), make([]error, len(entries))
+	var messages []NewMessage
+	var indexes []int //messages中每条消息在entries中的位置
+
+	for i, entry := range entries {
+		if entry.Body == "" {
+			errs[i] = newError(ErrInvalid, "body must not be null")
+			continue
+		}
+
+		message, err := this.newMessage(optionQueue, newID(), entry)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		messages = append(messages, message)
+		indexes = append(indexes, i)
 	}
-	return
-}
 
-//弹出队列
-func (this *Yumi) Pop(queueName string, waitSeconds int) (string, error) {
-	body,err := ReadyQ.Pop(queueName, waitSeconds)
-	if err != nil{
-		return "",err
+	saved, err := Store.Push(queueName, messages)
+	if err != nil {
+		return nil, nil, err
 	}
-	//出列后入延迟列
-	optionQueue, ok := Queue.Get(queueName)
-	if ok {
-		DelayQ.Add(queueName, body, toInt64(optionQueue.VisibilityTimeout));
+
+	var pushed int
+	for j, id := range saved {
+		ids[indexes[j]] = id
+		if id == messages[j].Id {
+			pushed++
+		}
 	}
-	return body,err
+	pushedTotal.WithLabelValues(queueName).Add(float64(pushed))
+	return
 }
 
-//删除
-func (this *Yumi) Del(queueName string, body string) (err error) {
-	return DelayQ.Del(queueName, body)
-
-}
+//待入列的消息，入列有延时按入列延时，入列没有延时按队列延时，都没有延时直接进入准备队列
+//指定到期时间时不再按队列延时，到期时间已过的直接进入准备队列，到期时间不能晚于消息保留时间
+//FIFO队列的消息必须指定消息组且不能延时，消息组只用于FIFO队列
+//没有去重ID而队列按消息体去重时，以消息体的sha256作为去重ID
+//FIFO队列按消息组顺序投递，不支持优先级
+func (this *Yumi) newMessage(optionQueue OptionQueue, id string, entry PushEntry) (message NewMessage, err error) {
+	var delay int64
 
-//
-func (this *Yumi) SetVisibilityTime(queueName string, body string, visibilityTime int64) (err error) {
-	err = DelayQ.SetVisibilityTime(queueName, body, visibilityTime)
-	return
-}
+	if entry.DelaySeconds != "" {
+		delay = toInt64(entry.DelaySeconds)
+	}
 
-func (this *Yumi) CleanQueue(queueName string) (err error) {
-	rdg := Pool.Get()
-	defer rdg.Close()
+	now := theMoment()
+	var deliverAt int64
+	if entry.DeliverAt != "" {
+		if delay != 0 {
+			return message, newError(ErrInvalid, "delaySeconds and deliverAt must not be both set")
+		}
+		if deliverAt, err = parseDeliverAt(entry.DeliverAt); err != nil {
+			return
+		}
+		retention := toInt64(optionQueue.MessageRetentionPeriod)
+		if retention != 0 && deliverAt > now+retention {
+			return message, newError(ErrInvalid, "deliverAt must be within messageRetentionPeriod (%d seconds) from now", retention)
+		}
+	}
 
-	optionQueue,ok := Queue.Get(queueName)
-	delayQueueName := DelayQ.Table(queueName)
+	var priority int
+	if entry.Priority != "" {
+		if priority, err = strconv.Atoi(entry.Priority); err != nil || priority < 0 || priority > maxPriority {
+			return message, newError(ErrInvalid, "priority must be between 0 and %d", maxPriority)
+		}
+	}
 
-	if !ok {
-		return fmt.Errorf("Queue does not exist")
+	attributes, err := parseAttributes(entry.Attributes)
+	if err != nil {
+		return
+	}
+	//数据类型随消息属性保存在保留属性中，死信队列和重新投递时一并移动
+	if len(entry.AttributeTypes) != 0 {
+		if attributes == nil {
+			attributes = make(map[string]string)
+		}
+		data, _ := json.Marshal(entry.AttributeTypes)
+		attributes[attributeTypesName] = string(data)
 	}
 
-	holdSecond := toInt64(optionQueue.MessageRetentionPeriod)
+	if optionQueue.Fifo() {
+		if entry.MessageGroupId == "" {
+			return message, newError(ErrInvalid, "messageGroupId must not be null for FIFO queue")
+		}
+		if delay != 0 || deliverAt != 0 {
+			return message, newError(ErrInvalid, "FIFO queue doesn't support delaySeconds or deliverAt")
+		}
+		if priority != 0 {
+			return message, newError(ErrInvalid, "FIFO queue doesn't support priority")
+		}
+	} else if entry.MessageGroupId != "" {
+		return message, newError(ErrInvalid, "messageGroupId is only supported by FIFO queue")
+	}
 
-	validBySecond := theMoment() - holdSecond
+	if delay == 0 && deliverAt == 0 && optionQueue.DelaySeconds != "" {
+		delay = toInt64(optionQueue.DelaySeconds)
+	}
 
-	if holdSecond != 0{
-		_,err = rdg.Do("ZREMRANGEBYSCORE", delayQueueName, 0, validBySecond)
+	message = NewMessage{Id: id, Body: entry.Body, SentAt: now, GroupId: entry.MessageGroupId, Priority: priority, Attributes: attributes}
+	if deliverAt > now {
+		message.DeliverAt = deliverAt
+	} else if deliverAt == 0 && delay != 0 {
+		message.DeliverAt = now + delay
+	}
+	if entry.DeduplicationId != "" {
+		message.DeduplicationId = entry.DeduplicationId
+	} else if optionQueue.contentBasedDedup() {
+		message.DeduplicationId = contentDedupID(entry.Body)
+	}
+	if message.DeduplicationId != "" {
+		message.DedupUntil = now + optionQueue.dedupWindow()
 	}
 	return
 }
 
-//删除队列
-func (this *Yumi) DelQueue(queueName string) (err error) {
-	if err = ReadyQ.DelQueue(queueName); err != nil {
-		return
+//解析到期时间，返回秒级时间戳，毫秒时间戳不足一秒的部分向上取整，不会早于指定的时间到期
+func parseDeliverAt(value string) (int64, error) {
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if millis < minDeliverAtMillis {
+			return 0, newError(ErrInvalid, "deliverAt must be an RFC3339 time or epoch milliseconds")
+		}
+		return (millis + 999) / 1000, nil
 	}
 
-	if err = DelayQ.DelQueue(queueName); err != nil {
-		return
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, newError(ErrInvalid, "deliverAt must be an RFC3339 time or epoch milliseconds")
 	}
-	if err = Queue.DelQueue(queueName); err != nil {
-		return
+	if t.Nanosecond() != 0 {
+		return t.Unix() + 1, nil
 	}
-	return
+	return t.Unix(), nil
 }
 
-func (this *Yumi) Write(res http.ResponseWriter, message interface{}) {
-	result, err := json.Marshal(message)
-	must(err)
-	res.Write(result)
+//解析消息属性，属性最多maxMessageAttributes个，属性名不能为空
+func parseAttributes(value string) (attributes map[string]string, err error) {
+	if value == "" {
+		return nil, nil
+	}
+	if err = json.Unmarshal([]byte(value), &attributes); err != nil {
+		return nil, newError(ErrInvalid, "attributes must be a JSON object of strings")
+	}
+	if len(attributes) > maxMessageAttributes {
+		return nil, newError(ErrInvalid, "at most %d attributes per message", maxMessageAttributes)
+	}
+	for name := range attributes {
+		if name == "" {
+			return nil, newError(ErrInvalid, "attribute name must not be null")
+		} else if name == attributeTypesName {
+			return nil, newError(ErrInvalid, "attribute name %s is reserved", name)
+		}
+	}
+	return
 }
 
-//准备队列
-type ReadyQueue struct {
-}
+//从消息属性中取出保留的数据类型，返回其余的属性，存储返回的属性不修改
+func splitAttributeTypes(attributes map[string]string) (map[string]string, map[string]string) {
+	value, ok := attributes[attributeTypesName]
+	if !ok {
+		return attributes, nil
+	}
 
-func NewReadyQueue() *ReadyQueue {
-	return &ReadyQueue{}
+	var types map[string]string
+	json.Unmarshal([]byte(value), &types)
+	var rest map[string]string
+	for name, value := range attributes {
+		if name == attributeTypesName {
+			continue
+		}
+		if rest == nil {
+			rest = make(map[string]string, len(attributes)-1)
+		}
+		rest[name] = value
+	}
+	return rest, types
 }
 
-func (this *ReadyQueue) Table(queueName string) string {
-	return "readyQueue_" + queueName
+//弹出队列，最多返回maxMessages条消息，每条带消息ID、消息体以及本次接收的回执
+//出列与放入延迟队列是原子的，进程中途退出不会丢失消息
+//准备队列为空时阻塞到有消息进入准备队列，最多等待waitSeconds秒
+func (this *Yumi) Pop(queueName string, waitSeconds int, maxMessages int) ([]Message, error) {
+	return this.PopContext(context.Background(), queueName, waitSeconds, maxMessages, 0)
 }
 
-//添加到准备队列
-func (this *ReadyQueue) Push(queueName string, id string) (err error) {
-	rdg := Pool.Get()
-	defer rdg.Close()
+//同Pop，ctx结束后不再出列，返回ctx的错误
+//visibilityTimeout为出列消息的隐藏时间，为0时按队列的隐藏时间
+func (this *Yumi) PopContext(ctx context.Context, queueName string, waitSeconds int, maxMessages int, visibilityTimeout int64) (messages []Message, err error) {
+	optionQueue, ok := Queue.Get(queueName)
+	if !ok {
+		return nil, newError(ErrNotFound, "Queue %s exception", queueName)
+	}
 
-	_, err = rdg.Do("LPUSH", this.Table(queueName), id)
-	return
-}
+	if maxMessages <= 0 {
+		maxMessages = 1
+	} else if maxMessages > MaxBatch {
+		return nil, newError(ErrInvalid, "maxMessages must not be greater than %d", MaxBatch)
+	}
 
-//出队列
-func (this *ReadyQueue) Pop(queueName string, waitSeconds int) (string, error) {
-	rdg := Pool.Get()
-	defer rdg.Close()
+	opt := PopOption{MaxMessages: maxMessages, PriorityAging: toInt64(optionQueue.PriorityAging)}
+	//接收次数超过队列MaxReceiveCount的消息移入死信队列
+	if optionQueue.DeadLetterQueue != "" {
+		opt.DeadLetterQueue = optionQueue.DeadLetterQueue
+		opt.MaxReceiveCount = toInt64(optionQueue.MaxReceiveCount)
+	}
+
+	if visibilityTimeout == 0 {
+		visibilityTimeout = toInt64(optionQueue.VisibilityTimeout)
+	}
 
-	qn := this.Table(queueName)
-	//假如在指定时间内没有任何元素被弹出，则返回一个 nil 和等待时长。 反之，返回一个含有两个元素的列表，第一个元素是被弹出元素所属的 key ，第二个元素是被弹出元素的值。
-	values, err := redis.Values(rdg.Do("BRPOP", qn, waitSeconds)) //表示阻塞等待waitsecond秒
+	deadline := time.Now().Add(time.Duration(waitSeconds) * time.Second)
+	for {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
 
-	var nul, body string
-	redis.Scan(values, &nul, &body)
+		//每次接收生成新的回执，之前的回执作废
+		opt.Now = theMoment()
+		opt.Deadline = opt.Now + visibilityTimeout
+		opt.Token = newID()
+		if messages, err = Store.Pop(queueName, opt); err != nil {
+			return
+		} else if len(messages) != 0 {
+			poppedTotal.WithLabelValues(queueName).Add(float64(len(messages)))
+			for i := range messages {
+				messages[i].Attributes, messages[i].dataTypes = splitAttributeTypes(messages[i].Attributes)
+			}
+			return
+		}
 
-	return body, err
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return nil, newError(ErrEmpty, "queue %s has no message", queueName)
+		}
+		//被唤醒后再出列，消息可能已被其他调用方取走
+		if err = Store.Wait(ctx, queueName, wait); err != nil {
+			return nil, err
+		}
+	}
 }
 
-func (this *ReadyQueue) DelQueue(queueName string) (err error) {
-	rdg := Pool.Get()
-	defer rdg.Close()
+//根据回执删除消息
+func (this *Yumi) Del(queueName string, receiptHandle string) (err error) {
+	id, err := Store.CheckReceipt(queueName, receiptHandle)
+	if err != nil {
+		return
+	}
 
-	_, err = rdg.Do("DEL", this.Table(queueName))
-	return err
+	if err = Store.Delete(queueName, id); err == nil {
+		deletedTotal.WithLabelValues(queueName).Inc()
+		Consumers.Acked(receiptHandle)
+	}
+	return
 }
 
-func (this *ReadyQueue) MultiPush(queueName string, items []string) {
-	rdg := Pool.Get()
-	defer rdg.Close()
+//根据回执修改消息的隐藏时间，为0时按队列的隐藏时间
+func (this *Yumi) SetVisibilityTime(queueName string, receiptHandle string, visibilityTime int64) (err error) {
+	id, err := Store.CheckReceipt(queueName, receiptHandle)
+	if err != nil {
+		return
+	}
 
-	queueName = this.Table(queueName)
-	for _, v := range items {
-		rdg.Send("LPUSH", queueName, v)
+	if visibilityTime == 0 {
+		optionQueue, _ := Queue.Get(queueName)
+		visibilityTime = toInt64(optionQueue.VisibilityTimeout)
 	}
-	rdg.Flush()
-}
 
-type DelayQueue struct {
+	//消息已回到准备队列时不能再修改，否则会同时存在于两个队列
+	deadline := theMoment() + visibilityTime
+	changed, err := Store.ChangeVisibility(queueName, id, deadline)
+	if err == nil && !changed {
+		err = newError(ErrConflict, "message %s is not in flight", id)
+	} else if err == nil {
+		visibilityChangesTotal.WithLabelValues(queueName).Inc()
+		Consumers.Extended(receiptHandle, deadline)
+	}
+	return
 }
 
-func NewDelayQueue() *DelayQueue {
-	return &DelayQueue{}
-}
+func (this *Yumi) CleanQueue(queueName string) (err error) {
+	optionQueue,ok := Queue.Get(queueName)
 
-func (this *DelayQueue) Table(queueName string) string {
-	return "delayQueue_" + queueName
-}
+	if !ok {
+		return newError(ErrNotFound, "Queue does not exist")
+	}
 
-//添加
-func (this *DelayQueue) Add(queueName string, id string, delaySeconds int64) (err error) {
-	redis := Pool.Get()
-	defer redis.Close()
+	holdSecond := toInt64(optionQueue.MessageRetentionPeriod)
 
-	delaySeconds = theMoment() + delaySeconds
-	_, err = redis.Do("ZADD", this.Table(queueName), delaySeconds, id)
-	return
-}
+	validBySecond := theMoment() - holdSecond
 
-//删除
-func (this *DelayQueue) Del(queueName string, id string) (err error) {
-	redis := Pool.Get()
-	defer redis.Close()
+	if holdSecond == 0 {
+		return
+	}
 
-	_, err = redis.Do("ZREM", this.Table(queueName), id)
+	count, err := Store.Clean(queueName, validBySecond)
+	if count != 0 {
+		retentionDeletedTotal.WithLabelValues(queueName).Add(float64(count))
+	}
 	return
 }
 
-func (this *DelayQueue) SetVisibilityTime(queueName string, body string, visibilityTime int64) (err error) {
-	redis := Pool.Get()
-	defer redis.Close()
-
-	optionQueue, _ := Queue.Get(queueName)
+//死信队列中的消息移回来源队列，sourceQueue不为空时只移回来自该队列的消息，maxMessages为0时不限条数
+func (this *Yumi) Redrive(queueName string, sourceQueue string, maxMessages int64) (count int64, err error) {
+	if _, ok := Queue.Get(queueName); !ok {
+		return 0, newError(ErrNotFound, "Queue %s exception", queueName)
+	}
+	if sourceQueue != "" {
+		if _, ok := Queue.Get(sourceQueue); !ok {
+			return 0, newError(ErrNotFound, "Queue %s exception", sourceQueue)
+		}
+	}
+	return Store.Redrive(queueName, sourceQueue, maxMessages, func(source string) bool {
+		_, ok := Queue.Get(source)
+		return ok
+	})
+}
 
-	if visibilityTime == 0 {
-		visibilityTime = toInt64(optionQueue.VisibilityTimeout)
+//获取队列属性，配置从存储中读取，消息数为读取时的近似值
+func (this *Yumi) GetQueueAttributes(queueName string) (attributes QueueAttributes, err error) {
+	if _, ok := Queue.Get(queueName); !ok {
+		return attributes, newError(ErrNotFound, "Queue %s exception", queueName)
 	}
 
-	visibilityTime = theMoment() + visibilityTime
-	_, err = redis.Do("ZADD", this.Table(queueName), visibilityTime, body)
+	opt, err := Queue.GetOptions(queueName)
+	if err != nil {
+		return
+	}
+	stats, err := Store.Stats(queueName)
+	if err != nil {
+		return
+	}
 
+	attributes = QueueAttributes{queueName, opt["visibilityTimeout"], opt["messageRetentionPeriod"], opt["delaySeconds"],
+		opt["deadLetterQueue"], opt["maxReceiveCount"], opt["fifoQueue"], opt["deduplicationWindow"], opt["contentBasedDeduplication"], opt["priorityAging"], opt["createdTimestamp"], opt["lastModifiedTimestamp"],
+		stats.Ready, stats.Delayed, stats.InFlight, 0}
+	if stats.OldestSentAt != 0 {
+		attributes.OldestMessageAge = theMoment() - stats.OldestSentAt
+	}
 	return
 }
 
-func (this *DelayQueue) DelQueue(queueName string) (err error) {
-	rdg := Pool.Get()
-	defer rdg.Close()
+//清空队列中的全部消息，保留队列配置
+func (this *Yumi) Purge(queueName string) (err error) {
+	if _, ok := Queue.Get(queueName); !ok {
+		return newError(ErrNotFound, "Queue %s exception", queueName)
+	}
 
-	_, err = rdg.Do("DEL", this.Table(queueName))
-	return err
+	if err = Store.DelMessages(queueName); err != nil {
+		return
+	}
+	return Store.Unschedule(queueName)
 }
 
-//移去 准备队列
-func (this *DelayQueue) ToReadyQueue(queueName string, exit chan bool) {
-	rdg := Pool.Get()
-	defer rdg.Close()
-
-	if ok, _ := Queue.ExistsQueueInOpt(queueName); !ok {  //查看队列名set中有无此队列
-		close(exit)
-		log.Printf("%s queue exit", queueName)
+//按出列顺序查看准备队列中的消息，不改变消息状态，maxMessages为0时为MaxBatch条
+func (this *Yumi) Peek(queueName string, maxMessages int) (messages []Message, err error) {
+	if _, ok := Queue.Get(queueName); !ok {
+		return nil, newError(ErrNotFound, "Queue %s exception", queueName)
 	}
 
-	nowBySecond := theMoment()
-	delayQueueName := this.Table(queueName)
-
-	items, err := redis.Strings(rdg.Do("ZRANGEBYSCORE", delayQueueName, 0, nowBySecond))
-	must(err)
-
-	if len(items) != 0 {  //把所有的到期队列移到准备队列中
-		_, err = redis.Int(rdg.Do("ZREMRANGEBYSCORE", delayQueueName, 0, nowBySecond))
-		must(err)
-		ReadyQ.MultiPush(queueName, items)
+	if maxMessages <= 0 {
+		maxMessages = MaxBatch
+	} else if maxMessages > maxPeekMessages {
+		return nil, newError(ErrInvalid, "maxMessages must not be greater than %d", maxPeekMessages)
+	}
+	if messages, err = Store.Peek(queueName, maxMessages); err != nil {
+		return
 	}
+	for i := range messages {
+		messages[i].Attributes, _ = splitAttributeTypes(messages[i].Attributes)
+	}
+	return
 }
 
-//监控队列 每秒监控是否有队列到期
-func (this *DelayQueue) Monitor(queueName string) {
-	redis := Pool.Get()
-	ticker := time.NewTicker(1 * time.Second)
+//按到期时间先后查看尚未到期的延迟消息，不包括隐藏中的消息，maxMessages为0时为MaxBatch条
+func (this *Yumi) Scheduled(queueName string, maxMessages int) (messages []ScheduledMessage, err error) {
+	if _, ok := Queue.Get(queueName); !ok {
+		return nil, newError(ErrNotFound, "Queue %s exception", queueName)
+	}
 
-	defer func() {
-		redis.Close()
-		ticker.Stop()
-		Qlock.Unlock()
-	}()
+	if maxMessages <= 0 {
+		maxMessages = MaxBatch
+	} else if maxMessages > maxPeekMessages {
+		return nil, newError(ErrInvalid, "maxMessages must not be greater than %d", maxPeekMessages)
+	}
+	if messages, err = Store.Scheduled(queueName, maxMessages); err != nil {
+		return
+	}
+	for i := range messages {
+		messages[i].Attributes, _ = splitAttributeTypes(messages[i].Attributes)
+	}
+	return
+}
 
-	exit := make(chan bool)
+//取消尚未到期的延迟消息，消息已到期或已被接收过时不能取消
+func (this *Yumi) CancelScheduled(queueName string, messageId string) (err error) {
+	if _, ok := Queue.Get(queueName); !ok {
+		return newError(ErrNotFound, "Queue %s exception", queueName)
+	}
 
-	for {
-		select {
-		case <-ticker.C:
-			if err := Qlock.Lock(); err == nil {
-				this.ToReadyQueue(queueName, exit)
-			}
-		case <-exit:
-			return
-		}
+	cancelled, err := Store.CancelScheduled(queueName, messageId)
+	if err == nil && !cancelled {
+		err = newError(ErrNotFound, "message %s is not scheduled", messageId)
+	} else if err == nil {
+		scheduledCancelledTotal.WithLabelValues(queueName).Inc()
 	}
+	return
 }
 
-//延迟队列定时
-func (this *DelayQueue) Trigger() {
-	rdg := Pool.Get()
-	defer rdg.Close()
+//删除队列
+func (this *Yumi) DelQueue(queueName string) (err error) {
+	if err = Store.DelMessages(queueName); err != nil {
+		return
+	}
 
-	items, _ := Queue.GetAllQueuesInfoByCache()
-	for qname,_ := range items {
-		go this.Monitor(qname)
+	if err = Store.Unschedule(queueName); err != nil {
+		return
 	}
+	if err = Queue.DelQueue(queueName); err != nil {
+		return
+	}
+	if err = this.unsubscribeQueue(queueName); err != nil {
+		return
+	}
+	forgetQueueMetrics(queueName)
+	return
+}
+
+func (this *Yumi) Write(res http.ResponseWriter, message interface{}) {
+	result, err := json.Marshal(message)
+	must(err)
+	res.Write(result)
 }
 
 type CreateResult struct {
@@ -561,6 +929,12 @@ type CreateResult struct {
 	VisibilityTimeout      string `json:"visibilityTimeout"`
 	MessageRetentionPeriod string `json:"messageRetentionPeriod"`
 	DelaySeconds           string `json:"delaySeconds"`
+	DeadLetterQueue        string `json:"deadLetterQueue"`
+	MaxReceiveCount        string `json:"maxReceiveCount"`
+	FifoQueue              string `json:"fifoQueue"`
+	DeduplicationWindow    string `json:"deduplicationWindow"`
+	ContentBasedDedup      string `json:"contentBasedDeduplication"`
+	PriorityAging          string `json:"priorityAging"`
 	Error                  string `json:"error"`
 }
 
@@ -570,27 +944,54 @@ type UpdateResult struct {
 	VisibilityTimeout      string `json:"visibilityTimeout"`
 	MessageRetentionPeriod string `json:"messageRetentionPeriod"`
 	DelaySeconds           string `json:"delaySeconds"`
+	DeadLetterQueue        string `json:"deadLetterQueue"`
+	MaxReceiveCount        string `json:"maxReceiveCount"`
+	FifoQueue              string `json:"fifoQueue"`
+	DeduplicationWindow    string `json:"deduplicationWindow"`
+	ContentBasedDedup      string `json:"contentBasedDeduplication"`
+	PriorityAging          string `json:"priorityAging"`
 	Error                  string `json:"error"`
 }
 
 type PushResult struct {
 	Success      bool   `json:"success"`
 	QueueName    string `json:"queueName"`
-	Body         string `json:"body"`
+	MessageId    string `json:"messageId"`
 	DelaySeconds string `json:"delaySeconds"`
+	DeliverAt    string `json:"deliverAt"`
+	Priority     string `json:"priority"`
 	Error        string `json:"error"`
 }
 
+type PushBatchEntryResult struct {
+	Index        int    `json:"index"`
+	Success      bool   `json:"success"`
+	MessageId    string `json:"messageId"`
+	DelaySeconds string `json:"delaySeconds"`
+	DeliverAt    string `json:"deliverAt"`
+	Priority     string `json:"priority"`
+	Error        string `json:"error"`
+}
+
+type PushBatchResult struct {
+	Success   bool                   `json:"success"`
+	QueueName string                 `json:"queueName"`
+	Entries   []PushBatchEntryResult `json:"entries"`
+	Error     string                 `json:"error"`
+}
+
+//单条出列时结果与第一条消息相同，Messages为本次取出的全部消息
 type PopResult struct {
-	Success bool   `json:"success"`
-	Body    string `json:"body"`
-	Error   string `json:"error"`
+	Success bool `json:"success"`
+	Message
+	Messages []Message `json:"messages"`
+	Error    string    `json:"error"`
 }
 
 type DelResult struct {
-	Success bool   `json:"success"`
-	Body    string `json:"body"`
-	Error   string `json:"error"`
+	Success       bool   `json:"success"`
+	ReceiptHandle string `json:"receiptHandle"`
+	Error         string `json:"error"`
 }
 
 type DelQueueResult struct {
@@ -599,9 +1000,56 @@ type DelQueueResult struct {
 	Error     string `json:"error"`
 }
 
+type PurgeQueueResult struct {
+	Success   bool   `json:"success"`
+	QueueName string `json:"queueName"`
+	Error     string `json:"error"`
+}
+
+type PeekResult struct {
+	Success  bool      `json:"success"`
+	Messages []Message `json:"messages"`
+	Error    string    `json:"error"`
+}
+
+type ScheduledResult struct {
+	Success  bool               `json:"success"`
+	Messages []ScheduledMessage `json:"messages"`
+	Error    string             `json:"error"`
+}
+
+type CancelScheduledResult struct {
+	Success   bool   `json:"success"`
+	QueueName string `json:"queueName"`
+	MessageId string `json:"messageId"`
+	Error     string `json:"error"`
+}
+
+type RedriveResult struct {
+	Success     bool   `json:"success"`
+	QueueName   string `json:"queueName"`
+	SourceQueue string `json:"sourceQueue"`
+	Count       int64  `json:"count"`
+	Error       string `json:"error"`
+}
+
+type QueueAttributesResult struct {
+	Success bool `json:"success"`
+	QueueAttributes
+	Error string `json:"error"`
+}
+
+type ListQueuesResult struct {
+	Success    bool     `json:"success"`
+	QueueNames []string `json:"queueNames"`
+	NextToken  string   `json:"nextToken"`
+	Error      string   `json:"error"`
+}
+
 type SetVisibilityTimeResult struct {
 	Success           bool   `json:"success"`
 	QueueName         string `json:"queueName"`
+	ReceiptHandle     string `json:"receiptHandle"`
 	VisibilityTimeout string `json:"visibilityTimeout"`
 	Error             string `json:"error"`
 }
@@ -612,9 +1060,15 @@ func CreateQueue(res http.ResponseWriter, req *http.Request) {
 	visibilityTimeout := req.PostFormValue("VisibilityTimeout")
 	messageRetentionPeriod := req.PostFormValue("MessageRetentionPeriod")
 	delaySeconds := req.PostFormValue("DelaySeconds")
+	deadLetterQueue := req.PostFormValue("DeadLetterQueue")
+	maxReceiveCount := req.PostFormValue("MaxReceiveCount")
+	fifoQueue := req.PostFormValue("FifoQueue")                         //FIFO队列，创建后不能修改
+	deduplicationWindow := req.PostFormValue("DeduplicationWindow")     //去重ID的有效秒数，默认300
+	contentBasedDedup := req.PostFormValue("ContentBasedDeduplication") //没有去重ID时按消息体去重
+	priorityAging := req.PostFormValue("PriorityAging")                 //每等待多少秒优先级加一，默认0不老化
 
 	if queueName == "" {
-		YumiQ.Write(res, CreateResult{false, queueName, visibilityTimeout, messageRetentionPeriod, delaySeconds, "QueueName must not be null"})
+		YumiQ.Write(res, CreateResult{false, queueName, visibilityTimeout, messageRetentionPeriod, delaySeconds, deadLetterQueue, maxReceiveCount, fifoQueue, deduplicationWindow, contentBasedDedup, priorityAging, "QueueName must not be null"})
 		return
 	}
 
@@ -623,11 +1077,17 @@ func CreateQueue(res http.ResponseWriter, req *http.Request) {
 	optionQueue.VisibilityTimeout = visibilityTimeout
 	optionQueue.MessageRetentionPeriod = messageRetentionPeriod //最大存储时间
 	optionQueue.DelaySeconds = delaySeconds
+	optionQueue.DeadLetterQueue = deadLetterQueue
+	optionQueue.MaxReceiveCount = maxReceiveCount
+	optionQueue.FifoQueue = fifoQueue
+	optionQueue.DeduplicationWindow = deduplicationWindow
+	optionQueue.ContentBasedDedup = contentBasedDedup
+	optionQueue.PriorityAging = priorityAging
 
 	if err := YumiQ.Create(optionQueue); err != nil {
-		YumiQ.Write(res, CreateResult{false, queueName, visibilityTimeout, messageRetentionPeriod, delaySeconds, err.Error()})
+		YumiQ.Write(res, CreateResult{false, queueName, visibilityTimeout, messageRetentionPeriod, delaySeconds, deadLetterQueue, maxReceiveCount, fifoQueue, deduplicationWindow, contentBasedDedup, priorityAging, err.Error()})
 	} else {
-		YumiQ.Write(res, CreateResult{true, queueName, visibilityTimeout, messageRetentionPeriod, delaySeconds, ""})
+		YumiQ.Write(res, CreateResult{true, queueName, visibilityTimeout, messageRetentionPeriod, delaySeconds, deadLetterQueue, maxReceiveCount, fifoQueue, deduplicationWindow, contentBasedDedup, priorityAging, ""})
 	}
 }
 
@@ -637,9 +1097,15 @@ func UpdateQueue(res http.ResponseWriter, req *http.Request) {
 	visibilityTimeout := req.PostFormValue("VisibilityTimeout")           //变成活跃时间
 	messageRetentionPeriod := req.PostFormValue("MessageRetentionPeriod") //信息最大保存时间
 	delaySeconds := req.PostFormValue("DelaySeconds")                     //延迟时间
+	deadLetterQueue := req.PostFormValue("DeadLetterQueue")               //死信队列
+	maxReceiveCount := req.PostFormValue("MaxReceiveCount")               //最大接收次数
+	fifoQueue := req.PostFormValue("FifoQueue")                           //为空时保持原来的类型
+	deduplicationWindow := req.PostFormValue("DeduplicationWindow")       //去重ID的有效秒数
+	contentBasedDedup := req.PostFormValue("ContentBasedDeduplication")   //没有去重ID时按消息体去重
+	priorityAging := req.PostFormValue("PriorityAging")                   //每等待多少秒优先级加一
 
 	if queueName == "" {
-		YumiQ.Write(res, UpdateResult{false, queueName, visibilityTimeout, messageRetentionPeriod, delaySeconds, "QueueName must not be null"})
+		YumiQ.Write(res, UpdateResult{false, queueName, visibilityTimeout, messageRetentionPeriod, delaySeconds, deadLetterQueue, maxReceiveCount, fifoQueue, deduplicationWindow, contentBasedDedup, priorityAging, "QueueName must not be null"})
 		return
 	}
 
@@ -648,11 +1114,17 @@ func UpdateQueue(res http.ResponseWriter, req *http.Request) {
 	optionQueue.VisibilityTimeout = visibilityTimeout
 	optionQueue.MessageRetentionPeriod = messageRetentionPeriod
 	optionQueue.DelaySeconds = delaySeconds
+	optionQueue.DeadLetterQueue = deadLetterQueue
+	optionQueue.MaxReceiveCount = maxReceiveCount
+	optionQueue.FifoQueue = fifoQueue
+	optionQueue.DeduplicationWindow = deduplicationWindow
+	optionQueue.ContentBasedDedup = contentBasedDedup
+	optionQueue.PriorityAging = priorityAging
 
 	if err := YumiQ.Update(optionQueue); err != nil {
-		YumiQ.Write(res, UpdateResult{false, queueName, visibilityTimeout, messageRetentionPeriod, delaySeconds, err.Error()})
+		YumiQ.Write(res, UpdateResult{false, queueName, visibilityTimeout, messageRetentionPeriod, delaySeconds, deadLetterQueue, maxReceiveCount, fifoQueue, deduplicationWindow, contentBasedDedup, priorityAging, err.Error()})
 	} else {
-		YumiQ.Write(res, UpdateResult{true, queueName, visibilityTimeout, messageRetentionPeriod, delaySeconds, ""})
+		YumiQ.Write(res, UpdateResult{true, queueName, visibilityTimeout, messageRetentionPeriod, delaySeconds, deadLetterQueue, maxReceiveCount, fifoQueue, deduplicationWindow, contentBasedDedup, priorityAging, ""})
 	}
 }
 
@@ -660,20 +1132,65 @@ func Push(res http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
 	queueName := req.PostFormValue("queueName")
 	body := req.PostFormValue("body")
-	delaySeconds := req.PostFormValue("delaySeconds") //延迟时间
+	delaySeconds := req.PostFormValue("delaySeconds")       //延迟时间
+	messageGroupId := req.PostFormValue("messageGroupId")   //消息组，只用于FIFO队列
+	deduplicationId := req.PostFormValue("deduplicationId") //去重ID，有效期内重复入列时返回之前的消息ID
+	priority := req.PostFormValue("priority")               //优先级0-9，数值大的先出列
+	attributes := req.PostFormValue("attributes")           //消息属性，json对象，如 {"contentType":"application/json"}
+	deliverAt := req.PostFormValue("deliverAt")             //到期时间，RFC3339或毫秒时间戳，如 2026-11-01T09:00:00+08:00
 
 	if queueName == "" || body == "" {
-		YumiQ.Write(res, PushResult{false, queueName, body, delaySeconds, "queueName and body must not be null"})
+		YumiQ.Write(res, PushResult{false, queueName, "", delaySeconds, deliverAt, priority, "queueName and body must not be null"})
 		return
 	}
 
-	if err := YumiQ.Push(queueName, body, delaySeconds); err != nil {
-		YumiQ.Write(res, PushResult{false, queueName, body, delaySeconds, err.Error()})
+	if id, err := YumiQ.Push(queueName, PushEntry{body, delaySeconds, messageGroupId, deduplicationId, priority, attributes, deliverAt, nil}); err != nil {
+		YumiQ.Write(res, PushResult{false, queueName, "", delaySeconds, deliverAt, priority, err.Error()})
 	} else {
-		YumiQ.Write(res, PushResult{true, queueName, body, delaySeconds, ""})
+		YumiQ.Write(res, PushResult{true, queueName, id, delaySeconds, deliverAt, priority, ""})
 	}
 }
 
+//批量入列，第N条消息的参数为 body.N、delaySeconds.N、messageGroupId.N、deduplicationId.N、priority.N、attributes.N 和 deliverAt.N，N从1开始连续编号
+func PushBatch(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	queueName := req.PostFormValue("queueName")
+
+	var entries []PushEntry
+	for i := 1; ; i++ {
+		index := strconv.Itoa(i)
+		if _, ok := req.PostForm["body."+index]; !ok {
+			break
+		}
+		entries = append(entries, PushEntry{req.PostFormValue("body." + index), req.PostFormValue("delaySeconds." + index),
+			req.PostFormValue("messageGroupId." + index), req.PostFormValue("deduplicationId." + index), req.PostFormValue("priority." + index),
+			req.PostFormValue("attributes." + index), req.PostFormValue("deliverAt." + index), nil})
+	}
+
+	if queueName == "" || len(entries) == 0 {
+		YumiQ.Write(res, PushBatchResult{false, queueName, nil, "queueName and body.1 must not be null"})
+		return
+	}
+
+	ids, errs, err := YumiQ.PushBatch(queueName, entries)
+	if err != nil {
+		YumiQ.Write(res, PushBatchResult{false, queueName, nil, err.Error()})
+		return
+	}
+
+	success := true
+	results := make([]PushBatchEntryResult, len(entries))
+	for i, entry := range entries {
+		if errs[i] != nil {
+			success = false
+			results[i] = PushBatchEntryResult{i + 1, false, "", entry.DelaySeconds, entry.DeliverAt, entry.Priority, errs[i].Error()}
+		} else {
+			results[i] = PushBatchEntryResult{i + 1, true, ids[i], entry.DelaySeconds, entry.DeliverAt, entry.Priority, ""}
+		}
+	}
+	YumiQ.Write(res, PushBatchResult{success, queueName, results, ""})
+}
+
 func Pop(res http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
 
@@ -681,37 +1198,41 @@ func Pop(res http.ResponseWriter, req *http.Request) {
 	waitSeconds := req.Form["waitSeconds"]
 
 	if len(waitSeconds) == 0 || len(queueName) == 0 {
-		YumiQ.Write(res, PopResult{false, "", "queueName or waitSeconds lose"})
+		YumiQ.Write(res, PopResult{false, Message{}, nil, "queueName or waitSeconds lose"})
 		return
 	}
 
 	second := toInt64(waitSeconds[0])
+	maxMessages := toInt64(req.Form.Get("maxMessages")) //一次最多取出条数，默认1条
 
-	body, err := YumiQ.Pop(queueName[0], int(second))
+	messages, err := YumiQ.Pop(queueName[0], int(second), int(maxMessages))
 
-	if err != nil {
-		YumiQ.Write(res, PopResult{false, "", "no news"})
+	//队列为空时仍返回no news，其他错误原样返回
+	if errorKind(err) == ErrEmpty {
+		YumiQ.Write(res, PopResult{false, Message{}, nil, "no news"})
+	} else if err != nil {
+		YumiQ.Write(res, PopResult{false, Message{}, nil, err.Error()})
 	} else {
-		YumiQ.Write(res, PopResult{true, body, ""})
+		YumiQ.Write(res, PopResult{true, messages[0], messages, ""})
 	}
 }
 
 func DelMessage(res http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
 	queueName := req.PostFormValue("queueName")
-	body := req.PostFormValue("body")
+	receiptHandle := req.PostFormValue("receiptHandle")
 
-	if queueName == "" || body == "" {
-		YumiQ.Write(res, DelResult{false, "", "queueName and body must not be null"})
+	if queueName == "" || receiptHandle == "" {
+		YumiQ.Write(res, DelResult{false, receiptHandle, "queueName and receiptHandle must not be null"})
 		return
 	}
 
-	err := YumiQ.Del(queueName, body)
+	err := YumiQ.Del(queueName, receiptHandle)
 	if err != nil {
-		YumiQ.Write(res, DelResult{false, "", err.Error()})
+		YumiQ.Write(res, DelResult{false, receiptHandle, err.Error()})
 
 	} else {
-		YumiQ.Write(res, DelResult{true, body, ""})
+		YumiQ.Write(res, DelResult{true, receiptHandle, ""})
 	}
 }
 
@@ -719,20 +1240,20 @@ func SetVisibilityTime(res http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
 
 	queueName := req.PostFormValue("queueName")
-	body := req.PostFormValue("body")
+	receiptHandle := req.PostFormValue("receiptHandle")
 	visibilityTime := req.PostFormValue("visibilityTime")
 
-	if queueName == "" || body == "" {
-		YumiQ.Write(res, SetVisibilityTimeResult{false, queueName, visibilityTime, "queueName and body must not be null"})
+	if queueName == "" || receiptHandle == "" {
+		YumiQ.Write(res, SetVisibilityTimeResult{false, queueName, receiptHandle, visibilityTime, "queueName and receiptHandle must not be null"})
 		return
 	}
 
 	visibilitySecond := toInt64(visibilityTime)
-	err := YumiQ.SetVisibilityTime(queueName, body, visibilitySecond)
+	err := YumiQ.SetVisibilityTime(queueName, receiptHandle, visibilitySecond)
 	if err != nil {
-		YumiQ.Write(res, SetVisibilityTimeResult{false, queueName, visibilityTime, err.Error()})
+		YumiQ.Write(res, SetVisibilityTimeResult{false, queueName, receiptHandle, visibilityTime, err.Error()})
 	} else {
-		YumiQ.Write(res, SetVisibilityTimeResult{true, queueName, visibilityTime, ""})
+		YumiQ.Write(res, SetVisibilityTimeResult{true, queueName, receiptHandle, visibilityTime, ""})
 	}
 }
 
@@ -752,3 +1273,132 @@ func DelQueue(res http.ResponseWriter, req *http.Request) {
 		YumiQ.Write(res, DelQueueResult{true, queueName, ""})
 	}
 }
+
+func Redrive(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	queueName := req.PostFormValue("queueName")     //死信队列
+	sourceQueue := req.PostFormValue("sourceQueue") //只移回来自该队列的消息，为空时全部移回
+	maxMessages := req.PostFormValue("maxMessages") //最多移回条数，为空时不限
+
+	if queueName == "" {
+		YumiQ.Write(res, RedriveResult{false, queueName, sourceQueue, 0, "queueName must not be null"})
+		return
+	}
+
+	count, err := YumiQ.Redrive(queueName, sourceQueue, toInt64(maxMessages))
+	if err != nil {
+		YumiQ.Write(res, RedriveResult{false, queueName, sourceQueue, count, err.Error()})
+	} else {
+		YumiQ.Write(res, RedriveResult{true, queueName, sourceQueue, count, ""})
+	}
+}
+
+//清空队列中的消息，保留队列
+func PurgeQueue(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	queueName := req.PostFormValue("queueName")
+
+	if queueName == "" {
+		YumiQ.Write(res, PurgeQueueResult{false, queueName, "queueName must not be null"})
+		return
+	}
+
+	if err := YumiQ.Purge(queueName); err != nil {
+		YumiQ.Write(res, PurgeQueueResult{false, queueName, err.Error()})
+	} else {
+		YumiQ.Write(res, PurgeQueueResult{true, queueName, ""})
+	}
+}
+
+//查看准备队列中的消息，返回的消息没有回执
+func Peek(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	queueName := req.Form.Get("queueName")
+	maxMessages := toInt64(req.Form.Get("maxMessages")) //最多条数，默认MaxBatch条
+
+	if queueName == "" {
+		YumiQ.Write(res, PeekResult{false, nil, "queueName must not be null"})
+		return
+	}
+
+	messages, err := YumiQ.Peek(queueName, int(maxMessages))
+	if err != nil {
+		YumiQ.Write(res, PeekResult{false, nil, err.Error()})
+	} else {
+		if messages == nil {
+			messages = []Message{}
+		}
+		YumiQ.Write(res, PeekResult{true, messages, ""})
+	}
+}
+
+//查看尚未到期的延迟消息
+func ListScheduled(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	queueName := req.Form.Get("queueName")
+	maxMessages := toInt64(req.Form.Get("maxMessages")) //最多条数，默认MaxBatch条
+
+	if queueName == "" {
+		YumiQ.Write(res, ScheduledResult{false, nil, "queueName must not be null"})
+		return
+	}
+
+	messages, err := YumiQ.Scheduled(queueName, int(maxMessages))
+	if err != nil {
+		YumiQ.Write(res, ScheduledResult{false, nil, err.Error()})
+	} else {
+		if messages == nil {
+			messages = []ScheduledMessage{}
+		}
+		YumiQ.Write(res, ScheduledResult{true, messages, ""})
+	}
+}
+
+//按消息ID取消尚未到期的延迟消息
+func CancelScheduled(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	queueName := req.PostFormValue("queueName")
+	messageId := req.PostFormValue("messageId")
+
+	if queueName == "" || messageId == "" {
+		YumiQ.Write(res, CancelScheduledResult{false, queueName, messageId, "queueName and messageId must not be null"})
+		return
+	}
+
+	if err := YumiQ.CancelScheduled(queueName, messageId); err != nil {
+		YumiQ.Write(res, CancelScheduledResult{false, queueName, messageId, err.Error()})
+	} else {
+		YumiQ.Write(res, CancelScheduledResult{true, queueName, messageId, ""})
+	}
+}
+
+func GetQueueAttributes(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	queueName := req.Form.Get("queueName")
+
+	if queueName == "" {
+		YumiQ.Write(res, QueueAttributesResult{false, QueueAttributes{QueueName: queueName}, "queueName must not be null"})
+		return
+	}
+
+	attributes, err := YumiQ.GetQueueAttributes(queueName)
+	if err != nil {
+		YumiQ.Write(res, QueueAttributesResult{false, QueueAttributes{QueueName: queueName}, err.Error()})
+	} else {
+		YumiQ.Write(res, QueueAttributesResult{true, attributes, ""})
+	}
+}
+
+func ListQueues(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	prefix := req.Form.Get("prefix")                   //只列出以此开头的队列
+	nextToken := req.Form.Get("nextToken")             //上一页返回的nextToken，为空时从头开始
+	maxResults := toInt64(req.Form.Get("maxResults")) //每页条数，默认且最多1000
+
+	names, next, err := Queue.List(prefix, nextToken, int(maxResults))
+	if err != nil {
+		YumiQ.Write(res, ListQueuesResult{false, nil, "", err.Error()})
+	} else {
+		YumiQ.Write(res, ListQueuesResult{true, names, next, ""})
+	}
+}
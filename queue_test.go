@@ -0,0 +1,518 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//按表单请求原有的接口，返回解析后的json
+func queueTestCall(t *testing.T, path string, form map[string]string) map[string]interface{} {
+	values := url.Values{}
+	for k, v := range form {
+		values.Set(k, v)
+	}
+	req := httptest.NewRequest("POST", path, strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	(&WaitForYou{}).ServeHTTP(rec, req)
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("%s: %s", path, rec.Body.String())
+	}
+	return result
+}
+
+//相同消息体的消息各自入列，按回执删除，重复删除失败
+func TestPushSameBodyAndDelete(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "q"})
+
+		first, err := YumiQ.Push("q", PushEntry{Body: "same"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := YumiQ.Push("q", PushEntry{Body: "same"})
+		if err != nil || second == first {
+			t.Fatalf("push same body: %q %q %v", first, second, err)
+		}
+
+		messages, err := YumiQ.Pop("q", 0, 2)
+		if err != nil || len(messages) != 2 || messages[0].MessageId != first || messages[1].MessageId != second {
+			t.Fatalf("pop: %v %v", messages, err)
+		}
+		if messages[0].ReceiptHandle == messages[1].ReceiptHandle {
+			t.Fatalf("same receipt handle: %q", messages[0].ReceiptHandle)
+		}
+
+		if err := YumiQ.Del("q", messages[0].ReceiptHandle); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+		if err := YumiQ.Del("q", messages[0].ReceiptHandle); errorKind(err) != ErrNotFound {
+			t.Fatalf("delete twice: %v", err)
+		}
+		if err := YumiQ.SetVisibilityTime("q", messages[1].ReceiptHandle, 5); err != nil {
+			t.Fatalf("set visibility time: %v", err)
+		}
+		if stats, _ := Store.Stats("q"); stats.Ready != 0 || stats.InFlight != 1 {
+			t.Fatalf("stats: %+v", stats)
+		}
+	})
+}
+
+//队列为空时返回ErrEmpty，队列不存在时返回ErrNotFound
+func TestPopEmptyAndMissingQueue(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "q"})
+
+		if _, err := YumiQ.Pop("q", 0, 1); errorKind(err) != ErrEmpty {
+			t.Fatalf("pop empty queue: %v", err)
+		}
+		if _, err := YumiQ.Pop("missing", 0, 1); errorKind(err) != ErrNotFound {
+			t.Fatalf("pop missing queue: %v", err)
+		}
+		if _, err := YumiQ.Push("missing", PushEntry{Body: "hello"}); errorKind(err) != ErrNotFound {
+			t.Fatalf("push to missing queue: %v", err)
+		}
+	})
+}
+
+//准备队列为空时阻塞到有消息入列，超时返回ErrEmpty
+func TestPopWaitsForMessage(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "q"})
+
+		start := time.Now()
+		if _, err := YumiQ.Pop("q", 1, 1); errorKind(err) != ErrEmpty || time.Since(start) < time.Second {
+			t.Fatalf("pop empty queue returned after %s: %v", time.Since(start), err)
+		}
+
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			YumiQ.Push("q", PushEntry{Body: "hello"})
+		}()
+		start = time.Now()
+		messages, err := YumiQ.Pop("q", 5, 1)
+		if err != nil || len(messages) != 1 || messages[0].Body != "hello" {
+			t.Fatalf("pop: %v %v", messages, err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Fatalf("pop woke after %s", elapsed)
+		}
+	})
+}
+
+//并发出列时每条消息只被取出一次
+func TestConcurrentPopsDistinct(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "q"})
+		for i := 0; i < 50; i++ {
+			if _, err := YumiQ.Push("q", PushEntry{Body: toString(int64(i))}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var mu sync.Mutex
+		seen := make(map[string]bool)
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					messages, err := YumiQ.Pop("q", 0, 3)
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					for _, message := range messages {
+						if seen[message.MessageId] {
+							t.Errorf("message %s popped twice", message.MessageId)
+						}
+						seen[message.MessageId] = true
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if len(seen) != 50 {
+			t.Fatalf("popped %d messages, want 50", len(seen))
+		}
+		if stats, _ := Store.Stats("q"); stats.Ready != 0 || stats.InFlight != 50 {
+			t.Fatalf("stats: %+v", stats)
+		}
+	})
+}
+
+//接收次数超过MaxReceiveCount的消息移入死信队列，redrive后回到来源队列
+func TestDeadLetterQueue(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "dlq"})
+		if err := YumiQ.Create(OptionQueue{QueueName: "src", VisibilityTimeout: "30", DeadLetterQueue: "missing", MaxReceiveCount: "2"}); errorKind(err) != ErrInvalid {
+			t.Fatalf("create with missing dead letter queue: %v", err)
+		}
+		storageTestQueue(t, OptionQueue{QueueName: "src", DeadLetterQueue: "dlq", MaxReceiveCount: "2"})
+
+		id, _ := YumiQ.Push("src", PushEntry{Body: "poison"})
+		for i := 0; i < 2; i++ {
+			if messages, err := YumiQ.Pop("src", 0, 1); err != nil || len(messages) != 1 || messages[0].MessageId != id {
+				t.Fatalf("pop %d: %v %v", i, messages, err)
+			}
+			Store.Promote("src", theMoment()+60, PromoteBatch) //隐藏到期
+		}
+		if _, err := YumiQ.Pop("src", 0, 1); errorKind(err) != ErrEmpty {
+			t.Fatalf("pop after max receives: %v", err)
+		}
+		messages, err := YumiQ.Peek("dlq", 0)
+		if err != nil || len(messages) != 1 || messages[0].MessageId != id || messages[0].Body != "poison" {
+			t.Fatalf("dead letter queue: %v %v", messages, err)
+		}
+
+		if _, err := YumiQ.Redrive("dlq", "missing", 0); errorKind(err) != ErrNotFound {
+			t.Fatalf("redrive to missing queue: %v", err)
+		}
+		if count, err := YumiQ.Redrive("dlq", "src", 0); err != nil || count != 1 {
+			t.Fatalf("redrive: %d %v", count, err)
+		}
+		messages, err = YumiQ.Pop("src", 0, 1)
+		if err != nil || len(messages) != 1 || messages[0].MessageId != id {
+			t.Fatalf("pop redriven message: %v %v", messages, err)
+		}
+	})
+}
+
+//来源队列已删除的死信消息保留在死信队列中
+func TestRedriveKeepsDeletedSource(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "dlq"})
+		for _, name := range []string{"a", "b"} {
+			storageTestQueue(t, OptionQueue{QueueName: name, DeadLetterQueue: "dlq", MaxReceiveCount: "1"})
+			YumiQ.Push(name, PushEntry{Body: name})
+			YumiQ.Pop(name, 0, 1)
+			Store.Promote(name, theMoment()+60, PromoteBatch)
+			YumiQ.Pop(name, 0, 1)
+		}
+		if stats, _ := Store.Stats("dlq"); stats.Ready != 2 {
+			t.Fatalf("dead letter queue stats: %+v", stats)
+		}
+
+		if err := YumiQ.DelQueue("a"); err != nil {
+			t.Fatal(err)
+		}
+		if count, err := YumiQ.Redrive("dlq", "", 0); err != nil || count != 1 {
+			t.Fatalf("redrive: %d %v", count, err)
+		}
+		messages, _ := YumiQ.Peek("dlq", 0)
+		if len(messages) != 1 || messages[0].Body != "a" {
+			t.Fatalf("dead letter queue after redrive: %v", messages)
+		}
+	})
+}
+
+//批量入列时单条消息的错误不影响其他消息，出列最多MaxBatch条
+func TestPushBatchAndPopBatch(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "q"})
+
+		result := queueTestCall(t, "/pushBatch", map[string]string{"queueName": "q",
+			"body.1": "a", "body.2": "", "body.3": "c", "delaySeconds.3": "100", "body.4": "d", "priority.4": "10", "body.5": "e"})
+		entries, _ := result["entries"].([]interface{})
+		if result["success"] != false || len(entries) != 5 {
+			t.Fatalf("push batch: %v", result)
+		}
+		for i, want := range []bool{true, false, true, false, true} {
+			if entry := entries[i].(map[string]interface{}); entry["success"] != want || entry["index"] != float64(i+1) {
+				t.Fatalf("entry %d: %v", i+1, entry)
+			}
+		}
+
+		result = queueTestCall(t, "/pop", map[string]string{"queueName": "q", "waitSeconds": "0", "maxMessages": "5"})
+		messages, _ := result["messages"].([]interface{})
+		if result["success"] != true || len(messages) != 2 || result["body"] != "a" || messages[1].(map[string]interface{})["body"] != "e" {
+			t.Fatalf("pop batch: %v", result)
+		}
+
+		if result = queueTestCall(t, "/pop", map[string]string{"queueName": "q", "waitSeconds": "0", "maxMessages": "11"}); result["success"] != false {
+			t.Fatalf("pop more than MaxBatch: %v", result)
+		}
+		var batch []PushEntry
+		for i := 0; i <= MaxBatch; i++ {
+			batch = append(batch, PushEntry{Body: "x"})
+		}
+		if _, _, err := YumiQ.PushBatch("q", batch); errorKind(err) != ErrInvalid {
+			t.Fatalf("push more than MaxBatch: %v", err)
+		}
+	})
+}
+
+//其他实例创建的队列不等刷新即可使用，删除的队列刷新后从缓存中移除
+func TestQueuesCacheAcrossInstances(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		other := NewQueues()
+		storageTestQueue(t, OptionQueue{QueueName: "q"})
+
+		if opt, ok := other.Get("q"); !ok || opt.VisibilityTimeout != "30" {
+			t.Fatalf("queue created by another instance: %+v %v", opt, ok)
+		}
+		if err := YumiQ.DelQueue("q"); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.refresh(); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := other.Get("q"); ok {
+			t.Fatal("deleted queue still cached")
+		}
+		if names, _ := other.GetAllQueuesInfoByCache(); len(names) != 0 {
+			t.Fatalf("cached queue names: %v", names)
+		}
+	})
+}
+
+//队列属性包括配置和各状态的消息数，列出队列按名称分页
+func TestQueueAttributesAndList(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "dlq"})
+		storageTestQueue(t, OptionQueue{QueueName: "q", DeadLetterQueue: "dlq", MaxReceiveCount: "3"})
+		storageTestQueue(t, OptionQueue{QueueName: "other"})
+		YumiQ.Push("q", PushEntry{Body: "a"})
+		YumiQ.Push("q", PushEntry{Body: "b"})
+		YumiQ.Push("q", PushEntry{Body: "c", DelaySeconds: "100"})
+		YumiQ.Pop("q", 0, 1)
+
+		result := queueTestCall(t, "/getQueueAttributes", map[string]string{"queueName": "q"})
+		if result["success"] != true || result["readyMessages"] != float64(1) || result["delayedMessages"] != float64(1) || result["inFlightMessages"] != float64(1) {
+			t.Fatalf("attributes: %v", result)
+		}
+		if result["deadLetterQueue"] != "dlq" || result["maxReceiveCount"] != "3" || result["createdTimestamp"] == "" {
+			t.Fatalf("attributes: %v", result)
+		}
+		if result = queueTestCall(t, "/getQueueAttributes", map[string]string{"queueName": "missing"}); result["success"] != false {
+			t.Fatalf("attributes of missing queue: %v", result)
+		}
+
+		result = queueTestCall(t, "/listQueues", map[string]string{"maxResults": "2"})
+		if names, _ := result["queueNames"].([]interface{}); len(names) != 2 || names[0] != "dlq" || result["nextToken"] != "other" {
+			t.Fatalf("list first page: %v", result)
+		}
+		result = queueTestCall(t, "/listQueues", map[string]string{"maxResults": "2", "nextToken": "other"})
+		if names, _ := result["queueNames"].([]interface{}); len(names) != 1 || names[0] != "q" || result["nextToken"] != "" {
+			t.Fatalf("list second page: %v", result)
+		}
+		result = queueTestCall(t, "/listQueues", map[string]string{"prefix": "d"})
+		if names, _ := result["queueNames"].([]interface{}); len(names) != 1 || names[0] != "dlq" {
+			t.Fatalf("list with prefix: %v", result)
+		}
+	})
+}
+
+//FIFO队列同一消息组每次只投递一条，前一条删除或移入死信队列后才投递下一条
+func TestFifoGroups(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "dlq"})
+		storageTestQueue(t, OptionQueue{QueueName: "f", FifoQueue: "true", DeadLetterQueue: "dlq", MaxReceiveCount: "1"})
+		if _, err := YumiQ.Push("f", PushEntry{Body: "x"}); errorKind(err) != ErrInvalid {
+			t.Fatalf("push without message group: %v", err)
+		}
+		if _, err := YumiQ.Push("dlq", PushEntry{Body: "x", MessageGroupId: "g"}); errorKind(err) != ErrInvalid {
+			t.Fatalf("push message group to standard queue: %v", err)
+		}
+		for _, entry := range []PushEntry{{Body: "a1", MessageGroupId: "g1"}, {Body: "a2", MessageGroupId: "g1"}, {Body: "b1", MessageGroupId: "g2"}, {Body: "a3", MessageGroupId: "g1"}} {
+			if _, err := YumiQ.Push("f", entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		messages, err := YumiQ.Pop("f", 0, 10)
+		if err != nil || len(messages) != 2 || messages[0].Body != "a1" || messages[1].Body != "b1" {
+			t.Fatalf("pop one message per group: %v %v", messages, err)
+		}
+		if _, err := YumiQ.Pop("f", 0, 10); errorKind(err) != ErrEmpty {
+			t.Fatalf("pop with every group in flight: %v", err)
+		}
+
+		//a1隐藏到期后超过最大接收次数移入死信队列，g1的下一条可以出列
+		YumiQ.Del("f", messages[1].ReceiptHandle)
+		Store.Promote("f", theMoment()+60, PromoteBatch)
+		next, err := YumiQ.Pop("f", 0, 10)
+		if err != nil || len(next) != 1 || next[0].Body != "a2" {
+			t.Fatalf("pop after dead letter: %v %v", next, err)
+		}
+		if dead, _ := YumiQ.Peek("dlq", 0); len(dead) != 1 || dead[0].Body != "a1" {
+			t.Fatalf("dead letter queue: %v", dead)
+		}
+		YumiQ.Del("f", next[0].ReceiptHandle)
+		next, err = YumiQ.Pop("f", 0, 10)
+		if err != nil || len(next) != 1 || next[0].Body != "a3" {
+			t.Fatalf("pop after delete: %v %v", next, err)
+		}
+
+		if err := YumiQ.Update(OptionQueue{QueueName: "f", VisibilityTimeout: "30", FifoQueue: "false"}); errorKind(err) != ErrInvalid {
+			t.Fatalf("change fifo: %v", err)
+		}
+		if err := YumiQ.Update(OptionQueue{QueueName: "f", VisibilityTimeout: "20", DeadLetterQueue: "dlq", MaxReceiveCount: "1"}); err != nil {
+			t.Fatalf("update fifo queue: %v", err)
+		}
+		if opt, _ := Queue.Get("f"); !opt.Fifo() {
+			t.Fatal("fifo queue became standard after update")
+		}
+	})
+}
+
+//按消息体去重的队列相同消息体只入列一次，指定去重ID时按去重ID
+func TestContentBasedDedup(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "c", ContentBasedDedup: "true"})
+		storageTestQueue(t, OptionQueue{QueueName: "s"})
+
+		first, _ := YumiQ.Push("c", PushEntry{Body: "same"})
+		if second, _ := YumiQ.Push("c", PushEntry{Body: "same"}); second != first {
+			t.Fatalf("same body: %q %q", first, second)
+		}
+		if other, _ := YumiQ.Push("c", PushEntry{Body: "same", DeduplicationId: "other"}); other == first {
+			t.Fatal("deduplicationId ignored")
+		}
+		ids, _, err := YumiQ.PushBatch("c", []PushEntry{{Body: "n"}, {Body: "n"}})
+		if err != nil || ids[0] != ids[1] {
+			t.Fatalf("same body in batch: %v %v", ids, err)
+		}
+		if stats, _ := Store.Stats("c"); stats.Ready != 3 {
+			t.Fatalf("stats: %+v", stats)
+		}
+
+		//普通队列只按去重ID去重
+		a, _ := YumiQ.Push("s", PushEntry{Body: "x", DeduplicationId: "k"})
+		b, _ := YumiQ.Push("s", PushEntry{Body: "y", DeduplicationId: "k"})
+		c, _ := YumiQ.Push("s", PushEntry{Body: "x"})
+		if a != b || c == a {
+			t.Fatalf("standard queue: %q %q %q", a, b, c)
+		}
+
+		result := queueTestCall(t, "/getQueueAttributes", map[string]string{"queueName": "c"})
+		if result["contentBasedDeduplication"] != "true" {
+			t.Fatalf("attributes: %v", result)
+		}
+	})
+}
+
+//优先级只能是0-9，FIFO队列不支持优先级
+func TestPushPriorityValidation(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "p"})
+		storageTestQueue(t, OptionQueue{QueueName: "f", FifoQueue: "true"})
+		for _, priority := range []string{"-1", "10", "high"} {
+			if _, err := YumiQ.Push("p", PushEntry{Body: "x", Priority: priority}); errorKind(err) != ErrInvalid {
+				t.Fatalf("priority %s: %v", priority, err)
+			}
+		}
+		if _, err := YumiQ.Push("f", PushEntry{Body: "x", MessageGroupId: "g", Priority: "1"}); errorKind(err) != ErrInvalid {
+			t.Fatalf("priority on fifo queue: %v", err)
+		}
+		if err := YumiQ.Create(OptionQueue{QueueName: "a", VisibilityTimeout: "30", PriorityAging: "-1"}); errorKind(err) != ErrInvalid {
+			t.Fatalf("negative priority aging: %v", err)
+		}
+		if _, err := YumiQ.Push("p", PushEntry{Body: "x", Priority: "9"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+//消息属性必须是值为字符串的json对象，名称不能为空或使用保留名称
+func TestPushAttributesValidation(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "q"})
+		tooMany := "{"
+		for i := 0; i <= maxMessageAttributes; i++ {
+			if i > 0 {
+				tooMany += ","
+			}
+			tooMany += `"a` + toString(int64(i)) + `":"v"`
+		}
+		tooMany += "}"
+		for _, attributes := range []string{"[1]", `{"a":1}`, `{"":"a"}`, `{"` + attributeTypesName + `":"{}"}`, tooMany} {
+			if _, err := YumiQ.Push("q", PushEntry{Body: "x", Attributes: attributes}); errorKind(err) != ErrInvalid {
+				t.Fatalf("attributes %s: %v", attributes, err)
+			}
+		}
+
+		result := queueTestCall(t, "/push", map[string]string{"queueName": "q", "body": "x", "attributes": `{"traceId":"t1"}`})
+		if result["success"] != true {
+			t.Fatalf("push: %v", result)
+		}
+		result = queueTestCall(t, "/pop", map[string]string{"queueName": "q", "waitSeconds": "0"})
+		if attributes, _ := result["attributes"].(map[string]interface{}); attributes["traceId"] != "t1" || result["sentTimestamp"] == float64(0) {
+			t.Fatalf("pop: %v", result)
+		}
+	})
+}
+
+//到期时间为RFC3339或毫秒时间戳，不足一秒向上取整，不能晚于消息保留时间，不能与延时同时指定
+func TestPushDeliverAt(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "q", MessageRetentionPeriod: "3600", DelaySeconds: "60"})
+		storageTestQueue(t, OptionQueue{QueueName: "f", FifoQueue: "true"})
+		now := time.Now()
+		at := now.Add(600 * time.Second).Truncate(time.Second)
+		millis := now.Add(300*time.Second).UnixNano()/1e6 + 1
+
+		r := queueTestCall(t, "/push", map[string]string{"queueName": "q", "body": "a", "deliverAt": at.Format(time.RFC3339)})
+		if r["success"] != true || r["deliverAt"] != at.Format(time.RFC3339) {
+			t.Fatalf("push with RFC3339: %v", r)
+		}
+		idB, err := YumiQ.Push("q", PushEntry{Body: "b", DeliverAt: strconv.FormatInt(millis, 10)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		//已过去的到期时间直接进入准备队列，不使用队列的延时
+		if _, err := YumiQ.Push("q", PushEntry{Body: "c", DeliverAt: now.Add(-time.Hour).Format(time.RFC3339)}); err != nil {
+			t.Fatal(err)
+		}
+		if stats, _ := Store.Stats("q"); stats.Ready != 1 || stats.Delayed != 2 {
+			t.Fatalf("stats: %+v", stats)
+		}
+
+		for _, entry := range []PushEntry{
+			{Body: "x", DelaySeconds: "5", DeliverAt: at.Format(time.RFC3339)},
+			{Body: "x", DeliverAt: now.Add(2 * time.Hour).Format(time.RFC3339)},
+			{Body: "x", DeliverAt: "tomorrow"},
+			{Body: "x", DeliverAt: strconv.FormatInt(now.Unix()+60, 10)}, //秒级时间戳
+		} {
+			if _, err := YumiQ.Push("q", entry); errorKind(err) != ErrInvalid {
+				t.Fatalf("push %+v: %v", entry, err)
+			}
+		}
+		if _, err := YumiQ.Push("f", PushEntry{Body: "x", MessageGroupId: "g", DeliverAt: at.Format(time.RFC3339)}); errorKind(err) != ErrInvalid {
+			t.Fatalf("deliverAt on fifo queue: %v", err)
+		}
+
+		scheduled, err := YumiQ.Scheduled("q", 0)
+		if err != nil || len(scheduled) != 2 || scheduled[0].MessageId != idB || scheduled[0].DeliverTimestamp != (millis+999)/1000 ||
+			scheduled[1].DeliverTimestamp != at.Unix() {
+			t.Fatalf("scheduled: %v %v", scheduled, err)
+		}
+		if _, err := YumiQ.Scheduled("q", maxPeekMessages+1); errorKind(err) != ErrInvalid {
+			t.Fatalf("scheduled too many: %v", err)
+		}
+		if _, err := YumiQ.Scheduled("missing", 0); errorKind(err) != ErrNotFound {
+			t.Fatalf("scheduled of missing queue: %v", err)
+		}
+
+		if r := queueTestCall(t, "/cancelScheduled", map[string]string{"queueName": "q", "messageId": idB}); r["success"] != true {
+			t.Fatalf("cancel: %v", r)
+		}
+		if err := YumiQ.CancelScheduled("q", idB); errorKind(err) != ErrNotFound {
+			t.Fatalf("cancel twice: %v", err)
+		}
+		r = queueTestCall(t, "/listScheduled", map[string]string{"queueName": "q"})
+		if messages, _ := r["messages"].([]interface{}); r["success"] != true || len(messages) != 1 {
+			t.Fatalf("list scheduled: %v", r)
+		}
+	})
+}
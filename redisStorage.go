@@ -0,0 +1,649 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gomodule/redigo/redis"
+	"strconv"
+	"time"
+)
+
+const redriveBatch = 100 //死信消息移回时每批检查的条数
+
+//redis存储
+type RedisStorage struct {
+	Pool *redis.Pool
+}
+
+func NewRedisStorage(pool *redis.Pool) *RedisStorage {
+	return &RedisStorage{pool}
+}
+
+//队列配置hash
+func (this *RedisStorage) OptionTable(queueName string) string {
+	return "configureQueue_" + queueName
+}
+
+//准备队列
+func (this *RedisStorage) ReadyTable(queueName string) string {
+	return "readyQueue_" + queueName
+}
+
+//优先级大于0的消息所在的准备队列，与脚本中的readyKey一致
+func (this *RedisStorage) PriorityReadyTable(queueName string, priority int) string {
+	return this.ReadyTable(queueName) + ":" + strconv.Itoa(priority)
+}
+
+//消息进入准备队列的信号，与脚本中的signalReady一致
+func (this *RedisStorage) ReadySignalTable(queueName string) string {
+	return this.ReadyTable(queueName) + ":signal"
+}
+
+//延迟队列，隐藏中的消息也在其中
+func (this *RedisStorage) DelayTable(queueName string) string {
+	return "delayQueue_" + queueName
+}
+
+//消息体
+func (this *RedisStorage) MessageTable(queueName string) string {
+	return "messageQueue_" + queueName
+}
+
+//最近一次接收的回执
+func (this *RedisStorage) ReceiptTable(queueName string) string {
+	return "receiptQueue_" + queueName
+}
+
+func (this *RedisStorage) ReceiveCountTable(queueName string) string {
+	return "receiveCountQueue_" + queueName
+}
+
+//死信消息的来源队列
+func (this *RedisStorage) SourceTable(queueName string) string {
+	return "deadLetterSourceQueue_" + queueName
+}
+
+//消息的入列时间
+func (this *RedisStorage) SentTimeTable(queueName string) string {
+	return "sentTimeQueue_" + queueName
+}
+
+//消息所属的消息组
+func (this *RedisStorage) MessageGroupTable(queueName string) string {
+	return "messageGroupQueue_" + queueName
+}
+
+//消息组，按入列顺序存放组内的消息ID，groupId为空时为所有消息组的前缀
+func (this *RedisStorage) GroupTable(queueName string, groupId string) string {
+	return "groupQueue_" + queueName + ":" + groupId
+}
+
+//有消息的消息组，统计时用组内消息数减去组数得到排在后面的消息数
+func (this *RedisStorage) GroupSetTable(queueName string) string {
+	return "groupSetQueue_" + queueName
+}
+
+//延迟队列中隐藏中的消息，统计时不需要遍历延迟队列
+func (this *RedisStorage) InFlightTable(queueName string) string {
+	return "inFlightQueue_" + queueName
+}
+
+//优先级大于0的消息的优先级
+func (this *RedisStorage) PriorityTable(queueName string) string {
+	return "priorityQueue_" + queueName
+}
+
+//消息属性，值为json
+func (this *RedisStorage) AttributeTable(queueName string) string {
+	return "attributeQueue_" + queueName
+}
+
+//消息首次被接收的时间
+func (this *RedisStorage) FirstReceiveTable(queueName string) string {
+	return "firstReceiveQueue_" + queueName
+}
+
+//去重ID对应的消息ID
+func (this *RedisStorage) DedupTable(queueName string) string {
+	return "dedupQueue_" + queueName
+}
+
+//去重ID的截止时间
+func (this *RedisStorage) DedupTimeTable(queueName string) string {
+	return "dedupTimeQueue_" + queueName
+}
+
+//主题的订阅hash，字段为队列名，值为订阅配置
+func (this *RedisStorage) SubscriptionTable(topicName string) string {
+	return "topicSubscriptions_" + topicName
+}
+
+//订阅过滤掉的消息数，hash的键为订阅的队列名
+func (this *RedisStorage) FilteredTable(topicName string) string {
+	return "topicFiltered_" + topicName
+}
+
+func (this *RedisStorage) SaveOptions(queueName string, opt map[string]string) (err error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	_, err = rdg.Do("HMSET", redis.Args{}.Add(this.OptionTable(queueName)).AddFlat(opt)...)
+	return
+}
+
+func (this *RedisStorage) GetOptions(queueName string) (map[string]string, error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	return redis.StringMap(rdg.Do("HGETALL", this.OptionTable(queueName)))
+}
+
+func (this *RedisStorage) OptionsExist(queueName string) (bool, error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	return redis.Bool(rdg.Do("EXISTS", this.OptionTable(queueName)))
+}
+
+func (this *RedisStorage) DelOptions(queueName string) (err error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	_, err = rdg.Do("DEL", this.OptionTable(queueName))
+	return
+}
+
+func (this *RedisStorage) AddQueueName(queueName string) (err error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	_, err = rdg.Do("SADD", OptQueueNames, queueName)
+	return
+}
+
+func (this *RedisStorage) DelQueueName(queueName string) (err error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	_, err = rdg.Do("SREM", OptQueueNames, queueName)
+	return
+}
+
+func (this *RedisStorage) ExistsQueueName(queueName string) (bool, error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	return redis.Bool(rdg.Do("SISMEMBER", OptQueueNames, queueName))
+}
+
+func (this *RedisStorage) QueueNames() ([]string, error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	return redis.Strings(rdg.Do("SMEMBERS", OptQueueNames))
+}
+
+func (this *RedisStorage) AddTopicName(topicName string) (err error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	_, err = rdg.Do("SADD", OptTopicNames, topicName)
+	return
+}
+
+func (this *RedisStorage) DelTopicName(topicName string) (err error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	rdg.Send("MULTI")
+	rdg.Send("SREM", OptTopicNames, topicName)
+	rdg.Send("DEL", this.SubscriptionTable(topicName))
+	rdg.Send("DEL", this.FilteredTable(topicName))
+	_, err = rdg.Do("EXEC")
+	return
+}
+
+func (this *RedisStorage) ExistsTopicName(topicName string) (bool, error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	return redis.Bool(rdg.Do("SISMEMBER", OptTopicNames, topicName))
+}
+
+func (this *RedisStorage) TopicNames() ([]string, error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	return redis.Strings(rdg.Do("SMEMBERS", OptTopicNames))
+}
+
+func (this *RedisStorage) SaveSubscription(topicName string, queueName string, subscription string) (err error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	_, err = rdg.Do("HSET", this.SubscriptionTable(topicName), queueName, subscription)
+	return
+}
+
+func (this *RedisStorage) DelSubscription(topicName string, queueName string) (err error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	rdg.Send("MULTI")
+	rdg.Send("HDEL", this.SubscriptionTable(topicName), queueName)
+	rdg.Send("HDEL", this.FilteredTable(topicName), queueName)
+	_, err = rdg.Do("EXEC")
+	return
+}
+
+func (this *RedisStorage) Subscriptions(topicName string) (map[string]string, error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	return redis.StringMap(rdg.Do("HGETALL", this.SubscriptionTable(topicName)))
+}
+
+func (this *RedisStorage) AddFiltered(topicName string, queueNames []string) (err error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	for _, queueName := range queueNames {
+		rdg.Send("HINCRBY", this.FilteredTable(topicName), queueName, 1)
+	}
+	if err = rdg.Flush(); err != nil {
+		return
+	}
+	for range queueNames {
+		if _, err = rdg.Receive(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (this *RedisStorage) FilteredCounts(topicName string) (map[string]int64, error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	return redis.Int64Map(rdg.Do("HGETALL", this.FilteredTable(topicName)))
+}
+
+//入列脚本的参数
+func (this *RedisStorage) pushArgs(queueName string, messages []NewMessage) (redis.Args, error) {
+	args := redis.Args{}.Add(this.ReadyTable(queueName), this.DelayTable(queueName), this.MessageTable(queueName),
+		this.SentTimeTable(queueName), this.MessageGroupTable(queueName), this.DedupTable(queueName),
+		this.DedupTimeTable(queueName), OptDueIndex, this.PriorityTable(queueName), this.AttributeTable(queueName),
+		this.GroupSetTable(queueName), queueName, this.GroupTable(queueName, ""))
+	for _, message := range messages {
+		var attributes []byte
+		if len(message.Attributes) != 0 {
+			var err error
+			if attributes, err = json.Marshal(message.Attributes); err != nil {
+				return nil, err
+			}
+		}
+		args = args.Add(message.Id, message.Body, message.DeliverAt, message.SentAt, message.GroupId, message.DeduplicationId,
+			message.DedupUntil, message.Priority, attributes)
+	}
+	return args, nil
+}
+
+//整批消息在一个脚本中写入
+func (this *RedisStorage) Push(queueName string, messages []NewMessage) ([]string, error) {
+	if len(messages) == 0 {
+		return nil, nil
+	}
+
+	args, err := this.pushArgs(queueName, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	return redis.Strings(pushScript.Do(rdg, args...))
+}
+
+//每个队列一个入列脚本，放在同一个事务中执行
+func (this *RedisStorage) PushMulti(messages map[string][]NewMessage) (map[string][]string, error) {
+	var queueNames []string
+	var batch []redis.Args
+	for queueName, queueMessages := range messages {
+		if len(queueMessages) == 0 {
+			continue
+		}
+		args, err := this.pushArgs(queueName, queueMessages)
+		if err != nil {
+			return nil, err
+		}
+		queueNames = append(queueNames, queueName)
+		batch = append(batch, args)
+	}
+	if len(batch) == 0 {
+		return map[string][]string{}, nil
+	}
+
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	//事务中不能按SHA执行，脚本首次使用时可能还未加载
+	if err := pushScript.Load(rdg); err != nil {
+		return nil, err
+	}
+	rdg.Send("MULTI")
+	for _, args := range batch {
+		if err := pushScript.SendHash(rdg, args...); err != nil {
+			return nil, err
+		}
+	}
+	values, err := redis.Values(rdg.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string][]string, len(queueNames))
+	for i, queueName := range queueNames {
+		if ids[queueName], err = redis.Strings(values[i], nil); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+func (this *RedisStorage) Pop(queueName string, opt PopOption) (messages []Message, err error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	dlq := opt.DeadLetterQueue
+	values, err := redis.Strings(popScript.Do(rdg, this.ReadyTable(queueName), this.DelayTable(queueName),
+		this.MessageTable(queueName), this.ReceiptTable(queueName), this.ReceiveCountTable(queueName),
+		this.ReadyTable(dlq), this.MessageTable(dlq), this.SourceTable(dlq), OptDueIndex,
+		this.SentTimeTable(queueName), this.SentTimeTable(dlq), this.MessageGroupTable(queueName), this.PriorityTable(queueName),
+		this.AttributeTable(queueName), this.AttributeTable(dlq), this.FirstReceiveTable(queueName),
+		this.GroupSetTable(queueName), this.InFlightTable(queueName), opt.Deadline, opt.Token, opt.MaxReceiveCount, queueName, opt.MaxMessages, this.GroupTable(queueName, ""),
+		opt.Now, opt.PriorityAging))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i+6 < len(values); i += 7 {
+		message := Message{MessageId: values[i], ReceiptHandle: values[i+1], Body: values[i+2], SentTimestamp: toInt64(values[i+4]),
+			ApproximateFirstReceiveTimestamp: toInt64(values[i+5]), ApproximateReceiveCount: toInt64(values[i+6])}
+		if values[i+3] != "" {
+			if err = json.Unmarshal([]byte(values[i+3]), &message.Attributes); err != nil {
+				return nil, err
+			}
+		}
+		messages = append(messages, message)
+	}
+	return
+}
+
+//阻塞读取准备队列的信号，redis的BRPOP超时按秒计，timeout向上取整
+func (this *RedisStorage) Wait(ctx context.Context, queueName string, timeout time.Duration) error {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	//BRPOP的超时为0时一直阻塞
+	seconds := int64((timeout + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	_, err := redis.DoContext(rdg, ctx, "BRPOP", this.ReadySignalTable(queueName), seconds)
+	if err == nil {
+		return nil
+	} else if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	//读超时按ctx的截止时间设置，可能先于ctx结束返回
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+func (this *RedisStorage) CheckReceipt(queueName string, receiptHandle string) (id string, err error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	if id = receiptMessageID(receiptHandle); id == "" {
+		return "", newError(ErrInvalid, "receipt handle is invalid")
+	}
+
+	current, err := redis.String(rdg.Do("HGET", this.ReceiptTable(queueName), id))
+	if err != nil && err != redis.ErrNil {
+		return "", err
+	}
+	if err == redis.ErrNil || current != receiptHandle {
+		return "", newError(ErrNotFound, "receipt handle has expired")
+	}
+	return
+}
+
+func (this *RedisStorage) Delete(queueName string, id string) (err error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	_, err = this.deleteMessages(rdg, queueName, true, 0, []string{id})
+	return
+}
+
+//删除消息的全部数据并移出消息组，fromReady为false时只删除仍在延迟队列中且到期时间不晚于before的消息
+func (this *RedisStorage) deleteMessages(rdg redis.Conn, queueName string, fromReady bool, before int64, ids []string) (int, error) {
+	args := redis.Args{}.Add(this.ReadyTable(queueName), this.DelayTable(queueName), this.MessageTable(queueName),
+		this.ReceiptTable(queueName), this.ReceiveCountTable(queueName), this.SourceTable(queueName),
+		this.SentTimeTable(queueName), this.MessageGroupTable(queueName), this.PriorityTable(queueName),
+		this.AttributeTable(queueName), this.FirstReceiveTable(queueName), this.GroupSetTable(queueName), this.InFlightTable(queueName),
+		this.GroupTable(queueName, ""), fromReady, before)
+	return redis.Int(deleteScript.Do(rdg, args.AddFlat(ids)...))
+}
+
+func (this *RedisStorage) ChangeVisibility(queueName string, id string, deadline int64) (bool, error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	return redis.Bool(visibilityScript.Do(rdg, this.DelayTable(queueName), OptDueIndex, queueName, deadline, id))
+}
+
+func (this *RedisStorage) Promote(queueName string, now int64, limit int) (int, error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	return redis.Int(promoteScript.Do(rdg, this.DelayTable(queueName), this.ReadyTable(queueName), OptDueIndex, this.PriorityTable(queueName),
+		this.InFlightTable(queueName), now, limit, queueName))
+}
+
+func (this *RedisStorage) Clean(queueName string, before int64) (int, error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	ids, err := redis.Strings(rdg.Do("ZRANGEBYSCORE", this.DelayTable(queueName), 0, before))
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	return this.deleteMessages(rdg, queueName, false, before, ids)
+}
+
+//先入死信队列的先移回，来源队列已删除的保留在死信队列中
+//每个脚本处理一批，留在死信队列中的消息在下一批跳过
+func (this *RedisStorage) Redrive(queueName string, sourceQueue string, maxMessages int64, exists func(string) bool) (count int64, err error) {
+	candidates := []string{sourceQueue}
+	if sourceQueue == "" {
+		if candidates, err = this.QueueNames(); err != nil {
+			return
+		}
+	}
+	var sources []string
+	for _, source := range candidates {
+		if exists(source) {
+			sources = append(sources, source)
+		}
+	}
+	if len(sources) == 0 {
+		return
+	}
+
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	var offset int64
+	for maxMessages <= 0 || count < maxMessages {
+		limit := int64(redriveBatch)
+		if maxMessages > 0 && maxMessages-count < limit {
+			limit = maxMessages - count
+		}
+		result, err := redis.Int64s(redriveScript.Do(rdg, redis.Args{}.Add(this.ReadyTable(queueName), this.MessageTable(queueName),
+			this.SourceTable(queueName), this.ReceiveCountTable(queueName), this.ReceiptTable(queueName),
+			this.SentTimeTable(queueName), this.AttributeTable(queueName), this.FirstReceiveTable(queueName),
+			offset, redriveBatch, limit, this.ReadyTable(""), this.MessageTable(""), this.SentTimeTable(""),
+			this.AttributeTable("")).AddFlat(sources)...))
+		if err != nil {
+			return count, err
+		}
+		count += result[0]
+		offset += result[1]
+		if result[2] < redriveBatch {
+			break
+		}
+	}
+	return
+}
+
+func (this *RedisStorage) DelMessages(queueName string) (err error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	groups, err := redis.Strings(rdg.Do("HVALS", this.MessageGroupTable(queueName)))
+	if err != nil {
+		return
+	}
+
+	keys := redis.Args{}.Add(this.ReadyTable(queueName), this.DelayTable(queueName), this.MessageTable(queueName),
+		this.ReceiptTable(queueName), this.ReceiveCountTable(queueName), this.SourceTable(queueName), this.SentTimeTable(queueName),
+		this.MessageGroupTable(queueName), this.DedupTable(queueName), this.DedupTimeTable(queueName), this.PriorityTable(queueName),
+		this.AttributeTable(queueName), this.FirstReceiveTable(queueName), this.GroupSetTable(queueName), this.InFlightTable(queueName),
+		this.ReadySignalTable(queueName))
+	for priority := 1; priority <= maxPriority; priority++ {
+		keys = keys.Add(this.PriorityReadyTable(queueName, priority))
+	}
+	deleted := make(map[string]bool)
+	for _, group := range groups {
+		if !deleted[group] {
+			deleted[group] = true
+			keys = keys.Add(this.GroupTable(queueName, group))
+		}
+	}
+	_, err = rdg.Do("DEL", keys...)
+	return
+}
+
+func (this *RedisStorage) Stats(queueName string) (stats QueueStats, err error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	values, err := redis.Int64s(statsScript.Do(rdg, this.ReadyTable(queueName), this.DelayTable(queueName),
+		this.InFlightTable(queueName), this.SentTimeTable(queueName), this.MessageGroupTable(queueName), this.GroupSetTable(queueName)))
+	if err != nil {
+		return
+	}
+	return QueueStats{values[0], values[1], values[2], values[3]}, nil
+}
+
+func (this *RedisStorage) Peek(queueName string, maxMessages int) (messages []Message, err error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	values, err := redis.Strings(peekScript.Do(rdg, this.ReadyTable(queueName), this.MessageTable(queueName),
+		this.AttributeTable(queueName), this.SentTimeTable(queueName), this.FirstReceiveTable(queueName),
+		this.ReceiveCountTable(queueName), maxMessages))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i+5 < len(values); i += 6 {
+		message := Message{MessageId: values[i], Body: values[i+1], SentTimestamp: toInt64(values[i+3]),
+			ApproximateFirstReceiveTimestamp: toInt64(values[i+4]), ApproximateReceiveCount: toInt64(values[i+5])}
+		if values[i+2] != "" {
+			if err = json.Unmarshal([]byte(values[i+2]), &message.Attributes); err != nil {
+				return nil, err
+			}
+		}
+		messages = append(messages, message)
+	}
+	return
+}
+
+func (this *RedisStorage) Scheduled(queueName string, maxMessages int) (messages []ScheduledMessage, err error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	values, err := redis.Strings(scheduledScript.Do(rdg, this.DelayTable(queueName), this.ReceiptTable(queueName),
+		this.MessageTable(queueName), this.AttributeTable(queueName), this.SentTimeTable(queueName), maxMessages))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i+4 < len(values); i += 5 {
+		message := ScheduledMessage{MessageId: values[i], Body: values[i+1], SentTimestamp: toInt64(values[i+3]), DeliverTimestamp: toInt64(values[i+4])}
+		if values[i+2] != "" {
+			if err = json.Unmarshal([]byte(values[i+2]), &message.Attributes); err != nil {
+				return nil, err
+			}
+		}
+		messages = append(messages, message)
+	}
+	return
+}
+
+func (this *RedisStorage) CancelScheduled(queueName string, id string) (bool, error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	return redis.Bool(cancelScheduledScript.Do(rdg, this.ReadyTable(queueName), this.DelayTable(queueName), this.MessageTable(queueName),
+		this.ReceiptTable(queueName), this.ReceiveCountTable(queueName), this.SourceTable(queueName),
+		this.SentTimeTable(queueName), this.MessageGroupTable(queueName), this.PriorityTable(queueName),
+		this.AttributeTable(queueName), this.FirstReceiveTable(queueName), this.GroupSetTable(queueName), this.InFlightTable(queueName),
+		this.GroupTable(queueName, ""), id))
+}
+
+func (this *RedisStorage) DueQueues(now int64) ([]string, error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	return redis.Strings(rdg.Do("ZRANGEBYSCORE", OptDueIndex, 0, now))
+}
+
+func (this *RedisStorage) OldestDue() (int64, error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	values, err := redis.Values(rdg.Do("ZRANGE", OptDueIndex, 0, 0, "WITHSCORES"))
+	if err != nil || len(values) < 2 {
+		return 0, err
+	}
+	return redis.Int64(values[1], nil)
+}
+
+func (this *RedisStorage) Reindex(queueName string) (err error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	_, err = reindexScript.Do(rdg, this.DelayTable(queueName), OptDueIndex, this.MessageGroupTable(queueName), this.GroupSetTable(queueName), queueName)
+	return
+}
+
+func (this *RedisStorage) Unschedule(queueName string) (err error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	_, err = rdg.Do("ZREM", OptDueIndex, queueName)
+	return
+}
+
+func (this *RedisStorage) AcquireLease(instanceId string, lease time.Duration) (bool, error) {
+	rdg := this.Pool.Get()
+	defer rdg.Close()
+
+	return redis.Bool(leaseScript.Do(rdg, OptSchedulerLeader, instanceId, int64(lease/time.Millisecond)))
+}
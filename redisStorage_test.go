@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func redisTestStorage(t *testing.T) (*RedisStorage, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	Pool = newPool(server.Addr())
+	return NewRedisStorage(Pool), server
+}
+
+//redis重启或执行SCRIPT FLUSH后脚本缓存丢失，出列脚本重新加载后仍可执行
+func TestRedisPopAfterScriptFlush(t *testing.T) {
+	store, _ := redisTestStorage(t)
+	store.Push("q", []NewMessage{{Id: "m1", Body: "a", SentAt: 1}, {Id: "m2", Body: "b", SentAt: 1}})
+	if messages, err := store.Pop("q", PopOption{MaxMessages: 1, Deadline: 10, Token: "t", Now: 1}); err != nil || len(messages) != 1 {
+		t.Fatalf("pop: %v %v", messages, err)
+	}
+
+	rdg := Pool.Get()
+	_, err := rdg.Do("SCRIPT", "FLUSH")
+	rdg.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messages, err := store.Pop("q", PopOption{MaxMessages: 1, Deadline: 10, Token: "t", Now: 1}); err != nil || len(messages) != 1 || messages[0].MessageId != "m2" {
+		t.Fatalf("pop after script flush: %v %v", messages, err)
+	}
+}
+
+//清理时只删除仍在延迟队列中且到期时间不晚于before的消息，取出ID后被延长的保留
+func TestRedisCleanSkipsExtendedMessages(t *testing.T) {
+	store, _ := redisTestStorage(t)
+	store.Push("q", []NewMessage{{Id: "a", Body: "a", SentAt: 1, DeliverAt: 10}, {Id: "b", Body: "b", SentAt: 1, DeliverAt: 100}})
+
+	rdg := Pool.Get()
+	defer rdg.Close()
+	if n, err := store.deleteMessages(rdg, "q", false, 50, []string{"b", "missing"}); err != nil || n != 0 {
+		t.Fatalf("delete extended messages: %d %v", n, err)
+	}
+	if n, err := store.Clean("q", 50); err != nil || n != 1 {
+		t.Fatalf("clean: %d %v", n, err)
+	}
+	if stats, _ := store.Stats("q"); stats.Delayed != 1 {
+		t.Fatalf("stats: %+v", stats)
+	}
+}
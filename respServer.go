@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	respMaxArgs    = 1024     //一条命令最多参数个数
+	respMaxBulk    = 8 << 20  //单个参数最大字节数
+	respMaxCommand = 16 << 20 //一条命令全部参数的总字节数
+	respMaxLine    = 64 << 10 //一行最大字节数，包括内联命令和长度行
+)
+
+//RESP协议的简单字符串和空数组，其他回复按值的类型写出：
+//string为bulk字符串，int64为整数，error为错误，[]interface{}为数组，nil为空bulk字符串
+type respStatus string
+
+type respNilArray struct{}
+
+var (
+	errRespSyntax    = errors.New("ERR syntax error")
+	errRespInteger   = errors.New("ERR value is not an integer or out of range")
+	errRespNoAuth    = errors.New("NOAUTH Authentication required.")
+	errRespWrongPass = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+)
+
+//兼容Redis协议的命令，redis-cli和Redis客户端库可以直接作为yumiQ的客户端
+//命令名不区分大小写，args不含命令名
+var respCommands = map[string]func(args []string) interface{}{
+	"PING":        respPing,
+	"COMMAND":     respCommand,
+	"QCREATE":     respCreate,
+	"QUPDATE":     respUpdate,
+	"QDROP":       respDrop,
+	"QLIST":       respList,
+	"QINFO":       respInfo,
+	"QPUSH":       respPush,
+	"QPOP":        respPop,
+	"QACK":        respAck,
+	"QVISIBILITY": respVisibility,
+}
+
+func ServeResp(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go serveRespConn(conn)
+	}
+}
+
+func serveRespConn(conn net.Conn) {
+	defer conn.Close()
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("error:%s", err)
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	authenticated := RespPassword == ""
+	for {
+		args, err := readRespCommand(r)
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			writeResp(w, fmt.Errorf("ERR Protocol error: %s", err.Error()))
+			w.Flush()
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		name := strings.ToUpper(args[0])
+		if name == "QUIT" {
+			writeResp(w, respStatus("OK"))
+			w.Flush()
+			return
+		}
+		if name == "AUTH" {
+			reply := respAuth(args[1:])
+			authenticated = authenticated || reply == respStatus("OK")
+			writeResp(w, reply)
+		} else if !authenticated {
+			writeResp(w, errRespNoAuth)
+		} else if command, ok := respCommands[name]; ok {
+			writeResp(w, command(args[1:]))
+		} else {
+			writeResp(w, fmt.Errorf("ERR unknown command '%s'", args[0]))
+		}
+
+		//管道中的命令读完后再一起写出
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+//读取一条命令，支持客户端发送的数组格式和telnet使用的内联格式
+//参数个数、单个参数和全部参数的大小都有上限，超过时断开连接，避免一条命令占用过多内存
+func readRespCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRespLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > respMaxArgs {
+		return nil, errors.New("invalid multibulk length")
+	}
+	args := make([]string, 0, n)
+	total := 0
+	for i := 0; i < n; i++ {
+		line, err := readRespLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected '$', got '%.1s'", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > respMaxBulk {
+			return nil, errors.New("invalid bulk length")
+		}
+		if total += size; total > respMaxCommand {
+			return nil, errors.New("command too large")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+//读取一行，超过respMaxLine时返回错误，不再继续读取
+func readRespLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > respMaxLine {
+			return "", errors.New("line too long")
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		} else if err != nil {
+			if err == io.EOF && len(line) != 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+var respLineBreak = strings.NewReplacer("\r", " ", "\n", " ")
+
+func writeResp(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case respStatus:
+		w.WriteString("+" + respLineBreak.Replace(string(v)) + "\r\n")
+	case error:
+		w.WriteString("-" + respLineBreak.Replace(v.Error()) + "\r\n")
+	case int64:
+		w.WriteString(":" + toString(v) + "\r\n")
+	case string:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case []interface{}:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			writeResp(w, item)
+		}
+	case respNilArray:
+		w.WriteString("*-1\r\n")
+	default:
+		w.WriteString("$-1\r\n")
+	}
+}
+
+//按错误类型加上Redis风格的错误前缀，没有类型的错误来自存储
+func respError(err error) error {
+	switch errorKind(err) {
+	case ErrNotFound:
+		return errors.New("NOTFOUND " + err.Error())
+	case ErrConflict:
+		return errors.New("CONFLICT " + err.Error())
+	}
+	return errors.New("ERR " + err.Error())
+}
+
+func respArity(name string) error {
+	return fmt.Errorf("ERR wrong number of arguments for '%s' command", name)
+}
+
+func respQueueExists(queueName string) error {
+	if _, ok := Queue.Get(queueName); !ok {
+		return fmt.Errorf("NOTFOUND Queue %s doesn't exist", queueName)
+	}
+	return nil
+}
+
+func respInt(value string) (int64, error) {
+	n, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return 0, errRespInteger
+	}
+	return n, nil
+}
+
+//AUTH password 或 AUTH default password，与Redis的requirepass一致，密码为-respPassword
+func respAuth(args []string) interface{} {
+	if len(args) == 2 && args[0] != "default" {
+		return errRespWrongPass
+	} else if len(args) == 2 {
+		args = args[1:]
+	} else if len(args) != 1 {
+		return respArity("auth")
+	}
+
+	if RespPassword == "" {
+		return errors.New("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+	if subtle.ConstantTimeCompare([]byte(args[0]), []byte(RespPassword)) != 1 {
+		return errRespWrongPass
+	}
+	return respStatus("OK")
+}
+
+//PING [message]
+func respPing(args []string) interface{} {
+	if len(args) == 0 {
+		return respStatus("PONG")
+	} else if len(args) == 1 {
+		return args[0]
+	}
+	return respArity("ping")
+}
+
+//redis-cli启动时查询命令文档，返回空列表
+func respCommand(args []string) interface{} {
+	return []interface{}{}
+}
+
+//QCREATE queue [option value ...]，选项名与/createQueue的参数相同，不区分大小写
+func respCreate(args []string) interface{} {
+	optionQueue, err := respOption("qcreate", args)
+	if err != nil {
+		return err
+	}
+	if err := YumiQ.Create(optionQueue); err != nil {
+		return respError(err)
+	}
+	return respStatus("OK")
+}
+
+//QUPDATE queue [option value ...]，没有给出的选项与/updateQueue一样按空值处理
+func respUpdate(args []string) interface{} {
+	optionQueue, err := respOption("qupdate", args)
+	if err != nil {
+		return err
+	}
+	if err := YumiQ.Update(optionQueue); err != nil {
+		return respError(err)
+	}
+	return respStatus("OK")
+}
+
+func respOption(name string, args []string) (optionQueue OptionQueue, err error) {
+	if len(args) == 0 || len(args)%2 == 0 {
+		return optionQueue, respArity(name)
+	}
+	if args[0] == "" {
+		return optionQueue, errors.New("ERR QueueName must not be null")
+	}
+	optionQueue.QueueName = args[0]
+
+	for i := 1; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToLower(args[i]) {
+		case "visibilitytimeout":
+			optionQueue.VisibilityTimeout = value
+		case "messageretentionperiod":
+			optionQueue.MessageRetentionPeriod = value
+		case "delayseconds":
+			optionQueue.DelaySeconds = value
+		case "deadletterqueue":
+			optionQueue.DeadLetterQueue = value
+		case "maxreceivecount":
+			optionQueue.MaxReceiveCount = value
+		case "fifoqueue":
+			optionQueue.FifoQueue = value
+		case "deduplicationwindow":
+			optionQueue.DeduplicationWindow = value
+		case "contentbaseddeduplication":
+			optionQueue.ContentBasedDedup = value
+		case "priorityaging":
+			optionQueue.PriorityAging = value
+		default:
+			return optionQueue, fmt.Errorf("ERR unknown queue option '%s'", args[i])
+		}
+	}
+	return
+}
+
+//QDROP queue，删除队列及其中的消息
+func respDrop(args []string) interface{} {
+	if len(args) != 1 {
+		return respArity("qdrop")
+	}
+	if exists, _ := Queue.queueExists(args[0]); !exists {
+		return fmt.Errorf("NOTFOUND Queue %s doesn't exist", args[0])
+	}
+	if err := YumiQ.DelQueue(args[0]); err != nil {
+		return respError(err)
+	}
+	return respStatus("OK")
+}
+
+//QLIST [prefix]，返回所有队列名
+func respList(args []string) interface{} {
+	if len(args) > 1 {
+		return respArity("qlist")
+	}
+	var prefix string
+	if len(args) == 1 {
+		prefix = args[0]
+	}
+
+	names := []interface{}{}
+	var next string
+	for {
+		page, token, err := Queue.List(prefix, next, 0)
+		if err != nil {
+			return respError(err)
+		}
+		for _, name := range page {
+			names = append(names, name)
+		}
+		if token == "" {
+			return names
+		}
+		next = token
+	}
+}
+
+//QINFO queue，按字段名排序返回字段和值，与HGETALL的格式相同
+func respInfo(args []string) interface{} {
+	if len(args) != 1 {
+		return respArity("qinfo")
+	}
+	attributes, err := YumiQ.GetQueueAttributes(args[0])
+	if err != nil {
+		return respError(err)
+	}
+
+	data, err := json.Marshal(attributes)
+	must(err)
+	var fields map[string]interface{}
+	must(json.Unmarshal(data, &fields))
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	reply := make([]interface{}, 0, len(fields)*2)
+	for _, name := range names {
+		reply = append(reply, name, fmt.Sprint(fields[name]))
+	}
+	return reply
+}
+
+//QPUSH queue body [DELAY s] [AT time] [GROUP id] [DEDUPID id] [PRIORITY p] [ATTRIBUTES json]，返回消息ID
+//AT为RFC3339或毫秒时间戳的到期时间
+func respPush(args []string) interface{} {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return respArity("qpush")
+	}
+	if args[1] == "" {
+		return errors.New("ERR body must not be null")
+	}
+
+	entry := PushEntry{Body: args[1]}
+	for i := 2; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToUpper(args[i]) {
+		case "DELAY":
+			if _, err := respInt(value); err != nil {
+				return err
+			}
+			entry.DelaySeconds = value
+		case "AT":
+			entry.DeliverAt = value
+		case "GROUP":
+			entry.MessageGroupId = value
+		case "DEDUPID":
+			entry.DeduplicationId = value
+		case "PRIORITY":
+			entry.Priority = value
+		case "ATTRIBUTES":
+			entry.Attributes = value
+		default:
+			return errRespSyntax
+		}
+	}
+
+	id, err := YumiQ.Push(args[0], entry)
+	if err != nil {
+		return respError(err)
+	}
+	return id
+}
+
+//QPOP queue [WAIT s] [COUNT n]，没有消息时返回空数组
+//每条消息为字段和值交替的数组，与HGETALL的格式相同
+func respPop(args []string) interface{} {
+	if len(args) < 1 || len(args)%2 != 1 {
+		return respArity("qpop")
+	}
+
+	var waitSeconds, maxMessages int64
+	for i := 1; i < len(args); i += 2 {
+		n, err := respInt(args[i+1])
+		if err != nil || n < 0 {
+			return errRespInteger
+		}
+		switch strings.ToUpper(args[i]) {
+		case "WAIT":
+			waitSeconds = n
+		case "COUNT":
+			maxMessages = n
+		default:
+			return errRespSyntax
+		}
+	}
+
+	messages, err := YumiQ.Pop(args[0], int(waitSeconds), int(maxMessages))
+	if errorKind(err) == ErrEmpty {
+		return respNilArray{}
+	} else if err != nil {
+		return respError(err)
+	}
+
+	reply := make([]interface{}, 0, len(messages))
+	for _, message := range messages {
+		fields := []interface{}{
+			"messageId", message.MessageId,
+			"receiptHandle", message.ReceiptHandle,
+			"body", message.Body,
+			"sentTimestamp", toString(message.SentTimestamp),
+			"approximateFirstReceiveTimestamp", toString(message.ApproximateFirstReceiveTimestamp),
+			"approximateReceiveCount", toString(message.ApproximateReceiveCount),
+		}
+		if len(message.Attributes) != 0 {
+			data, err := json.Marshal(message.Attributes)
+			must(err)
+			fields = append(fields, "attributes", string(data))
+		}
+		reply = append(reply, fields)
+	}
+	return reply
+}
+
+//QACK queue receiptHandle，删除消息
+func respAck(args []string) interface{} {
+	if len(args) != 2 {
+		return respArity("qack")
+	}
+	if err := respQueueExists(args[0]); err != nil {
+		return err
+	}
+	if err := YumiQ.Del(args[0], args[1]); err != nil {
+		return respError(err)
+	}
+	return int64(1)
+}
+
+//QVISIBILITY queue receiptHandle seconds，修改隐藏时间，为0时按队列的隐藏时间
+func respVisibility(args []string) interface{} {
+	if len(args) != 3 {
+		return respArity("qvisibility")
+	}
+	seconds, err := respInt(args[2])
+	if err != nil || seconds < 0 {
+		return errRespInteger
+	}
+	if err := respQueueExists(args[0]); err != nil {
+		return err
+	}
+	if err := YumiQ.SetVisibilityTime(args[0], args[1], seconds); err != nil {
+		return respError(err)
+	}
+	return respStatus("OK")
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+//在随机端口启动RESP服务，返回地址
+func respTestListen(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveRespConn(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().String()
+}
+
+func respTestDial(t *testing.T, addr string, options ...redis.DialOption) redis.Conn {
+	conn, err := redis.Dial("tcp", addr, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+//Redis客户端库按命令创建队列、入列、出列和确认，错误按前缀区分类型
+func TestRespCommands(t *testing.T) {
+	storageTestSetup(t, "memory")
+	c := respTestDial(t, respTestListen(t))
+
+	if reply, err := redis.String(c.Do("qcreate", "r", "VisibilityTimeout", "30")); reply != "OK" {
+		t.Fatalf("create: %q %v", reply, err)
+	}
+	if _, err := c.Do("QCREATE", "r", "bogus", "1"); err == nil || !strings.HasPrefix(err.Error(), "ERR unknown queue option") {
+		t.Fatalf("create with unknown option: %v", err)
+	}
+	if _, err := c.Do("QPUSH", "missing", "x"); err == nil || !strings.HasPrefix(err.Error(), "NOTFOUND") {
+		t.Fatalf("push to missing queue: %v", err)
+	}
+	if _, err := c.Do("QPUSH", "r", "x", "DELAY", "abc"); err == nil {
+		t.Fatal("push with bad delay succeeded")
+	}
+
+	id, err := redis.String(c.Do("QPUSH", "r", "hello\r\nworld", "PRIORITY", "3", "ATTRIBUTES", `{"a":"b"}`))
+	if err != nil || id == "" {
+		t.Fatalf("push: %q %v", id, err)
+	}
+	values, err := redis.Values(c.Do("QPOP", "r", "COUNT", "5"))
+	if err != nil || len(values) != 1 {
+		t.Fatalf("pop: %v %v", values, err)
+	}
+	message, _ := redis.StringMap(values[0], nil)
+	if message["messageId"] != id || message["body"] != "hello\r\nworld" || message["attributes"] != `{"a":"b"}` || message["approximateReceiveCount"] != "1" {
+		t.Fatalf("popped message: %v", message)
+	}
+	if reply, err := c.Do("QPOP", "r"); reply != nil || err != nil {
+		t.Fatalf("pop from empty queue: %v %v", reply, err)
+	}
+
+	if reply, err := redis.String(c.Do("QVISIBILITY", "r", message["receiptHandle"], "60")); reply != "OK" {
+		t.Fatalf("visibility: %q %v", reply, err)
+	}
+	if n, err := redis.Int(c.Do("QACK", "r", message["receiptHandle"])); n != 1 {
+		t.Fatalf("ack: %d %v", n, err)
+	}
+	if _, err := c.Do("QACK", "r", message["receiptHandle"]); err == nil {
+		t.Fatal("ack twice succeeded")
+	}
+
+	info, err := redis.StringMap(c.Do("QINFO", "r"))
+	if err != nil || info["visibilityTimeout"] != "30" {
+		t.Fatalf("info: %v %v", info, err)
+	}
+	if names, err := redis.Strings(c.Do("QLIST")); err != nil || len(names) != 1 || names[0] != "r" {
+		t.Fatalf("list: %v %v", names, err)
+	}
+	if _, err := c.Do("NOPE"); err == nil || !strings.HasPrefix(err.Error(), "ERR unknown command") {
+		t.Fatalf("unknown command: %v", err)
+	}
+	if reply, err := redis.String(c.Do("QDROP", "r")); reply != "OK" {
+		t.Fatalf("drop: %q %v", reply, err)
+	}
+	if _, ok := Queue.Get("r"); ok {
+		t.Fatal("dropped queue still exists")
+	}
+}
+
+//管道中的命令依次回复，telnet可以发送内联命令
+func TestRespPipelineAndInline(t *testing.T) {
+	storageTestSetup(t, "memory")
+	storageTestQueue(t, OptionQueue{QueueName: "r"})
+	addr := respTestListen(t)
+	c := respTestDial(t, addr)
+
+	c.Send("QPUSH", "r", "a")
+	c.Send("QPUSH", "r", "b")
+	c.Send("PING")
+	c.Flush()
+	for i := 0; i < 3; i++ {
+		if _, err := c.Receive(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats, _ := Store.Stats("r"); stats.Ready != 2 {
+		t.Fatalf("stats: %+v", stats)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("PING\r\n"))
+	if line, _ := bufio.NewReader(conn).ReadString('\n'); line != "+PONG\r\n" {
+		t.Fatalf("inline ping: %q", line)
+	}
+}
+
+//设置密码后除AUTH外的命令需要先认证，AUTH接受密码或default用户和密码
+func TestRespAuth(t *testing.T) {
+	storageTestSetup(t, "memory")
+	addr := respTestListen(t)
+	if _, err := respTestDial(t, addr).Do("AUTH", "x"); err == nil || !strings.HasPrefix(err.Error(), "ERR AUTH") {
+		t.Fatalf("auth without password: %v", err)
+	}
+
+	RespPassword = "secret"
+	t.Cleanup(func() { RespPassword = "" })
+	c := respTestDial(t, addr)
+	if _, err := c.Do("QLIST"); err == nil || !strings.HasPrefix(err.Error(), "NOAUTH") {
+		t.Fatalf("command before auth: %v", err)
+	}
+	if _, err := c.Do("AUTH", "bad"); err == nil || !strings.HasPrefix(err.Error(), "WRONGPASS") {
+		t.Fatalf("auth with wrong password: %v", err)
+	}
+	if reply, err := redis.String(c.Do("AUTH", "default", "secret")); reply != "OK" {
+		t.Fatalf("auth: %q %v", reply, err)
+	}
+	if _, err := c.Do("QLIST"); err != nil {
+		t.Fatalf("command after auth: %v", err)
+	}
+	if _, err := respTestDial(t, addr, redis.DialPassword("secret")).Do("PING"); err != nil {
+		t.Fatalf("dial with password: %v", err)
+	}
+}
+
+//行或命令超过上限时返回协议错误并断开，不读入全部内容
+func TestRespLimits(t *testing.T) {
+	storageTestSetup(t, "memory")
+	addr := respTestListen(t)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(strings.Repeat("a", respMaxLine+1) + "\r\n"))
+	if line, _ := bufio.NewReader(conn).ReadString('\n'); !strings.Contains(line, "line too long") {
+		t.Fatalf("long line: %q", line)
+	}
+
+	conn, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		w := bufio.NewWriter(conn)
+		w.WriteString("*3\r\n")
+		for i := 0; i < 3; i++ {
+			w.WriteString("$" + toString(respMaxBulk) + "\r\n" + strings.Repeat("x", respMaxBulk) + "\r\n")
+		}
+		w.Flush()
+	}()
+	if line, _ := bufio.NewReader(conn).ReadString('\n'); !strings.Contains(line, "command too large") {
+		t.Fatalf("large command: %q", line)
+	}
+
+	conn, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("*" + toString(respMaxArgs+1) + "\r\n"))
+	if line, _ := bufio.NewReader(conn).ReadString('\n'); !strings.Contains(line, "invalid multibulk length") {
+		t.Fatalf("too many arguments: %q", line)
+	}
+}
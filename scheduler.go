@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	schedulerInterval = 1 * time.Second //调度间隔
+	leaderLease       = 5 * time.Second //leader租约时长，leader退出后其他实例最多等待这么久接管
+)
+
+//延迟队列调度器
+//所有队列共用一个到期索引，每次只处理已有消息到期的队列，一个Go程即可支撑大量队列
+//多个yumiQ实例通过租约选出一个leader，只有leader移动到期消息
+type Scheduler struct {
+	InstanceId string
+	leader     int32 //为1时是leader，其他Go程通过IsLeader读取
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{InstanceId: newID()}
+}
+
+//启动调度，每个实例都定期刷新队列深度快照供指标抓取
+func (this *Scheduler) Start() {
+	go func() {
+		refreshQueueDepths()
+		ticker := time.NewTicker(queueDepthInterval)
+		for {
+			select {
+			case <-ticker.C:
+				refreshQueueDepths()
+			}
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(schedulerInterval)
+		for {
+			select {
+			case <-ticker.C:
+				if this.elect() {
+					this.promoteDue()
+				}
+			}
+		}
+	}()
+}
+
+//获取或续约leader租约，返回本实例是否为leader
+func (this *Scheduler) elect() bool {
+	leader, err := Store.AcquireLease(this.InstanceId, leaderLease)
+	if err != nil {
+		log.Printf("scheduler lease error: %s", err.Error())
+		leader = false
+	}
+
+	if leader && !this.IsLeader() {
+		log.Printf("scheduler %s became leader", this.InstanceId)
+		this.reindex()
+	} else if !leader && this.IsLeader() {
+		log.Printf("scheduler %s lost leadership", this.InstanceId)
+	}
+	if leader {
+		atomic.StoreInt32(&this.leader, 1)
+	} else {
+		atomic.StoreInt32(&this.leader, 0)
+	}
+	return leader
+}
+
+func (this *Scheduler) IsLeader() bool {
+	return atomic.LoadInt32(&this.leader) == 1
+}
+
+//成为leader时重建所有队列的到期索引，防止索引与延迟队列不一致
+func (this *Scheduler) reindex() {
+	queues, err := Queue.GetAllQueuesInfo()
+	if err != nil {
+		log.Printf("scheduler reindex error: %s", err.Error())
+		return
+	}
+
+	for _, qname := range queues {
+		if err := Store.Reindex(qname); err != nil {
+			log.Printf("%s queue reindex error: %s", qname, err.Error())
+		}
+	}
+}
+
+//把到期索引中已到期队列的消息移到准备队列
+func (this *Scheduler) promoteDue() {
+	now := theMoment()
+	queues, err := Store.DueQueues(now)
+	if err != nil {
+		log.Printf("scheduler due index error: %s", err.Error())
+		return
+	}
+
+	for _, qname := range queues {
+		if ok, _ := Queue.ExistsQueueInOpt(qname); !ok { //队列已删除
+			Store.Unschedule(qname)
+			continue
+		}
+
+		//每次最多移动PromoteBatch条，剩余的下次再移
+		if _, err := Store.Promote(qname, now, PromoteBatch); err != nil {
+			log.Printf("%s queue promote error: %s", qname, err.Error())
+		}
+	}
+}
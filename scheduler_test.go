@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+)
+
+//同一时刻只有一个实例持有租约，租约到期后其他实例接管
+func TestSchedulerLeaderElection(t *testing.T) {
+	store, server := redisTestStorage(t)
+	Store = store
+	Queue = NewQueues()
+
+	a, b := NewScheduler(), NewScheduler()
+	if !a.elect() || !a.IsLeader() {
+		t.Fatal("first scheduler must become leader")
+	}
+	if b.elect() || b.IsLeader() {
+		t.Fatal("second scheduler must not become leader while the lease is held")
+	}
+
+	//leader续约后租约重新计时
+	server.FastForward(leaderLease / 2)
+	if !a.elect() {
+		t.Fatal("leader must renew its lease")
+	}
+	server.FastForward(leaderLease / 2)
+	if b.elect() {
+		t.Fatal("renewed lease must not be taken over")
+	}
+
+	//leader停止续约，租约到期后被接管
+	server.FastForward(leaderLease)
+	if !b.elect() || !b.IsLeader() {
+		t.Fatal("second scheduler must take over the expired lease")
+	}
+	if a.elect() || a.IsLeader() {
+		t.Fatal("old leader must step down")
+	}
+}
+
+//redis不可用时不是leader
+func TestSchedulerLeaseError(t *testing.T) {
+	store, server := redisTestStorage(t)
+	Store = store
+	Queue = NewQueues()
+
+	scheduler := NewScheduler()
+	if !scheduler.elect() {
+		t.Fatal("scheduler must become leader")
+	}
+	server.Close()
+	if scheduler.elect() || scheduler.IsLeader() {
+		t.Fatal("scheduler must step down when the lease can't be renewed")
+	}
+}
+
+//leader只移动到期队列的消息，已删除的队列移出到期索引
+func TestSchedulerPromoteDue(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "a"})
+		storageTestQueue(t, OptionQueue{QueueName: "b"})
+		now := theMoment()
+		Store.Push("a", []NewMessage{{Id: "m1", Body: "due", SentAt: now, DeliverAt: now - 1}, {Id: "m2", Body: "later", SentAt: now, DeliverAt: now + 3600}})
+		Store.Push("b", []NewMessage{{Id: "m3", Body: "due", SentAt: now, DeliverAt: now - 1}})
+		if err := YumiQ.DelQueue("b"); err != nil {
+			t.Fatal(err)
+		}
+		Store.Push("b", []NewMessage{{Id: "m4", Body: "left over", SentAt: now, DeliverAt: now - 1}})
+
+		if !Sched.elect() {
+			t.Fatal("scheduler must become leader")
+		}
+		Sched.promoteDue()
+		if stats, _ := Store.Stats("a"); stats.Ready != 1 || stats.Delayed != 1 {
+			t.Fatalf("stats: %+v", stats)
+		}
+		if due, _ := Store.DueQueues(now + 3600); len(due) != 1 || due[0] != "a" {
+			t.Fatalf("due queues: %v", due)
+		}
+		if oldest, _ := Store.OldestDue(); oldest != now+3600 {
+			t.Fatalf("oldest due: %d, want %d", oldest, now+3600)
+		}
+	})
+}
+
+//成为leader时重建到期索引，索引丢失的延迟消息仍会到期
+func TestSchedulerReindexOnElection(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "q"})
+		now := theMoment()
+		Store.Push("q", []NewMessage{{Id: "m1", Body: "due", SentAt: now, DeliverAt: now - 1}})
+		if err := Store.Unschedule("q"); err != nil {
+			t.Fatal(err)
+		}
+		if due, _ := Store.DueQueues(now); len(due) != 0 {
+			t.Fatalf("due queues after unschedule: %v", due)
+		}
+
+		if !Sched.elect() {
+			t.Fatal("scheduler must become leader")
+		}
+		Sched.promoteDue()
+		if stats, _ := Store.Stats("q"); stats.Ready != 1 {
+			t.Fatalf("stats: %+v", stats)
+		}
+	})
+}
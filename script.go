@@ -0,0 +1,547 @@
+package main
+
+import (
+	"github.com/gomodule/redigo/redis"
+)
+
+//脚本公用函数
+//markDue 队列有消息在score时到期，到期索引中该队列的时间晚于score时提前
+//reindex 按延迟队列中最早的到期时间重建该队列的到期索引，延迟队列为空时移出索引
+const dueIndexLua = `
+local function markDue(index, queue, score)
+	local current = redis.call('ZSCORE', index, queue)
+	if not current or tonumber(current) > tonumber(score) then
+		redis.call('ZADD', index, score, queue)
+	end
+end
+
+local function reindex(delay, index, queue)
+	local first = redis.call('ZRANGE', delay, 0, 0, 'WITHSCORES')
+	if #first == 0 then
+		redis.call('ZREM', index, queue)
+	else
+		redis.call('ZADD', index, first[2], queue)
+	end
+end
+`
+
+//优先级为0的消息在准备队列中，优先级1-9的在 准备队列:优先级 中，最高优先级与maxPriority一致
+//readyKey 某优先级的准备队列
+//pushReady 按消息的优先级放入准备队列
+//removeReady 从所在优先级的准备队列中移除
+//popReady 取出下一条消息，aging为0时从最高优先级取；否则每等待aging秒优先级加一，按各队列最早的消息比较
+//signalReady 有count条消息进入准备队列时放入同样多的信号，最多保留100个，等待出列的调用方阻塞读取信号后再出列
+const readyLua = `
+local function readyKey(ready, priority)
+	if priority == 0 then
+		return ready
+	end
+	return ready .. ':' .. priority
+end
+
+local function signalReady(ready, count)
+	if count <= 0 then
+		return
+	end
+	local signals = {}
+	for i = 1, math.min(count, 100) do
+		signals[i] = '1'
+	end
+	local signal = ready .. ':signal'
+	redis.call('LPUSH', signal, unpack(signals))
+	redis.call('LTRIM', signal, 0, 99)
+end
+
+local function pushReady(ready, priorities, id)
+	local priority = redis.call('HGET', priorities, id)
+	redis.call('LPUSH', readyKey(ready, tonumber(priority or 0)), id)
+end
+
+local function removeReady(ready, priorities, id)
+	local priority = redis.call('HGET', priorities, id)
+	redis.call('LREM', readyKey(ready, tonumber(priority or 0)), 0, id)
+end
+
+local function popReady(ready, sentTimes, now, aging)
+	if aging <= 0 then
+		for priority = 9, 0, -1 do
+			local id = redis.call('RPOP', readyKey(ready, priority))
+			if id then
+				return id
+			end
+		end
+		return false
+	end
+
+	local best, bestPriority = -1, nil
+	for priority = 9, 0, -1 do
+		local id = redis.call('LINDEX', readyKey(ready, priority), -1)
+		if id then
+			local effective = priority
+			local sentAt = redis.call('ZSCORE', sentTimes, id)
+			if sentAt and now > tonumber(sentAt) then
+				effective = priority + math.floor((now - tonumber(sentAt)) / aging)
+			end
+			if effective > best then
+				best, bestPriority = effective, priority
+			end
+		end
+	end
+	if not bestPriority then
+		return false
+	end
+	return redis.call('RPOP', readyKey(ready, bestPriority))
+end
+`
+
+//releaseGroup 消息删除或移出队列时移出所在的消息组，是组内第一条时把下一条放入准备队列，组内没有消息时移出消息组set
+//需与readyLua一起使用
+const groupLua = `
+local function releaseGroup(ready, messageGroup, groups, groupPrefix, id)
+	local group = redis.call('HGET', messageGroup, id)
+	if not group then
+		return
+	end
+	redis.call('HDEL', messageGroup, id)
+	local groupList = groupPrefix .. group
+	local head = redis.call('LINDEX', groupList, 0)
+	redis.call('LREM', groupList, 1, id)
+	if head == id then
+		local nextId = redis.call('LINDEX', groupList, 0)
+		if nextId then
+			redis.call('LPUSH', ready, nextId)
+			signalReady(ready, 1)
+		end
+	end
+	if redis.call('LLEN', groupList) == 0 then
+		redis.call('SREM', groups, group)
+	end
+end
+`
+
+/*
+入列
+KEYS[1] 准备队列  KEYS[2] 延迟队列  KEYS[3] 消息体hash  KEYS[4] 入列时间zset  KEYS[5] 消息组hash
+KEYS[6] 去重hash  KEYS[7] 去重截止时间zset  KEYS[8] 到期索引  KEYS[9] 优先级hash  KEYS[10] 消息属性hash
+KEYS[11] 消息组set
+ARGV[1] 队列名  ARGV[2] 消息组列表前缀
+ARGV[3...] 每条消息9个参数：ID, 消息体, 到期时间, 入列时间, 消息组, 去重ID, 去重截止时间, 优先级, 消息属性json
+先按第一条消息的入列时间清理过期的去重ID，去重ID未过期的消息不入列
+有消息组的追加到组列表末尾，只有组内第一条进入准备或延迟队列
+返回每条消息实际的ID，去重时为之前入列的消息ID
+*/
+var pushScript = redis.NewScript(11, dueIndexLua+readyLua+`
+local result, readied = {}, 0
+if #ARGV >= 11 then
+	local expired = redis.call('ZRANGEBYSCORE', KEYS[7], 0, ARGV[6])
+	for _, dedupId in ipairs(expired) do
+		redis.call('HDEL', KEYS[6], dedupId)
+	end
+	redis.call('ZREMRANGEBYSCORE', KEYS[7], 0, ARGV[6])
+end
+for i = 3, #ARGV, 9 do
+	local id, body, deliverAt, sentAt = ARGV[i], ARGV[i + 1], ARGV[i + 2], ARGV[i + 3]
+	local group, dedupId, dedupUntil, priority = ARGV[i + 4], ARGV[i + 5], ARGV[i + 6], tonumber(ARGV[i + 7])
+	local attributes = ARGV[i + 8]
+	local original = false
+	if dedupId ~= '' then
+		original = redis.call('HGET', KEYS[6], dedupId)
+	end
+	if original then
+		table.insert(result, original)
+	else
+		if dedupId ~= '' then
+			redis.call('HSET', KEYS[6], dedupId, id)
+			redis.call('ZADD', KEYS[7], dedupUntil, dedupId)
+		end
+		redis.call('HSET', KEYS[3], id, body)
+		redis.call('ZADD', KEYS[4], sentAt, id)
+		if priority > 0 then
+			redis.call('HSET', KEYS[9], id, priority)
+		end
+		if attributes ~= '' then
+			redis.call('HSET', KEYS[10], id, attributes)
+		end
+		local head = true
+		if group ~= '' then
+			redis.call('HSET', KEYS[5], id, group)
+			head = redis.call('RPUSH', ARGV[2] .. group, id) == 1
+			if head then
+				redis.call('SADD', KEYS[11], group)
+			end
+		end
+		if head then
+			if tonumber(deliverAt) == 0 then
+				redis.call('LPUSH', readyKey(KEYS[1], priority), id)
+				readied = readied + 1
+			else
+				redis.call('ZADD', KEYS[2], deliverAt, id)
+				markDue(KEYS[8], ARGV[1], deliverAt)
+			end
+		end
+		table.insert(result, id)
+	end
+end
+signalReady(KEYS[1], readied)
+return result
+`)
+
+/*
+删除消息
+KEYS[1] 准备队列  KEYS[2] 延迟队列  KEYS[3] 消息体hash  KEYS[4] 回执hash  KEYS[5] 接收次数hash
+KEYS[6] 死信来源hash  KEYS[7] 入列时间zset  KEYS[8] 消息组hash  KEYS[9] 优先级hash
+KEYS[10] 消息属性hash  KEYS[11] 首次接收时间hash  KEYS[12] 消息组set  KEYS[13] 隐藏中消息set
+ARGV[1] 消息组列表前缀  ARGV[2] 为1时也从准备队列中移除，为0时只删除仍在延迟队列中且到期时间不晚于ARGV[3]的消息
+ARGV[3] 到期时间上限，ARGV[2]为0时使用  ARGV[4...] 消息ID
+返回实际删除的消息数，消息体已不存在的不计
+*/
+var deleteScript = redis.NewScript(13, readyLua+groupLua+`
+local deleted = 0
+for i = 4, #ARGV do
+	local id = ARGV[i]
+	local deliverAt = redis.call('ZSCORE', KEYS[2], id)
+	if ARGV[2] == '1' or (deliverAt and tonumber(deliverAt) <= tonumber(ARGV[3])) then
+		redis.call('ZREM', KEYS[2], id)
+		redis.call('SREM', KEYS[13], id)
+		if ARGV[2] == '1' then
+			removeReady(KEYS[1], KEYS[9], id)
+		end
+		if redis.call('HDEL', KEYS[3], id) == 1 then
+			deleted = deleted + 1
+		end
+		for k = 4, 6 do
+			redis.call('HDEL', KEYS[k], id)
+		end
+		for k = 9, 11 do
+			redis.call('HDEL', KEYS[k], id)
+		end
+		redis.call('ZREM', KEYS[7], id)
+		releaseGroup(KEYS[1], KEYS[8], KEYS[12], ARGV[1], id)
+	end
+end
+return deleted
+`)
+
+/*
+出列并隐藏
+KEYS[1] 准备队列  KEYS[2] 延迟队列  KEYS[3] 消息体hash  KEYS[4] 回执hash  KEYS[5] 接收次数hash
+KEYS[6] 死信准备队列  KEYS[7] 死信消息体hash  KEYS[8] 死信来源hash  KEYS[9] 到期索引
+KEYS[10] 入列时间zset  KEYS[11] 死信入列时间zset  KEYS[12] 消息组hash  KEYS[13] 优先级hash
+KEYS[14] 消息属性hash  KEYS[15] 死信消息属性hash  KEYS[16] 首次接收时间hash  KEYS[17] 消息组set  KEYS[18] 隐藏中消息set
+ARGV[1] 隐藏截止时间  ARGV[2] 回执随机串  ARGV[3] 最大接收次数，0为不限  ARGV[4] 本队列名  ARGV[5] 最多取出条数
+ARGV[6] 消息组列表前缀  ARGV[7] 当前时间  ARGV[8] 优先级老化秒数，0为不老化
+取出消息ID、放入延迟队列、记录回执在同一脚本内完成，消息要么仍在准备队列，要么已带截止时间进入延迟队列
+接收次数超过上限的消息移入死信队列并记录来源队列，保留原入列时间，消息体已不存在的ID直接丢弃，均继续取下一个
+移入死信队列或丢弃的消息同时移出消息组，死信队列中的消息不再属于消息组，也不再有优先级，消息属性随消息移动
+第一次接收时记录首次接收时间
+返回 {id1, 回执1, 消息体1, 消息属性json1, 入列时间1, 首次接收时间1, 接收次数1, id2, ...}，准备队列为空时返回空列表
+*/
+var popScript = redis.NewScript(18, dueIndexLua+readyLua+groupLua+`
+local maxReceiveCount = tonumber(ARGV[3])
+local maxMessages = tonumber(ARGV[5])
+local now, aging = tonumber(ARGV[7]), tonumber(ARGV[8])
+local result = {}
+while #result < maxMessages * 7 do
+	local id = popReady(KEYS[1], KEYS[10], now, aging)
+	if not id then
+		break
+	end
+	local body = redis.call('HGET', KEYS[3], id)
+	if not body then
+		redis.call('HDEL', KEYS[4], id)
+		redis.call('HDEL', KEYS[5], id)
+		redis.call('ZREM', KEYS[10], id)
+		redis.call('HDEL', KEYS[13], id)
+		redis.call('HDEL', KEYS[14], id)
+		redis.call('HDEL', KEYS[16], id)
+		releaseGroup(KEYS[1], KEYS[12], KEYS[17], ARGV[6], id)
+	else
+		local count = redis.call('HINCRBY', KEYS[5], id, 1)
+		if maxReceiveCount > 0 and count > maxReceiveCount then
+			redis.call('HSET', KEYS[7], id, body)
+			redis.call('HSET', KEYS[8], id, ARGV[4])
+			redis.call('LPUSH', KEYS[6], id)
+			signalReady(KEYS[6], 1)
+			local sentAt = redis.call('ZSCORE', KEYS[10], id)
+			if sentAt then
+				redis.call('ZADD', KEYS[11], sentAt, id)
+			end
+			local attributes = redis.call('HGET', KEYS[14], id)
+			if attributes then
+				redis.call('HSET', KEYS[15], id, attributes)
+			end
+			redis.call('HDEL', KEYS[3], id)
+			redis.call('HDEL', KEYS[4], id)
+			redis.call('HDEL', KEYS[5], id)
+			redis.call('ZREM', KEYS[10], id)
+			redis.call('HDEL', KEYS[13], id)
+			redis.call('HDEL', KEYS[14], id)
+			redis.call('HDEL', KEYS[16], id)
+			releaseGroup(KEYS[1], KEYS[12], KEYS[17], ARGV[6], id)
+		else
+			local receipt = id .. ':' .. ARGV[2]
+			redis.call('ZADD', KEYS[2], ARGV[1], id)
+			redis.call('SADD', KEYS[18], id)
+			redis.call('HSET', KEYS[4], id, receipt)
+			redis.call('HSETNX', KEYS[16], id, ARGV[7])
+			table.insert(result, id)
+			table.insert(result, receipt)
+			table.insert(result, body)
+			table.insert(result, redis.call('HGET', KEYS[14], id) or '')
+			table.insert(result, redis.call('ZSCORE', KEYS[10], id) or '0')
+			table.insert(result, redis.call('HGET', KEYS[16], id))
+			table.insert(result, tostring(count))
+		end
+	end
+end
+if #result > 0 then
+	markDue(KEYS[9], ARGV[4], ARGV[1])
+end
+return result
+`)
+
+/*
+延迟队列到期消息移入准备队列
+KEYS[1] 延迟队列  KEYS[2] 准备队列  KEYS[3] 到期索引  KEYS[4] 优先级hash  KEYS[5] 隐藏中消息set
+ARGV[1] 当前时间  ARGV[2] 本次最多移动的条数  ARGV[3] 队列名
+只移动本次读到的成员，按到期时间先后入列，unpack分段进行避免超出lua栈，移动后重建该队列的到期索引
+队列中有带优先级的消息时逐条放入对应优先级的准备队列
+*/
+var promoteScript = redis.NewScript(5, dueIndexLua+readyLua+`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], 0, ARGV[1], 'LIMIT', 0, ARGV[2])
+local prioritized = redis.call('HLEN', KEYS[4]) > 0
+for i = 1, #ids, 1000 do
+	local chunk = {unpack(ids, i, math.min(i + 999, #ids))}
+	redis.call('ZREM', KEYS[1], unpack(chunk))
+	redis.call('SREM', KEYS[5], unpack(chunk))
+	if prioritized then
+		for _, id in ipairs(chunk) do
+			pushReady(KEYS[2], KEYS[4], id)
+		end
+	else
+		redis.call('LPUSH', KEYS[2], unpack(chunk))
+	end
+end
+signalReady(KEYS[2], #ids)
+reindex(KEYS[1], KEYS[3], ARGV[3])
+return #ids
+`)
+
+/*
+修改隐藏中消息的到期时间
+KEYS[1] 延迟队列  KEYS[2] 到期索引
+ARGV[1] 队列名  ARGV[2] 新的到期时间  ARGV[3] 消息ID
+消息已回到准备队列时返回0，否则会同时存在于两个队列
+*/
+var visibilityScript = redis.NewScript(2, dueIndexLua+`
+if not redis.call('ZSCORE', KEYS[1], ARGV[3]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[3])
+markDue(KEYS[2], ARGV[1], ARGV[2])
+return 1
+`)
+
+/*
+获取或续约调度器leader租约
+KEYS[1] leader键
+ARGV[1] 实例ID  ARGV[2] 租约毫秒数
+返回1表示本实例为leader
+*/
+var leaseScript = redis.NewScript(1, `
+local owner = redis.call('GET', KEYS[1])
+if not owner then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+elseif owner == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+/*
+按延迟队列重建某队列的到期索引，并按消息组hash重建消息组set
+KEYS[1] 延迟队列  KEYS[2] 到期索引  KEYS[3] 消息组hash  KEYS[4] 消息组set
+ARGV[1] 队列名
+*/
+var reindexScript = redis.NewScript(4, dueIndexLua+`
+reindex(KEYS[1], KEYS[2], ARGV[1])
+redis.call('DEL', KEYS[4])
+for _, group in ipairs(redis.call('HVALS', KEYS[3])) do
+	redis.call('SADD', KEYS[4], group)
+end
+return 0
+`)
+
+/*
+死信队列中的消息分批移回来源队列，每批从准备队列队尾（先入的一端）跳过offset条后最多检查size条
+KEYS[1] 死信准备队列  KEYS[2] 死信消息体hash  KEYS[3] 死信来源hash  KEYS[4] 死信接收次数hash  KEYS[5] 死信回执hash
+KEYS[6] 死信入列时间zset  KEYS[7] 死信消息属性hash  KEYS[8] 死信首次接收时间hash
+ARGV[1] offset  ARGV[2] size  ARGV[3] 本批最多移动条数
+ARGV[4] 准备队列前缀  ARGV[5] 消息体hash前缀  ARGV[6] 入列时间zset前缀  ARGV[7] 消息属性hash前缀  ARGV[8...] 仍存在的来源队列
+移动的消息先在原位置标记为空串，最后从队尾一次删除，不逐条LREM，保留原入列时间和消息属性
+返回 {移动条数, 留在死信队列的条数, 本批读取的条数}
+*/
+var redriveScript = redis.NewScript(8, readyLua+`
+local offset, size, limit = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local allowed = {}
+for i = 8, #ARGV do
+	allowed[ARGV[i]] = true
+end
+local ids = redis.call('LRANGE', KEYS[1], -(offset + size), -(offset + 1))
+if #ids == 0 then
+	return {0, 0, 0}
+end
+local sources = redis.call('HMGET', KEYS[3], unpack(ids))
+local moved, removed, kept = 0, 0, 0
+for i = #ids, 1, -1 do
+	if moved >= limit then
+		break
+	end
+	local id, source = ids[i], sources[i]
+	if source and allowed[source] then
+		redis.call('LSET', KEYS[1], -(offset + #ids - i + 1), '')
+		removed = removed + 1
+		local body = redis.call('HGET', KEYS[2], id)
+		local sentAt = redis.call('ZSCORE', KEYS[6], id)
+		local attributes = redis.call('HGET', KEYS[7], id)
+		redis.call('HDEL', KEYS[2], id)
+		redis.call('HDEL', KEYS[3], id)
+		redis.call('HDEL', KEYS[4], id)
+		redis.call('HDEL', KEYS[5], id)
+		redis.call('ZREM', KEYS[6], id)
+		redis.call('HDEL', KEYS[7], id)
+		redis.call('HDEL', KEYS[8], id)
+		if body then
+			redis.call('HSET', ARGV[5] .. source, id, body)
+			redis.call('LPUSH', ARGV[4] .. source, id)
+			signalReady(ARGV[4] .. source, 1)
+			if sentAt then
+				redis.call('ZADD', ARGV[6] .. source, sentAt, id)
+			end
+			if attributes then
+				redis.call('HSET', ARGV[7] .. source, id, attributes)
+			end
+			moved = moved + 1
+		end
+	else
+		kept = kept + 1
+	end
+end
+if removed > 0 then
+	redis.call('LREM', KEYS[1], -removed, '')
+end
+return {moved, kept, #ids}
+`)
+
+/*
+统计队列中各状态的消息数
+KEYS[1] 准备队列  KEYS[2] 延迟队列  KEYS[3] 隐藏中消息set  KEYS[4] 入列时间zset  KEYS[5] 消息组hash  KEYS[6] 消息组set
+延迟队列中隐藏中的消息另外记在set中，其余为延迟消息，准备包括各优先级的准备队列
+每个消息组只有第一条在准备或延迟队列中，排在后面的（消息组hash的条数减去消息组数）也算作准备
+只读取各键的长度，不遍历消息
+返回 {准备, 延迟, 隐藏中, 最早入列时间}，队列为空时最早入列时间为0
+*/
+var statsScript = redis.NewScript(6, readyLua+`
+local inFlight = redis.call('SCARD', KEYS[3])
+local delayed = math.max(redis.call('ZCARD', KEYS[2]) - inFlight, 0)
+local ready = math.max(redis.call('HLEN', KEYS[5]) - redis.call('SCARD', KEYS[6]), 0)
+for priority = 0, 9 do
+	ready = ready + redis.call('LLEN', readyKey(KEYS[1], priority))
+end
+local oldest = redis.call('ZRANGE', KEYS[4], 0, 0, 'WITHSCORES')
+local sentAt = 0
+if #oldest > 0 then
+	sentAt = tonumber(oldest[2])
+end
+return {ready, delayed, inFlight, sentAt}
+`)
+
+/*
+按出列顺序查看准备队列中的消息，不改变消息状态，从最高优先级开始，不考虑老化
+KEYS[1] 准备队列  KEYS[2] 消息体hash  KEYS[3] 属性hash  KEYS[4] 入列时间zset  KEYS[5] 首次接收时间hash  KEYS[6] 接收次数hash
+ARGV[1] 最多条数
+每条消息返回 消息ID, 消息体, 属性json或'', 入列时间, 首次接收时间, 接收次数
+*/
+var peekScript = redis.NewScript(6, readyLua+`
+local max = tonumber(ARGV[1])
+local result, count = {}, 0
+for priority = 9, 0, -1 do
+	if count >= max then
+		break
+	end
+	local ids = redis.call('LRANGE', readyKey(KEYS[1], priority), -(max - count), -1)
+	for i = #ids, 1, -1 do
+		local id = ids[i]
+		local body = redis.call('HGET', KEYS[2], id)
+		if body then
+			table.insert(result, id)
+			table.insert(result, body)
+			table.insert(result, redis.call('HGET', KEYS[3], id) or '')
+			table.insert(result, redis.call('ZSCORE', KEYS[4], id) or '0')
+			table.insert(result, redis.call('HGET', KEYS[5], id) or '0')
+			table.insert(result, redis.call('HGET', KEYS[6], id) or '0')
+			count = count + 1
+		end
+	end
+end
+return result
+`)
+
+/*
+按到期时间先后查看延迟队列中未被接收过的消息，有回执的是隐藏中的消息，跳过
+KEYS[1] 延迟队列  KEYS[2] 回执hash  KEYS[3] 消息体hash  KEYS[4] 属性hash  KEYS[5] 入列时间zset
+ARGV[1] 最多条数
+延迟队列按排名分段读取，每段最多条数，够数后不再读取，不一次读出整个延迟队列
+每条消息返回 消息ID, 消息体, 属性json或'', 入列时间, 到期时间
+*/
+var scheduledScript = redis.NewScript(5, `
+local max = tonumber(ARGV[1])
+local result, count, offset = {}, 0, 0
+while count < max do
+	local ids = redis.call('ZRANGE', KEYS[1], offset, offset + max - 1, 'WITHSCORES')
+	for i = 1, #ids, 2 do
+		if count >= max then
+			break
+		end
+		local id = ids[i]
+		local body = redis.call('HGET', KEYS[3], id)
+		if body and redis.call('HEXISTS', KEYS[2], id) == 0 then
+			table.insert(result, id)
+			table.insert(result, body)
+			table.insert(result, redis.call('HGET', KEYS[4], id) or '')
+			table.insert(result, redis.call('ZSCORE', KEYS[5], id) or '0')
+			table.insert(result, ids[i + 1])
+			count = count + 1
+		end
+	end
+	if #ids < max * 2 then
+		break
+	end
+	offset = offset + max
+end
+return result
+`)
+
+/*
+取消未到期的消息，KEYS与删除消息相同
+ARGV[1] 消息组列表前缀  ARGV[2] 消息ID
+消息不在延迟队列中或已被接收过时返回0，检查与删除在同一脚本内完成，不会删除已到期进入准备队列的消息
+*/
+var cancelScheduledScript = redis.NewScript(13, readyLua+groupLua+`
+local id = ARGV[2]
+if not redis.call('ZSCORE', KEYS[2], id) or redis.call('HEXISTS', KEYS[4], id) == 1 then
+	return 0
+end
+redis.call('ZREM', KEYS[2], id)
+for k = 3, 6 do
+	redis.call('HDEL', KEYS[k], id)
+end
+for k = 9, 11 do
+	redis.call('HDEL', KEYS[k], id)
+end
+redis.call('ZREM', KEYS[7], id)
+releaseGroup(KEYS[1], KEYS[8], KEYS[12], ARGV[1], id)
+return 1
+`)
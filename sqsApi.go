@@ -0,0 +1,807 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//SQS兼容接口，AWS SDK把endpoint指向yumiQ即可使用
+//同时支持query协议（表单请求、XML响应）和JSON协议（X-Amz-Target头），不校验签名，只用于本地开发和内网部署
+const (
+	sqsAccountId = "000000000000" //队列URL和ARN中的账号
+	sqsRegion    = "us-east-1"
+	sqsNamespace = "http://queue.amazonaws.com/doc/2012-11-05/"
+
+	sqsMaxBatch             = 10    //SQS批量操作最多条数
+	sqsMaxWaitSeconds       = 20    //ReceiveMessage最长等待秒数
+	sqsMaxDelaySeconds      = 900   //消息最长延迟15分钟
+	sqsMaxVisibilityTimeout = 43200 //隐藏时间最长12小时
+)
+
+type sqsHandler func(req *http.Request, in *sqsInput) (interface{}, error)
+
+var sqsActions = map[string]sqsHandler{
+	"CreateQueue":             sqsCreateQueue,
+	"GetQueueUrl":             sqsGetQueueUrl,
+	"SendMessage":             sqsSendMessage,
+	"SendMessageBatch":        sqsSendMessageBatch,
+	"ReceiveMessage":          sqsReceiveMessage,
+	"DeleteMessage":           sqsDeleteMessage,
+	"ChangeMessageVisibility": sqsChangeMessageVisibility,
+	"GetQueueAttributes":      sqsGetQueueAttributes,
+	"PurgeQueue":              sqsPurgeQueue,
+}
+
+//SQS的错误，Type为JSON协议的错误类型，Code为query协议的错误码
+type sqsError struct {
+	Status  int
+	Type    string
+	Code    string
+	Message string
+}
+
+func (this *sqsError) Error() string {
+	return this.Code + ": " + this.Message
+}
+
+func sqsInvalid(format string, args ...interface{}) error {
+	return &sqsError{http.StatusBadRequest, "InvalidParameterValue", "InvalidParameterValue", fmt.Sprintf(format, args...)}
+}
+
+func sqsMissing(name string) error {
+	return &sqsError{http.StatusBadRequest, "MissingParameter", "MissingParameter", "The request must contain the parameter " + name + "."}
+}
+
+func sqsNoQueue() error {
+	return &sqsError{http.StatusBadRequest, "QueueDoesNotExist", "AWS.SimpleQueueService.NonExistentQueue", "The specified queue does not exist."}
+}
+
+func sqsInvalidReceipt(err error) error {
+	return &sqsError{http.StatusBadRequest, "ReceiptHandleIsInvalid", "ReceiptHandleIsInvalid", err.Error()}
+}
+
+//按错误类型转换，没有类型的错误来自存储
+func sqsFail(err error) error {
+	switch errorKind(err) {
+	case ErrNotFound:
+		return sqsNoQueue()
+	case ErrInvalid, ErrConflict:
+		return sqsInvalid("%s", err.Error())
+	}
+	return &sqsError{http.StatusInternalServerError, "InternalError", "InternalError", err.Error()}
+}
+
+//SQS的请求参数，JSON协议按字段名解码，query协议由sqsQueryInput从表单中读取
+type sqsInput struct {
+	QueueName                   string
+	QueueUrl                    string
+	Attributes                  map[string]string
+	AttributeNames              []string
+	MessageSystemAttributeNames []string
+	MessageAttributeNames       []string
+	sqsSendEntry
+	Entries             []sqsSendEntry
+	MaxNumberOfMessages int64
+	WaitTimeSeconds     int64
+	VisibilityTimeout   *int64
+	ReceiptHandle       string
+}
+
+//SendMessage和SendMessageBatch的一条消息
+type sqsSendEntry struct {
+	Id                     string
+	MessageBody            string
+	DelaySeconds           *int64
+	MessageGroupId         string
+	MessageDeduplicationId string
+	MessageAttributes      map[string]sqsMessageAttribute
+}
+
+//消息属性，yumiQ的消息属性只保存字符串，不支持二进制
+type sqsMessageAttribute struct {
+	StringValue string `json:",omitempty" xml:",omitempty"`
+	BinaryValue []byte `json:",omitempty" xml:",omitempty"`
+	DataType    string
+}
+
+//按属性名排序写成query协议的 <Attribute><Name/><Value/></Attribute> 列表
+type sqsAttributes map[string]string
+
+func (this sqsAttributes) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	names := make([]string, 0, len(this))
+	for name := range this {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := e.EncodeElement(struct{ Name, Value string }{name, this[name]}, start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sqsMessageAttributes map[string]sqsMessageAttribute
+
+func (this sqsMessageAttributes) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	names := make([]string, 0, len(this))
+	for name := range this {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entry := struct {
+			Name  string
+			Value sqsMessageAttribute
+		}{name, this[name]}
+		if err := e.EncodeElement(entry, start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sqsQueueUrlResult struct {
+	QueueUrl string
+}
+
+type sqsSendResult struct {
+	Id                     string `json:",omitempty" xml:",omitempty"` //只用于批量
+	MessageId              string
+	MD5OfMessageBody       string
+	MD5OfMessageAttributes string `json:",omitempty" xml:",omitempty"`
+}
+
+type sqsBatchError struct {
+	Id          string
+	SenderFault bool
+	Code        string
+	Message     string
+}
+
+type sqsSendBatchResult struct {
+	Successful []sqsSendResult `xml:"SendMessageBatchResultEntry"`
+	Failed     []sqsBatchError `xml:"BatchResultErrorEntry"`
+}
+
+type sqsMessage struct {
+	MessageId              string
+	ReceiptHandle          string
+	MD5OfBody              string
+	Body                   string
+	Attributes             sqsAttributes        `json:",omitempty" xml:"Attribute,omitempty"`
+	MD5OfMessageAttributes string               `json:",omitempty" xml:",omitempty"`
+	MessageAttributes      sqsMessageAttributes `json:",omitempty" xml:"MessageAttribute,omitempty"`
+}
+
+type sqsReceiveResult struct {
+	Messages []sqsMessage `json:",omitempty" xml:"Message"`
+}
+
+type sqsAttributesResult struct {
+	Attributes sqsAttributes `xml:"Attribute"`
+}
+
+//query协议的错误响应
+type sqsErrorResponse struct {
+	XMLName   xml.Name `xml:"ErrorResponse"`
+	Error     sqsErrorDetail
+	RequestId string
+}
+
+type sqsErrorDetail struct {
+	Type    string
+	Code    string
+	Message string
+}
+
+//处理SQS请求，返回操作名用于请求耗时指标，未知操作时返回other
+func ServeSqs(res http.ResponseWriter, req *http.Request) string {
+	var action string
+	var in sqsInput
+	var err error
+
+	target := req.Header.Get("X-Amz-Target")
+	jsonProtocol := strings.HasPrefix(target, "AmazonSQS.")
+	if jsonProtocol {
+		action = strings.TrimPrefix(target, "AmazonSQS.")
+		if err = json.NewDecoder(req.Body).Decode(&in); err == io.EOF {
+			err = nil
+		} else if err != nil {
+			err = &sqsError{http.StatusBadRequest, "SerializationException", "SerializationException", err.Error()}
+		}
+	} else {
+		req.ParseForm()
+		action = req.Form.Get("Action")
+		in, err = sqsQueryInput(req.Form)
+	}
+
+	//query协议可以直接请求队列URL
+	if in.QueueUrl == "" && strings.HasPrefix(req.URL.Path, "/"+sqsAccountId+"/") {
+		in.QueueUrl = req.URL.Path
+	}
+
+	route := "other"
+	var result interface{}
+	if handler, ok := sqsActions[action]; !ok {
+		if jsonProtocol {
+			err = &sqsError{http.StatusBadRequest, "UnknownOperationException", "UnknownOperationException", "unknown operation " + action}
+		} else {
+			err = &sqsError{http.StatusBadRequest, "InvalidAction", "InvalidAction", "The action " + action + " is not valid for this endpoint."}
+		}
+	} else if route = "sqs:" + action; err == nil {
+		result, err = handler(req, &in)
+	}
+
+	requestId := newID()
+	res.Header().Set("x-amzn-RequestId", requestId)
+	if jsonProtocol {
+		sqsWriteJSON(res, result, err)
+	} else {
+		sqsWriteXML(res, action, requestId, result, err)
+	}
+	return route
+}
+
+func sqsWriteJSON(res http.ResponseWriter, result interface{}, err error) {
+	res.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	if err != nil {
+		e := sqsErrorOf(err)
+		res.Header().Set("x-amzn-query-error", e.Code+";"+e.fault())
+		res.WriteHeader(e.Status)
+		data, _ := json.Marshal(map[string]string{"__type": "com.amazonaws.sqs#" + e.Type, "message": e.Message})
+		res.Write(data)
+		return
+	}
+
+	if result == nil {
+		result = struct{}{}
+	}
+	data, err := json.Marshal(result)
+	must(err)
+	res.Write(data)
+}
+
+func sqsWriteXML(res http.ResponseWriter, action string, requestId string, result interface{}, err error) {
+	res.Header().Set("Content-Type", "text/xml")
+	if err != nil {
+		e := sqsErrorOf(err)
+		res.WriteHeader(e.Status)
+		io.WriteString(res, xml.Header)
+		xml.NewEncoder(res).Encode(sqsErrorResponse{Error: sqsErrorDetail{e.fault(), e.Code, e.Message}, RequestId: requestId})
+		return
+	}
+
+	io.WriteString(res, xml.Header)
+	enc := xml.NewEncoder(res)
+	start := xml.StartElement{Name: xml.Name{Local: action + "Response"}, Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: sqsNamespace}}}
+	enc.EncodeToken(start)
+	if result != nil {
+		enc.EncodeElement(result, xml.StartElement{Name: xml.Name{Local: action + "Result"}})
+	}
+	enc.EncodeElement(struct{ RequestId string }{requestId}, xml.StartElement{Name: xml.Name{Local: "ResponseMetadata"}})
+	enc.EncodeToken(start.End())
+	enc.Flush()
+}
+
+func sqsErrorOf(err error) *sqsError {
+	if e, ok := err.(*sqsError); ok {
+		return e
+	}
+	return sqsFail(err).(*sqsError)
+}
+
+func (this *sqsError) fault() string {
+	if this.Status >= http.StatusInternalServerError {
+		return "Receiver"
+	}
+	return "Sender"
+}
+
+//query协议的参数，列表按 名字.序号 从1开始编号
+func sqsQueryInput(form url.Values) (in sqsInput, err error) {
+	in.QueueName = form.Get("QueueName")
+	in.QueueUrl = form.Get("QueueUrl")
+	in.ReceiptHandle = form.Get("ReceiptHandle")
+	in.AttributeNames = sqsQueryList(form, "AttributeName")
+	in.MessageSystemAttributeNames = sqsQueryList(form, "MessageSystemAttributeName")
+	in.MessageAttributeNames = sqsQueryList(form, "MessageAttributeName")
+
+	for i := 1; form.Get("Attribute."+strconv.Itoa(i)+".Name") != ""; i++ {
+		if in.Attributes == nil {
+			in.Attributes = make(map[string]string)
+		}
+		prefix := "Attribute." + strconv.Itoa(i)
+		in.Attributes[form.Get(prefix+".Name")] = form.Get(prefix + ".Value")
+	}
+
+	var n *int64
+	if n, err = sqsQueryInt(form, "MaxNumberOfMessages"); err != nil {
+		return
+	} else if n != nil {
+		in.MaxNumberOfMessages = *n
+	}
+	if n, err = sqsQueryInt(form, "WaitTimeSeconds"); err != nil {
+		return
+	} else if n != nil {
+		in.WaitTimeSeconds = *n
+	}
+	if in.VisibilityTimeout, err = sqsQueryInt(form, "VisibilityTimeout"); err != nil {
+		return
+	}
+
+	if in.sqsSendEntry, err = sqsQueryEntry(form, ""); err != nil {
+		return
+	}
+	for i := 1; form.Get("SendMessageBatchRequestEntry."+strconv.Itoa(i)+".Id") != ""; i++ {
+		entry, err := sqsQueryEntry(form, "SendMessageBatchRequestEntry."+strconv.Itoa(i)+".")
+		if err != nil {
+			return in, err
+		}
+		in.Entries = append(in.Entries, entry)
+	}
+	return
+}
+
+func sqsQueryEntry(form url.Values, prefix string) (entry sqsSendEntry, err error) {
+	entry.Id = form.Get(prefix + "Id")
+	entry.MessageBody = form.Get(prefix + "MessageBody")
+	entry.MessageGroupId = form.Get(prefix + "MessageGroupId")
+	entry.MessageDeduplicationId = form.Get(prefix + "MessageDeduplicationId")
+	if entry.DelaySeconds, err = sqsQueryInt(form, prefix+"DelaySeconds"); err != nil {
+		return
+	}
+
+	for i := 1; form.Get(prefix+"MessageAttribute."+strconv.Itoa(i)+".Name") != ""; i++ {
+		if entry.MessageAttributes == nil {
+			entry.MessageAttributes = make(map[string]sqsMessageAttribute)
+		}
+		attribute := prefix + "MessageAttribute." + strconv.Itoa(i)
+		value := sqsMessageAttribute{StringValue: form.Get(attribute + ".Value.StringValue"), DataType: form.Get(attribute + ".Value.DataType")}
+		if binaryValue := form.Get(attribute + ".Value.BinaryValue"); binaryValue != "" {
+			if value.BinaryValue, err = base64.StdEncoding.DecodeString(binaryValue); err != nil {
+				return entry, sqsInvalid("BinaryValue of message attribute is not valid base64")
+			}
+		}
+		entry.MessageAttributes[form.Get(attribute+".Name")] = value
+	}
+	return
+}
+
+func sqsQueryList(form url.Values, prefix string) (values []string) {
+	for i := 1; ; i++ {
+		value, ok := form[prefix+"."+strconv.Itoa(i)]
+		if !ok || len(value) == 0 {
+			return
+		}
+		values = append(values, value[0])
+	}
+}
+
+func sqsQueryInt(form url.Values, name string) (*int64, error) {
+	value := form.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return nil, sqsInvalid("Value %s for parameter %s is invalid.", value, name)
+	}
+	return &n, nil
+}
+
+func sqsQueueUrl(req *http.Request, queueName string) string {
+	return "http://" + req.Host + "/" + sqsAccountId + "/" + queueName
+}
+
+func sqsQueueArn(queueName string) string {
+	return "arn:aws:sqs:" + sqsRegion + ":" + sqsAccountId + ":" + queueName
+}
+
+//从队列URL取出队列名，队列不存在时返回错误
+func sqsQueue(in *sqsInput) (string, error) {
+	if in.QueueUrl == "" {
+		return "", sqsMissing("QueueUrl")
+	}
+	queueName := in.QueueUrl[strings.LastIndex(in.QueueUrl, "/")+1:]
+	if _, ok := Queue.Get(queueName); !ok {
+		return "", sqsNoQueue()
+	}
+	return queueName, nil
+}
+
+//SQS的隐藏时间为0时消息立即可见，yumiQ中0表示队列的隐藏时间，按1秒处理
+func sqsVisibility(visibilityTimeout int64) int64 {
+	if visibilityTimeout == 0 {
+		return 1
+	}
+	return visibilityTimeout
+}
+
+func sqsMD5(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+//消息属性的MD5，算法与SQS相同，SDK会用它校验收发的属性
+//按属性名排序，依次写入带4字节长度的名字、类型，传输类型（字符串为1，二进制为2），以及带长度的值
+func sqsAttributesMD5(attributes map[string]sqsMessageAttribute) string {
+	if len(attributes) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := md5.New()
+	writeField := func(b []byte) {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(b)))
+		hash.Write(size[:])
+		hash.Write(b)
+	}
+	for _, name := range names {
+		attribute := attributes[name]
+		writeField([]byte(name))
+		writeField([]byte(attribute.DataType))
+		if attribute.BinaryValue != nil {
+			hash.Write([]byte{2})
+			writeField(attribute.BinaryValue)
+		} else {
+			hash.Write([]byte{1})
+			writeField([]byte(attribute.StringValue))
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+//转换为入列的消息，同时返回消息属性的MD5
+func sqsPushEntry(send sqsSendEntry) (entry PushEntry, attributesMD5 string, err error) {
+	if send.MessageBody == "" {
+		return entry, "", sqsMissing("MessageBody")
+	}
+	entry = PushEntry{Body: send.MessageBody, MessageGroupId: send.MessageGroupId, DeduplicationId: send.MessageDeduplicationId}
+	if send.DelaySeconds != nil {
+		if *send.DelaySeconds < 0 || *send.DelaySeconds > sqsMaxDelaySeconds {
+			return entry, "", sqsInvalid("DelaySeconds must be between 0 and %d", sqsMaxDelaySeconds)
+		}
+		entry.DelaySeconds = toString(*send.DelaySeconds)
+	}
+
+	if len(send.MessageAttributes) != 0 {
+		attributes := make(map[string]string)
+		for name, attribute := range send.MessageAttributes {
+			if !strings.HasPrefix(attribute.DataType, "String") && !strings.HasPrefix(attribute.DataType, "Number") {
+				return entry, "", sqsInvalid("The message attribute '%s' has an unsupported data type %s.", name, attribute.DataType)
+			}
+			attributes[name] = attribute.StringValue
+			//接收时按原数据类型返回，MD5才与发送时一致
+			if attribute.DataType != "String" {
+				if entry.AttributeTypes == nil {
+					entry.AttributeTypes = make(map[string]string)
+				}
+				entry.AttributeTypes[name] = attribute.DataType
+			}
+		}
+		data, err := json.Marshal(attributes)
+		must(err)
+		entry.Attributes = string(data)
+	}
+	return entry, sqsAttributesMD5(send.MessageAttributes), nil
+}
+
+//队列已存在时直接返回队列URL，不比较属性
+//SQS没有的默认值按SQS处理：隐藏时间30秒，消息保存4天
+func sqsCreateQueue(req *http.Request, in *sqsInput) (interface{}, error) {
+	if in.QueueName == "" {
+		return nil, sqsMissing("QueueName")
+	}
+	if _, ok := Queue.Get(in.QueueName); ok {
+		return sqsQueueUrlResult{sqsQueueUrl(req, in.QueueName)}, nil
+	}
+
+	optionQueue := OptionQueue{QueueName: in.QueueName, VisibilityTimeout: "30", MessageRetentionPeriod: "345600"}
+	for name, value := range in.Attributes {
+		switch name {
+		case "VisibilityTimeout", "MessageRetentionPeriod", "DelaySeconds":
+			if _, err := strconv.ParseInt(value, 10, 32); err != nil {
+				return nil, sqsInvalid("Invalid value for the parameter %s.", name)
+			}
+			switch name {
+			case "VisibilityTimeout":
+				optionQueue.VisibilityTimeout = value
+			case "MessageRetentionPeriod":
+				optionQueue.MessageRetentionPeriod = value
+			case "DelaySeconds":
+				optionQueue.DelaySeconds = value
+			}
+		case "FifoQueue":
+			optionQueue.FifoQueue = value
+		case "ContentBasedDeduplication":
+			optionQueue.ContentBasedDedup = value
+		case "RedrivePolicy":
+			var policy map[string]interface{}
+			if err := json.Unmarshal([]byte(value), &policy); err != nil {
+				return nil, sqsInvalid("Invalid value for the parameter RedrivePolicy.")
+			}
+			arn := fmt.Sprint(policy["deadLetterTargetArn"])
+			optionQueue.DeadLetterQueue = arn[strings.LastIndex(arn, ":")+1:]
+			optionQueue.MaxReceiveCount = fmt.Sprint(policy["maxReceiveCount"])
+		}
+		//其他属性（如MaximumMessageSize、加密）yumiQ没有对应配置，忽略
+	}
+
+	if err := YumiQ.Create(optionQueue); err != nil && errorKind(err) != ErrConflict {
+		return nil, sqsFail(err)
+	}
+	return sqsQueueUrlResult{sqsQueueUrl(req, in.QueueName)}, nil
+}
+
+func sqsGetQueueUrl(req *http.Request, in *sqsInput) (interface{}, error) {
+	if in.QueueName == "" {
+		return nil, sqsMissing("QueueName")
+	}
+	if _, ok := Queue.Get(in.QueueName); !ok {
+		return nil, sqsNoQueue()
+	}
+	return sqsQueueUrlResult{sqsQueueUrl(req, in.QueueName)}, nil
+}
+
+func sqsSendMessage(req *http.Request, in *sqsInput) (interface{}, error) {
+	queueName, err := sqsQueue(in)
+	if err != nil {
+		return nil, err
+	}
+	entry, attributesMD5, err := sqsPushEntry(in.sqsSendEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := YumiQ.Push(queueName, entry)
+	if err != nil {
+		return nil, sqsFail(err)
+	}
+	return sqsSendResult{"", id, sqsMD5(entry.Body), attributesMD5}, nil
+}
+
+//单条消息的错误放在Failed中，整批的错误直接返回
+func sqsSendMessageBatch(req *http.Request, in *sqsInput) (interface{}, error) {
+	queueName, err := sqsQueue(in)
+	if err != nil {
+		return nil, err
+	}
+	if len(in.Entries) == 0 {
+		return nil, &sqsError{http.StatusBadRequest, "EmptyBatchRequest", "AWS.SimpleQueueService.EmptyBatchRequest", "There should be at least one SendMessageBatchRequestEntry in the request."}
+	}
+	if len(in.Entries) > sqsMaxBatch || len(in.Entries) > MaxBatch {
+		return nil, &sqsError{http.StatusBadRequest, "TooManyEntriesInBatchRequest", "AWS.SimpleQueueService.TooManyEntriesInBatchRequest", fmt.Sprintf("Maximum number of entries per request are %d.", MaxBatch)}
+	}
+
+	result := sqsSendBatchResult{[]sqsSendResult{}, []sqsBatchError{}}
+	ids := make(map[string]bool)
+	var entries []PushEntry
+	var sends []sqsSendResult //entries中每条消息对应的结果，入列后填入消息ID
+	for _, send := range in.Entries {
+		if ids[send.Id] {
+			return nil, &sqsError{http.StatusBadRequest, "BatchEntryIdsNotDistinct", "AWS.SimpleQueueService.BatchEntryIdsNotDistinct", "Id " + send.Id + " repeated."}
+		}
+		ids[send.Id] = true
+
+		entry, attributesMD5, err := sqsPushEntry(send)
+		if err != nil {
+			e := sqsErrorOf(err)
+			result.Failed = append(result.Failed, sqsBatchError{send.Id, true, e.Code, e.Message})
+			continue
+		}
+		entries = append(entries, entry)
+		sends = append(sends, sqsSendResult{send.Id, "", sqsMD5(entry.Body), attributesMD5})
+	}
+	if len(entries) == 0 {
+		return result, nil
+	}
+
+	pushed, errs, err := YumiQ.PushBatch(queueName, entries)
+	if err != nil {
+		return nil, sqsFail(err)
+	}
+	for i, send := range sends {
+		if errs[i] != nil {
+			e := sqsErrorOf(errs[i])
+			result.Failed = append(result.Failed, sqsBatchError{send.Id, true, e.Code, e.Message})
+			continue
+		}
+		send.MessageId = pushed[i]
+		result.Successful = append(result.Successful, send)
+	}
+	return result, nil
+}
+
+//没有消息时返回空列表，系统属性和消息属性只返回请求的
+func sqsReceiveMessage(req *http.Request, in *sqsInput) (interface{}, error) {
+	queueName, err := sqsQueue(in)
+	if err != nil {
+		return nil, err
+	}
+	//-maxBatch小于SQS的上限时按-maxBatch校验
+	maxMessages, maxBatch := in.MaxNumberOfMessages, int64(sqsMaxBatch)
+	if int64(MaxBatch) < maxBatch {
+		maxBatch = int64(MaxBatch)
+	}
+	if maxMessages == 0 {
+		maxMessages = 1
+	} else if maxMessages < 1 || maxMessages > maxBatch {
+		return nil, sqsInvalid("Value %d for parameter MaxNumberOfMessages is invalid. Reason: Must be between 1 and %d, if provided.", maxMessages, maxBatch)
+	}
+	if in.WaitTimeSeconds < 0 || in.WaitTimeSeconds > sqsMaxWaitSeconds {
+		return nil, sqsInvalid("Value %d for parameter WaitTimeSeconds is invalid. Reason: Must be >= 0 and <= %d, if provided.", in.WaitTimeSeconds, sqsMaxWaitSeconds)
+	}
+	if in.VisibilityTimeout != nil && (*in.VisibilityTimeout < 0 || *in.VisibilityTimeout > sqsMaxVisibilityTimeout) {
+		return nil, sqsInvalid("Value %d for parameter VisibilityTimeout is invalid.", *in.VisibilityTimeout)
+	}
+
+	//请求中的隐藏时间覆盖队列的隐藏时间，出列时直接作为到期时间
+	var visibilityTimeout int64
+	if in.VisibilityTimeout != nil {
+		visibilityTimeout = sqsVisibility(*in.VisibilityTimeout)
+	}
+
+	messages, err := YumiQ.PopContext(req.Context(), queueName, int(in.WaitTimeSeconds), int(maxMessages), visibilityTimeout)
+	if errorKind(err) == ErrEmpty {
+		return sqsReceiveResult{}, nil
+	} else if err != nil {
+		return nil, sqsFail(err)
+	}
+
+	systemNames := append(append([]string{}, in.AttributeNames...), in.MessageSystemAttributeNames...)
+	var result sqsReceiveResult
+	for _, message := range messages {
+		//时间戳按SQS的毫秒返回
+		system := sqsAttributes{
+			"SentTimestamp":                    toString(message.SentTimestamp * 1000),
+			"ApproximateFirstReceiveTimestamp": toString(message.ApproximateFirstReceiveTimestamp * 1000),
+			"ApproximateReceiveCount":          toString(message.ApproximateReceiveCount),
+		}
+		m := sqsMessage{MessageId: message.MessageId, ReceiptHandle: message.ReceiptHandle, MD5OfBody: sqsMD5(message.Body), Body: message.Body,
+			Attributes: sqsSelect(system, systemNames)}
+
+		attributes := make(map[string]string)
+		for name, value := range message.Attributes {
+			attributes[name] = value
+		}
+		if selected := sqsSelect(attributes, in.MessageAttributeNames); len(selected) != 0 {
+			m.MessageAttributes = make(sqsMessageAttributes)
+			for name, value := range selected {
+				dataType := message.dataTypes[name]
+				if dataType == "" {
+					dataType = "String"
+				}
+				m.MessageAttributes[name] = sqsMessageAttribute{StringValue: value, DataType: dataType}
+			}
+			m.MD5OfMessageAttributes = sqsAttributesMD5(m.MessageAttributes)
+		}
+		result.Messages = append(result.Messages, m)
+	}
+	return result, nil
+}
+
+//按名字选出属性，All或.*为全部，以.*结尾的按前缀匹配
+func sqsSelect(attributes map[string]string, names []string) sqsAttributes {
+	selected := make(sqsAttributes)
+	for _, name := range names {
+		for key, value := range attributes {
+			if name == "All" || name == ".*" || name == key || (strings.HasSuffix(name, ".*") && strings.HasPrefix(key, strings.TrimSuffix(name, "*"))) {
+				selected[key] = value
+			}
+		}
+	}
+	return selected
+}
+
+func sqsDeleteMessage(req *http.Request, in *sqsInput) (interface{}, error) {
+	queueName, err := sqsQueue(in)
+	if err != nil {
+		return nil, err
+	}
+	if in.ReceiptHandle == "" {
+		return nil, sqsMissing("ReceiptHandle")
+	}
+
+	if err := YumiQ.Del(queueName, in.ReceiptHandle); errorKind(err) == ErrInvalid || errorKind(err) == ErrNotFound {
+		return nil, sqsInvalidReceipt(err)
+	} else if err != nil {
+		return nil, sqsFail(err)
+	}
+	return nil, nil
+}
+
+func sqsChangeMessageVisibility(req *http.Request, in *sqsInput) (interface{}, error) {
+	queueName, err := sqsQueue(in)
+	if err != nil {
+		return nil, err
+	}
+	if in.ReceiptHandle == "" {
+		return nil, sqsMissing("ReceiptHandle")
+	}
+	if in.VisibilityTimeout == nil {
+		return nil, sqsMissing("VisibilityTimeout")
+	}
+	if *in.VisibilityTimeout < 0 || *in.VisibilityTimeout > sqsMaxVisibilityTimeout {
+		return nil, sqsInvalid("Value %d for parameter VisibilityTimeout is invalid.", *in.VisibilityTimeout)
+	}
+
+	if err := YumiQ.SetVisibilityTime(queueName, in.ReceiptHandle, sqsVisibility(*in.VisibilityTimeout)); errorKind(err) == ErrConflict {
+		return nil, &sqsError{http.StatusBadRequest, "MessageNotInflight", "AWS.SimpleQueueService.MessageNotInflight", err.Error()}
+	} else if errorKind(err) == ErrInvalid || errorKind(err) == ErrNotFound {
+		return nil, sqsInvalidReceipt(err)
+	} else if err != nil {
+		return nil, sqsFail(err)
+	}
+	return nil, nil
+}
+
+//只返回AttributeNames中的属性，All为全部
+func sqsGetQueueAttributes(req *http.Request, in *sqsInput) (interface{}, error) {
+	queueName, err := sqsQueue(in)
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := YumiQ.GetQueueAttributes(queueName)
+	if err != nil {
+		return nil, sqsFail(err)
+	}
+
+	all := map[string]string{
+		"QueueArn":                              sqsQueueArn(queueName),
+		"VisibilityTimeout":                     attributes.VisibilityTimeout,
+		"MessageRetentionPeriod":                attributes.MessageRetentionPeriod,
+		"DelaySeconds":                          attributes.DelaySeconds,
+		"CreatedTimestamp":                      attributes.CreatedTimestamp,
+		"LastModifiedTimestamp":                 attributes.LastModifiedTimestamp,
+		"ApproximateNumberOfMessages":           toString(attributes.ReadyMessages),
+		"ApproximateNumberOfMessagesNotVisible": toString(attributes.InFlightMessages),
+		"ApproximateNumberOfMessagesDelayed":    toString(attributes.DelayedMessages),
+	}
+	if attributes.DeadLetterQueue != "" {
+		policy, _ := json.Marshal(map[string]interface{}{"deadLetterTargetArn": sqsQueueArn(attributes.DeadLetterQueue), "maxReceiveCount": toInt64(attributes.MaxReceiveCount)})
+		all["RedrivePolicy"] = string(policy)
+	}
+	//与SQS一样，FIFO相关的属性只在FIFO队列中返回
+	if attributes.FifoQueue == "true" {
+		all["FifoQueue"] = "true"
+		all["ContentBasedDeduplication"] = attributes.ContentBasedDedup
+	}
+
+	selected := make(sqsAttributes)
+	for _, name := range in.AttributeNames {
+		for key, value := range all {
+			if name == "All" || name == key {
+				selected[key] = value
+			}
+		}
+	}
+	return sqsAttributesResult{selected}, nil
+}
+
+func sqsPurgeQueue(req *http.Request, in *sqsInput) (interface{}, error) {
+	queueName, err := sqsQueue(in)
+	if err != nil {
+		return nil, err
+	}
+	if err := YumiQ.Purge(queueName); err != nil {
+		return nil, sqsFail(err)
+	}
+	return nil, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+//按SQS的JSON协议请求，返回状态码和响应
+func sqsTestDo(t *testing.T, action string, body string) (int, map[string]interface{}) {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("X-Amz-Target", "AmazonSQS."+action)
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	rec := httptest.NewRecorder()
+	(&WaitForYou{}).ServeHTTP(rec, req)
+
+	var out map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("%s: %s", action, rec.Body.String())
+	}
+	return rec.Code, out
+}
+
+//按SQS的query协议请求，返回状态码和xml响应
+func sqsTestQuery(path string, form url.Values) (int, string) {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	(&WaitForYou{}).ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+//CreateQueue返回后立即可以发送消息
+func TestSqsCreateQueueThenSend(t *testing.T) {
+	v1TestSetup(t)
+
+	code, out := sqsTestDo(t, "CreateQueue", `{"QueueName":"q"}`)
+	if code != http.StatusOK {
+		t.Fatalf("create queue: %d %v", code, out)
+	}
+	queueUrl, _ := out["QueueUrl"].(string)
+
+	code, out = sqsTestDo(t, "SendMessage", `{"QueueUrl":"`+queueUrl+`","MessageBody":"hello"}`)
+	if code != http.StatusOK || out["MessageId"] == nil {
+		t.Fatalf("send message: %d %v", code, out)
+	}
+
+	code, out = sqsTestDo(t, "ReceiveMessage", `{"QueueUrl":"`+queueUrl+`"}`)
+	messages, _ := out["Messages"].([]interface{})
+	if code != http.StatusOK || len(messages) != 1 {
+		t.Fatalf("receive message: %d %v", code, out)
+	}
+}
+
+//JSON协议的发送、接收、修改隐藏时间和删除，错误返回SQS的错误类型
+func TestSqsMessages(t *testing.T) {
+	v1TestSetup(t)
+	_, out := sqsTestDo(t, "CreateQueue", `{"QueueName":"s","Attributes":{"VisibilityTimeout":"40"}}`)
+	queueUrl, _ := out["QueueUrl"].(string)
+	if !strings.HasSuffix(queueUrl, "/000000000000/s") {
+		t.Fatalf("queue url: %v", out)
+	}
+	if code, out := sqsTestDo(t, "GetQueueUrl", `{"QueueName":"missing"}`); code != http.StatusBadRequest || out["__type"] != "com.amazonaws.sqs#QueueDoesNotExist" {
+		t.Fatalf("get url of missing queue: %d %v", code, out)
+	}
+
+	code, out := sqsTestDo(t, "SendMessage", `{"QueueUrl":"`+queueUrl+`","MessageBody":"hi","MessageAttributes":{"k":{"DataType":"String","StringValue":"v"}}}`)
+	if code != http.StatusOK || out["MD5OfMessageBody"] != "49f68a5c8493ec2c0bf489821c21fc3b" || out["MD5OfMessageAttributes"] == nil {
+		t.Fatalf("send message: %d %v", code, out)
+	}
+	attributesMD5 := out["MD5OfMessageAttributes"]
+	code, out = sqsTestDo(t, "SendMessageBatch", `{"QueueUrl":"`+queueUrl+`","Entries":[{"Id":"a","MessageBody":"x"},{"Id":"b","MessageBody":""},{"Id":"c","MessageBody":"y","DelaySeconds":5}]}`)
+	successful, _ := out["Successful"].([]interface{})
+	failed, _ := out["Failed"].([]interface{})
+	if code != http.StatusOK || len(successful) != 2 || len(failed) != 1 || failed[0].(map[string]interface{})["Id"] != "b" {
+		t.Fatalf("send message batch: %d %v", code, out)
+	}
+
+	code, out = sqsTestDo(t, "ReceiveMessage", `{"QueueUrl":"`+queueUrl+`","MaxNumberOfMessages":10,"MessageAttributeNames":["All"],"AttributeNames":["ApproximateReceiveCount"]}`)
+	messages, _ := out["Messages"].([]interface{})
+	if code != http.StatusOK || len(messages) != 2 {
+		t.Fatalf("receive message: %d %v", code, out)
+	}
+	first := messages[0].(map[string]interface{})
+	if first["Body"] != "hi" || first["MD5OfMessageAttributes"] != attributesMD5 {
+		t.Fatalf("received message: %v, attributes md5 %v", first, attributesMD5)
+	}
+	if attributes, _ := first["Attributes"].(map[string]interface{}); len(attributes) != 1 || attributes["ApproximateReceiveCount"] != "1" {
+		t.Fatalf("received attributes: %v", first)
+	}
+
+	_, out = sqsTestDo(t, "GetQueueAttributes", `{"QueueUrl":"`+queueUrl+`","AttributeNames":["All"]}`)
+	attributes, _ := out["Attributes"].(map[string]interface{})
+	if attributes["VisibilityTimeout"] != "40" || attributes["ApproximateNumberOfMessagesNotVisible"] != "2" || attributes["ApproximateNumberOfMessagesDelayed"] != "1" {
+		t.Fatalf("queue attributes: %v", out)
+	}
+
+	receipt, _ := first["ReceiptHandle"].(string)
+	if code, out := sqsTestDo(t, "ChangeMessageVisibility", `{"QueueUrl":"`+queueUrl+`","ReceiptHandle":"`+receipt+`","VisibilityTimeout":100}`); code != http.StatusOK {
+		t.Fatalf("change visibility: %d %v", code, out)
+	}
+	if code, out := sqsTestDo(t, "ChangeMessageVisibility", `{"QueueUrl":"`+queueUrl+`","ReceiptHandle":"`+receipt+`"}`); code != http.StatusBadRequest {
+		t.Fatalf("change visibility without timeout: %d %v", code, out)
+	}
+	if code, out := sqsTestDo(t, "DeleteMessage", `{"QueueUrl":"`+queueUrl+`","ReceiptHandle":"`+receipt+`"}`); code != http.StatusOK {
+		t.Fatalf("delete message: %d %v", code, out)
+	}
+	if code, out := sqsTestDo(t, "DeleteMessage", `{"QueueUrl":"`+queueUrl+`","ReceiptHandle":"`+receipt+`"}`); code != http.StatusBadRequest || out["__type"] != "com.amazonaws.sqs#ReceiptHandleIsInvalid" {
+		t.Fatalf("delete message twice: %d %v", code, out)
+	}
+	if code, _ := sqsTestDo(t, "Bogus", `{}`); code != http.StatusBadRequest {
+		t.Fatalf("unknown action: %d", code)
+	}
+}
+
+//query协议返回xml，消息属性带数据类型，错误返回SQS的错误码
+func TestSqsQueryProtocol(t *testing.T) {
+	v1TestSetup(t)
+	code, body := sqsTestQuery("/", url.Values{"Action": {"CreateQueue"}, "QueueName": {"s"}})
+	if code != http.StatusOK || !strings.Contains(body, "<QueueUrl>") {
+		t.Fatalf("create queue: %d %s", code, body)
+	}
+
+	code, body = sqsTestQuery("/000000000000/s", url.Values{"Action": {"SendMessage"}, "MessageBody": {"q"},
+		"MessageAttribute.1.Name": {"n"}, "MessageAttribute.1.Value.DataType": {"Number"}, "MessageAttribute.1.Value.StringValue": {"5"}})
+	if code != http.StatusOK || !strings.Contains(body, "<SendMessageResponse") || !strings.Contains(body, "<MessageId>") {
+		t.Fatalf("send message: %d %s", code, body)
+	}
+	code, body = sqsTestQuery("/000000000000/s", url.Values{"Action": {"ReceiveMessage"}, "MessageAttributeName.1": {"All"}, "AttributeName.1": {"All"}})
+	if code != http.StatusOK || !strings.Contains(body, "<Attribute><Name>ApproximateFirstReceiveTimestamp</Name>") ||
+		!strings.Contains(body, "<MessageAttribute><Name>n</Name><Value><StringValue>5</StringValue><DataType>Number</DataType></Value></MessageAttribute>") {
+		t.Fatalf("receive message: %d %s", code, body)
+	}
+	code, body = sqsTestQuery("/", url.Values{"Action": {"GetQueueUrl"}, "QueueName": {"missing"}})
+	if code != http.StatusBadRequest || !strings.Contains(body, "<Code>AWS.SimpleQueueService.NonExistentQueue</Code>") {
+		t.Fatalf("get url of missing queue: %d %s", code, body)
+	}
+
+	code, body = sqsTestQuery("/000000000000/s", url.Values{"Action": {"PurgeQueue"}})
+	if code != http.StatusOK || !strings.Contains(body, "<PurgeQueueResponse") {
+		t.Fatalf("purge queue: %d %s", code, body)
+	}
+	if stats, _ := Store.Stats("s"); stats.Ready+stats.Delayed+stats.InFlight != 0 {
+		t.Fatalf("stats after purge: %+v", stats)
+	}
+}
+
+//Number等数据类型随消息保存，接收时属性和MD5与发送时一致，其他接口看不到保留属性
+func TestSqsAttributeDataTypes(t *testing.T) {
+	v1TestSetup(t)
+	_, out := sqsTestDo(t, "CreateQueue", `{"QueueName":"n"}`)
+	queueUrl, _ := out["QueueUrl"].(string)
+
+	_, out = sqsTestDo(t, "SendMessage", `{"QueueUrl":"`+queueUrl+`","MessageBody":"hi","MessageAttributes":{"k":{"DataType":"Number","StringValue":"12"},"s":{"DataType":"String","StringValue":"v"},"c":{"DataType":"Number.int","StringValue":"3"}}}`)
+	attributesMD5 := out["MD5OfMessageAttributes"]
+	if messages, err := YumiQ.Peek("n", 0); err != nil || len(messages) != 1 || len(messages[0].Attributes) != 3 {
+		t.Fatalf("peek: %v %v", messages, err)
+	}
+
+	_, out = sqsTestDo(t, "ReceiveMessage", `{"QueueUrl":"`+queueUrl+`","MessageAttributeNames":["All"]}`)
+	messages, _ := out["Messages"].([]interface{})
+	if len(messages) != 1 {
+		t.Fatalf("receive message: %v", out)
+	}
+	message := messages[0].(map[string]interface{})
+	if message["MD5OfMessageAttributes"] != attributesMD5 {
+		t.Fatalf("attributes md5: %v, want %v", message["MD5OfMessageAttributes"], attributesMD5)
+	}
+	attributes, _ := message["MessageAttributes"].(map[string]interface{})
+	if len(attributes) != 3 || attributes["k"].(map[string]interface{})["DataType"] != "Number" || attributes["c"].(map[string]interface{})["DataType"] != "Number.int" {
+		t.Fatalf("message attributes: %v", attributes)
+	}
+}
+
+//接收时的VisibilityTimeout覆盖队列配置，为0时按1秒，超过MaxBatch的条数返回错误
+func TestSqsReceiveOptions(t *testing.T) {
+	v1TestSetup(t)
+	_, out := sqsTestDo(t, "CreateQueue", `{"QueueName":"v","Attributes":{"VisibilityTimeout":"40"}}`)
+	queueUrl, _ := out["QueueUrl"].(string)
+	sqsTestDo(t, "SendMessage", `{"QueueUrl":"`+queueUrl+`","MessageBody":"a"}`)
+	sqsTestDo(t, "SendMessage", `{"QueueUrl":"`+queueUrl+`","MessageBody":"b"}`)
+
+	if code, out := sqsTestDo(t, "ReceiveMessage", `{"QueueUrl":"`+queueUrl+`","MaxNumberOfMessages":11}`); code != http.StatusBadRequest || out["__type"] == nil {
+		t.Fatalf("receive more than MaxBatch: %d %v", code, out)
+	}
+	if code, out := sqsTestDo(t, "ReceiveMessage", `{"QueueUrl":"`+queueUrl+`","VisibilityTimeout":0}`); code != http.StatusOK || len(out["Messages"].([]interface{})) != 1 {
+		t.Fatalf("receive with zero visibility timeout: %d %v", code, out)
+	}
+	if code, out := sqsTestDo(t, "ReceiveMessage", `{"QueueUrl":"`+queueUrl+`"}`); code != http.StatusOK || len(out["Messages"].([]interface{})) != 1 {
+		t.Fatalf("receive: %d %v", code, out)
+	}
+
+	Store.Promote("v", theMoment()+1, PromoteBatch)
+	if stats, _ := Store.Stats("v"); stats.Ready != 1 || stats.InFlight != 1 {
+		t.Fatalf("stats: %+v", stats)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var (
+	Store Storage //全局存储
+)
+
+//待入列的消息
+type NewMessage struct {
+	Id              string
+	Body            string
+	DeliverAt       int64  //到期时间，为0时直接进入准备队列
+	SentAt          int64  //入列时间
+	GroupId         string //消息组，同组的消息按入列顺序逐条投递，同一时刻只有一条被接收
+	DeduplicationId string //去重ID，DedupUntil之前相同去重ID的消息不再入列
+	DedupUntil      int64
+	Priority        int               //优先级0-maxPriority，数值大的先出列
+	Attributes      map[string]string //消息属性，随消息保存，出列时原样返回
+}
+
+//出列参数
+type PopOption struct {
+	MaxMessages     int    //最多取出条数
+	Deadline        int64  //隐藏截止时间
+	Token           string //回执随机串，回执格式为 消息ID:随机串
+	DeadLetterQueue string //死信队列，为空时不限接收次数
+	MaxReceiveCount int64  //最大接收次数，超过后移入死信队列
+	Now             int64  //当前时间，用于计算优先级老化
+	PriorityAging   int64  //每等待多少秒优先级加一，为0时严格按优先级出列
+}
+
+//队列中各状态的消息数
+type QueueStats struct {
+	Ready        int64 //准备队列中的消息数
+	Delayed      int64 //延迟队列中未被接收过的消息数
+	InFlight     int64 //已被接收、隐藏中的消息数
+	OldestSentAt int64 //最早入列的消息的入列时间，队列为空时为0
+}
+
+//存储接口，队列配置、准备队列、延迟（含隐藏中）队列以及消息体均通过它读写
+//时间、消息ID和回执随机串都由调用方传入，同样的调用顺序得到同样的结果
+type Storage interface {
+	//队列配置
+	SaveOptions(queueName string, opt map[string]string) error
+	GetOptions(queueName string) (map[string]string, error)
+	OptionsExist(queueName string) (bool, error)
+	DelOptions(queueName string) error
+
+	//队列名集合
+	AddQueueName(queueName string) error
+	DelQueueName(queueName string) error
+	ExistsQueueName(queueName string) (bool, error)
+	QueueNames() ([]string, error)
+
+	//主题名集合，删除主题时一并删除它的订阅
+	AddTopicName(topicName string) error
+	DelTopicName(topicName string) error
+	ExistsTopicName(topicName string) (bool, error)
+	TopicNames() ([]string, error)
+
+	//主题的订阅，按订阅的队列名保存订阅配置（json）
+	SaveSubscription(topicName string, queueName string, subscription string) error
+	DelSubscription(topicName string, queueName string) error
+	Subscriptions(topicName string) (map[string]string, error)
+	//订阅过滤掉的消息数，按订阅的队列名计数，删除订阅或主题时一并删除
+	AddFiltered(topicName string, queueNames []string) error
+	FilteredCounts(topicName string) (map[string]int64, error)
+
+	//入列，DeliverAt为0的进入准备队列，其余进入延迟队列
+	//有消息组的排在组内，只有组内第一条进入准备或延迟队列，该条删除后下一条进入准备队列
+	//返回每条消息实际的ID，去重时为之前入列的消息ID
+	Push(queueName string, messages []NewMessage) ([]string, error)
+	//同时入列到多个队列，要么全部入列要么都不入列，返回每个队列中消息实际的ID
+	PushMulti(messages map[string][]NewMessage) (map[string][]string, error)
+	//出列并隐藏到opt.Deadline，准备队列为空时返回空列表
+	Pop(queueName string, opt PopOption) ([]Message, error)
+	//阻塞到有消息进入准备队列、超过timeout或ctx结束，ctx结束时返回ctx的错误，返回时准备队列仍可能为空
+	Wait(ctx context.Context, queueName string, timeout time.Duration) error
+	//校验回执，只有最近一次接收的回执有效，返回对应的消息ID
+	CheckReceipt(queueName string, receiptHandle string) (string, error)
+	//删除消息，不论在准备队列还是延迟队列
+	Delete(queueName string, id string) error
+	//修改隐藏中消息的到期时间，消息已不在延迟队列时返回false
+	ChangeVisibility(queueName string, id string, deadline int64) (bool, error)
+	//把到期时间不晚于now的消息移到准备队列，最多limit条，返回移动的条数
+	Promote(queueName string, now int64, limit int) (int, error)
+	//到期时间早于before的延迟消息直接删除，返回删除的条数
+	Clean(queueName string, before int64) (int, error)
+	//死信准备队列中的消息移回来源队列，exists判断来源队列是否仍存在
+	Redrive(queueName string, sourceQueue string, maxMessages int64, exists func(string) bool) (int64, error)
+	//删除队列的全部消息
+	DelMessages(queueName string) error
+	//统计各状态的消息数
+	Stats(queueName string) (QueueStats, error)
+	//按出列顺序查看准备队列中的消息，不改变消息状态，不考虑优先级老化，返回的消息没有回执
+	Peek(queueName string, maxMessages int) ([]Message, error)
+	//按到期时间先后查看延迟队列中未被接收过的消息，最多maxMessages条
+	Scheduled(queueName string, maxMessages int) ([]ScheduledMessage, error)
+	//删除延迟队列中未被接收过的消息，消息已到期进入准备队列或不存在时返回false
+	CancelScheduled(queueName string, id string) (bool, error)
+
+	//调度
+	DueQueues(now int64) ([]string, error)
+	//所有队列中最早的到期时间，没有延迟消息时为0
+	OldestDue() (int64, error)
+	Reindex(queueName string) error
+	Unschedule(queueName string) error
+	AcquireLease(instanceId string, lease time.Duration) (bool, error)
+}
+
+//按名称创建存储
+func NewStorage(name string) (Storage, error) {
+	switch name {
+	case "redis":
+		Pool = newPool(Redis) //创建redis连接池
+		return NewRedisStorage(Pool), nil
+	case "memory":
+		return NewMemoryStorage(), nil
+	case "disk":
+		return NewDiskStorage(DataDir, time.Duration(SnapshotInterval)*time.Second)
+	}
+	return nil, fmt.Errorf("unknown storage: %s", name)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+//三种存储分别运行同一个测试，redis使用miniredis，不需要真实的redis
+var storageTestNames = []string{"redis", "memory", "disk"}
+
+func storageTestEach(t *testing.T, test func(t *testing.T)) {
+	for _, name := range storageTestNames {
+		t.Run(name, func(t *testing.T) {
+			storageTestSetup(t, name)
+			test(t)
+		})
+	}
+}
+
+//按名称创建存储并初始化队列管理器和调度器
+func storageTestSetup(t *testing.T, name string) {
+	switch name {
+	case "redis":
+		Pool = newPool(miniredis.RunT(t).Addr())
+		Store = NewRedisStorage(Pool)
+	case "memory":
+		Store = NewMemoryStorage()
+	case "disk":
+		disk, err := NewDiskStorage(t.TempDir(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		Store = disk
+	}
+	MaxBatch = 10
+	PromoteBatch = 1000
+	YumiQ = NewYumi()
+	Queue = NewQueues()
+	Sched = NewScheduler()
+	//只读取一次队列配置，不启动定期刷新，避免每个测试留下一个刷新的Go程
+	if err := Queue.refresh(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//创建队列，VisibilityTimeout为空时为30秒
+func storageTestQueue(t *testing.T, opt OptionQueue) {
+	if opt.VisibilityTimeout == "" {
+		opt.VisibilityTimeout = "30"
+	}
+	if err := YumiQ.Create(opt); err != nil {
+		t.Fatalf("create queue %s: %v", opt.QueueName, err)
+	}
+}
+
+//每条消息ID不同，只有最近一次接收的回执有效
+func TestStorageReceipts(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		ids, err := Store.Push("q", []NewMessage{{Id: "m1", Body: "same", SentAt: 1}, {Id: "m2", Body: "same", SentAt: 1}})
+		if err != nil || len(ids) != 2 || ids[0] != "m1" || ids[1] != "m2" {
+			t.Fatalf("push: %v %v", ids, err)
+		}
+
+		first, err := Store.Pop("q", PopOption{MaxMessages: 1, Deadline: 10, Token: "t1", Now: 1})
+		if err != nil || len(first) != 1 || first[0].MessageId != "m1" || first[0].ReceiptHandle != "m1:t1" {
+			t.Fatalf("pop: %v %v", first, err)
+		}
+		if id, err := Store.CheckReceipt("q", first[0].ReceiptHandle); err != nil || id != "m1" {
+			t.Fatalf("check receipt: %q %v", id, err)
+		}
+		if _, err := Store.CheckReceipt("q", "m1:other"); errorKind(err) != ErrNotFound {
+			t.Fatalf("check wrong receipt: %v", err)
+		}
+
+		//隐藏到期后回到准备队列末尾，再次接收后之前的回执作废
+		if n, err := Store.Promote("q", 10, 10); err != nil || n != 1 {
+			t.Fatalf("promote: %d %v", n, err)
+		}
+		again, err := Store.Pop("q", PopOption{MaxMessages: 2, Deadline: 20, Token: "t2", Now: 10})
+		if err != nil || len(again) != 2 || again[1].MessageId != "m1" || again[1].ApproximateReceiveCount != 2 {
+			t.Fatalf("pop again: %v %v", again, err)
+		}
+		if again[0].ReceiptHandle != "m2:t2" || again[1].ReceiptHandle != "m1:t2" {
+			t.Fatalf("receipt handles: %q %q", again[0].ReceiptHandle, again[1].ReceiptHandle)
+		}
+		if _, err := Store.CheckReceipt("q", first[0].ReceiptHandle); errorKind(err) != ErrNotFound {
+			t.Fatalf("check stale receipt: %v", err)
+		}
+	})
+}
+
+//出列的消息隐藏到Deadline，之前不会回到准备队列
+func TestStoragePopHides(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		Store.Push("q", []NewMessage{{Id: "m1", Body: "hello", SentAt: 1}})
+		if messages, err := Store.Pop("q", PopOption{MaxMessages: 1, Deadline: 10, Token: "t", Now: 1}); err != nil || len(messages) != 1 {
+			t.Fatalf("pop: %v %v", messages, err)
+		}
+		if stats, _ := Store.Stats("q"); stats.Ready != 0 || stats.InFlight != 1 || stats.Delayed != 0 {
+			t.Fatalf("stats after pop: %+v", stats)
+		}
+		if messages, err := Store.Pop("q", PopOption{MaxMessages: 1, Deadline: 10, Token: "t", Now: 1}); err != nil || len(messages) != 0 {
+			t.Fatalf("pop hidden message: %v %v", messages, err)
+		}
+		if n, _ := Store.Promote("q", 9, 10); n != 0 {
+			t.Fatalf("promoted %d messages before the deadline", n)
+		}
+		if n, _ := Store.Promote("q", 10, 10); n != 1 {
+			t.Fatalf("promoted %d messages at the deadline", n)
+		}
+	})
+}
+
+//到期的延迟消息按到期时间先后移入准备队列，每次最多limit条，并发移动时每条只移动一次
+func TestStoragePromote(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		var messages []NewMessage
+		for i := 0; i < 30; i++ {
+			messages = append(messages, NewMessage{Id: "m" + toString(int64(i)), Body: "x", SentAt: 1, DeliverAt: int64(40 - i)})
+		}
+		if _, err := Store.Push("q", messages); err != nil {
+			t.Fatal(err)
+		}
+
+		if due, _ := Store.DueQueues(20); len(due) != 1 || due[0] != "q" {
+			t.Fatalf("due queues: %v", due)
+		}
+		if n, err := Store.Promote("q", 20, 5); err != nil || n != 5 {
+			t.Fatalf("promote with limit: %d %v", n, err)
+		}
+		peeked, _ := Store.Peek("q", 10)
+		if len(peeked) != 5 || peeked[0].MessageId != "m29" || peeked[4].MessageId != "m25" {
+			t.Fatalf("promoted out of order: %v", peeked)
+		}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		total := 5
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				n, err := Store.Promote("q", 40, 100)
+				if err != nil {
+					t.Error(err)
+				}
+				mu.Lock()
+				total += n
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		if total != 30 {
+			t.Fatalf("promoted %d messages, want 30", total)
+		}
+		if stats, _ := Store.Stats("q"); stats.Ready != 30 || stats.Delayed != 0 {
+			t.Fatalf("stats: %+v", stats)
+		}
+		if due, _ := Store.DueQueues(1000); len(due) != 0 {
+			t.Fatalf("due queues after promote: %v", due)
+		}
+	})
+}
+
+//去重ID在DedupUntil之前返回原消息ID，过期后作为新消息入列
+func TestStorageDedupWindow(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		ids, err := Store.Push("q", []NewMessage{{Id: "m1", Body: "a", SentAt: 1, DeduplicationId: "k", DedupUntil: 10},
+			{Id: "m2", Body: "b", SentAt: 1, DeduplicationId: "k", DedupUntil: 10}})
+		if err != nil || len(ids) != 2 || ids[0] != "m1" || ids[1] != "m1" {
+			t.Fatalf("push duplicates in one batch: %v %v", ids, err)
+		}
+		if ids, _ := Store.Push("q", []NewMessage{{Id: "m3", Body: "c", SentAt: 9, DeduplicationId: "k", DedupUntil: 20}}); ids[0] != "m1" {
+			t.Fatalf("push duplicate in window: %v", ids)
+		}
+		if ids, _ := Store.Push("q", []NewMessage{{Id: "m4", Body: "d", SentAt: 10, DeduplicationId: "k", DedupUntil: 20}}); ids[0] != "m4" {
+			t.Fatalf("push after window: %v", ids)
+		}
+		//消息删除后去重ID仍然有效
+		messages, _ := Store.Pop("q", PopOption{MaxMessages: 10, Deadline: 100, Token: "t", Now: 11})
+		for _, message := range messages {
+			Store.Delete("q", message.MessageId)
+		}
+		if ids, _ := Store.Push("q", []NewMessage{{Id: "m5", Body: "e", SentAt: 12, DeduplicationId: "k", DedupUntil: 30}}); ids[0] != "m4" {
+			t.Fatalf("push duplicate of deleted message: %v", ids)
+		}
+		if stats, _ := Store.Stats("q"); stats.Ready != 0 || stats.InFlight != 0 {
+			t.Fatalf("stats: %+v", stats)
+		}
+	})
+}
+
+//优先级高的先出列，相同优先级按入列顺序，到期的延迟消息和隐藏到期的消息保留优先级
+func TestStoragePriority(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		Store.Push("q", []NewMessage{{Id: "low", Body: "x", SentAt: 1}, {Id: "mid", Body: "x", SentAt: 1, Priority: 5},
+			{Id: "high", Body: "x", SentAt: 1, Priority: 9}, {Id: "mid2", Body: "x", SentAt: 1, Priority: 5},
+			{Id: "delayed", Body: "x", SentAt: 1, DeliverAt: 5, Priority: 7}})
+		if first, _ := Store.Pop("q", PopOption{MaxMessages: 1, Deadline: 5, Token: "t", Now: 1}); len(first) != 1 || first[0].MessageId != "high" {
+			t.Fatalf("pop highest priority: %v", first)
+		}
+
+		Store.Promote("q", 5, PromoteBatch)
+		messages, err := Store.Pop("q", PopOption{MaxMessages: 10, Deadline: 100, Token: "t", Now: 5})
+		if err != nil || len(messages) != 5 {
+			t.Fatalf("pop: %v %v", messages, err)
+		}
+		for i, want := range []string{"high", "delayed", "mid", "mid2", "low"} {
+			if messages[i].MessageId != want {
+				t.Fatalf("message %d: %s, want %s", i, messages[i].MessageId, want)
+			}
+		}
+	})
+}
+
+//配置优先级老化时等待越久优先级越高，为0时严格按优先级
+func TestStoragePriorityAging(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		Store.Push("q", []NewMessage{{Id: "old", Body: "x", SentAt: 1}})
+		Store.Push("q", []NewMessage{{Id: "new", Body: "x", SentAt: 100, Priority: 5}})
+
+		strict, _ := Store.Peek("q", 10)
+		if len(strict) != 2 || strict[0].MessageId != "new" {
+			t.Fatalf("peek: %v", strict)
+		}
+		//old等待99秒，每10秒加一后高于new
+		messages, err := Store.Pop("q", PopOption{MaxMessages: 1, Deadline: 200, Token: "t", Now: 100, PriorityAging: 10})
+		if err != nil || len(messages) != 1 || messages[0].MessageId != "old" {
+			t.Fatalf("pop with aging: %v %v", messages, err)
+		}
+		Store.Push("q", []NewMessage{{Id: "older", Body: "x", SentAt: 90}})
+		if messages, _ := Store.Pop("q", PopOption{MaxMessages: 1, Deadline: 200, Token: "t", Now: 100, PriorityAging: 10}); len(messages) != 1 || messages[0].MessageId != "new" {
+			t.Fatalf("pop with aging below priority: %v", messages)
+		}
+	})
+}
+
+//消息属性随消息保存，首次接收时间不随再次接收改变，移入死信队列时保留属性并重新计数
+func TestStorageMessageAttributes(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		Store.Push("q", []NewMessage{{Id: "m1", Body: "x", SentAt: 1, Attributes: map[string]string{"traceId": "t1", "contentType": "text/plain"}}})
+		opt := PopOption{MaxMessages: 1, Deadline: 10, Token: "t", DeadLetterQueue: "dlq", MaxReceiveCount: 2, Now: 5}
+		first, err := Store.Pop("q", opt)
+		if err != nil || len(first) != 1 || first[0].Attributes["traceId"] != "t1" || first[0].SentTimestamp != 1 {
+			t.Fatalf("pop: %v %v", first, err)
+		}
+		if first[0].ApproximateReceiveCount != 1 || first[0].ApproximateFirstReceiveTimestamp != 5 {
+			t.Fatalf("first receive: %+v", first[0])
+		}
+
+		Store.Promote("q", 10, PromoteBatch)
+		opt.Deadline, opt.Now = 30, 20
+		again, _ := Store.Pop("q", opt)
+		if len(again) != 1 || again[0].ApproximateReceiveCount != 2 || again[0].ApproximateFirstReceiveTimestamp != 5 {
+			t.Fatalf("receive again: %v", again)
+		}
+
+		Store.Promote("q", 30, PromoteBatch)
+		opt.Deadline, opt.Now = 50, 40
+		if messages, _ := Store.Pop("q", opt); len(messages) != 0 {
+			t.Fatalf("pop after max receives: %v", messages)
+		}
+		dead, err := Store.Pop("dlq", PopOption{MaxMessages: 1, Deadline: 60, Token: "t", Now: 50})
+		if err != nil || len(dead) != 1 || dead[0].Attributes["contentType"] != "text/plain" || dead[0].ApproximateReceiveCount != 1 {
+			t.Fatalf("dead letter: %v %v", dead, err)
+		}
+	})
+}
+
+//按到期时间先后列出未被接收过的延迟消息，跳过隐藏中的消息，已到期或隐藏中的消息不能取消
+func TestStorageScheduled(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		Store.Push("q", []NewMessage{{Id: "h1", Body: "x", SentAt: 1}, {Id: "h2", Body: "x", SentAt: 1}, {Id: "h3", Body: "x", SentAt: 1}})
+		//隐藏中的消息比延迟消息先到期，超过一次读取的条数
+		if hidden, _ := Store.Pop("q", PopOption{MaxMessages: 3, Deadline: 5, Token: "t", Now: 1}); len(hidden) != 3 {
+			t.Fatalf("pop: %v", hidden)
+		}
+		Store.Push("q", []NewMessage{{Id: "s1", Body: "later", SentAt: 2, DeliverAt: 100, Attributes: map[string]string{"k": "v"}},
+			{Id: "s2", Body: "sooner", SentAt: 3, DeliverAt: 50}})
+
+		scheduled, err := Store.Scheduled("q", 2)
+		if err != nil || len(scheduled) != 2 || scheduled[0].MessageId != "s2" || scheduled[1].MessageId != "s1" {
+			t.Fatalf("scheduled: %v %v", scheduled, err)
+		}
+		if s := scheduled[1]; s.Body != "later" || s.SentTimestamp != 2 || s.DeliverTimestamp != 100 || s.Attributes["k"] != "v" {
+			t.Fatalf("scheduled message: %+v", s)
+		}
+		if scheduled, _ := Store.Scheduled("q", 1); len(scheduled) != 1 || scheduled[0].MessageId != "s2" {
+			t.Fatalf("scheduled with limit: %v", scheduled)
+		}
+		if scheduled, err := Store.Scheduled("missing", 10); err != nil || len(scheduled) != 0 {
+			t.Fatalf("scheduled of missing queue: %v %v", scheduled, err)
+		}
+
+		if ok, err := Store.CancelScheduled("q", "h1"); err != nil || ok {
+			t.Fatalf("cancel hidden message: %v %v", ok, err)
+		}
+		if ok, err := Store.CancelScheduled("q", "s2"); err != nil || !ok {
+			t.Fatalf("cancel: %v %v", ok, err)
+		}
+		if ok, _ := Store.CancelScheduled("q", "s2"); ok {
+			t.Fatal("cancelled twice")
+		}
+		if stats, _ := Store.Stats("q"); stats.Delayed != 1 || stats.InFlight != 3 {
+			t.Fatalf("stats after cancel: %+v", stats)
+		}
+
+		Store.Promote("q", 100, PromoteBatch)
+		if ok, _ := Store.CancelScheduled("q", "s1"); ok {
+			t.Fatal("cancelled a due message")
+		}
+		if scheduled, _ := Store.Scheduled("q", 10); len(scheduled) != 0 {
+			t.Fatalf("scheduled after promote: %v", scheduled)
+		}
+	})
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+)
+
+//主题的订阅，按json保存在存储中
+type Subscription struct {
+	QueueName        string          `json:"queueName"`
+	CreatedTimestamp int64           `json:"createdTimestamp"`
+	FilterPolicy     json.RawMessage `json:"filterPolicy,omitempty"` //过滤策略，为空时不过滤
+	FilteredMessages int64           `json:"filteredMessages"`       //被过滤策略过滤掉的消息数，查询订阅时从过滤计数读取
+}
+
+//创建主题
+func (this *Yumi) CreateTopic(topicName string) (err error) {
+	exists, err := Store.ExistsTopicName(topicName)
+	if err != nil {
+		return
+	}
+	if exists {
+		return newError(ErrConflict, "Topic %s exist", topicName)
+	}
+	return Store.AddTopicName(topicName)
+}
+
+//删除主题及其全部订阅，已入列的消息不受影响
+func (this *Yumi) DelTopic(topicName string) (err error) {
+	if err = this.topicExists(topicName); err != nil {
+		return
+	}
+	subscriptions, err := Store.Subscriptions(topicName)
+	if err != nil {
+		return
+	}
+	if err = Store.DelTopicName(topicName); err != nil {
+		return
+	}
+	for queueName := range subscriptions {
+		filteredTotal.DeleteLabelValues(topicName, queueName)
+	}
+	publishedTotal.DeleteLabelValues(topicName)
+	return
+}
+
+func (this *Yumi) ListTopics() ([]string, error) {
+	names, err := Store.TopicNames()
+	sort.Strings(names)
+	return names, err
+}
+
+func (this *Yumi) topicExists(topicName string) error {
+	exists, err := Store.ExistsTopicName(topicName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return newError(ErrNotFound, "Topic %s doesn't exist", topicName)
+	}
+	return nil
+}
+
+//队列订阅主题，之后发布到主题且满足过滤策略的消息都入列一份到该队列
+//重复订阅时替换过滤策略，保留原来的订阅时间
+func (this *Yumi) Subscribe(topicName string, queueName string, filterPolicy string) (err error) {
+	if err = this.topicExists(topicName); err != nil {
+		return
+	}
+	if _, ok := Queue.Get(queueName); !ok {
+		return newError(ErrNotFound, "Queue %s exception", queueName)
+	}
+	if _, err = parseFilterPolicy(filterPolicy); err != nil {
+		return
+	}
+
+	subscription := Subscription{queueName, theMoment(), nil, 0}
+	if filterPolicy != "" {
+		subscription.FilterPolicy = json.RawMessage(filterPolicy)
+	}
+	subscriptions, err := Store.Subscriptions(topicName)
+	if err != nil {
+		return
+	}
+	if saved, ok := subscriptions[queueName]; ok {
+		var current Subscription
+		if err = json.Unmarshal([]byte(saved), &current); err != nil {
+			return
+		}
+		subscription.CreatedTimestamp = current.CreatedTimestamp
+	}
+
+	value, err := json.Marshal(subscription)
+	if err != nil {
+		return
+	}
+	return Store.SaveSubscription(topicName, queueName, string(value))
+}
+
+func (this *Yumi) Unsubscribe(topicName string, queueName string) (err error) {
+	subscriptions, err := this.subscriptions(topicName)
+	if err != nil {
+		return
+	}
+	for _, subscription := range subscriptions {
+		if subscription.QueueName != queueName {
+			continue
+		}
+		if err = Store.DelSubscription(topicName, queueName); err != nil {
+			return
+		}
+		filteredTotal.DeleteLabelValues(topicName, queueName)
+		return
+	}
+	return newError(ErrNotFound, "Queue %s doesn't subscribe topic %s", queueName, topicName)
+}
+
+//主题的全部订阅，按队列名排序，包含各订阅过滤掉的消息数
+func (this *Yumi) Subscriptions(topicName string) (subscriptions []Subscription, err error) {
+	if subscriptions, err = this.subscriptions(topicName); err != nil {
+		return
+	}
+	counts, err := Store.FilteredCounts(topicName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range subscriptions {
+		subscriptions[i].FilteredMessages = counts[subscriptions[i].QueueName]
+	}
+	return
+}
+
+//主题的全部订阅，按队列名排序，不读取过滤计数
+func (this *Yumi) subscriptions(topicName string) (subscriptions []Subscription, err error) {
+	if err = this.topicExists(topicName); err != nil {
+		return
+	}
+
+	saved, err := Store.Subscriptions(topicName)
+	if err != nil {
+		return
+	}
+	for queueName, value := range saved {
+		subscription := Subscription{QueueName: queueName}
+		if err = json.Unmarshal([]byte(value), &subscription); err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].QueueName < subscriptions[j].QueueName
+	})
+	return
+}
+
+//删除队列时取消它在各主题中的订阅
+func (this *Yumi) unsubscribeQueue(queueName string) error {
+	topics, err := Store.TopicNames()
+	if err != nil {
+		return err
+	}
+	for _, topicName := range topics {
+		subscriptions, err := Store.Subscriptions(topicName)
+		if err != nil {
+			return err
+		}
+		if _, ok := subscriptions[queueName]; !ok {
+			continue
+		}
+		if err = Store.DelSubscription(topicName, queueName); err != nil {
+			return err
+		}
+		filteredTotal.DeleteLabelValues(topicName, queueName)
+	}
+	return nil
+}
+
+//发布消息，每个订阅的队列按各自的配置（延时、去重、FIFO等）入列一份，返回每个队列中的消息ID
+//消息组只用于订阅的FIFO队列，入列到其他队列时忽略
+//消息属性不满足订阅的过滤策略时不入列到该队列，按订阅计入过滤掉的消息数
+//任一队列不接受该消息时都不入列，全部队列的入列是原子的
+//没有订阅或全部被过滤时消息直接丢弃，返回空的结果，不计入发布数
+func (this *Yumi) Publish(topicName string, entry PushEntry) (ids map[string]string, err error) {
+	subscriptions, err := this.subscriptions(topicName)
+	if err != nil {
+		return
+	}
+	attributes, err := parseAttributes(entry.Attributes)
+	if err != nil {
+		return
+	}
+
+	messages := make(map[string][]NewMessage)
+	var filtered []string
+	for _, subscription := range subscriptions {
+		optionQueue, ok := Queue.Get(subscription.QueueName)
+		if !ok {
+			continue //订阅后队列被删除
+		}
+
+		filter, err := parseFilterPolicy(string(subscription.FilterPolicy))
+		if err != nil {
+			return nil, err
+		}
+		if filter != nil && !filter.Match(attributes) {
+			filtered = append(filtered, subscription.QueueName)
+			continue
+		}
+
+		queueEntry := entry
+		if !optionQueue.Fifo() {
+			queueEntry.MessageGroupId = ""
+		}
+		message, err := this.newMessage(optionQueue, newID(), queueEntry)
+		if err != nil {
+			return nil, newError(errorKind(err), "Queue %s: %s", subscription.QueueName, err.Error())
+		}
+		messages[subscription.QueueName] = []NewMessage{message}
+	}
+
+	saved, err := Store.PushMulti(messages)
+	if err != nil {
+		return
+	}
+
+	ids = make(map[string]string, len(saved))
+	delivered := 0
+	for queueName, queueIds := range saved {
+		ids[queueName] = queueIds[0]
+		if queueIds[0] == messages[queueName][0].Id {
+			pushedTotal.WithLabelValues(queueName).Inc()
+			delivered++
+		}
+	}
+	if len(filtered) > 0 {
+		//消息已入列，过滤计数失败时不返回错误
+		if err := Store.AddFiltered(topicName, filtered); err != nil {
+			log.Printf("%s topic filtered count error: %s", topicName, err.Error())
+		}
+		for _, queueName := range filtered {
+			filteredTotal.WithLabelValues(topicName, queueName).Inc()
+		}
+	}
+	//只有至少入列到一个队列（去重命中的不算）时才计入发布数
+	if delivered > 0 {
+		publishedTotal.WithLabelValues(topicName).Inc()
+	}
+	return
+}
+
+type TopicResult struct {
+	Success   bool   `json:"success"`
+	TopicName string `json:"topicName"`
+	Error     string `json:"error"`
+}
+
+type ListTopicsResult struct {
+	Success    bool     `json:"success"`
+	TopicNames []string `json:"topicNames"`
+	Error      string   `json:"error"`
+}
+
+type SubscribeResult struct {
+	Success   bool   `json:"success"`
+	TopicName string `json:"topicName"`
+	QueueName string `json:"queueName"`
+	Error     string `json:"error"`
+}
+
+type ListSubscriptionsResult struct {
+	Success       bool           `json:"success"`
+	TopicName     string         `json:"topicName"`
+	Subscriptions []Subscription `json:"subscriptions"`
+	Error         string         `json:"error"`
+}
+
+//MessageIds的键为队列名，值为该队列中的消息ID
+type PublishResult struct {
+	Success    bool              `json:"success"`
+	TopicName  string            `json:"topicName"`
+	MessageIds map[string]string `json:"messageIds"`
+	Error      string            `json:"error"`
+}
+
+func CreateTopic(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	topicName := req.PostFormValue("topicName")
+
+	if topicName == "" {
+		YumiQ.Write(res, TopicResult{false, topicName, "topicName must not be null"})
+		return
+	}
+
+	if err := YumiQ.CreateTopic(topicName); err != nil {
+		YumiQ.Write(res, TopicResult{false, topicName, err.Error()})
+	} else {
+		YumiQ.Write(res, TopicResult{true, topicName, ""})
+	}
+}
+
+func DelTopic(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	topicName := req.PostFormValue("topicName")
+
+	if topicName == "" {
+		YumiQ.Write(res, TopicResult{false, topicName, "topicName must not be null"})
+		return
+	}
+
+	if err := YumiQ.DelTopic(topicName); err != nil {
+		YumiQ.Write(res, TopicResult{false, topicName, err.Error()})
+	} else {
+		YumiQ.Write(res, TopicResult{true, topicName, ""})
+	}
+}
+
+func ListTopics(res http.ResponseWriter, req *http.Request) {
+	names, err := YumiQ.ListTopics()
+	if err != nil {
+		YumiQ.Write(res, ListTopicsResult{false, nil, err.Error()})
+	} else {
+		if names == nil {
+			names = []string{}
+		}
+		YumiQ.Write(res, ListTopicsResult{true, names, ""})
+	}
+}
+
+//队列订阅主题
+func SubscribeTopic(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	topicName := req.PostFormValue("topicName")
+	queueName := req.PostFormValue("queueName")
+	filterPolicy := req.PostFormValue("filterPolicy") //过滤策略，json对象，如 {"color":["red",{"prefix":"bl"}]}，为空时不过滤
+
+	if topicName == "" || queueName == "" {
+		YumiQ.Write(res, SubscribeResult{false, topicName, queueName, "topicName and queueName must not be null"})
+		return
+	}
+
+	if err := YumiQ.Subscribe(topicName, queueName, filterPolicy); err != nil {
+		YumiQ.Write(res, SubscribeResult{false, topicName, queueName, err.Error()})
+	} else {
+		YumiQ.Write(res, SubscribeResult{true, topicName, queueName, ""})
+	}
+}
+
+func UnsubscribeTopic(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	topicName := req.PostFormValue("topicName")
+	queueName := req.PostFormValue("queueName")
+
+	if topicName == "" || queueName == "" {
+		YumiQ.Write(res, SubscribeResult{false, topicName, queueName, "topicName and queueName must not be null"})
+		return
+	}
+
+	if err := YumiQ.Unsubscribe(topicName, queueName); err != nil {
+		YumiQ.Write(res, SubscribeResult{false, topicName, queueName, err.Error()})
+	} else {
+		YumiQ.Write(res, SubscribeResult{true, topicName, queueName, ""})
+	}
+}
+
+func ListSubscriptions(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	topicName := req.Form.Get("topicName")
+
+	if topicName == "" {
+		YumiQ.Write(res, ListSubscriptionsResult{false, topicName, nil, "topicName must not be null"})
+		return
+	}
+
+	subscriptions, err := YumiQ.Subscriptions(topicName)
+	if err != nil {
+		YumiQ.Write(res, ListSubscriptionsResult{false, topicName, nil, err.Error()})
+	} else {
+		if subscriptions == nil {
+			subscriptions = []Subscription{}
+		}
+		YumiQ.Write(res, ListSubscriptionsResult{true, topicName, subscriptions, ""})
+	}
+}
+
+//发布消息，参数与/push相同，queueName换为topicName
+func Publish(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	topicName := req.PostFormValue("topicName")
+	body := req.PostFormValue("body")
+	delaySeconds := req.PostFormValue("delaySeconds")       //延迟时间，为空时按各队列的延时
+	messageGroupId := req.PostFormValue("messageGroupId")   //消息组，订阅的队列中有FIFO队列时必须指定
+	deduplicationId := req.PostFormValue("deduplicationId") //去重ID，在每个队列中分别去重
+	priority := req.PostFormValue("priority")
+	attributes := req.PostFormValue("attributes")
+	deliverAt := req.PostFormValue("deliverAt") //到期时间，不能晚于各队列的消息保留时间
+
+	if topicName == "" || body == "" {
+		YumiQ.Write(res, PublishResult{false, topicName, nil, "topicName and body must not be null"})
+		return
+	}
+
+	if ids, err := YumiQ.Publish(topicName, PushEntry{body, delaySeconds, messageGroupId, deduplicationId, priority, attributes, deliverAt, nil}); err != nil {
+		YumiQ.Write(res, PublishResult{false, topicName, nil, err.Error()})
+	} else {
+		YumiQ.Write(res, PublishResult{true, topicName, ids, ""})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+//发布到每个订阅的队列各一份，按各队列的延时入列，FIFO队列使用消息组
+func TestPublishFanOut(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "a"})
+		storageTestQueue(t, OptionQueue{QueueName: "delayed", DelaySeconds: "100"})
+		storageTestQueue(t, OptionQueue{QueueName: "f", FifoQueue: "true"})
+		if err := YumiQ.CreateTopic("t"); err != nil {
+			t.Fatal(err)
+		}
+		if err := YumiQ.CreateTopic("t"); errorKind(err) != ErrConflict {
+			t.Fatalf("create existing topic: %v", err)
+		}
+		if err := YumiQ.Subscribe("t", "missing", ""); errorKind(err) != ErrNotFound {
+			t.Fatalf("subscribe missing queue: %v", err)
+		}
+		for _, queueName := range []string{"a", "delayed", "f"} {
+			if err := YumiQ.Subscribe("t", queueName, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ids, err := YumiQ.Publish("t", PushEntry{Body: "x", MessageGroupId: "g"})
+		if err != nil || len(ids) != 3 || ids["a"] == ids["delayed"] {
+			t.Fatalf("publish: %v %v", ids, err)
+		}
+		for queueName, want := range map[string]QueueStats{"a": {Ready: 1}, "delayed": {Delayed: 1}, "f": {Ready: 1}} {
+			if stats, _ := Store.Stats(queueName); stats.Ready != want.Ready || stats.Delayed != want.Delayed {
+				t.Fatalf("%s stats: %+v", queueName, stats)
+			}
+		}
+		if _, err := YumiQ.Publish("missing", PushEntry{Body: "x"}); errorKind(err) != ErrNotFound {
+			t.Fatalf("publish to missing topic: %v", err)
+		}
+		YumiQ.DelTopic("t")
+	})
+}
+
+//任一队列不接受消息时都不入列
+func TestPublishAtomic(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "a"})
+		storageTestQueue(t, OptionQueue{QueueName: "f", FifoQueue: "true"})
+		YumiQ.CreateTopic("t")
+		YumiQ.Subscribe("t", "a", "")
+		YumiQ.Subscribe("t", "f", "")
+
+		//FIFO队列要求消息组
+		if _, err := YumiQ.Publish("t", PushEntry{Body: "x"}); errorKind(err) != ErrInvalid {
+			t.Fatalf("publish without message group: %v", err)
+		}
+		for _, queueName := range []string{"a", "f"} {
+			if stats, _ := Store.Stats(queueName); stats.Ready != 0 {
+				t.Fatalf("%s stats after failed publish: %+v", queueName, stats)
+			}
+		}
+		YumiQ.DelTopic("t")
+	})
+}
+
+//没有订阅或全部去重命中时不计入发布数，删除队列时取消订阅
+func TestPublishCountsAndUnsubscribe(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "a"})
+		storageTestQueue(t, OptionQueue{QueueName: "b"})
+		YumiQ.CreateTopic("t")
+		defer YumiQ.DelTopic("t")
+
+		if ids, err := YumiQ.Publish("t", PushEntry{Body: "x"}); err != nil || len(ids) != 0 {
+			t.Fatalf("publish without subscriptions: %v %v", ids, err)
+		}
+		if got := testutil.ToFloat64(publishedTotal.WithLabelValues("t")); got != 0 {
+			t.Fatalf("published %v without subscriptions", got)
+		}
+
+		YumiQ.Subscribe("t", "a", "")
+		YumiQ.Subscribe("t", "b", "")
+		first, _ := YumiQ.Publish("t", PushEntry{Body: "x", DeduplicationId: "k"})
+		again, _ := YumiQ.Publish("t", PushEntry{Body: "x", DeduplicationId: "k"})
+		if again["a"] != first["a"] || again["b"] != first["b"] {
+			t.Fatalf("deduplicated publish: %v %v", first, again)
+		}
+		if got := testutil.ToFloat64(publishedTotal.WithLabelValues("t")); got != 1 {
+			t.Fatalf("published %v, want 1", got)
+		}
+
+		if err := YumiQ.DelQueue("b"); err != nil {
+			t.Fatal(err)
+		}
+		subscriptions, err := YumiQ.Subscriptions("t")
+		if err != nil || len(subscriptions) != 1 || subscriptions[0].QueueName != "a" {
+			t.Fatalf("subscriptions after deleting queue: %v %v", subscriptions, err)
+		}
+		if err := YumiQ.Unsubscribe("t", "b"); errorKind(err) != ErrNotFound {
+			t.Fatalf("unsubscribe twice: %v", err)
+		}
+		if err := YumiQ.Unsubscribe("t", "a"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+//删除主题后订阅一并删除，已入列的消息不受影响
+func TestDelTopic(t *testing.T) {
+	storageTestEach(t, func(t *testing.T) {
+		storageTestQueue(t, OptionQueue{QueueName: "a"})
+		YumiQ.CreateTopic("t")
+		YumiQ.Subscribe("t", "a", "")
+		YumiQ.Publish("t", PushEntry{Body: "x"})
+
+		if err := YumiQ.DelTopic("t"); err != nil {
+			t.Fatal(err)
+		}
+		if topics, _ := YumiQ.ListTopics(); len(topics) != 0 {
+			t.Fatalf("topics: %v", topics)
+		}
+		if subscriptions, _ := Store.Subscriptions("t"); len(subscriptions) != 0 {
+			t.Fatalf("subscriptions of deleted topic: %v", subscriptions)
+		}
+		if stats, _ := Store.Stats("a"); stats.Ready != 1 {
+			t.Fatalf("stats: %+v", stats)
+		}
+		if err := YumiQ.DelTopic("t"); errorKind(err) != ErrNotFound {
+			t.Fatalf("delete missing topic: %v", err)
+		}
+	})
+}
@@ -3,14 +3,16 @@ package main
 import (
 	"time"
 	"strconv"
+	"strings"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"github.com/gomodule/redigo/redis"
-	"github.com/hjr265/redsync.go/redsync"
 	"log"
 )
 
 var (
-	Pool  *redis.Pool
-	Qlock *redsync.Mutex
+	Pool *redis.Pool
 )
 
 
@@ -65,3 +67,24 @@ func toString(parameters int64) string{
 	return strconv.FormatInt(parameters,10)
 }
 
+//生成消息ID
+func newID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	must(err)
+	return hex.EncodeToString(b)
+}
+
+//从回执中解析消息ID，回执格式为 消息ID:随机串，每次接收都不同
+func receiptMessageID(receiptHandle string) string {
+	if i := strings.Index(receiptHandle, ":"); i > 0 {
+		return receiptHandle[:i]
+	}
+	return ""
+}
+
+//按消息体去重时的去重ID
+func contentDedupID(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
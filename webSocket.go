@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const wsWriteTimeout = 10 * time.Second //WebSocket每帧的写超时
+
+//只接受同源或没有Origin的连接，其他站点的页面不能借浏览器订阅队列
+var wsUpgrader = websocket.Upgrader{ReadBufferSize: 4096, WriteBufferSize: 4096}
+
+//客户端发送的帧
+//ack删除消息；nack在visibilityTimeout秒后（默认1秒）放回队列；extend修改隐藏时间，为0时按队列的隐藏时间
+type wsRequest struct {
+	Action            string `json:"action"`
+	QueueName         string `json:"queueName"`
+	ReceiptHandle     string `json:"receiptHandle"`
+	VisibilityTimeout int64  `json:"visibilityTimeout"`
+}
+
+//服务端发送的帧，type为message时带消息，为ack、nack、extend时是对应请求的结果，为error时连接随后关闭
+type wsResponse struct {
+	Type          string   `json:"type"`
+	QueueName     string   `json:"queueName,omitempty"`
+	Message       *Message `json:"message,omitempty"`
+	ReceiptHandle string   `json:"receiptHandle,omitempty"`
+	Success       bool     `json:"success"`
+	Error         string   `json:"error,omitempty"`
+}
+
+//gorilla的连接同一时刻只能有一个写入者
+type wsConn struct {
+	*websocket.Conn
+	mu sync.Mutex
+}
+
+func (this *wsConn) send(response wsResponse) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return this.WriteJSON(response)
+}
+
+//WebSocket消费，GET /ws?queueName=a&queueName=b&maxUnacked=10
+//消息就绪后推送，未确认的消息达到maxUnacked时暂停，与grpc的Consume共用流控
+func Consume(res http.ResponseWriter, req *http.Request) {
+	queues := req.URL.Query()["queueName"]
+	if len(queues) == 0 {
+		v1Error(res, http.StatusBadRequest, "queueName must not be null")
+		return
+	}
+	for _, queueName := range queues {
+		if !v1QueueExists(res, queueName) {
+			return
+		}
+	}
+	maxUnacked := int(toInt64(req.URL.Query().Get("maxUnacked")))
+	if maxUnacked < 0 {
+		v1Error(res, http.StatusBadRequest, "maxUnacked must not be less than zero")
+		return
+	}
+
+	ws, err := wsUpgrader.Upgrade(res, req, nil) //失败时已返回错误
+	if err != nil {
+		return
+	}
+	conn := &wsConn{Conn: ws}
+	defer conn.Close()
+
+	c := Consumers.Add(maxUnacked)
+	defer Consumers.Remove(c)
+
+	//读到错误（包括客户端关闭）时结束推送
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			var request wsRequest
+			if err := conn.ReadJSON(&request); err != nil {
+				return
+			}
+			if conn.send(handleWsRequest(request)) != nil {
+				return
+			}
+		}
+	}()
+
+	err = c.Run(done, queues, func(queueName string, message *Message) error {
+		return conn.send(wsResponse{Type: "message", QueueName: queueName, Message: message, Success: true})
+	})
+	if err != nil {
+		conn.send(wsResponse{Type: "error", Error: err.Error()})
+	}
+}
+
+func handleWsRequest(request wsRequest) wsResponse {
+	response := wsResponse{Type: request.Action, QueueName: request.QueueName, ReceiptHandle: request.ReceiptHandle}
+
+	var err error
+	if _, ok := Queue.Get(request.QueueName); !ok {
+		err = newError(ErrNotFound, "Queue %s doesn't exist", request.QueueName)
+	} else if request.VisibilityTimeout < 0 {
+		err = newError(ErrInvalid, "visibilityTimeout must not be less than zero")
+	} else {
+		switch request.Action {
+		case "ack":
+			err = YumiQ.Del(request.QueueName, request.ReceiptHandle)
+		case "nack":
+			//隐藏时间为0时是队列的隐藏时间，nack至少等1秒
+			if request.VisibilityTimeout == 0 {
+				request.VisibilityTimeout = 1
+			}
+			if err = YumiQ.SetVisibilityTime(request.QueueName, request.ReceiptHandle, request.VisibilityTimeout); err == nil {
+				Consumers.Acked(request.ReceiptHandle) //已放回队列，不再占用未确认的额度
+			}
+		case "extend":
+			err = YumiQ.SetVisibilityTime(request.QueueName, request.ReceiptHandle, request.VisibilityTimeout)
+		default:
+			response.Type = "error"
+			err = newError(ErrInvalid, "unknown action: %s", request.Action)
+		}
+	}
+
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		response.Success = true
+	}
+	return response
+}
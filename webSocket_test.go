@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+//启动HTTP服务，返回/ws的地址
+func wsTestServer(t *testing.T) string {
+	srv := httptest.NewServer(&WaitForYou{})
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+}
+
+//连接后在后台读取服务端的帧，超时后gorilla的连接不能再读，不使用读超时
+func wsTestDial(t *testing.T, url string) (*websocket.Conn, <-chan wsResponse) {
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	frames := make(chan wsResponse, 10)
+	go func() {
+		defer close(frames)
+		for {
+			var response wsResponse
+			if err := ws.ReadJSON(&response); err != nil {
+				return
+			}
+			frames <- response
+		}
+	}()
+	return ws, frames
+}
+
+func wsTestRead(t *testing.T, frames <-chan wsResponse) wsResponse {
+	select {
+	case response, ok := <-frames:
+		if !ok {
+			t.Fatal("connection closed")
+		}
+		return response
+	case <-time.After(2 * time.Second):
+		t.Fatal("no frame received")
+	}
+	return wsResponse{}
+}
+
+//在几次轮询间隔内没有更多帧
+func wsTestExpectNone(t *testing.T, frames <-chan wsResponse) {
+	select {
+	case response := <-frames:
+		t.Fatalf("unexpected frame: %+v", response)
+	case <-time.After(3 * popPollInterval):
+	}
+}
+
+//订阅的队列不存在、参数错误或其他站点发起时不升级
+func TestWebSocketRejected(t *testing.T) {
+	storageTestSetup(t, "memory")
+	storageTestQueue(t, OptionQueue{QueueName: "w"})
+	url := wsTestServer(t)
+
+	for query, code := range map[string]int{"": http.StatusBadRequest, "?queueName=missing": http.StatusNotFound,
+		"?queueName=w&queueName=missing": http.StatusNotFound, "?queueName=w&maxUnacked=-1": http.StatusBadRequest} {
+		if _, res, err := websocket.DefaultDialer.Dial(url+query, nil); err == nil || res.StatusCode != code {
+			t.Fatalf("dial %q: %v", query, err)
+		}
+	}
+	header := http.Header{"Origin": []string{"http://other.example"}}
+	if _, res, err := websocket.DefaultDialer.Dial(url+"?queueName=w", header); err == nil || res.StatusCode != http.StatusForbidden {
+		t.Fatalf("dial from other origin: %v", err)
+	}
+}
+
+//多个队列的消息推送到同一连接，未确认的达到maxUnacked时暂停，ack、nack后继续
+func TestWebSocketConsume(t *testing.T) {
+	storageTestSetup(t, "memory")
+	storageTestQueue(t, OptionQueue{QueueName: "w1"})
+	storageTestQueue(t, OptionQueue{QueueName: "w2"})
+	for i := 0; i < 3; i++ {
+		YumiQ.Push("w1", PushEntry{Body: "a"})
+		YumiQ.Push("w2", PushEntry{Body: "b"})
+	}
+	ws, frames := wsTestDial(t, wsTestServer(t)+"?queueName=w1&queueName=w2&maxUnacked=2")
+
+	first, second := wsTestRead(t, frames), wsTestRead(t, frames)
+	if first.Type != "message" || second.Type != "message" || first.QueueName != "w1" || second.QueueName != "w1" {
+		t.Fatalf("messages: %+v %+v", first, second)
+	}
+	wsTestExpectNone(t, frames)
+
+	ws.WriteJSON(wsRequest{Action: "ack", QueueName: first.QueueName, ReceiptHandle: first.Message.ReceiptHandle})
+	if response := wsTestRead(t, frames); response.Type != "ack" || !response.Success || response.ReceiptHandle != first.Message.ReceiptHandle {
+		t.Fatalf("ack: %+v", response)
+	}
+	third := wsTestRead(t, frames)
+	if third.Type != "message" {
+		t.Fatalf("message after ack: %+v", third)
+	}
+	wsTestExpectNone(t, frames)
+
+	ws.WriteJSON(wsRequest{Action: "nack", QueueName: second.QueueName, ReceiptHandle: second.Message.ReceiptHandle})
+	if response := wsTestRead(t, frames); response.Type != "nack" || !response.Success {
+		t.Fatalf("nack: %+v", response)
+	}
+	if response := wsTestRead(t, frames); response.Type != "message" {
+		t.Fatalf("message after nack: %+v", response)
+	}
+
+	ws.WriteJSON(wsRequest{Action: "extend", QueueName: third.QueueName, ReceiptHandle: third.Message.ReceiptHandle, VisibilityTimeout: 60})
+	if response := wsTestRead(t, frames); response.Type != "extend" || !response.Success {
+		t.Fatalf("extend: %+v", response)
+	}
+	ws.WriteJSON(wsRequest{Action: "ack", QueueName: "w1", ReceiptHandle: "bad"})
+	if response := wsTestRead(t, frames); response.Type != "ack" || response.Success || response.Error == "" {
+		t.Fatalf("ack with bad receipt: %+v", response)
+	}
+	ws.WriteJSON(wsRequest{Action: "extend", QueueName: "missing", ReceiptHandle: "a:b"})
+	if response := wsTestRead(t, frames); response.Success || response.Error == "" {
+		t.Fatalf("extend in missing queue: %+v", response)
+	}
+	ws.WriteJSON(wsRequest{Action: "zap", QueueName: "w1"})
+	if response := wsTestRead(t, frames); response.Type != "error" || response.Success {
+		t.Fatalf("unknown action: %+v", response)
+	}
+}
+
+//连接关闭后已推送的消息仍在隐藏中，新的连接只收到其余消息
+func TestWebSocketReconnect(t *testing.T) {
+	storageTestSetup(t, "memory")
+	storageTestQueue(t, OptionQueue{QueueName: "w"})
+	for i := 0; i < 3; i++ {
+		YumiQ.Push("w", PushEntry{Body: "a"})
+	}
+	url := wsTestServer(t) + "?queueName=w&maxUnacked=2"
+
+	ws, frames := wsTestDial(t, url)
+	wsTestRead(t, frames)
+	wsTestRead(t, frames)
+	wsTestExpectNone(t, frames)
+	ws.Close()
+
+	ws, frames = wsTestDial(t, url)
+	if response := wsTestRead(t, frames); response.Type != "message" {
+		t.Fatalf("message after reconnect: %+v", response)
+	}
+	wsTestExpectNone(t, frames)
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	webhookRefreshInterval    = 5 * time.Second      //重新读取webhook配置的间隔
+	webhookPollInterval       = 1 * time.Second      //队列为空时再次出列的间隔
+	webhookMinBackoff         = 1 * time.Second      //连续推送失败后暂停出列的初始时长，每次失败翻倍
+	webhookMaxBackoff         = 60 * time.Second     //暂停出列的最长时长
+	webhookMaxResponseBody    = 64 << 10             //读取并丢弃的响应体上限，便于复用连接
+	defaultWebhookTimeout     = 10                   //推送请求默认的超时秒数
+	defaultWebhookConcurrency = 10                   //每个webhook默认最多同时推送的消息数
+	maxWebhookConcurrency     = 100                  //每个webhook最多同时推送的消息数
+	webhookSignatureHeader    = "X-YumiQ-Signature"  //sha256=hex(HMAC-SHA256(secret, 时间戳 + "." + 请求体))
+	webhookTimestampHeader    = "X-YumiQ-Timestamp"  //推送时间，秒
+	webhookQueueHeader        = "X-YumiQ-Queue-Name" //队列名
+	webhookMessageHeader      = "X-YumiQ-Message-Id" //消息ID
+)
+
+//队列的webhook配置，与队列配置保存在同一个hash中，修改队列时保留
+type WebhookConfig struct {
+	QueueName      string
+	Endpoint       string //推送地址，为空时不推送
+	MaxConcurrency int    //最多同时推送的消息数
+	Timeout        int64  //推送请求的超时秒数，不能超过队列的隐藏时间
+	Secret         string //签名密钥，为空时不签名
+}
+
+//从队列配置中读取webhook配置
+//设置webhook后队列的隐藏时间可能被改小，超时按当前的隐藏时间截断
+func webhookConfig(queueName string, opt map[string]string) WebhookConfig {
+	config := WebhookConfig{queueName, opt["webhookEndpoint"], int(toInt64(opt["webhookMaxConcurrency"])), toInt64(opt["webhookTimeout"]), opt["webhookSecret"]}
+	if visibilityTimeout := toInt64(opt["visibilityTimeout"]); visibilityTimeout > 0 && config.Timeout > visibilityTimeout {
+		config.Timeout = visibilityTimeout
+	}
+	return config
+}
+
+//推送的请求体，回执可用于延长隐藏时间
+type webhookPayload struct {
+	QueueName string `json:"queueName"`
+	Message
+}
+
+//webhook推送：从配置了推送地址的队列中出列并POST到该地址，返回2xx时删除消息
+//推送失败时不做处理，消息在隐藏时间到期后回到准备队列再次推送
+//多个实例时只有调度器的leader推送，每个webhook的并发上限对所有实例有效
+type WebhookDispatcher struct {
+	mu      sync.Mutex
+	workers map[string]*webhookWorker //按队列名
+	done    map[string]chan struct{}  //按队列名，最近一个推送的结束信号，新的推送等它结束后才开始
+	client  *http.Client
+}
+
+var Webhooks = &WebhookDispatcher{workers: make(map[string]*webhookWorker), done: make(map[string]chan struct{}), client: &http.Client{}}
+
+//定期按配置启动和停止推送
+func (this *WebhookDispatcher) Start() {
+	go func() {
+		ticker := time.NewTicker(webhookRefreshInterval)
+		for {
+			select {
+			case <-ticker.C:
+				this.refresh()
+			}
+		}
+	}()
+}
+
+//读取各队列的webhook配置，配置有变化的重新启动推送，不是leader时全部停止
+//队列名直接从存储读取，不经过队列管理器的缓存，读取配置失败的队列保持原来的推送
+func (this *WebhookDispatcher) refresh() {
+	configs := make(map[string]WebhookConfig)
+	failed := make(map[string]bool)
+	if Sched.IsLeader() {
+		queues, err := Store.QueueNames()
+		if err != nil {
+			log.Printf("webhook refresh error: %s", err.Error())
+			return
+		}
+		for _, qname := range queues {
+			opt, err := Store.GetOptions(qname)
+			if err != nil {
+				log.Printf("%s queue webhook error: %s", qname, err.Error())
+				failed[qname] = true
+				continue
+			}
+			if config := webhookConfig(qname, opt); config.Endpoint != "" {
+				configs[qname] = config
+			}
+		}
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	for qname, worker := range this.workers {
+		if failed[qname] {
+			continue
+		}
+		if config, ok := configs[qname]; !ok || config != worker.config {
+			close(worker.stop)
+			delete(this.workers, qname)
+		}
+	}
+	for qname, done := range this.done {
+		if _, ok := this.workers[qname]; !ok && isClosed(done) {
+			delete(this.done, qname)
+		}
+	}
+	//停止的推送中还有请求时，新的推送等它们完成，同一队列的并发不超过上限
+	for qname, config := range configs {
+		if _, ok := this.workers[qname]; !ok {
+			worker := &webhookWorker{config: config, client: this.client, stop: make(chan struct{}), done: make(chan struct{}), previous: this.done[qname]}
+			this.workers[qname] = worker
+			this.done[qname] = worker.done
+			go worker.run()
+		}
+	}
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+//单个队列的推送
+type webhookWorker struct {
+	config     WebhookConfig
+	client     *http.Client
+	stop       chan struct{}
+	done       chan struct{}  //停止且全部请求完成后关闭
+	previous   chan struct{}  //同一队列上一个推送的done，为nil时没有
+	deliveries sync.WaitGroup //正在推送的请求
+	failures   int32          //连续失败次数，成功后清零
+}
+
+//出列数不超过空闲的并发数，正在推送的请求在停止后继续完成，全部完成后才关闭done
+func (this *webhookWorker) run() {
+	defer func() {
+		this.deliveries.Wait()
+		if this.previous != nil {
+			<-this.previous
+		}
+		close(this.done)
+	}()
+	if this.previous != nil {
+		select {
+		case <-this.stop:
+			return
+		case <-this.previous:
+		}
+	}
+
+	slots := make(chan struct{}, this.config.MaxConcurrency)
+	for {
+		if failures := atomic.LoadInt32(&this.failures); failures > 0 {
+			if !this.sleep(webhookBackoff(failures)) {
+				return
+			}
+		}
+
+		select {
+		case <-this.stop:
+			return
+		case slots <- struct{}{}:
+		}
+		free := 1
+		for free < MaxBatch && acquireSlot(slots) {
+			free++
+		}
+
+		messages, err := YumiQ.Pop(this.config.QueueName, 0, free)
+		for i := len(messages); i < free; i++ {
+			<-slots
+		}
+		if errorKind(err) == ErrNotFound { //队列已删除
+			return
+		} else if err != nil {
+			if errorKind(err) != ErrEmpty {
+				log.Printf("%s queue webhook pop error: %s", this.config.QueueName, err.Error())
+			}
+			if !this.sleep(webhookPollInterval) {
+				return
+			}
+			continue
+		}
+
+		for _, message := range messages {
+			this.deliveries.Add(1)
+			go func(message Message) {
+				defer this.deliveries.Done()
+				defer func() { <-slots }()
+				this.deliver(message)
+			}(message)
+		}
+	}
+}
+
+//不阻塞地占用一个并发额度
+func acquireSlot(slots chan struct{}) bool {
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+//等待d，停止时返回false
+func (this *webhookWorker) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-this.stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+//第n次连续失败后暂停出列的时长
+func webhookBackoff(failures int32) time.Duration {
+	backoff := webhookMinBackoff
+	for i := int32(1); i < failures && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > webhookMaxBackoff {
+		backoff = webhookMaxBackoff
+	}
+	return backoff
+}
+
+func (this *webhookWorker) deliver(message Message) {
+	queueName := this.config.QueueName
+	if err := this.post(message); err != nil {
+		atomic.AddInt32(&this.failures, 1)
+		webhookDeliveriesTotal.WithLabelValues(queueName, "failure").Inc()
+		log.Printf("%s queue webhook error: %s", queueName, err.Error())
+		return
+	}
+
+	atomic.StoreInt32(&this.failures, 0)
+	webhookDeliveriesTotal.WithLabelValues(queueName, "success").Inc()
+	//超时后回执已失效时消息会再次推送
+	if err := YumiQ.Del(queueName, message.ReceiptHandle); err != nil {
+		log.Printf("%s queue webhook delete error: %s", queueName, err.Error())
+	}
+}
+
+//推送一条消息，返回2xx以外的状态码时返回错误
+func (this *webhookWorker) post(message Message) error {
+	payload, err := json.Marshal(webhookPayload{this.config.QueueName, message})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(this.config.Timeout)*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", this.config.Endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	timestamp := toString(theMoment())
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookQueueHeader, this.config.QueueName)
+	req.Header.Set(webhookMessageHeader, message.MessageId)
+	if this.config.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, webhookSignature(this.config.Secret, timestamp, payload))
+	}
+
+	res, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, webhookMaxResponseBody))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("endpoint responded %s", res.Status)
+	}
+	return nil
+}
+
+//签名包含时间戳，接收方可据此拒绝重放的请求
+func webhookSignature(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+//设置队列的webhook，推送地址为空时停止推送
+func (this *Yumi) SetWebhook(config WebhookConfig) (err error) {
+	optionQueue, ok := Queue.Get(config.QueueName)
+	if !ok {
+		return newError(ErrNotFound, "Queue %s exception", config.QueueName)
+	}
+
+	saved := map[string]string{"webhookEndpoint": "", "webhookMaxConcurrency": "", "webhookTimeout": "", "webhookSecret": ""}
+	if config.Endpoint != "" {
+		endpoint, err := url.Parse(config.Endpoint)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			return newError(ErrInvalid, "endpoint must be an http or https URL")
+		}
+
+		if config.MaxConcurrency == 0 {
+			config.MaxConcurrency = defaultWebhookConcurrency
+		} else if config.MaxConcurrency < 0 || config.MaxConcurrency > maxWebhookConcurrency {
+			return newError(ErrInvalid, "maxConcurrency must be between 1 and %d", maxWebhookConcurrency)
+		}
+
+		//超过隐藏时间时消息会在推送完成前再次出列
+		if config.Timeout == 0 {
+			config.Timeout = defaultWebhookTimeout
+			if visibilityTimeout := toInt64(optionQueue.VisibilityTimeout); config.Timeout > visibilityTimeout {
+				config.Timeout = visibilityTimeout
+			}
+		} else if config.Timeout < 0 || config.Timeout > toInt64(optionQueue.VisibilityTimeout) {
+			return newError(ErrInvalid, "timeout must be between 1 and the queue's VisibilityTimeout")
+		}
+
+		saved = map[string]string{
+			"webhookEndpoint":       config.Endpoint,
+			"webhookMaxConcurrency": strconv.Itoa(config.MaxConcurrency),
+			"webhookTimeout":        toString(config.Timeout),
+			"webhookSecret":         config.Secret,
+		}
+	}
+
+	if err = Store.SaveOptions(config.QueueName, saved); err != nil {
+		return
+	}
+	Webhooks.refresh()
+	return
+}
+
+func (this *Yumi) GetWebhook(queueName string) (config WebhookConfig, err error) {
+	if _, ok := Queue.Get(queueName); !ok {
+		return config, newError(ErrNotFound, "Queue %s exception", queueName)
+	}
+
+	opt, err := Store.GetOptions(queueName)
+	if err != nil {
+		return
+	}
+	return webhookConfig(queueName, opt), nil
+}
+
+//不返回签名密钥，只返回是否设置了密钥
+type WebhookResult struct {
+	Success        bool   `json:"success"`
+	QueueName      string `json:"queueName"`
+	Endpoint       string `json:"endpoint"`
+	MaxConcurrency int    `json:"maxConcurrency"`
+	Timeout        int64  `json:"timeout"`
+	Signed         bool   `json:"signed"`
+	Error          string `json:"error"`
+}
+
+func SetWebhook(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	queueName := req.PostFormValue("queueName")
+	endpoint := req.PostFormValue("endpoint")                           //推送地址，为空时停止推送
+	maxConcurrency := int(toInt64(req.PostFormValue("maxConcurrency"))) //最多同时推送的消息数，默认10
+	timeout := toInt64(req.PostFormValue("timeout"))                    //推送请求的超时秒数，默认10且不超过队列的隐藏时间
+	secret := req.PostFormValue("secret")                               //签名密钥，为空时不签名
+
+	if queueName == "" {
+		YumiQ.Write(res, WebhookResult{false, queueName, endpoint, maxConcurrency, timeout, secret != "", "queueName must not be null"})
+		return
+	}
+
+	if err := YumiQ.SetWebhook(WebhookConfig{queueName, endpoint, maxConcurrency, timeout, secret}); err != nil {
+		YumiQ.Write(res, WebhookResult{false, queueName, endpoint, maxConcurrency, timeout, secret != "", err.Error()})
+		return
+	}
+	GetWebhook(res, req)
+}
+
+func GetWebhook(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	queueName := req.Form.Get("queueName")
+
+	if queueName == "" {
+		YumiQ.Write(res, WebhookResult{false, queueName, "", 0, 0, false, "queueName must not be null"})
+		return
+	}
+
+	config, err := YumiQ.GetWebhook(queueName)
+	if err != nil {
+		YumiQ.Write(res, WebhookResult{false, queueName, "", 0, 0, false, err.Error()})
+	} else {
+		YumiQ.Write(res, WebhookResult{true, queueName, config.Endpoint, config.MaxConcurrency, config.Timeout, config.Secret != "", ""})
+	}
+}
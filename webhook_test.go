@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//接收推送的服务，记录请求并统计同时处理的请求数，release关闭前请求一直阻塞
+type webhookTestEndpoint struct {
+	*httptest.Server
+	mu          sync.Mutex
+	requests    []*http.Request
+	payloads    [][]byte
+	status      int32
+	inflight    int32
+	maxInflight int32
+	release     chan struct{}
+}
+
+func webhookTestServe(t *testing.T) *webhookTestEndpoint {
+	endpoint := &webhookTestEndpoint{status: 200, release: make(chan struct{})}
+	close(endpoint.release)
+	endpoint.Server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&endpoint.inflight, 1)
+		defer atomic.AddInt32(&endpoint.inflight, -1)
+		for {
+			max := atomic.LoadInt32(&endpoint.maxInflight)
+			if n <= max || atomic.CompareAndSwapInt32(&endpoint.maxInflight, max, n) {
+				break
+			}
+		}
+		endpoint.mu.Lock()
+		release := endpoint.release
+		endpoint.mu.Unlock()
+		<-release
+
+		body, _ := ioutil.ReadAll(req.Body)
+		endpoint.mu.Lock()
+		endpoint.requests = append(endpoint.requests, req)
+		endpoint.payloads = append(endpoint.payloads, body)
+		endpoint.mu.Unlock()
+		res.WriteHeader(int(atomic.LoadInt32(&endpoint.status)))
+	}))
+	t.Cleanup(endpoint.Close)
+	return endpoint
+}
+
+func (this *webhookTestEndpoint) block() {
+	this.mu.Lock()
+	this.release = make(chan struct{})
+	this.mu.Unlock()
+}
+
+func (this *webhookTestEndpoint) unblock() {
+	this.mu.Lock()
+	close(this.release)
+	this.mu.Unlock()
+}
+
+func (this *webhookTestEndpoint) received() int {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return len(this.payloads)
+}
+
+//使用内存存储并成为leader，推送才会启动
+//测试结束时停止全部推送并等它们结束，避免旧的推送从下一个测试的存储中出列
+//其他测试的WebSocket连接关闭后服务端可能还在出列，队列名不与它们相同
+func webhookTestSetup(t *testing.T) {
+	storageTestSetup(t, "memory")
+	if !Sched.elect() {
+		t.Fatal("not elected")
+	}
+	t.Cleanup(func() {
+		Webhooks.mu.Lock()
+		var done []chan struct{}
+		for queueName, worker := range Webhooks.workers {
+			close(worker.stop)
+			delete(Webhooks.workers, queueName)
+		}
+		for queueName, ch := range Webhooks.done {
+			done = append(done, ch)
+			delete(Webhooks.done, queueName)
+		}
+		Webhooks.mu.Unlock()
+		for _, ch := range done {
+			<-ch
+		}
+	})
+}
+
+//等待条件成立，超时后失败
+func webhookTestWait(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func webhookTestWorker(queueName string) *webhookWorker {
+	Webhooks.mu.Lock()
+	defer Webhooks.mu.Unlock()
+	return Webhooks.workers[queueName]
+}
+
+//连续失败后暂停时长翻倍，不超过上限
+func TestWebhookBackoff(t *testing.T) {
+	for failures, want := range map[int32]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 7: webhookMaxBackoff, 30: webhookMaxBackoff} {
+		if got := webhookBackoff(failures); got != want {
+			t.Fatalf("backoff after %d failures: %s, want %s", failures, got, want)
+		}
+	}
+}
+
+//签名为时间戳和请求体的HMAC-SHA256
+func TestWebhookSignature(t *testing.T) {
+	got := webhookSignature("secret", "1700000000", []byte(`{"body":"x"}`))
+	if want := "sha256=df3da0114d7187ed1972645d49f585defa78fa4450c6519678f13a3fe65ea62b"; got != want {
+		t.Fatalf("signature: %s, want %s", got, want)
+	}
+	if got == webhookSignature("other", "1700000000", []byte(`{"body":"x"}`)) || got == webhookSignature("secret", "1700000001", []byte(`{"body":"x"}`)) {
+		t.Fatal("signature does not depend on secret and timestamp")
+	}
+}
+
+//设置时校验推送地址、并发数和超时，队列的隐藏时间改小后超时按隐藏时间截断
+func TestSetWebhookValidation(t *testing.T) {
+	webhookTestSetup(t)
+	storageTestQueue(t, OptionQueue{QueueName: "wh", VisibilityTimeout: "5"})
+
+	for _, config := range []WebhookConfig{
+		{"wh", "ftp://example.com/", 0, 0, ""},
+		{"wh", "http://", 0, 0, ""},
+		{"wh", "http://127.0.0.1:1/", 101, 0, ""},
+		{"wh", "http://127.0.0.1:1/", 0, 6, ""},
+		{"wh", "http://127.0.0.1:1/", 0, -1, ""},
+	} {
+		if err := YumiQ.SetWebhook(config); errorKind(err) != ErrInvalid {
+			t.Fatalf("set webhook %+v: %v", config, err)
+		}
+	}
+	if err := YumiQ.SetWebhook(WebhookConfig{"missing", "http://127.0.0.1:1/", 0, 0, ""}); errorKind(err) != ErrNotFound {
+		t.Fatalf("set webhook on missing queue: %v", err)
+	}
+
+	//默认超时不超过隐藏时间
+	r := queueTestCall(t, "/setWebhook", map[string]string{"queueName": "wh", "endpoint": "http://127.0.0.1:1/", "secret": "s"})
+	if r["success"] != true || r["timeout"] != float64(5) || r["maxConcurrency"] != float64(defaultWebhookConcurrency) || r["signed"] != true {
+		t.Fatalf("set webhook: %v", r)
+	}
+	if err := Store.SaveOptions("wh", map[string]string{"visibilityTimeout": "3"}); err != nil {
+		t.Fatal(err)
+	}
+	if r := queueTestCall(t, "/getWebhook", map[string]string{"queueName": "wh"}); r["timeout"] != float64(3) {
+		t.Fatalf("get webhook after visibility change: %v", r)
+	}
+
+	//修改队列时保留webhook
+	if err := YumiQ.Update(OptionQueue{QueueName: "wh", VisibilityTimeout: "5"}); err != nil {
+		t.Fatal(err)
+	}
+	if r := queueTestCall(t, "/getWebhook", map[string]string{"queueName": "wh"}); r["endpoint"] != "http://127.0.0.1:1/" {
+		t.Fatalf("get webhook after update: %v", r)
+	}
+
+	queueTestCall(t, "/setWebhook", map[string]string{"queueName": "wh", "endpoint": ""})
+	if r := queueTestCall(t, "/getWebhook", map[string]string{"queueName": "wh"}); r["endpoint"] != "" || r["signed"] != false {
+		t.Fatalf("get webhook after disable: %v", r)
+	}
+	if webhookTestWorker("wh") != nil {
+		t.Fatal("worker still running after disable")
+	}
+}
+
+//推送带签名，返回2xx后删除消息；失败时消息留在隐藏中并记录失败次数
+func TestWebhookDelivery(t *testing.T) {
+	webhookTestSetup(t)
+	storageTestQueue(t, OptionQueue{QueueName: "wh"})
+	endpoint := webhookTestServe(t)
+
+	if _, err := YumiQ.Push("wh", PushEntry{Body: "hello", Attributes: `{"a":"b"}`}); err != nil {
+		t.Fatal(err)
+	}
+	if err := YumiQ.SetWebhook(WebhookConfig{"wh", endpoint.URL, 0, 0, "s3cret"}); err != nil {
+		t.Fatal(err)
+	}
+	webhookTestWait(t, "delivery", func() bool {
+		stats, _ := Store.Stats("wh")
+		return endpoint.received() == 1 && stats.Ready == 0 && stats.InFlight == 0
+	})
+
+	req, body := endpoint.requests[0], endpoint.payloads[0]
+	if sig := req.Header.Get(webhookSignatureHeader); sig != webhookSignature("s3cret", req.Header.Get(webhookTimestampHeader), body) {
+		t.Fatalf("signature: %q", sig)
+	}
+	var payload webhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.QueueName != "wh" || payload.Body != "hello" || payload.Attributes["a"] != "b" || payload.ReceiptHandle == "" ||
+		req.Header.Get(webhookQueueHeader) != "wh" || req.Header.Get(webhookMessageHeader) != payload.MessageId {
+		t.Fatalf("payload: %+v %v", payload, req.Header)
+	}
+
+	atomic.StoreInt32(&endpoint.status, 500)
+	YumiQ.Push("wh", PushEntry{Body: "fail"})
+	webhookTestWait(t, "failed delivery", func() bool {
+		worker := webhookTestWorker("wh")
+		return endpoint.received() == 2 && worker != nil && atomic.LoadInt32(&worker.failures) == 1
+	})
+	if stats, _ := Store.Stats("wh"); stats.InFlight != 1 {
+		t.Fatalf("stats after failed delivery: %+v", stats)
+	}
+}
+
+//同时推送的请求数不超过上限，配置变化后新的推送等旧的请求完成
+func TestWebhookConcurrency(t *testing.T) {
+	webhookTestSetup(t)
+	storageTestQueue(t, OptionQueue{QueueName: "wh"})
+	endpoint := webhookTestServe(t)
+	endpoint.block()
+
+	for i := 0; i < 10; i++ {
+		YumiQ.Push("wh", PushEntry{Body: "x"})
+	}
+	if err := YumiQ.SetWebhook(WebhookConfig{"wh", endpoint.URL, 3, 0, ""}); err != nil {
+		t.Fatal(err)
+	}
+	webhookTestWait(t, "concurrent deliveries", func() bool { return atomic.LoadInt32(&endpoint.inflight) == 3 })
+
+	if err := YumiQ.SetWebhook(WebhookConfig{"wh", endpoint.URL, 3, 0, "changed"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if max := atomic.LoadInt32(&endpoint.maxInflight); max != 3 {
+		t.Fatalf("max inflight after config change: %d", max)
+	}
+
+	endpoint.unblock()
+	webhookTestWait(t, "all deliveries", func() bool {
+		stats, _ := Store.Stats("wh")
+		return stats.Ready == 0 && stats.InFlight == 0
+	})
+	if max := atomic.LoadInt32(&endpoint.maxInflight); max != 3 {
+		t.Fatalf("max inflight: %d", max)
+	}
+}